@@ -1,61 +1,573 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/ayushgala/tinkerdb/internal/auth"
+	"github.com/ayushgala/tinkerdb/internal/cdc"
+	"github.com/ayushgala/tinkerdb/internal/certs"
+	"github.com/ayushgala/tinkerdb/internal/config"
+	"github.com/ayushgala/tinkerdb/internal/logging"
+	"github.com/ayushgala/tinkerdb/internal/metrics"
+	"github.com/ayushgala/tinkerdb/internal/raft"
 	"github.com/ayushgala/tinkerdb/internal/server"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"github.com/ayushgala/tinkerdb/internal/tracing"
 	pb "github.com/ayushgala/tinkerdb/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
-const (
-	defaultPort = "8080"
-)
+// shutdownTimeout bounds the wait for in-flight RPCs on shutdown. Watch and
+// CDC streams only end when their clients cancel them, so they are cut off
+// after it.
+const shutdownTimeout = 10 * time.Second
 
 func main() {
-	port := os.Getenv("TINKERDB_PORT")
-	if port == "" {
-		port = defaultPort
+	// Load the configuration from the file, environment and flags
+	cfg, opts, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+	if opts.PrintConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+	logLevel, slowLog, err := setupLogging(cfg.Log)
+	if err != nil {
+		fatalf("Failed to set up logging: %v", err)
+	}
+	if opts.Path != "" {
+		slog.Info("Loaded configuration", "path", opts.Path)
+	}
+
+	// Export traces of calls, store operations, lock waits and WAL fsyncs
+	shutdownTracing, err := setupTracing(cfg.Tracing)
+	if err != nil {
+		fatalf("Failed to set up tracing: %v", err)
+	}
+
+	// Serve the health probes before recovering the store, so that a long
+	// recovery reads as alive but not ready
+	health := server.NewHealth(services(cfg)...)
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/healthz", health.LiveHandler())
+	httpMux.Handle("/readyz", health.ReadyHandler())
+	httpServer, err := startHTTP(cfg.Metrics.Listen, httpMux)
+	if err != nil {
+		fatalf("Failed to serve HTTP on %s: %v", cfg.Metrics.Listen, err)
+	}
+
+	// Serve TLS if a certificate is configured, reloading it as it changes
+	reloader, creds, err := setupTLS(cfg.TLS)
+	if err != nil {
+		fatalf("Failed to set up TLS: %v", err)
+	}
+
+	// Open the durable store and replay the write-ahead log. In cluster mode
+	// the log is replicated by a Raft node, which joins the cluster once the
+	// services are registered.
+	store, node, transport, err := openStore(cfg, reloader)
+	if err != nil {
+		fatalf("Failed to open store in %s: %v", cfg.DataDir, err)
+	}
+	slog.Info("Recovered store", "data_dir", cfg.DataDir, "wal_sync", cfg.WAL.Sync)
+
+	// Publish committed changes to the configured CDC sinks
+	publisher, streamSink, err := startCDC(cfg, store)
+	if err != nil {
+		fatalf("Failed to start change data capture: %v", err)
+	}
+
+	// Load the issued tokens, users and roles and configure how calls are
+	// authenticated
+	tokens, rbac, authenticator, err := setupAuth(cfg, store)
+	if err != nil {
+		fatalf("Failed to set up authentication: %v", err)
 	}
 
+	// Record request, store and runtime metrics and serve them over HTTP
+	serverMetrics := metrics.New(store, metrics.Options{MaxTenants: cfg.Metrics.MaxTenants})
+	httpMux.Handle("/metrics", serverMetrics.Handler())
+
 	// Create listener
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
+	lis, err := net.Listen("tcp", cfg.Listen)
 	if err != nil {
-		log.Fatalf("Failed to listen on port %s: %v", port, err)
+		fatalf("Failed to listen on %s: %v", cfg.Listen, err)
 	}
 
-	// Create gRPC server
-	grpcServer := grpc.NewServer()
+	// Create gRPC server. Tracing, metrics and the request log come first
+	// so that rejected calls are traced, counted and logged too; the request
+	// log records the trace ID of each call.
+	requestLog := logging.NewInterceptor(slog.Default(), slowLog, requestLogOptions(cfg.Log))
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			tracing.UnaryServerInterceptor(),
+			serverMetrics.UnaryServerInterceptor(),
+			requestLog.UnaryServerInterceptor(),
+			authenticator.UnaryInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			tracing.StreamServerInterceptor(),
+			serverMetrics.StreamServerInterceptor(),
+			requestLog.StreamServerInterceptor(),
+			authenticator.StreamInterceptor(),
+		),
+	}
+	if creds != nil {
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+	grpcServer := grpc.NewServer(serverOpts...)
 
 	// Register KVStore service
-	kvStoreServer := server.NewKVStoreServer()
+	kvStoreServer := server.NewKVStoreServerWithStore(store)
+	kvStoreServer.SetLimits(limits(cfg.Limits))
 	pb.RegisterKVStoreServer(grpcServer, kvStoreServer)
 
+	// Register Admin service for operational controls such as on-demand
+	// snapshots and token management
+	pb.RegisterAdminServer(grpcServer, server.NewAdminServer(store, tokens, rbac))
+
+	// Register CDC service when the stream sink is enabled
+	if streamSink != nil {
+		pb.RegisterCDCServer(grpcServer, server.NewCDCServer(streamSink))
+	}
+
+	// Register the Raft service the nodes of a cluster replicate the log
+	// through and the Cluster service managing its members, serve reads at
+	// the consistency they request, and join the cluster
+	if node != nil {
+		kvStoreServer.SetNode(node)
+		pb.RegisterRaftServer(grpcServer, raft.NewServer(node, cfg.Cluster.Secret))
+		pb.RegisterClusterServer(grpcServer, server.NewClusterServer(node))
+		node.Start(store)
+	}
+
+	// Serve only the keys the shard map places on this group and publish the
+	// map to routers and clients
+	if cfg.Shard.Enabled() {
+		// Validated already
+		shards, _ := cfg.Shard.Map()
+		kvStoreServer.SetShards(shards, cfg.Shard.ID)
+		pb.RegisterShardServer(grpcServer, server.NewShardServer(shards, cfg.Shard.ID))
+		slog.Info("Sharding enabled", "shard", cfg.Shard.ID, "groups", len(shards.Groups()), "map_version", shards.Version())
+	}
+
 	// Register reflection service for debugging with tools like grpcurl
 	reflection.Register(grpcServer)
 
-	// Set up signal handling for graceful shutdown
+	// Register the health service, which reports the services as serving
+	// now that the store is recovered
+	healthpb.RegisterHealthServer(grpcServer, health)
+	health.SetReady(true)
+
+	// Set up signal handling for graceful shutdown and configuration reloads
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("TinkerDB server starting on port %s...", port)
-		log.Printf("Server is ready to accept connections")
+		slog.Info("TinkerDB server starting", "addr", lis.Addr().String())
+		slog.Info("Server is ready to accept connections")
 		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatalf("Failed to serve: %v", err)
+			fatalf("Failed to serve: %v", err)
 		}
 	}()
 
-	// Wait for termination signal
-	<-sigCh
-	log.Println("\nShutting down server gracefully...")
-	grpcServer.GracefulStop()
-	log.Println("Server stopped")
+	// Wait for termination signal, reloading the configuration on SIGHUP
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		cfg = reloadConfig(cfg, kvStoreServer, logLevel, requestLog)
+	}
+	slog.Info("Shutting down server gracefully")
+	// Report the services as not serving while the calls in flight drain
+	health.Shutdown()
+	stopServer(grpcServer, shutdownTimeout)
+	if httpServer != nil {
+		httpServer.Close()
+	}
+	if publisher != nil {
+		if err := publisher.Close(); err != nil {
+			slog.Error("Failed to close CDC publisher", "err", err)
+		}
+	}
+	tokens.Close()
+	rbac.Close()
+	if reloader != nil {
+		reloader.Close()
+	}
+	// The node stops applying entries before the store closes its log
+	if node != nil {
+		node.Stop()
+		transport.Close()
+	}
+	if err := store.Close(); err != nil {
+		slog.Error("Failed to close store", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "err", err)
+	}
+	cancel()
+	slog.Info("Server stopped")
+}
+
+// reloadConfig loads the configuration again and applies the settings that
+// can change while serving: the limits, the log level and the request log
+// options. Other changes are reported and wait for a restart. An invalid
+// configuration is ignored.
+func reloadConfig(current *config.Config, kvStoreServer *server.KVStoreServer, logLevel *slog.LevelVar, requestLog *logging.Interceptor) *config.Config {
+	cfg, _, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping the current one", "err", err)
+		return current
+	}
+
+	kvStoreServer.SetLimits(limits(cfg.Limits))
+	level, _ := config.ParseLevel(cfg.Log.Level)
+	logLevel.Set(level)
+	requestLog.SetOptions(requestLogOptions(cfg.Log))
+	slog.Info("Reloaded configuration", "log_level", cfg.Log.Level, "limits", cfg.Limits)
+	if changed := current.RestartRequired(cfg); len(changed) > 0 {
+		slog.Warn("Changes take effect after a restart", "settings", strings.Join(changed, ","))
+	}
+
+	// Keep the settings in effect, so the next reload reports the same
+	// pending changes
+	applied := *current
+	applied.Limits = cfg.Limits
+	applied.Log.Level = cfg.Log.Level
+	applied.Log.Keys = cfg.Log.Keys
+	applied.Log.KeySample = cfg.Log.KeySample
+	applied.Log.SlowThreshold = cfg.Log.SlowThreshold
+	return &applied
+}
+
+// setupLogging routes the log package through a leveled slog handler. It
+// returns the level, which can be changed while serving, and the logger of
+// slow requests, which writes to log.slow_file if it is set.
+func setupLogging(cfg config.LogConfig) (*slog.LevelVar, *slog.Logger, error) {
+	level := new(slog.LevelVar)
+	l, _ := config.ParseLevel(cfg.Level)
+	level.Set(l)
+
+	handler, err := logging.NewHandler(os.Stderr, cfg.Format, level)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.SetDefault(slog.New(handler))
+
+	if cfg.SlowFile == "" {
+		return level, slog.Default(), nil
+	}
+	f, err := os.OpenFile(cfg.SlowFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open slow log: %w", err)
+	}
+	slowHandler, err := logging.NewHandler(f, cfg.Format, nil)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return level, slog.New(slowHandler), nil
+}
+
+// requestLogOptions converts the configured request logging
+func requestLogOptions(cfg config.LogConfig) logging.Options {
+	return logging.Options{
+		Keys:          cfg.Keys,
+		KeySample:     cfg.KeySample,
+		SlowThreshold: cfg.SlowThreshold,
+	}
+}
+
+// setupTracing installs the configured trace exporter. It returns the
+// function flushing the spans still buffered on shutdown.
+func setupTracing(cfg config.TracingConfig) (func(context.Context) error, error) {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Exporter,
+		Endpoint:    cfg.Endpoint,
+		Insecure:    cfg.Insecure,
+		File:        cfg.File,
+		SampleRatio: cfg.SampleRatio,
+		ServiceName: cfg.ServiceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Exporter {
+	case tracing.ExporterOTLP:
+		slog.Info("Exporting traces over OTLP", "endpoint", cfg.Endpoint, "sample_ratio", cfg.SampleRatio)
+	case tracing.ExporterStdout:
+		slog.Info("Writing traces to stdout", "sample_ratio", cfg.SampleRatio)
+	case tracing.ExporterFile:
+		slog.Info("Writing traces to a file", "file", cfg.File, "sample_ratio", cfg.SampleRatio)
+	}
+	return shutdown, nil
+}
+
+// fatalf logs an error whatever the log level and exits
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// limits converts the configured limits
+func limits(cfg config.LimitsConfig) server.Limits {
+	return server.Limits{
+		MaxKeySize:   cfg.MaxKeySize,
+		MaxValueSize: cfg.MaxValueSize,
+		MaxBatchKeys: cfg.MaxBatchKeys,
+		MaxScanLimit: cfg.MaxScanLimit,
+	}
+}
+
+// stopServer stops the server gracefully, forcing it to stop after timeout
+func stopServer(grpcServer *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Graceful shutdown timed out, closing remaining streams", "timeout", timeout)
+		grpcServer.Stop()
+		<-done
+	}
+}
+
+// startHTTP serves handler at listen, the metrics and health probes. With an
+// empty address nothing is served and nil is returned.
+func startHTTP(listen string, handler http.Handler) (*http.Server, error) {
+	if listen == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	httpServer := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := httpServer.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "err", err)
+		}
+	}()
+	slog.Info("Serving metrics and health probes", "url", "http://"+lis.Addr().String())
+	return httpServer, nil
+}
+
+// services returns the names of the gRPC services the configuration serves,
+// whose health is reported
+func services(cfg *config.Config) []string {
+	names := []string{pb.KVStore_ServiceDesc.ServiceName, pb.Admin_ServiceDesc.ServiceName}
+	if slices.Contains(cfg.CDC.Sinks, "stream") {
+		names = append(names, pb.CDC_ServiceDesc.ServiceName)
+	}
+	if cfg.Cluster.Enabled() {
+		names = append(names, pb.Raft_ServiceDesc.ServiceName, pb.Cluster_ServiceDesc.ServiceName)
+	}
+	if cfg.Shard.Enabled() {
+		names = append(names, pb.Shard_ServiceDesc.ServiceName)
+	}
+	return names
+}
+
+// openStore opens the store. In cluster mode its log is a Raft node, which
+// is returned with its transport and only joins the cluster once started.
+func openStore(cfg *config.Config, reloader *certs.Reloader) (*storage.Store, *raft.Node, *raft.GRPCTransport, error) {
+	storeOpts := cfg.StoreOptions()
+	if !cfg.Cluster.Enabled() {
+		store, err := storage.Open(storeOpts)
+		return store, nil, nil, err
+	}
+
+	// Validated already
+	peers, _ := cfg.Cluster.PeerAddresses()
+	creds, err := peerCredentials(cfg.Cluster, reloader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	transport := raft.NewGRPCTransport(creds, cfg.Cluster.Secret)
+
+	node, err := raft.NewNode(raft.Config{
+		ID:                cfg.Cluster.NodeID,
+		Peers:             peers,
+		Join:              cfg.Cluster.Join,
+		Dir:               filepath.Join(cfg.DataDir, "raft"),
+		WAL:               storeOpts.WAL,
+		ElectionTimeout:   cfg.Cluster.ElectionTimeout,
+		HeartbeatInterval: cfg.Cluster.HeartbeatInterval,
+	}, transport)
+	if err != nil {
+		transport.Close()
+		return nil, nil, nil, err
+	}
+	store, err := storage.OpenWithLog(storeOpts, node)
+	if err != nil {
+		transport.Close()
+		return nil, nil, nil, err
+	}
+
+	if members := node.Members(); len(members) > 0 {
+		slog.Info("Cluster mode enabled", "node", cfg.Cluster.NodeID, "members", len(members))
+	} else {
+		slog.Info("Cluster mode enabled, waiting to be added to the cluster", "node", cfg.Cluster.NodeID)
+	}
+	if cfg.Cluster.Secret == "" {
+		slog.Warn("No cluster.secret configured, any client can send Raft messages")
+	}
+	return store, node, transport, nil
+}
+
+// peerCredentials returns the credentials a node connects to its peers with.
+// With TLS configured the peers are verified against cluster.ca and the
+// server certificate is presented to them, otherwise they are plaintext.
+func peerCredentials(cfg config.ClusterConfig, reloader *certs.Reloader) (credentials.TransportCredentials, error) {
+	if reloader == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.Certificate(), nil
+		},
+	}
+	if cfg.CA != "" {
+		pool, err := certs.LoadCertPool(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// setupAuth loads the issued tokens, users and roles. With auth.mode token
+// every call needs a bearer token or client certificate; with none calls
+// without one are still served.
+func setupAuth(cfg *config.Config, store *storage.Store) (*auth.Tokens, *auth.RBAC, *server.Authenticator, error) {
+	opts := server.AuthOptions{
+		Required:     cfg.Auth.Mode == "token",
+		AdminToken:   cfg.Auth.AdminToken,
+		AdminClients: cfg.TLS.AdminClients,
+	}
+
+	tokens, err := auth.OpenTokens(store)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rbac, err := auth.OpenRBAC(store)
+	if err != nil {
+		tokens.Close()
+		return nil, nil, nil, err
+	}
+	if opts.Required {
+		slog.Info("Token authentication required", "tokens", len(tokens.List()))
+		if opts.AdminToken == "" && len(tokens.List()) == 0 && len(opts.AdminClients) == 0 {
+			slog.Warn("No tokens issued and no admin token or admin clients configured, every call will be rejected")
+		}
+	}
+	return tokens, rbac, server.NewAuthenticator(tokens, rbac, opts), nil
+}
+
+// setupTLS loads the server certificate and the CAs verifying client
+// certificates, and reloads them as the files change. Without a certificate
+// it returns nil and the server listens in plaintext.
+func setupTLS(cfg config.TLSConfig) (*certs.Reloader, credentials.TransportCredentials, error) {
+	if cfg.Cert == "" {
+		return nil, nil, nil
+	}
+
+	clientAuth := tls.RequireAndVerifyClientCert
+	if cfg.ClientAuth == "optional" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	reloader, err := certs.NewReloader(certs.Files{Cert: cfg.Cert, Key: cfg.Key, ClientCA: cfg.ClientCA}, cfg.ReloadInterval)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.ClientCA != "" {
+		slog.Info("TLS enabled with client certificates", "cert", cfg.Cert, "client_ca", cfg.ClientCA, "client_auth", clientAuth)
+	} else {
+		slog.Info("TLS enabled", "cert", cfg.Cert)
+	}
+	return reloader, credentials.NewTLS(reloader.ServerConfig(clientAuth)), nil
+}
+
+// startCDC starts change data capture if any sinks are configured: "file"
+// writes rotating JSON-lines files, "stream" serves the CDC gRPC service.
+// State lives in cdc.dir, <data dir>/cdc by default.
+func startCDC(cfg *config.Config, store *storage.Store) (*cdc.Publisher, *cdc.StreamSink, error) {
+	if len(cfg.CDC.Sinks) == 0 {
+		return nil, nil, nil
+	}
+
+	dir := cfg.CDC.Dir
+	if dir == "" {
+		dir = filepath.Join(cfg.DataDir, "cdc")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create cdc directory: %w", err)
+	}
+	offsets, err := cdc.OpenOffsets(filepath.Join(dir, "offsets.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sinks []cdc.Sink
+	var streamSink *cdc.StreamSink
+	for _, name := range cfg.CDC.Sinks {
+		switch name {
+		case "file":
+			fileOpts := cdc.DefaultFileSinkOptions()
+			fileOpts.MaxFileSize = cfg.CDC.FileMaxBytes
+			fileOpts.MaxFiles = cfg.CDC.FileRetain
+			fileSink, err := cdc.OpenFileSink(filepath.Join(dir, "files"), fileOpts)
+			if err != nil {
+				return nil, nil, err
+			}
+			sinks = append(sinks, fileSink)
+		case "stream":
+			streamSink = cdc.NewStreamSink(store, offsets)
+			sinks = append(sinks, streamSink)
+		}
+	}
+
+	publisher, err := cdc.Start(store, dir, offsets, sinks...)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.Info("Change data capture enabled", "sinks", strings.Join(cfg.CDC.Sinks, ","), "dir", dir)
+	return publisher, streamSink, nil
 }
@@ -0,0 +1,429 @@
+// Command tinkerctl manages the roles, users and tokens of a TinkerDB server
+// through its Admin service, the members of a cluster through its Cluster
+// service, and shows the shard map of a sharded deployment.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/auth"
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	"github.com/ayushgala/tinkerdb/pkg/client"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const usage = `Usage: tinkerctl [flags] <command> [arguments]
+
+Commands:
+  role put <name> <tenant:prefix:access>...  Create or replace a role, access is read, write or admin
+  role delete <name>                         Delete a role
+  role list                                  List roles
+  user grant <user> <role>                   Give a role to a user
+  user revoke <user> <role>                  Take a role away from a user
+  user list                                  List users and their roles
+  user delete <user>                         Delete a user
+  token issue <name> -user <user>            Issue a token acting as a user
+  token issue <name> -tenant <tenant>...     Issue a token bound to tenants
+  token issue <name> -admin                  Issue an admin token
+  token revoke <token id>                    Revoke a token
+  token list                                 List tokens
+  member list                                List the cluster members as the node knows them
+  member add <id> <host:port> [-promote]     Add a node as a learner, -promote then makes it a voter
+  member promote <id>                        Make a learner a voter once it has caught up
+  member remove <id>                         Remove a node from the cluster
+  member transfer-leader <id>                Hand the leadership to a voter
+  shard map                                  Show the shard groups and the map version
+  shard locate <tenant> <key>                Show the shard group owning a key
+
+Member changes are sent to the leader, whichever node -addr names.
+
+Flags:
+`
+
+func main() {
+	addr := flag.String("addr", envOr("TINKERDB_ADDR", "localhost:50051"), "server address, or TINKERDB_ADDR")
+	token := flag.String("token", os.Getenv("TINKERDB_TOKEN"), "bearer token, or TINKERDB_TOKEN")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each call")
+	tlsCfg := &client.Config{}
+	flag.BoolVar(&tlsCfg.TLS, "tls", false, "connect with TLS, implied by the options below")
+	flag.StringVar(&tlsCfg.CAFile, "ca", os.Getenv("TINKERDB_CA"), "CA bundle verifying the server, or TINKERDB_CA")
+	flag.StringVar(&tlsCfg.CertFile, "cert", os.Getenv("TINKERDB_CLIENT_CERT"), "client certificate for mutual TLS, or TINKERDB_CLIENT_CERT")
+	flag.StringVar(&tlsCfg.KeyFile, "key", os.Getenv("TINKERDB_CLIENT_KEY"), "key of the client certificate, or TINKERDB_CLIENT_KEY")
+	flag.StringVar(&tlsCfg.ServerName, "server-name", os.Getenv("TINKERDB_SERVER_NAME"), "name verified in the server certificate, or TINKERDB_SERVER_NAME")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	creds, err := tlsCfg.TransportCredentials()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	conn, err := grpc.NewClient(*addr, dialOpts...)
+	if err != nil {
+		fatalf("failed to connect to %s: %v", *addr, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if *token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+	}
+
+	admin := pb.NewAdminClient(conn)
+	cluster := &clusterClient{conn: conn, dialOpts: dialOpts}
+	args := flag.Args()
+	switch args[0] + " " + args[1] {
+	case "role put":
+		err = rolePut(ctx, admin, args[2:])
+	case "role delete":
+		err = withName(args[2:], func(name string) error {
+			_, err := admin.DeleteRole(ctx, &pb.DeleteRoleRequest{Name: name})
+			return err
+		})
+	case "role list":
+		err = roleList(ctx, admin)
+	case "user grant", "user revoke":
+		err = userRole(ctx, admin, args[1], args[2:])
+	case "user list":
+		err = userList(ctx, admin)
+	case "user delete":
+		err = withName(args[2:], func(name string) error {
+			_, err := admin.DeleteUser(ctx, &pb.DeleteUserRequest{Name: name})
+			return err
+		})
+	case "token issue":
+		err = tokenIssue(ctx, admin, args[2:])
+	case "token revoke":
+		err = withName(args[2:], func(id string) error {
+			_, err := admin.RevokeToken(ctx, &pb.RevokeTokenRequest{TokenId: id})
+			return err
+		})
+	case "token list":
+		err = tokenList(ctx, admin)
+	case "member list":
+		err = memberList(ctx, cluster)
+	case "member add":
+		err = memberAdd(ctx, cluster, args[2:])
+	case "member promote":
+		err = withName(args[2:], func(id string) error {
+			return cluster.promote(ctx, id)
+		})
+	case "member remove":
+		err = withName(args[2:], func(id string) error {
+			return cluster.call(func(c pb.ClusterClient) error {
+				resp, err := c.RemoveMember(ctx, &pb.RemoveMemberRequest{Id: id})
+				if err == nil {
+					fmt.Println(resp.Message)
+				}
+				return err
+			})
+		})
+	case "member transfer-leader":
+		err = withName(args[2:], func(id string) error {
+			return cluster.call(func(c pb.ClusterClient) error {
+				resp, err := c.TransferLeadership(ctx, &pb.TransferLeadershipRequest{Id: id})
+				if err == nil {
+					fmt.Println(resp.Message)
+				}
+				return err
+			})
+		})
+	case "shard map":
+		err = shardMap(ctx, pb.NewShardClient(conn))
+	case "shard locate":
+		err = shardLocate(ctx, pb.NewShardClient(conn), args[2:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func rolePut(ctx context.Context, admin pb.AdminClient, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: role put <name> <tenant:prefix:access>...")
+	}
+
+	role := &pb.Role{Name: args[0]}
+	for _, arg := range args[1:] {
+		p, err := auth.ParsePermission(arg)
+		if err != nil {
+			return err
+		}
+		role.Permissions = append(role.Permissions, &pb.Permission{
+			TenantId: p.Tenant,
+			Prefix:   p.Prefix,
+			Access:   pb.Permission_Access(p.Access),
+		})
+	}
+
+	_, err := admin.PutRole(ctx, &pb.PutRoleRequest{Role: role})
+	return err
+}
+
+func roleList(ctx context.Context, admin pb.AdminClient) error {
+	resp, err := admin.ListRoles(ctx, &pb.ListRolesRequest{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROLE\tPERMISSIONS")
+	for _, role := range resp.Roles {
+		var permissions []string
+		for _, p := range role.Permissions {
+			permissions = append(permissions, auth.Permission{Tenant: p.TenantId, Prefix: p.Prefix, Access: auth.Access(p.Access)}.String())
+		}
+		fmt.Fprintf(w, "%s\t%s\n", role.Name, strings.Join(permissions, " "))
+	}
+	return w.Flush()
+}
+
+func userRole(ctx context.Context, admin pb.AdminClient, action string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: user %s <user> <role>", action)
+	}
+
+	var err error
+	if action == "grant" {
+		_, err = admin.GrantRole(ctx, &pb.GrantRoleRequest{User: args[0], Role: args[1]})
+	} else {
+		_, err = admin.RevokeRole(ctx, &pb.RevokeRoleRequest{User: args[0], Role: args[1]})
+	}
+	return err
+}
+
+func userList(ctx context.Context, admin pb.AdminClient) error {
+	resp, err := admin.ListUsers(ctx, &pb.ListUsersRequest{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tROLES")
+	for _, user := range resp.Users {
+		fmt.Fprintf(w, "%s\t%s\n", user.Name, strings.Join(user.Roles, " "))
+	}
+	return w.Flush()
+}
+
+func tokenIssue(ctx context.Context, admin pb.AdminClient, args []string) error {
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		return errors.New("usage: token issue <name> [-user <user> | -tenant <tenant>... | -admin]")
+	}
+
+	var tenants stringsFlag
+	fs := flag.NewFlagSet("token issue", flag.ContinueOnError)
+	user := fs.String("user", "", "user whose roles the token acts with")
+	isAdmin := fs.Bool("admin", false, "issue an admin token")
+	fs.Var(&tenants, "tenant", "tenant the token may read and write, repeatable")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	resp, err := admin.IssueToken(ctx, &pb.IssueTokenRequest{Name: args[0], Tenants: tenants, User: *user, Admin: *isAdmin})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Token ID: %s\n", resp.Info.TokenId)
+	fmt.Printf("Token:    %s\n", resp.Token)
+	fmt.Println("The token cannot be shown again.")
+	return nil
+}
+
+func tokenList(ctx context.Context, admin pb.AdminClient) error {
+	resp, err := admin.ListTokens(ctx, &pb.ListTokensRequest{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tBOUND TO\tCREATED")
+	for _, t := range resp.Tokens {
+		bound := "tenants " + strings.Join(t.Tenants, ",")
+		switch {
+		case t.Admin:
+			bound = "admin"
+		case t.User != "":
+			bound = "user " + t.User
+		}
+		created := time.UnixMilli(t.CreatedAt).Format(time.RFC3339)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.TokenId, t.Name, bound, created)
+	}
+	return w.Flush()
+}
+
+// clusterClient calls the Cluster service, on the leader for changes
+type clusterClient struct {
+	conn     *grpc.ClientConn
+	dialOpts []grpc.DialOption
+}
+
+// call calls fn with the Cluster service of the node -addr names, and once
+// more with the leader's if the node is a follower naming it
+func (c *clusterClient) call(fn func(pb.ClusterClient) error) error {
+	err := fn(pb.NewClusterClient(c.conn))
+	leader, ok := client.NotLeader(err)
+	if !ok || leader == "" {
+		return err
+	}
+
+	conn, err := grpc.NewClient(leader, c.dialOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the leader at %s: %v", leader, err)
+	}
+	defer conn.Close()
+	return fn(pb.NewClusterClient(conn))
+}
+
+func (c *clusterClient) promote(ctx context.Context, id string) error {
+	return c.call(func(cluster pb.ClusterClient) error {
+		resp, err := cluster.PromoteMember(ctx, &pb.PromoteMemberRequest{Id: id})
+		if err == nil {
+			fmt.Println(resp.Message)
+		}
+		return err
+	})
+}
+
+func memberList(ctx context.Context, cluster *clusterClient) error {
+	return cluster.call(func(c pb.ClusterClient) error {
+		resp, err := c.ListMembers(ctx, &pb.ListMembersRequest{})
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tADDRESS\tROLE\tMATCH")
+		for _, m := range resp.Members {
+			role := "voter"
+			switch {
+			case m.Leader:
+				role = "leader"
+			case m.Learner:
+				role = "learner"
+			}
+			match := "-"
+			if m.MatchIndex > 0 {
+				match = fmt.Sprint(m.MatchIndex)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Id, m.Address, role, match)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("Term %d, commit index %d\n", resp.Term, resp.CommitIndex)
+		return nil
+	})
+}
+
+func memberAdd(ctx context.Context, cluster *clusterClient, args []string) error {
+	if len(args) < 2 || strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[1], "-") {
+		return errors.New("usage: member add <id> <host:port> [-promote]")
+	}
+
+	fs := flag.NewFlagSet("member add", flag.ContinueOnError)
+	promote := fs.Bool("promote", false, "make the node a voter once it has caught up")
+	if err := fs.Parse(args[2:]); err != nil {
+		return err
+	}
+
+	err := cluster.call(func(c pb.ClusterClient) error {
+		resp, err := c.AddMember(ctx, &pb.AddMemberRequest{Id: args[0], Address: args[1]})
+		if err == nil {
+			fmt.Println(resp.Message)
+		}
+		return err
+	})
+	if err != nil || !*promote {
+		return err
+	}
+	return cluster.promote(ctx, args[0])
+}
+
+// withName runs fn with the single argument of a command
+func shardMap(ctx context.Context, shards pb.ShardClient) error {
+	resp, err := shards.GetShardMap(ctx, &pb.GetShardMapRequest{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tADDRESSES")
+	for _, g := range resp.Map.Groups {
+		fmt.Fprintf(w, "%s\t%s\n", g.Id, strings.Join(g.Addresses, ","))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Map version %d, %d virtual nodes per group, served by %s\n", resp.Map.Version, resp.Map.VirtualNodes, resp.ShardId)
+	return nil
+}
+
+func shardLocate(ctx context.Context, shards pb.ShardClient, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: shard locate <tenant> <key>")
+	}
+	resp, err := shards.GetShardMap(ctx, &pb.GetShardMapRequest{})
+	if err != nil {
+		return err
+	}
+	m, err := shard.FromProto(resp.Map)
+	if err != nil {
+		return err
+	}
+
+	g := m.Locate(args[0], args[1])
+	fmt.Printf("%s\t%s\n", g.ID, strings.Join(g.Addresses, ","))
+	return nil
+}
+
+func withName(args []string, fn func(string) error) error {
+	if len(args) != 1 {
+		return errors.New("expected exactly one argument")
+	}
+	return fn(args[0])
+}
+
+// stringsFlag collects the values of a repeated flag
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "tinkerctl: "+format+"\n", args...)
+	os.Exit(1)
+}
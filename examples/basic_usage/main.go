@@ -77,14 +77,13 @@ func main() {
 
 	// Example 4: List all keys
 	fmt.Println("\n=== Example 4: List All Keys ===")
-	allKeys, err := c.Keys(ctx)
+	page, err := c.Scan(ctx, client.ScanOptions{})
 	if err != nil {
-		log.Printf("Keys retrieval failed: %v", err)
+		log.Printf("Scan failed: %v", err)
 	} else {
-		fmt.Printf("✓ Total keys: %d\n", len(allKeys))
-		for _, k := range allKeys {
-			val, _ := c.GetString(ctx, k)
-			fmt.Printf("  - %s: %s\n", k, val)
+		fmt.Printf("✓ Total keys: %d\n", len(page.Items))
+		for _, item := range page.Items {
+			fmt.Printf("  - %s: %s\n", item.Key, item.Value)
 		}
 	}
 
@@ -138,5 +137,20 @@ func main() {
 		fmt.Printf("✓ Retrieved binary data: %v\n", retrieved)
 	}
 
+	// Example 8: Atomic transaction
+	fmt.Println("\n=== Example 8: Atomic Transaction ===")
+	c.SetString(ctx, "checking", "100")
+	_, version, _ := c.GetWithVersion(ctx, "checking")
+	resp, err := c.Txn(ctx).
+		If(client.Version("checking").Equal(version)).
+		Then(client.OpSet("checking", []byte("70")), client.OpSet("savings", []byte("30"))).
+		Else(client.OpGet("checking")).
+		Commit()
+	if err != nil {
+		log.Printf("Transaction failed: %v", err)
+	} else {
+		fmt.Printf("✓ Moved 30 from checking to savings: %v\n", resp.Succeeded)
+	}
+
 	fmt.Println("\n=== All examples completed successfully! ===")
 }
@@ -3,19 +3,34 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ayushgala/tinkerdb/pkg/client"
 )
 
 func main() {
 	// Connect to TinkerDB
+	addr := os.Getenv("TINKERDB_ADDR")
+	if addr == "" {
+		addr = "localhost:50051"
+	}
 	cfg := &client.Config{
-		Address:  "localhost:50051",
+		Address:  addr,
 		TenantID: "interactive",
+		Token:    os.Getenv("TINKERDB_TOKEN"),
+
+		CAFile:     os.Getenv("TINKERDB_CA"),
+		CertFile:   os.Getenv("TINKERDB_CLIENT_CERT"),
+		KeyFile:    os.Getenv("TINKERDB_CLIENT_KEY"),
+		ServerName: os.Getenv("TINKERDB_SERVER_NAME"),
+
+		Sharded: os.Getenv("TINKERDB_SHARDED") == "true",
 	}
 
 	c, err := client.NewClient(cfg)
@@ -33,11 +48,16 @@ func main() {
 	fmt.Printf("\nConnected to: %s\n", cfg.Address)
 	fmt.Printf("Current tenant: %s\n", c.GetTenant())
 	fmt.Println("\nCommands:")
-	fmt.Println("  set <key> <value>  - Set a key-value pair")
+	fmt.Println("  set <key> <value> [ex <seconds>] - Set a key-value pair, optionally expiring")
 	fmt.Println("  get <key>          - Get value for a key")
+	fmt.Println("  mget <key>...      - Get values for several keys")
 	fmt.Println("  delete <key>       - Delete a key")
 	fmt.Println("  exists <key>       - Check if key exists")
+	fmt.Println("  expire <key> <sec> - Expire a key after a number of seconds")
+	fmt.Println("  persist <key>      - Remove the expiry from a key")
+	fmt.Println("  ttl <key>          - Show the time left before a key expires")
 	fmt.Println("  keys               - List all keys")
+	fmt.Println("  scan <prefix> [limit] [rev] - List keys with a prefix, in pages")
 	fmt.Println("  tenant <id>        - Switch tenant (or show current)")
 	fmt.Println("  help               - Show this help")
 	fmt.Println("  quit               - Exit")
@@ -60,14 +80,30 @@ func main() {
 		switch cmd {
 		case "set":
 			if len(parts) < 3 {
-				fmt.Println("❌ Usage: set <key> <value>")
+				fmt.Println("❌ Usage: set <key> <value> [ex <seconds>]")
 				continue
 			}
 			key := parts[1]
-			value := strings.Join(parts[2:], " ")
-			err := c.SetString(ctx, key, value)
+			valueParts := parts[2:]
+
+			// A trailing "ex <seconds>" sets a TTL, like Redis SET ... EX
+			var ttl time.Duration
+			if n := len(valueParts); n >= 3 && strings.EqualFold(valueParts[n-2], "ex") {
+				seconds, err := strconv.Atoi(valueParts[n-1])
+				if err != nil || seconds <= 0 {
+					fmt.Println("❌ Usage: set <key> <value> [ex <seconds>]")
+					continue
+				}
+				ttl = time.Duration(seconds) * time.Second
+				valueParts = valueParts[:n-2]
+			}
+
+			value := strings.Join(valueParts, " ")
+			err := c.SetWithTTL(ctx, key, []byte(value), ttl)
 			if err != nil {
 				fmt.Printf("❌ Error: %v\n", err)
+			} else if ttl > 0 {
+				fmt.Printf("✓ Set '%s' = '%s' (expires in %v)\n", key, value, ttl)
 			} else {
 				fmt.Printf("✓ Set '%s' = '%s'\n", key, value)
 			}
@@ -78,11 +114,31 @@ func main() {
 				continue
 			}
 			key := parts[1]
-			value, err := c.GetString(ctx, key)
-			if err != nil {
+			value, version, err := c.GetWithVersion(ctx, key)
+			if errors.Is(err, client.ErrNotFound) {
+				fmt.Printf("  %s not found\n", key)
+			} else if err != nil {
 				fmt.Printf("❌ Error: %v\n", err)
 			} else {
-				fmt.Printf("✓ %s = '%s'\n", key, value)
+				fmt.Printf("✓ %s = '%s' (version %d)\n", key, value, version)
+			}
+
+		case "mget":
+			if len(parts) < 2 {
+				fmt.Println("❌ Usage: mget <key>...")
+				continue
+			}
+			results, err := c.MGet(ctx, parts[1:])
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				continue
+			}
+			for _, r := range results {
+				if r.Found {
+					fmt.Printf("  %s = '%s' (version %d)\n", r.Key, r.Value, r.Version)
+				} else {
+					fmt.Printf("  %s not found\n", r.Key)
+				}
 			}
 
 		case "delete":
@@ -115,8 +171,61 @@ func main() {
 				}
 			}
 
+		case "expire":
+			if len(parts) < 3 {
+				fmt.Println("❌ Usage: expire <key> <seconds>")
+				continue
+			}
+			key := parts[1]
+			seconds, err := strconv.Atoi(parts[2])
+			if err != nil || seconds <= 0 {
+				fmt.Println("❌ Seconds must be a positive number")
+				continue
+			}
+			ttl := time.Duration(seconds) * time.Second
+			if err := c.Expire(ctx, key, ttl); err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+			} else {
+				fmt.Printf("✓ '%s' expires in %v\n", key, ttl)
+			}
+
+		case "persist":
+			if len(parts) < 2 {
+				fmt.Println("❌ Usage: persist <key>")
+				continue
+			}
+			key := parts[1]
+			if err := c.Persist(ctx, key); err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+			} else {
+				fmt.Printf("✓ '%s' no longer expires\n", key)
+			}
+
+		case "ttl":
+			if len(parts) < 2 {
+				fmt.Println("❌ Usage: ttl <key>")
+				continue
+			}
+			key := parts[1]
+			ttl, err := c.TTL(ctx, key)
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+			} else if ttl == client.NoExpiry {
+				fmt.Printf("✓ '%s' does not expire\n", key)
+			} else {
+				fmt.Printf("✓ '%s' expires in %v\n", key, ttl.Round(time.Millisecond))
+			}
+
 		case "keys":
-			keys, err := c.Keys(ctx)
+			var keys []string
+			var err error
+			for item, streamErr := range c.ScanStream(ctx, client.ScanOptions{KeysOnly: true}) {
+				if streamErr != nil {
+					err = streamErr
+					break
+				}
+				keys = append(keys, item.Key)
+			}
 			if err != nil {
 				fmt.Printf("❌ Error: %v\n", err)
 			} else {
@@ -130,6 +239,44 @@ func main() {
 				}
 			}
 
+		case "scan":
+			opts := client.ScanOptions{Limit: 10}
+			if len(parts) > 1 {
+				opts.Prefix = parts[1]
+			}
+			if len(parts) > 2 {
+				limit, err := strconv.Atoi(parts[2])
+				if err != nil || limit <= 0 {
+					fmt.Println("❌ Usage: scan <prefix> [limit] [rev]")
+					continue
+				}
+				opts.Limit = limit
+			}
+			opts.Reverse = len(parts) > 3 && parts[3] == "rev"
+
+			// Show one page at a time, Enter fetches the next
+			for {
+				page, err := c.Scan(ctx, opts)
+				if err != nil {
+					fmt.Printf("❌ Error: %v\n", err)
+					break
+				}
+				if len(page.Items) == 0 {
+					fmt.Println("No keys found")
+				}
+				for _, item := range page.Items {
+					fmt.Printf("  %s = '%s' (version %d)\n", item.Key, item.Value, item.Version)
+				}
+				if page.Cursor == "" {
+					break
+				}
+				fmt.Print("-- more, press Enter (q to stop) -- ")
+				if !scanner.Scan() || strings.TrimSpace(scanner.Text()) == "q" {
+					break
+				}
+				opts.Cursor = page.Cursor
+			}
+
 		case "tenant":
 			if len(parts) < 2 {
 				fmt.Printf("Current tenant: %s\n", c.GetTenant())
@@ -140,11 +287,16 @@ func main() {
 
 		case "help":
 			fmt.Println("\nCommands:")
-			fmt.Println("  set <key> <value>  - Set a key-value pair")
+			fmt.Println("  set <key> <value> [ex <seconds>] - Set a key-value pair, optionally expiring")
 			fmt.Println("  get <key>          - Get value for a key")
+			fmt.Println("  mget <key>...      - Get values for several keys")
 			fmt.Println("  delete <key>       - Delete a key")
 			fmt.Println("  exists <key>       - Check if key exists")
+			fmt.Println("  expire <key> <sec> - Expire a key after a number of seconds")
+			fmt.Println("  persist <key>      - Remove the expiry from a key")
+			fmt.Println("  ttl <key>          - Show the time left before a key expires")
 			fmt.Println("  keys               - List all keys")
+			fmt.Println("  scan <prefix> [limit] [rev] - List keys with a prefix, in pages")
 			fmt.Println("  tenant <id>        - Switch tenant (or show current)")
 			fmt.Println("  help               - Show this help")
 			fmt.Println("  quit               - Exit")
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Identity is who a call was authenticated as and what it may access
+type Identity struct {
+	Name        string
+	TokenID     string       // Empty unless authenticated with an issued token
+	User        string       // User whose roles apply, empty for a token bound to tenants
+	Admin       bool         // Whether the caller may use every service and tenant
+	Permissions []Permission // What the caller may access, unused for admins
+}
+
+// Allows reports whether the identity has any access to tenantID
+func (id *Identity) Allows(tenantID string) bool {
+	if id.Admin {
+		return true
+	}
+	for _, p := range id.Permissions {
+		if p.appliesTo(tenantID, AccessRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultTenant returns the tenant of a call that names none: the only tenant
+// the identity has access to. It reports false if there is no single tenant.
+func (id *Identity) DefaultTenant() (string, bool) {
+	if id.Admin {
+		return "", false
+	}
+	tenant := ""
+	for _, p := range id.Permissions {
+		if p.Tenant == AllTenants || (tenant != "" && p.Tenant != tenant) {
+			return "", false
+		}
+		tenant = p.Tenant
+	}
+	return tenant, tenant != ""
+}
+
+// Can reports whether the identity has access to key of tenantID
+func (id *Identity) Can(tenantID, key string, access Access) bool {
+	if id.Admin {
+		return true
+	}
+	for _, p := range id.Permissions {
+		if p.appliesTo(tenantID, access) && strings.HasPrefix(key, p.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRange reports whether the identity has access to every key of tenantID
+// in [start, end) that starts with prefix. An empty end means no upper bound.
+func (id *Identity) CanRange(tenantID, start, end, prefix string, access Access) bool {
+	if id.Admin {
+		return true
+	}
+	for _, p := range id.Permissions {
+		if p.appliesTo(tenantID, access) && p.coversRange(start, end, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Administers reports whether the identity may manage the roles of tenantID
+func (id *Identity) Administers(tenantID string) bool {
+	return id.CanRange(tenantID, "", "", "", AccessAdmin)
+}
+
+type identityKey struct{}
+
+// NewContext returns a context carrying id
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the call ctx belongs to, if it was
+// authenticated
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+// reloadBackoff is the wait between attempts to reload a mirror whose watch
+// failed
+const reloadBackoff = time.Second
+
+// mirror keeps a decoded in-memory copy of the records stored under a key
+// prefix of the auth tenant. A watch keeps it current, so it also follows
+// changes made through another handle on the same store.
+type mirror[T any] struct {
+	store  *storage.Store
+	prefix string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.RWMutex
+	items     map[string]*T     // By key without the prefix
+	revisions map[string]uint64 // Revision of the last change applied to each key, kept for removed keys
+	onChange  func()            // Called without mu held
+}
+
+// openMirror loads the records under prefix and starts following changes.
+// onChange, if not nil, is called after every change.
+func openMirror[T any](store *storage.Store, prefix string, onChange func()) (*mirror[T], error) {
+	m := &mirror[T]{
+		store:     store,
+		prefix:    prefix,
+		done:      make(chan struct{}),
+		items:     make(map[string]*T),
+		revisions: make(map[string]uint64),
+		onChange:  onChange,
+	}
+
+	w, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	go m.run(ctx, w)
+	return m, nil
+}
+
+// load reads every record from a consistent view and returns a watcher that
+// reports the changes made after it
+func (m *mirror[T]) load() (*storage.Watcher, error) {
+	sc, err := m.store.NewScanner(Tenant, storage.ScanOptions{Prefix: m.prefix})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s records: %w", m.prefix, err)
+	}
+	defer sc.Close()
+
+	w, err := m.store.Watch(Tenant, storage.WatchOptions{Key: m.prefix, Prefix: true, FromRevision: sc.Revision() + 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s records: %w", m.prefix, err)
+	}
+
+	seen := make(map[string]bool)
+	for {
+		items, err := sc.Next(storage.DefaultScanLimit)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("failed to read %s records: %w", m.prefix, err)
+		}
+		for _, kv := range items {
+			seen[kv.Key] = true
+			m.apply(kv.Key, kv.Value, kv.Version)
+		}
+	}
+
+	// Records missing from the view were removed by the time it was taken
+	m.mu.RLock()
+	var removed []string
+	for id := range m.items {
+		if !seen[m.prefix+id] {
+			removed = append(removed, m.prefix+id)
+		}
+	}
+	m.mu.RUnlock()
+	for _, key := range removed {
+		m.apply(key, nil, sc.Revision())
+	}
+	return w, nil
+}
+
+// run applies watched changes until the mirror is closed. A watcher that
+// fails, for instance because it fell behind, is replaced after a reload.
+func (m *mirror[T]) run(ctx context.Context, w *storage.Watcher) {
+	defer close(m.done)
+
+	for {
+		events, err := w.Next(ctx)
+		if ctx.Err() != nil {
+			w.Close()
+			return
+		}
+		if err != nil {
+			slog.Warn("Reloading auth records after watch failed", "prefix", m.prefix, "err", err)
+			w.Close()
+			for {
+				if w, err = m.load(); err == nil {
+					break
+				}
+				slog.Error("Failed to reload auth records", "prefix", m.prefix, "err", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(reloadBackoff):
+				}
+			}
+			continue
+		}
+
+		for _, ev := range events {
+			var value []byte
+			if ev.Type == storage.EventPut {
+				value = ev.Value
+			}
+			m.apply(ev.Key, value, ev.Revision)
+		}
+	}
+}
+
+// apply records the value a key took at revision, nil for a removed key.
+// Changes older than the last one applied to the key are ignored, since
+// local writes are applied before their watch event arrives.
+func (m *mirror[T]) apply(key string, value []byte, revision uint64) {
+	id, ok := strings.CutPrefix(key, m.prefix)
+	if !ok {
+		return
+	}
+
+	var item *T
+	if value != nil {
+		item = new(T)
+		if err := json.Unmarshal(value, item); err != nil {
+			slog.Warn("Ignoring malformed auth record", "key", key, "err", err)
+			item = nil
+		}
+	}
+
+	m.mu.Lock()
+	if revision <= m.revisions[id] {
+		m.mu.Unlock()
+		return
+	}
+	m.revisions[id] = revision
+	if item == nil {
+		delete(m.items, id)
+	} else {
+		m.items[id] = item
+	}
+	m.mu.Unlock()
+
+	if m.onChange != nil {
+		m.onChange()
+	}
+}
+
+// get returns the record with id, nil if there is none. The record is shared
+// and must not be modified.
+func (m *mirror[T]) get(id string) *T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.items[id]
+}
+
+// list returns every record, in no particular order
+func (m *mirror[T]) list() []*T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := make([]*T, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	return items
+}
+
+// put stores the record with id and applies it right away
+func (m *mirror[T]) put(id string, item *T) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode auth record: %w", err)
+	}
+	revision, err := m.store.SetWithOptions(Tenant, m.prefix+id, data, storage.SetOptions{})
+	if err != nil {
+		return err
+	}
+	m.apply(m.prefix+id, data, revision)
+	return nil
+}
+
+// remove deletes the record with id and reports whether it existed
+func (m *mirror[T]) remove(id string) (bool, error) {
+	existed, err := m.store.Delete(Tenant, m.prefix+id)
+	if err != nil {
+		return false, err
+	}
+	// The current revision is at least the deletion's
+	m.apply(m.prefix+id, nil, m.store.Revision())
+	return existed, nil
+}
+
+// close stops following changes
+func (m *mirror[T]) close() {
+	m.cancel()
+	<-m.done
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+// Access is a level of access to keys. Each level includes the ones below it.
+type Access int
+
+const (
+	AccessRead  Access = iota + 1 // Read keys
+	AccessWrite                   // Read and write keys
+	AccessAdmin                   // Read and write keys, and manage the roles of the tenant
+)
+
+// String returns the name used for the access level in permissions
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessAdmin:
+		return "admin"
+	}
+	return fmt.Sprintf("Access(%d)", int(a))
+}
+
+// ParseAccess parses read, write or admin
+func ParseAccess(name string) (Access, error) {
+	switch name {
+	case "read":
+		return AccessRead, nil
+	case "write":
+		return AccessWrite, nil
+	case "admin":
+		return AccessAdmin, nil
+	}
+	return 0, storage.Errorf(storage.ErrInvalidArgument, "unknown access %q, expected read, write or admin", name)
+}
+
+func (a Access) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Access) UnmarshalText(text []byte) error {
+	access, err := ParseAccess(string(text))
+	if err != nil {
+		return err
+	}
+	*a = access
+	return nil
+}
+
+// Permission grants access to the keys of a tenant that start with a prefix
+type Permission struct {
+	Tenant string `json:"tenant"` // AllTenants for every tenant
+	Prefix string `json:"prefix"` // Empty for every key
+	Access Access `json:"access"`
+}
+
+// String formats the permission as tenant:prefix:access
+func (p Permission) String() string {
+	return p.Tenant + ":" + p.Prefix + ":" + p.Access.String()
+}
+
+// ParsePermission parses tenant:prefix:access, the prefix may be empty
+func ParsePermission(s string) (Permission, error) {
+	first := strings.Index(s, ":")
+	last := strings.LastIndex(s, ":")
+	if first < 0 || first == last {
+		return Permission{}, storage.Errorf(storage.ErrInvalidArgument, "invalid permission %q, expected tenant:prefix:access", s)
+	}
+	access, err := ParseAccess(s[last+1:])
+	if err != nil {
+		return Permission{}, err
+	}
+	p := Permission{Tenant: s[:first], Prefix: s[first+1 : last], Access: access}
+	return p, p.Validate()
+}
+
+// Validate rejects permissions on no tenant, on system tenants, or without
+// an access level
+func (p Permission) Validate() error {
+	if p.Tenant != AllTenants {
+		if err := ValidateTenant(p.Tenant); err != nil {
+			return err
+		}
+	}
+	if p.Access < AccessRead || p.Access > AccessAdmin {
+		return storage.Errorf(storage.ErrInvalidArgument, "permission on %q has no access level", p.Tenant)
+	}
+	return nil
+}
+
+// appliesTo reports whether the permission covers tenantID with at least
+// access
+func (p Permission) appliesTo(tenantID string, access Access) bool {
+	return (p.Tenant == tenantID || p.Tenant == AllTenants) && p.Access >= access
+}
+
+// coversRange reports whether every key in [start, end) that starts with
+// prefix also starts with the permission's prefix. An empty end means no
+// upper bound.
+func (p Permission) coversRange(start, end, prefix string) bool {
+	if strings.HasPrefix(prefix, p.Prefix) {
+		return true
+	}
+
+	// Otherwise the range itself must lie within the permission's prefix
+	if prefix != "" {
+		start = max(start, prefix)
+		if prefixEnd := storage.PrefixEnd(prefix); prefixEnd != "" && (end == "" || prefixEnd < end) {
+			end = prefixEnd
+		}
+	}
+	limit := storage.PrefixEnd(p.Prefix)
+	return strings.HasPrefix(start, p.Prefix) && end != "" && limit != "" && end <= limit
+}
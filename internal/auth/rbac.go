@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+const (
+	rolePrefix = "role/"
+	userPrefix = "user/"
+)
+
+var (
+	// ErrRoleNotFound is returned for a role that does not exist
+	ErrRoleNotFound = errors.New("role not found")
+
+	// ErrUserNotFound is returned for a user that does not exist
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// Role is a named set of permissions
+type Role struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Tenants returns the tenants the role grants access to, without repeats
+func (r *Role) Tenants() []string {
+	var tenants []string
+	for _, p := range r.Permissions {
+		if !slices.Contains(tenants, p.Tenant) {
+			tenants = append(tenants, p.Tenant)
+		}
+	}
+	return tenants
+}
+
+// User is a principal that acts with the permissions of its roles
+type User struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// RBAC keeps users and roles and resolves the permissions of users. Resolved
+// permissions are cached until a user or role changes, so authorizing a call
+// does not decode or walk the roles.
+type RBAC struct {
+	roles *mirror[Role]
+	users *mirror[User]
+
+	writeMu sync.Mutex // Serializes updates that read a user or role first
+
+	cacheMu    sync.Mutex
+	cache      map[string][]Permission // By user
+	generation uint64                  // Bumped by every change, so stale results are not cached
+}
+
+// OpenRBAC loads the users and roles stored in store and keeps following
+// changes to them until Close
+func OpenRBAC(store *storage.Store) (*RBAC, error) {
+	r := &RBAC{cache: make(map[string][]Permission)}
+
+	var err error
+	if r.roles, err = openMirror[Role](store, rolePrefix, r.invalidate); err != nil {
+		return nil, err
+	}
+	if r.users, err = openMirror[User](store, userPrefix, r.invalidate); err != nil {
+		r.roles.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// PutRole creates or replaces a role
+func (r *RBAC) PutRole(role Role) error {
+	if role.Name == "" {
+		return storage.Errorf(storage.ErrInvalidArgument, "role name cannot be empty")
+	}
+	for _, p := range role.Permissions {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	role.Permissions = slices.Clone(role.Permissions)
+	if err := r.roles.put(role.Name, &role); err != nil {
+		return fmt.Errorf("failed to store role: %w", err)
+	}
+	return nil
+}
+
+// DeleteRole removes a role and takes it away from every user
+func (r *RBAC) DeleteRole(name string) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	existed, err := r.roles.remove(name)
+	if err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+	if !existed {
+		return ErrRoleNotFound
+	}
+
+	for _, user := range r.users.list() {
+		if !slices.Contains(user.Roles, name) {
+			continue
+		}
+		updated := &User{Name: user.Name, Roles: slices.DeleteFunc(slices.Clone(user.Roles), func(role string) bool { return role == name })}
+		if err := r.users.put(user.Name, updated); err != nil {
+			return fmt.Errorf("failed to update user %s: %w", user.Name, err)
+		}
+	}
+	return nil
+}
+
+// Role returns the role called name, nil if there is none. It must not be
+// modified.
+func (r *RBAC) Role(name string) *Role {
+	return r.roles.get(name)
+}
+
+// Roles returns every role, by name
+func (r *RBAC) Roles() []*Role {
+	roles := r.roles.list()
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+// Grant gives role to user, creating the user if needed
+func (r *RBAC) Grant(user, role string) error {
+	if user == "" {
+		return storage.Errorf(storage.ErrInvalidArgument, "user name cannot be empty")
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	if r.roles.get(role) == nil {
+		return ErrRoleNotFound
+	}
+	updated := &User{Name: user}
+	if current := r.users.get(user); current != nil {
+		if slices.Contains(current.Roles, role) {
+			return nil
+		}
+		updated.Roles = slices.Clone(current.Roles)
+	}
+	updated.Roles = append(updated.Roles, role)
+
+	if err := r.users.put(user, updated); err != nil {
+		return fmt.Errorf("failed to store user: %w", err)
+	}
+	return nil
+}
+
+// Revoke takes role away from user
+func (r *RBAC) Revoke(user, role string) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	current := r.users.get(user)
+	if current == nil {
+		return ErrUserNotFound
+	}
+	if !slices.Contains(current.Roles, role) {
+		return ErrRoleNotFound
+	}
+	updated := &User{Name: user, Roles: slices.DeleteFunc(slices.Clone(current.Roles), func(name string) bool { return name == role })}
+
+	if err := r.users.put(user, updated); err != nil {
+		return fmt.Errorf("failed to store user: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser removes a user. Tokens issued for the user stop granting
+// anything but are not revoked.
+func (r *RBAC) DeleteUser(name string) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	existed, err := r.users.remove(name)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if !existed {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// User returns the user called name, nil if there is none. It must not be
+// modified.
+func (r *RBAC) User(name string) *User {
+	return r.users.get(name)
+}
+
+// Users returns every user, by name
+func (r *RBAC) Users() []*User {
+	users := r.users.list()
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// Permissions returns the permissions of every role of user. The result is
+// shared and must not be modified.
+func (r *RBAC) Permissions(user string) []Permission {
+	r.cacheMu.Lock()
+	permissions, ok := r.cache[user]
+	generation := r.generation
+	r.cacheMu.Unlock()
+	if ok {
+		return permissions
+	}
+
+	if u := r.users.get(user); u != nil {
+		for _, name := range u.Roles {
+			// Roles deleted since they were granted grant nothing
+			if role := r.roles.get(name); role != nil {
+				permissions = append(permissions, role.Permissions...)
+			}
+		}
+	}
+
+	r.cacheMu.Lock()
+	if r.generation == generation {
+		r.cache[user] = permissions
+	}
+	r.cacheMu.Unlock()
+	return permissions
+}
+
+// invalidate drops every cached resolution after a user or role changed
+func (r *RBAC) invalidate() {
+	r.cacheMu.Lock()
+	r.generation++
+	clear(r.cache)
+	r.cacheMu.Unlock()
+}
+
+// TokenIdentity returns the identity a token authenticates as
+func (r *RBAC) TokenIdentity(t *Token) *Identity {
+	id := &Identity{Name: t.Name, TokenID: t.ID, User: t.User, Admin: t.Admin}
+	switch {
+	case t.Admin:
+	case t.User != "":
+		id.Permissions = r.Permissions(t.User)
+	default:
+		// A token bound to tenants may read and write all of their keys
+		for _, tenant := range t.Tenants {
+			id.Permissions = append(id.Permissions, Permission{Tenant: tenant, Access: AccessWrite})
+		}
+	}
+	return id
+}
+
+// UserIdentity returns the identity of a user authenticated by other means
+// than a token
+func (r *RBAC) UserIdentity(user string) *Identity {
+	return &Identity{Name: user, User: user, Permissions: r.Permissions(user)}
+}
+
+// Close stops following changes to users and roles
+func (r *RBAC) Close() {
+	r.roles.close()
+	r.users.close()
+}
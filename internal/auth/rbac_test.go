@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+func openTestRBAC(t *testing.T, store *storage.Store) *RBAC {
+	t.Helper()
+
+	rbac, err := OpenRBAC(store)
+	if err != nil {
+		t.Fatalf("OpenRBAC failed: %v", err)
+	}
+	t.Cleanup(rbac.Close)
+	return rbac
+}
+
+func TestParsePermission(t *testing.T) {
+	p, err := ParsePermission("orders:eu:region:write")
+	if err != nil {
+		t.Fatalf("ParsePermission failed: %v", err)
+	}
+	if p.Tenant != "orders" || p.Prefix != "eu:region" || p.Access != AccessWrite {
+		t.Fatalf("Unexpected permission: %+v", p)
+	}
+	if p.String() != "orders:eu:region:write" {
+		t.Fatalf("Expected the permission to format back, got %s", p)
+	}
+
+	p, err = ParsePermission("orders::read")
+	if err != nil || p.Prefix != "" || p.Access != AccessRead {
+		t.Fatalf("Expected a read permission on the whole tenant, got %+v %v", p, err)
+	}
+
+	for _, bad := range []string{"orders", "orders:read", "orders::owner", ":x:read", Tenant + "::read"} {
+		if _, err := ParsePermission(bad); !errors.Is(err, storage.ErrInvalidArgument) {
+			t.Fatalf("Expected ErrInvalidArgument for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestIdentity_Permissions(t *testing.T) {
+	id := &Identity{Permissions: []Permission{
+		{Tenant: "orders", Prefix: "", Access: AccessRead},
+		{Tenant: "orders", Prefix: "eu:", Access: AccessWrite},
+	}}
+
+	tests := []struct {
+		key    string
+		access Access
+		want   bool
+	}{
+		{"us:1", AccessRead, true},
+		{"us:1", AccessWrite, false},
+		{"eu:1", AccessWrite, true},
+		{"eu:1", AccessAdmin, false},
+	}
+	for _, tt := range tests {
+		if got := id.Can("orders", tt.key, tt.access); got != tt.want {
+			t.Fatalf("Can(%q, %s) = %v, expected %v", tt.key, tt.access, got, tt.want)
+		}
+	}
+	if id.Can("billing", "eu:1", AccessRead) {
+		t.Fatal("Expected no access to another tenant")
+	}
+
+	if tenant, ok := id.DefaultTenant(); !ok || tenant != "orders" {
+		t.Fatalf("Expected default tenant orders, got %q %v", tenant, ok)
+	}
+	if !id.Allows("orders") || id.Allows("billing") {
+		t.Fatal("Expected only orders to be allowed")
+	}
+}
+
+func TestIdentity_CanRange(t *testing.T) {
+	id := &Identity{Permissions: []Permission{{Tenant: "orders", Prefix: "eu:", Access: AccessRead}}}
+
+	tests := []struct {
+		start, end, prefix string
+		want               bool
+	}{
+		{"", "", "eu:", true},
+		{"", "", "eu:de:", true},
+		{"", "", "", false},
+		{"", "", "e", false},
+		{"eu:a", "eu:z", "", true},
+		{"eu:a", "", "", false},
+		{"eu:a", "eu;", "", true},
+		{"eu:a", "ev", "", false},
+		{"a", "eu:z", "", false},
+		{"eu:a", "", "eu:", true},
+	}
+	for _, tt := range tests {
+		if got := id.CanRange("orders", tt.start, tt.end, tt.prefix, AccessRead); got != tt.want {
+			t.Fatalf("CanRange(%q, %q, %q) = %v, expected %v", tt.start, tt.end, tt.prefix, got, tt.want)
+		}
+	}
+
+	if !(&Identity{Admin: true}).CanRange("any", "", "", "", AccessAdmin) {
+		t.Fatal("Expected an admin to access everything")
+	}
+}
+
+func TestRBAC_GrantRevoke(t *testing.T) {
+	rbac := openTestRBAC(t, storage.NewStore())
+
+	if err := rbac.Grant("alice", "missing"); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("Expected ErrRoleNotFound granting a missing role, got %v", err)
+	}
+
+	analyst := Role{Name: "analyst", Permissions: []Permission{{Tenant: "orders", Access: AccessRead}}}
+	if err := rbac.PutRole(analyst); err != nil {
+		t.Fatalf("PutRole failed: %v", err)
+	}
+	if err := rbac.Grant("alice", "analyst"); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+	if perms := rbac.Permissions("alice"); len(perms) != 1 || perms[0].Access != AccessRead {
+		t.Fatalf("Expected the analyst permissions, got %v", perms)
+	}
+
+	// Changing the role is seen through the cache
+	analyst.Permissions[0].Access = AccessWrite
+	rbac.PutRole(analyst)
+	if perms := rbac.Permissions("alice"); len(perms) != 1 || perms[0].Access != AccessWrite {
+		t.Fatalf("Expected the updated permissions, got %v", perms)
+	}
+
+	if err := rbac.Revoke("alice", "analyst"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if perms := rbac.Permissions("alice"); len(perms) != 0 {
+		t.Fatalf("Expected no permissions after revoking, got %v", perms)
+	}
+	if err := rbac.Revoke("alice", "analyst"); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("Expected ErrRoleNotFound revoking twice, got %v", err)
+	}
+	if err := rbac.Revoke("bob", "analyst"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestRBAC_DeleteRole(t *testing.T) {
+	rbac := openTestRBAC(t, storage.NewStore())
+
+	rbac.PutRole(Role{Name: "writer", Permissions: []Permission{{Tenant: "orders", Access: AccessWrite}}})
+	rbac.PutRole(Role{Name: "reader", Permissions: []Permission{{Tenant: "billing", Access: AccessRead}}})
+	rbac.Grant("alice", "writer")
+	rbac.Grant("alice", "reader")
+
+	if err := rbac.DeleteRole("writer"); err != nil {
+		t.Fatalf("DeleteRole failed: %v", err)
+	}
+	if user := rbac.User("alice"); len(user.Roles) != 1 || user.Roles[0] != "reader" {
+		t.Fatalf("Expected the role to be taken away, got %v", user.Roles)
+	}
+	if perms := rbac.Permissions("alice"); len(perms) != 1 || perms[0].Tenant != "billing" {
+		t.Fatalf("Expected only the reader permissions, got %v", perms)
+	}
+	if err := rbac.DeleteRole("writer"); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("Expected ErrRoleNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestRBAC_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	rbac, err := OpenRBAC(store)
+	if err != nil {
+		t.Fatalf("OpenRBAC failed: %v", err)
+	}
+	rbac.PutRole(Role{Name: "writer", Permissions: []Permission{{Tenant: "orders", Prefix: "eu:", Access: AccessWrite}}})
+	rbac.Grant("alice", "writer")
+	rbac.Close()
+	store.Close()
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+	rbac = openTestRBAC(t, store)
+
+	id := rbac.UserIdentity("alice")
+	if !id.Can("orders", "eu:1", AccessWrite) || id.Can("orders", "us:1", AccessRead) {
+		t.Fatalf("Expected the permissions to survive a restart, got %v", id.Permissions)
+	}
+}
+
+func TestRBAC_FollowsStoreChanges(t *testing.T) {
+	store := storage.NewStore()
+	writer := openTestRBAC(t, store)
+	reader := openTestRBAC(t, store)
+
+	// Resolve once so that the reader has a cached result to invalidate
+	if perms := reader.Permissions("alice"); len(perms) != 0 {
+		t.Fatalf("Expected no permissions yet, got %v", perms)
+	}
+
+	writer.PutRole(Role{Name: "reader", Permissions: []Permission{{Tenant: "orders", Access: AccessRead}}})
+	writer.Grant("alice", "reader")
+	waitFor(t, func() bool { return len(reader.Permissions("alice")) == 1 })
+}
+
+func TestRBAC_TokenIdentity(t *testing.T) {
+	rbac := openTestRBAC(t, storage.NewStore())
+	rbac.PutRole(Role{Name: "reader", Permissions: []Permission{{Tenant: "orders", Access: AccessRead}}})
+	rbac.Grant("alice", "reader")
+
+	id := rbac.TokenIdentity(&Token{ID: "1", Name: "app", Tenants: []string{"orders"}})
+	if !id.Can("orders", "key", AccessWrite) || id.Can("orders", "key", AccessAdmin) {
+		t.Fatalf("Expected a tenant token to read and write its tenant, got %v", id.Permissions)
+	}
+
+	id = rbac.TokenIdentity(&Token{ID: "2", Name: "alice", User: "alice"})
+	if !id.Can("orders", "key", AccessRead) || id.Can("orders", "key", AccessWrite) {
+		t.Fatalf("Expected a user token to act with the user's roles, got %v", id.Permissions)
+	}
+
+	id = rbac.TokenIdentity(&Token{ID: "3", Name: "root", Admin: true})
+	if !id.Admin || !id.Can("any", "key", AccessAdmin) {
+		t.Fatal("Expected an admin token to access everything")
+	}
+}
@@ -0,0 +1,179 @@
+// Package auth keeps the credentials clients authenticate with. They live in
+// a system tenant of the store, so they are exactly as durable as the data:
+// logged, snapshotted and recovered with it. Tokens are only stored hashed;
+// the token itself is returned once, when it is issued.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+const (
+	// Tenant is the system tenant holding authentication state
+	Tenant = storage.SystemTenantPrefix + "auth"
+
+	// AllTenants binds a token to every tenant
+	AllTenants = "*"
+
+	tokenPrefix = "token/"
+
+	// Tokens read "tdb_<id>_<secret>": the ID locates the stored hash without
+	// trying them all
+	tokenScheme = "tdb"
+	idBytes     = 8
+	secretBytes = 32
+)
+
+var (
+	// ErrTokenNotFound is returned when revoking a token that does not exist
+	ErrTokenNotFound = errors.New("token not found")
+
+	// ErrInvalidToken is returned for a token that was not issued or has been
+	// revoked
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Token is an issued API token. Only the hash of the token is kept.
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Tenants   []string  `json:"tenants,omitempty"` // Tenants the token may read and write, AllTenants for every tenant
+	User      string    `json:"user,omitempty"`    // User whose roles the token acts with, instead of tenants
+	Admin     bool      `json:"admin,omitempty"`   // Whether the token may use every service and tenant
+	Hash      []byte    `json:"hash"`              // SHA-256 of the whole token
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Tokens issues, revokes and verifies API tokens
+type Tokens struct {
+	tokens *mirror[Token]
+}
+
+// OpenTokens loads the tokens stored in store and keeps following changes to
+// them until Close
+func OpenTokens(store *storage.Store) (*Tokens, error) {
+	tokens, err := openMirror[Token](store, tokenPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{tokens: tokens}, nil
+}
+
+// Issue creates a token as described by spec, of which only the name and
+// the tenants, user or admin flag it is bound to are used. It returns the
+// token along with its record. A token is bound to exactly one of: one or
+// more tenants, a user, or admin rights.
+func (t *Tokens) Issue(spec Token) (string, *Token, error) {
+	bindings := 0
+	for _, bound := range []bool{len(spec.Tenants) > 0, spec.User != "", spec.Admin} {
+		if bound {
+			bindings++
+		}
+	}
+	if bindings != 1 {
+		return "", nil, storage.Errorf(storage.ErrInvalidArgument, "a token must be bound to tenants, a user or admin rights, and only one of them")
+	}
+	for _, tenant := range spec.Tenants {
+		if err := ValidateTenant(tenant); err != nil && tenant != AllTenants {
+			return "", nil, err
+		}
+	}
+
+	id := make([]byte, idBytes)
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	rec := &Token{
+		ID:        hex.EncodeToString(id),
+		Name:      spec.Name,
+		Tenants:   slices.Clone(spec.Tenants),
+		User:      spec.User,
+		Admin:     spec.Admin,
+		CreatedAt: time.Now().UTC(),
+	}
+	token := tokenScheme + "_" + rec.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(token))
+	rec.Hash = hash[:]
+
+	if err := t.tokens.put(rec.ID, rec); err != nil {
+		return "", nil, fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, rec, nil
+}
+
+// Revoke removes a token, it stops authenticating immediately
+func (t *Tokens) Revoke(id string) error {
+	existed, err := t.tokens.remove(id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if !existed {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// List returns every token, oldest first
+func (t *Tokens) List() []*Token {
+	tokens := t.tokens.list()
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens
+}
+
+// Authenticate returns the record of token, or ErrInvalidToken
+func (t *Tokens) Authenticate(token string) (*Token, error) {
+	scheme, rest, _ := strings.Cut(token, "_")
+	id, _, _ := strings.Cut(rest, "_")
+	if scheme != tokenScheme || id == "" {
+		return nil, ErrInvalidToken
+	}
+
+	rec := t.tokens.get(id)
+	if rec == nil {
+		return nil, ErrInvalidToken
+	}
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(hash[:], rec.Hash) != 1 {
+		return nil, ErrInvalidToken
+	}
+	return rec, nil
+}
+
+// Close stops following changes to the stored tokens
+func (t *Tokens) Close() {
+	t.tokens.close()
+}
+
+// ValidateTenant rejects tenant IDs a client may not be bound to, such as
+// the system tenants
+func ValidateTenant(tenantID string) error {
+	if tenantID == "" {
+		return storage.Errorf(storage.ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if storage.IsSystemTenant(tenantID) {
+		return storage.Errorf(storage.ErrInvalidArgument, "tenant IDs starting with %q are reserved", storage.SystemTenantPrefix)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+func openTestStore(t *testing.T, dir string) *storage.Store {
+	t.Helper()
+
+	opts := storage.DefaultOptions(dir)
+	opts.SnapshotInterval = 0
+	store, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return store
+}
+
+func TestTokens_IssueAuthenticateRevoke(t *testing.T) {
+	tokens, err := OpenTokens(storage.NewStore())
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+
+	token, rec, err := tokens.Issue(Token{Name: "app", Tenants: []string{"tenant1", "tenant2"}})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if !strings.HasPrefix(token, "tdb_"+rec.ID+"_") {
+		t.Fatalf("Expected the token to carry its ID %s, got %s", rec.ID, token)
+	}
+
+	got, err := tokens.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if got.ID != rec.ID || got.Name != "app" || len(got.Tenants) != 2 {
+		t.Fatalf("Unexpected token record: %+v", got)
+	}
+
+	// A token with the right ID but another secret is rejected
+	forged := "tdb_" + rec.ID + "_" + strings.Repeat("A", 43)
+	if _, err := tokens.Authenticate(forged); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected ErrInvalidToken for a forged token, got %v", err)
+	}
+	if _, err := tokens.Authenticate("garbage"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected ErrInvalidToken for garbage, got %v", err)
+	}
+
+	if err := tokens.Revoke(rec.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := tokens.Authenticate(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected a revoked token to be rejected, got %v", err)
+	}
+	if err := tokens.Revoke(rec.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("Expected ErrTokenNotFound revoking twice, got %v", err)
+	}
+}
+
+func TestTokens_IssueValidation(t *testing.T) {
+	tokens, err := OpenTokens(storage.NewStore())
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+
+	for _, tenants := range [][]string{nil, {""}, {Tenant}} {
+		if _, _, err := tokens.Issue(Token{Name: "bad", Tenants: tenants}); !errors.Is(err, storage.ErrInvalidArgument) {
+			t.Fatalf("Expected ErrInvalidArgument for tenants %q, got %v", tenants, err)
+		}
+	}
+
+	// Admin tokens need no tenant
+	if _, _, err := tokens.Issue(Token{Name: "admin", Admin: true}); err != nil {
+		t.Fatalf("Expected an admin token without tenants, got %v", err)
+	}
+}
+
+func TestTokens_OnlyHashIsStored(t *testing.T) {
+	store := storage.NewStore()
+	tokens, err := OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+
+	token, rec, err := tokens.Issue(Token{Name: "app", Tenants: []string{"tenant"}})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	data, found := store.Get(Tenant, tokenPrefix+rec.ID)
+	if !found {
+		t.Fatal("Expected the token record in the auth tenant")
+	}
+	secret := token[strings.LastIndex(token, "_")+1:]
+	if strings.Contains(string(data), secret) {
+		t.Fatalf("Expected the token secret not to be stored, got %s", data)
+	}
+}
+
+func TestTokens_SurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	tokens, err := OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	kept, _, _ := tokens.Issue(Token{Name: "kept", Tenants: []string{"tenant"}})
+	revoked, rec, _ := tokens.Issue(Token{Name: "revoked", Tenants: []string{"tenant"}})
+	tokens.Revoke(rec.ID)
+	tokens.Close()
+	store.Close()
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+	tokens, err = OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+
+	if _, err := tokens.Authenticate(kept); err != nil {
+		t.Fatalf("Expected the token to survive a restart, got %v", err)
+	}
+	if _, err := tokens.Authenticate(revoked); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected the revoked token to stay revoked, got %v", err)
+	}
+	if list := tokens.List(); len(list) != 1 || list[0].Name != "kept" {
+		t.Fatalf("Expected only the kept token to be listed, got %v", list)
+	}
+}
+
+func TestTokens_FollowStoreChanges(t *testing.T) {
+	store := storage.NewStore()
+	issuer, err := OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer issuer.Close()
+	verifier, err := OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer verifier.Close()
+
+	token, rec, err := issuer.Issue(Token{Name: "app", Tenants: []string{"tenant"}})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	waitFor(t, func() bool {
+		_, err := verifier.Authenticate(token)
+		return err == nil
+	})
+
+	issuer.Revoke(rec.ID)
+	waitFor(t, func() bool {
+		_, err := verifier.Authenticate(token)
+		return err != nil
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,144 @@
+// Package cdc publishes the changes committed to a storage.Store to external
+// sinks, in revision order and off the write path.
+//
+// The store hands each commit's changes, including the values they replaced,
+// to a Publisher, which queues them and writes them to every sink from a
+// background goroutine. After each successful write the sink's offset, the
+// last revision it holds, is checkpointed, so a restarted server resumes
+// every sink where it stopped. Changes committed before a crash but not yet
+// written are read back from the WAL; the WAL does not keep old values, so
+// those changes are marked with OldValueUnknown.
+package cdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+// Sink receives committed changes in revision order
+type Sink interface {
+	// Name identifies the sink's checkpoint, it must stay the same across
+	// restarts
+	Name() string
+
+	// Write stores a batch of changes. Once it returns nil the changes are
+	// checkpointed and will not be written again.
+	Write(changes []storage.Change) error
+
+	Close() error
+}
+
+// Record is the JSON form of a change
+type Record struct {
+	Revision        uint64 `json:"revision"`
+	Tenant          string `json:"tenant"`
+	Key             string `json:"key,omitempty"`
+	Op              string `json:"op"`
+	OldValue        []byte `json:"old_value"` // null if the key did not exist
+	NewValue        []byte `json:"new_value"` // null unless op is put
+	ExpireAt        int64  `json:"expire_at,omitempty"`
+	OldValueUnknown bool   `json:"old_value_unknown,omitempty"`
+}
+
+// NewRecord converts a change to its JSON form
+func NewRecord(c storage.Change) Record {
+	return Record{
+		Revision:        c.Revision,
+		Tenant:          c.Tenant,
+		Key:             c.Key,
+		Op:              c.Op.String(),
+		OldValue:        c.OldValue,
+		NewValue:        c.NewValue,
+		ExpireAt:        c.ExpireAt,
+		OldValueUnknown: c.OldValueUnknown,
+	}
+}
+
+// Offsets is a durable map from sink or consumer name to the last revision
+// it has processed, stored as a JSON file
+type Offsets struct {
+	path string
+
+	mu      sync.Mutex
+	offsets map[string]uint64
+}
+
+// OpenOffsets loads the offsets stored at path, starting empty if the file
+// does not exist
+func OpenOffsets(path string) (*Offsets, error) {
+	o := &Offsets{path: path, offsets: make(map[string]uint64)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offsets: %w", err)
+	}
+	if err := json.Unmarshal(data, &o.offsets); err != nil {
+		return nil, fmt.Errorf("failed to parse offsets %s: %w", path, err)
+	}
+	return o, nil
+}
+
+// Get returns the offset of name and whether one has been checkpointed
+func (o *Offsets) Get(name string) (uint64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	offset, ok := o.offsets[name]
+	return offset, ok
+}
+
+// Commit sets the offset of name and writes the offsets to disk. The file
+// is replaced atomically, so a crash leaves either the old or new offsets.
+func (o *Offsets) Commit(name string, offset uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.offsets[name] = offset
+	data, err := json.MarshalIndent(o.offsets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode offsets: %w", err)
+	}
+
+	tmp := o.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write offsets: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write offsets: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync offsets: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write offsets: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("failed to replace offsets: %w", err)
+	}
+	return syncDir(filepath.Dir(o.path))
+}
+
+// syncDir fsyncs a directory so that renames and new files in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory for sync: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package cdc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+const (
+	filePrefix = "changes-"
+	fileSuffix = ".jsonl"
+)
+
+// FileSinkOptions configures a FileSink
+type FileSinkOptions struct {
+	MaxFileSize int64 // Size in bytes after which a new file is started
+	MaxFiles    int   // Number of files kept, oldest deleted first; 0 keeps all
+}
+
+// DefaultFileSinkOptions returns the default file sizing and retention
+func DefaultFileSinkOptions() FileSinkOptions {
+	return FileSinkOptions{
+		MaxFileSize: 64 * 1024 * 1024,
+		MaxFiles:    16,
+	}
+}
+
+// FileSink writes changes as JSON lines, one Record per line, to files
+// named after the first revision they hold, so that the files sort in
+// revision order. Each batch is fsynced before it is checkpointed.
+type FileSink struct {
+	dir  string
+	opts FileSinkOptions
+
+	file *os.File
+	size int64
+}
+
+// OpenFileSink opens a file sink in dir, appending to its newest file. A
+// line left incomplete by a crash is discarded; the changes on it were not
+// checkpointed, so they are written again.
+func OpenFileSink(dir string, opts FileSinkOptions) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cdc file directory: %w", err)
+	}
+
+	s := &FileSink{dir: dir, opts: opts}
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return s, nil
+	}
+
+	path := files[len(files)-1]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cdc file: %w", err)
+	}
+	size := int64(bytes.LastIndexByte(data, '\n') + 1)
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cdc file: %w", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate cdc file: %w", err)
+	}
+	if _, err := f.Seek(size, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek cdc file: %w", err)
+	}
+	s.file = f
+	s.size = size
+	return s, nil
+}
+
+// Name implements Sink
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+// Write implements Sink
+func (s *FileSink) Write(changes []storage.Change) error {
+	if s.file == nil || (s.opts.MaxFileSize > 0 && s.size >= s.opts.MaxFileSize) {
+		if err := s.rotate(changes[0].Revision); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range changes {
+		if err := enc.Encode(NewRecord(c)); err != nil {
+			return fmt.Errorf("failed to encode change: %w", err)
+		}
+	}
+
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write cdc file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync cdc file: %w", err)
+	}
+	return nil
+}
+
+// rotate starts a new file for changes from revision first on and deletes
+// the oldest files beyond MaxFiles
+func (s *FileSink) rotate(first uint64) error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return fmt.Errorf("failed to close cdc file: %w", err)
+		}
+		s.file = nil
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", filePrefix, first, fileSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create cdc file: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = 0
+
+	if s.opts.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for len(files) > s.opts.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return fmt.Errorf("failed to remove old cdc file: %w", err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// files lists the sink's files, oldest first
+func (s *FileSink) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cdc files: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			files = append(files, filepath.Join(s.dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,278 @@
+package cdc
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+const (
+	// maxPending bounds the changes queued in memory. Past it the queue is
+	// dropped and the changes are read back from the WAL once the sinks
+	// catch up, without their old values.
+	maxPending = 100000
+
+	// maxBatch bounds the changes handed to a sink in one Write
+	maxBatch = 1000
+
+	// retryMin and retryMax bound the wait before a failed write is retried
+	retryMin = 100 * time.Millisecond
+	retryMax = 10 * time.Second
+)
+
+// Publisher delivers the changes committed to a store to its sinks
+type Publisher struct {
+	store   *storage.Store
+	sinks   []Sink
+	offsets *Offsets
+
+	mu     sync.Mutex
+	queue  []storage.Change
+	latest uint64 // Highest revision committed, queued or not
+
+	// next is the revision of the first change not yet written to every
+	// sink, only touched by the run loop
+	next uint64
+
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Start begins publishing the changes committed to store to sinks. Offsets
+// are checkpointed in dir. A sink without a checkpoint starts with the
+// changes committed after Start.
+func Start(store *storage.Store, dir string, offsets *Offsets, sinks ...Sink) (*Publisher, error) {
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no cdc sinks configured")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cdc directory: %w", err)
+	}
+	if offsets == nil {
+		var err error
+		offsets, err = OpenOffsets(filepath.Join(dir, "offsets.json"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := &Publisher{
+		store:   store,
+		sinks:   sinks,
+		offsets: offsets,
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	first := store.SetChangeHandler(p.enqueue)
+	p.next = first
+	p.latest = first - 1
+	for _, sink := range sinks {
+		offset, ok := offsets.Get(sinkKey(sink))
+		if !ok {
+			if err := offsets.Commit(sinkKey(sink), first-1); err != nil {
+				store.SetChangeHandler(nil)
+				return nil, err
+			}
+			continue
+		}
+		if offset+1 < p.next {
+			p.next = offset + 1
+		}
+	}
+	if p.next < first {
+		slog.Info("CDC resuming", "revision", p.next)
+	}
+
+	go p.run()
+	p.signal()
+	return p, nil
+}
+
+// sinkKey is the name a sink's offset is checkpointed under
+func sinkKey(sink Sink) string {
+	return "sink/" + sink.Name()
+}
+
+// enqueue is the store's change handler. It never blocks the committing
+// writer for longer than an append.
+func (p *Publisher) enqueue(changes []storage.Change) {
+	p.mu.Lock()
+	p.latest = changes[len(changes)-1].Revision
+	if len(p.queue)+len(changes) > maxPending {
+		// The run loop reads the dropped changes back from the WAL
+		p.queue = nil
+	} else {
+		p.queue = append(p.queue, changes...)
+	}
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *Publisher) signal() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run writes queued changes to the sinks until Close
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	retry := retryMin
+	for {
+		select {
+		case <-p.notify:
+		case <-p.stop:
+			// Deliver what was committed before Close, without retrying
+			for {
+				if progressed, err := p.deliver(); err != nil || !progressed {
+					if err != nil {
+						slog.Error("CDC delivery failed on close", "err", err)
+					}
+					return
+				}
+			}
+		}
+
+		for {
+			progressed, err := p.deliver()
+			if err != nil {
+				slog.Warn("CDC delivery failed, retrying", "retry_in", retry, "err", err)
+				select {
+				case <-time.After(retry):
+				case <-p.stop:
+					return
+				}
+				retry = min(2*retry, retryMax)
+				continue
+			}
+			retry = retryMin
+			if !progressed {
+				break
+			}
+		}
+	}
+}
+
+// deliver writes the next batch of changes to every sink. It reports
+// whether there was anything to write.
+func (p *Publisher) deliver() (bool, error) {
+	p.mu.Lock()
+	latest := p.latest
+	// Drop the changes written by the previous call
+	written := 0
+	for written < len(p.queue) && p.queue[written].Revision < p.next {
+		written++
+	}
+	p.queue = p.queue[written:]
+	var batch []storage.Change
+	if len(p.queue) > 0 && p.queue[0].Revision == p.next {
+		batch = p.queue[:min(len(p.queue), maxBatch)]
+		// Never split a revision between batches
+		for len(batch) < len(p.queue) && batch[len(batch)-1].Revision == p.queue[len(batch)].Revision {
+			batch = p.queue[:len(batch)+1]
+		}
+	}
+	gapEnd := latest
+	if len(p.queue) > 0 {
+		gapEnd = p.queue[0].Revision - 1
+	}
+	p.mu.Unlock()
+
+	if batch == nil {
+		if p.next > gapEnd {
+			return false, nil
+		}
+		// Changes missing from the queue are read back from the WAL
+		var err error
+		batch, err = p.replay(p.next, gapEnd)
+		if err != nil || len(batch) == 0 {
+			return err == nil, err
+		}
+	}
+
+	last := batch[len(batch)-1].Revision
+	for _, sink := range p.sinks {
+		offset, _ := p.offsets.Get(sinkKey(sink))
+		pending := batch
+		for len(pending) > 0 && pending[0].Revision <= offset {
+			pending = pending[1:]
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		if err := sink.Write(pending); err != nil {
+			return false, fmt.Errorf("sink %s: %w", sink.Name(), err)
+		}
+		if err := p.offsets.Commit(sinkKey(sink), last); err != nil {
+			return false, err
+		}
+	}
+	p.next = last + 1
+	return true, nil
+}
+
+// replay reads up to maxBatch changes from revision from to to from the WAL.
+// Revisions the WAL no longer holds are skipped with a warning.
+func (p *Publisher) replay(from, to uint64) ([]storage.Change, error) {
+	var batch []storage.Change
+	errFull := errors.New("batch full")
+	err := p.store.ReplayChanges(from, to, func(c storage.Change) error {
+		if len(batch) >= maxBatch && c.Revision != batch[len(batch)-1].Revision {
+			return errFull
+		}
+		batch = append(batch, c)
+		return nil
+	})
+	if errors.Is(err, storage.ErrCompacted) {
+		// Skip to the oldest change still retained, if it is in range
+		if retained := p.store.RetainedRevision(); retained > from && retained <= to {
+			to = retained - 1
+		}
+		slog.Warn("CDC changes are no longer in the WAL and were skipped", "from", from, "to", to)
+		return nil, p.skip(to)
+	}
+	if err != nil && !errors.Is(err, errFull) {
+		return nil, fmt.Errorf("failed to replay changes: %w", err)
+	}
+	return batch, nil
+}
+
+// skip moves every sink past revision to
+func (p *Publisher) skip(to uint64) error {
+	for _, sink := range p.sinks {
+		if offset, _ := p.offsets.Get(sinkKey(sink)); offset < to {
+			if err := p.offsets.Commit(sinkKey(sink), to); err != nil {
+				return err
+			}
+		}
+	}
+	p.next = to + 1
+	return nil
+}
+
+// Close stops the publisher after writing the changes already committed,
+// then closes the sinks. The store must still be open.
+func (p *Publisher) Close() error {
+	p.store.SetChangeHandler(nil)
+	close(p.stop)
+	<-p.done
+
+	var firstErr error
+	for _, sink := range p.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,172 @@
+package cdc
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+// memorySink records the changes written to it and can be made to fail
+type memorySink struct {
+	name string
+
+	mu      sync.Mutex
+	changes []storage.Change
+	fail    error
+}
+
+func (s *memorySink) Name() string { return s.name }
+
+func (s *memorySink) Write(changes []storage.Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func (s *memorySink) setFail(err error) {
+	s.mu.Lock()
+	s.fail = err
+	s.mu.Unlock()
+}
+
+func (s *memorySink) written() []storage.Change {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]storage.Change(nil), s.changes...)
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func openTestStore(t *testing.T, dir string) *storage.Store {
+	t.Helper()
+
+	opts := storage.DefaultOptions(dir)
+	opts.SnapshotInterval = 0
+	store, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return store
+}
+
+func TestPublisher_DeliversInOrder(t *testing.T) {
+	store := storage.NewStore()
+	sink := &memorySink{name: "memory"}
+	p, err := Start(store, t.TempDir(), nil, sink)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	store.Set("tenant", "key", []byte("v1"))
+	store.Set("tenant", "key", []byte("v2"))
+	store.Delete("tenant", "key")
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got := sink.written()
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 changes, got %+v", got)
+	}
+	if string(got[1].OldValue) != "v1" || string(got[1].NewValue) != "v2" || got[2].Op != storage.ChangeDelete {
+		t.Fatalf("Expected old and new values, got %+v", got)
+	}
+}
+
+func TestPublisher_RetriesFailedSink(t *testing.T) {
+	store := storage.NewStore()
+	sink := &memorySink{name: "memory"}
+	sink.setFail(errors.New("unavailable"))
+	p, err := Start(store, t.TempDir(), nil, sink)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer p.Close()
+
+	store.Set("tenant", "key", []byte("v1"))
+	time.Sleep(50 * time.Millisecond)
+	if len(sink.written()) != 0 {
+		t.Fatalf("Expected nothing written while the sink fails")
+	}
+
+	sink.setFail(nil)
+	waitFor(t, "the retried change", func() bool { return len(sink.written()) == 1 })
+}
+
+func TestPublisher_ResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	cdcDir := filepath.Join(dir, "cdc")
+
+	store := openTestStore(t, dir)
+	sink := &memorySink{name: "memory"}
+	p, err := Start(store, cdcDir, nil, sink)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	store.Set("tenant", "a", []byte("1"))
+	p.Close()
+
+	// Changes committed while no publisher runs are read back from the WAL
+	store.Set("tenant", "b", []byte("2"))
+	store.Set("tenant", "c", []byte("3"))
+	store.Close()
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+	sink = &memorySink{name: "memory"}
+	p, err = Start(store, cdcDir, nil, sink)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	store.Set("tenant", "d", []byte("4"))
+	p.Close()
+
+	got := sink.written()
+	if len(got) != 3 || got[0].Key != "b" || got[1].Key != "c" || got[2].Key != "d" {
+		t.Fatalf("Expected b, c and d, got %+v", got)
+	}
+	if !got[0].OldValueUnknown || got[2].OldValueUnknown {
+		t.Fatalf("Expected only replayed changes to have unknown old values, got %+v", got)
+	}
+}
+
+func TestPublisher_NewSinkStartsNow(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer store.Close()
+	store.Set("tenant", "before", []byte("1"))
+
+	sink := &memorySink{name: "memory"}
+	p, err := Start(store, filepath.Join(dir, "cdc"), nil, sink)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	store.Set("tenant", "after", []byte("2"))
+	p.Close()
+
+	got := sink.written()
+	if len(got) != 1 || got[0].Key != "after" {
+		t.Fatalf("Expected only the change after Start, got %+v", got)
+	}
+}
@@ -0,0 +1,234 @@
+package cdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+// streamHistory is the minimum number of recent changes a StreamSink keeps
+// in memory. Consumers further behind read from the WAL instead.
+const streamHistory = 4096
+
+// ErrSinkClosed is returned by Subscription.Next once the sink is closed
+var ErrSinkClosed = errors.New("cdc sink closed")
+
+// StreamSink serves the changes written to it to any number of consumers,
+// such as the CDC gRPC service. Each consumer reads at its own pace and
+// checkpoints its own offset; a consumer that has fallen out of the recent
+// history reads from the WAL until it catches up.
+type StreamSink struct {
+	store   *storage.Store
+	offsets *Offsets
+
+	mu      sync.Mutex
+	history []storage.Change
+	first   uint64        // Lowest revision whose changes are all in history, 0 before the first Write
+	last    uint64        // Highest revision written
+	changed chan struct{} // Closed and replaced on every Write
+	closed  bool
+}
+
+// NewStreamSink returns a stream sink serving the changes of store, with
+// consumer offsets checkpointed in offsets
+func NewStreamSink(store *storage.Store, offsets *Offsets) *StreamSink {
+	return &StreamSink{
+		store:   store,
+		offsets: offsets,
+		changed: make(chan struct{}),
+	}
+}
+
+// Name implements Sink
+func (s *StreamSink) Name() string {
+	return "stream"
+}
+
+// Write implements Sink. It never fails: consumers read the changes when
+// they are ready.
+func (s *StreamSink) Write(changes []storage.Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.first == 0 {
+		s.first = changes[0].Revision
+	}
+	s.history = append(s.history, changes...)
+	s.last = changes[len(changes)-1].Revision
+
+	// Trim in bulk once the history reaches twice its size, so that the
+	// copy is amortized over many writes, and never split a revision
+	if len(s.history) >= 2*streamHistory {
+		cut := len(s.history) - streamHistory
+		for cut < len(s.history) && s.history[cut].Revision == s.history[cut-1].Revision {
+			cut++
+		}
+		s.first = s.history[cut-1].Revision + 1
+		s.history = append([]storage.Change(nil), s.history[cut:]...)
+	}
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return nil
+}
+
+// Close implements Sink, ending every subscription
+func (s *StreamSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.changed)
+	}
+	return nil
+}
+
+// consumerKey is the name a consumer's offset is checkpointed under
+func consumerKey(consumerID string) string {
+	return "consumer/" + consumerID
+}
+
+// Subscription reads the changes of a StreamSink in revision order
+type Subscription struct {
+	sink *StreamSink
+	next uint64
+}
+
+// Subscribe starts reading changes for a consumer. A non-zero
+// startRevision starts at that revision. Otherwise a consumer resumes after
+// its checkpointed offset, and a consumer without one, or an anonymous
+// consumer, starts with the changes committed from now on.
+func (s *StreamSink) Subscribe(consumerID string, startRevision uint64) (*Subscription, error) {
+	sub := &Subscription{sink: s, next: startRevision}
+	if sub.next != 0 {
+		return sub, nil
+	}
+	if consumerID != "" {
+		if offset, ok := s.offsets.Get(consumerKey(consumerID)); ok {
+			sub.next = offset + 1
+			return sub, nil
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first != 0 {
+		sub.next = s.last + 1
+	} else {
+		sub.next = s.store.Revision() + 1
+	}
+	return sub, nil
+}
+
+// Commit checkpoints that a consumer has processed every change up to and
+// including revision
+func (s *StreamSink) Commit(consumerID string, revision uint64) error {
+	if consumerID == "" {
+		return fmt.Errorf("consumer ID cannot be empty")
+	}
+	return s.offsets.Commit(consumerKey(consumerID), revision)
+}
+
+// Revision returns the revision the subscription has read up to: the next
+// call to Next returns changes after it
+func (sub *Subscription) Revision() uint64 {
+	return sub.next - 1
+}
+
+// Next waits for changes and returns up to maxBatch of them, in revision
+// order. The changes of one revision are always returned together. It
+// returns an error wrapping storage.ErrCompacted if the changes the
+// subscription needs are no longer retained, ErrSinkClosed once the sink is
+// closed, or ctx's error once it is done.
+func (sub *Subscription) Next(ctx context.Context) ([]storage.Change, error) {
+	s := sub.sink
+	for {
+		s.mu.Lock()
+		closed, changed := s.closed, s.changed
+		first := s.first
+		var batch []storage.Change
+		if first != 0 && sub.next >= first {
+			batch = s.collectLocked(sub.next)
+		}
+		s.mu.Unlock()
+
+		if closed {
+			return nil, ErrSinkClosed
+		}
+		if len(batch) > 0 {
+			sub.next = batch[len(batch)-1].Revision + 1
+			return batch, nil
+		}
+
+		// Changes before the history are read from the WAL. Before the first
+		// Write the history starts after the revisions written by an earlier
+		// publisher, the rest are still on their way.
+		var to uint64
+		switch {
+		case first == 0:
+			to, _ = s.offsets.Get(sinkKey(s))
+		case sub.next < first:
+			to = first - 1
+		}
+		if sub.next <= to {
+			batch, err := sub.replay(to)
+			if err != nil {
+				return nil, err
+			}
+			if len(batch) > 0 {
+				return batch, nil
+			}
+			continue
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// collectLocked returns up to maxBatch changes from revision from on, caller
+// must hold s.mu
+func (s *StreamSink) collectLocked(from uint64) []storage.Change {
+	i := 0
+	for i < len(s.history) && s.history[i].Revision < from {
+		i++
+	}
+	end := min(len(s.history), i+maxBatch)
+	for end < len(s.history) && s.history[end].Revision == s.history[end-1].Revision {
+		end++
+	}
+	if i == end {
+		return nil
+	}
+	return append([]storage.Change(nil), s.history[i:end]...)
+}
+
+// replay reads up to maxBatch changes from the WAL, from the subscription's
+// next revision up to revision to, and advances past them
+func (sub *Subscription) replay(to uint64) ([]storage.Change, error) {
+	var batch []storage.Change
+	errFull := errors.New("batch full")
+	err := sub.sink.store.ReplayChanges(sub.next, to, func(c storage.Change) error {
+		if len(batch) >= maxBatch && c.Revision != batch[len(batch)-1].Revision {
+			return errFull
+		}
+		batch = append(batch, c)
+		return nil
+	})
+	if errors.Is(err, errFull) {
+		sub.next = batch[len(batch)-1].Revision + 1
+		return batch, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Revisions without changes are skipped as well
+	sub.next = to + 1
+	return batch, nil
+}
@@ -0,0 +1,149 @@
+package cdc
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+)
+
+// nextChanges reads one batch of changes, failing the test if none arrive
+func nextChanges(t *testing.T, sub *Subscription) []storage.Change {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	changes, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	return changes
+}
+
+func startStream(t *testing.T, store *storage.Store, dir string) (*Publisher, *StreamSink) {
+	t.Helper()
+
+	offsets, err := OpenOffsets(filepath.Join(dir, "offsets.json"))
+	if err != nil {
+		t.Fatalf("OpenOffsets failed: %v", err)
+	}
+	sink := NewStreamSink(store, offsets)
+	p, err := Start(store, dir, offsets, sink)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	return p, sink
+}
+
+func TestStreamSink_SubscribeFromNow(t *testing.T) {
+	store := storage.NewStore()
+	store.Set("tenant", "before", []byte("0"))
+	p, sink := startStream(t, store, t.TempDir())
+	defer p.Close()
+
+	sub, _ := sink.Subscribe("", 0)
+	store.Set("tenant", "a", []byte("1"))
+	store.Set("tenant", "b", []byte("2"))
+
+	var got []storage.Change
+	for len(got) < 2 {
+		got = append(got, nextChanges(t, sub)...)
+	}
+	if got[0].Key != "a" || got[1].Key != "b" {
+		t.Fatalf("Expected a and b, got %+v", got)
+	}
+	if sub.Revision() != store.Revision() {
+		t.Fatalf("Expected the subscription at revision %d, got %d", store.Revision(), sub.Revision())
+	}
+}
+
+func TestStreamSink_ConsumerResumesAfterCommit(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer store.Close()
+	cdcDir := filepath.Join(dir, "cdc")
+	p, sink := startStream(t, store, cdcDir)
+
+	sub, _ := sink.Subscribe("mirror", 0)
+	store.Set("tenant", "a", []byte("1"))
+	store.Set("tenant", "b", []byte("2"))
+	changes := nextChanges(t, sub)
+	if err := sink.Commit("mirror", changes[0].Revision); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	p.Close()
+
+	// A restarted server serves the consumer from its checkpoint, reading
+	// changes from before the new publisher started back from the WAL
+	p, sink = startStream(t, store, cdcDir)
+	defer p.Close()
+	sub, _ = sink.Subscribe("mirror", 0)
+	store.Set("tenant", "c", []byte("3"))
+
+	var got []storage.Change
+	for len(got) < 2 {
+		got = append(got, nextChanges(t, sub)...)
+	}
+	if got[0].Key != "b" || got[1].Key != "c" {
+		t.Fatalf("Expected b and c, got %+v", got)
+	}
+}
+
+func TestStreamSink_ReadsBehindHistoryFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer store.Close()
+	p, sink := startStream(t, store, filepath.Join(dir, "cdc"))
+	defer p.Close()
+
+	first := store.Revision() + 1
+	for i := 0; i < 3*streamHistory; i++ {
+		store.Set("tenant", "key", []byte("v"))
+	}
+	last := store.Revision()
+	waitFor(t, "the history to be trimmed", func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return sink.last == last
+	})
+
+	sub, _ := sink.Subscribe("", first)
+	next := first
+	for next <= last {
+		for _, c := range nextChanges(t, sub) {
+			if c.Revision != next {
+				t.Fatalf("Expected revision %d, got %d", next, c.Revision)
+			}
+			next++
+		}
+	}
+}
+
+func TestStreamSink_Errors(t *testing.T) {
+	store := storage.NewStore()
+	p, sink := startStream(t, store, t.TempDir())
+
+	if err := sink.Commit("", 1); err == nil {
+		t.Fatalf("Expected error committing without a consumer ID")
+	}
+
+	// An in-memory store keeps no WAL to read old changes from
+	store.Set("tenant", "key", []byte("v"))
+	sub, _ := sink.Subscribe("", 1)
+	waitFor(t, "the change to be written", func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return sink.last == store.Revision()
+	})
+	if changes := nextChanges(t, sub); len(changes) != 1 {
+		t.Fatalf("Expected the change from history, got %+v", changes)
+	}
+
+	p.Close()
+	if _, err := sub.Next(context.Background()); !errors.Is(err, ErrSinkClosed) {
+		t.Fatalf("Expected ErrSinkClosed, got %v", err)
+	}
+}
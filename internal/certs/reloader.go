@@ -0,0 +1,220 @@
+// Package certs serves TLS certificates from files and reloads them when the
+// files change, so certificates can be rotated without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is how often the files are checked for changes
+const DefaultReloadInterval = 10 * time.Second
+
+// Files are the PEM files of a server's TLS configuration
+type Files struct {
+	Cert string // Server certificate chain
+	Key  string // Private key of the certificate
+
+	// ClientCA holds the CAs client certificates are verified against. Empty
+	// to not verify client certificates.
+	ClientCA string
+}
+
+// Reloader loads the server certificate and client CAs from their files and
+// reloads them whenever the files change. A change that fails to load, such
+// as a certificate written before its key, keeps the previous certificates
+// and is retried on the next check.
+type Reloader struct {
+	files Files
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	stamps   []fileStamp // Of the files currently loaded
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader loads the files and checks them for changes every interval
+// until Close. An interval of 0 never reloads.
+func NewReloader(files Files, interval time.Duration) (*Reloader, error) {
+	if files.Cert == "" || files.Key == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+
+	r := &Reloader{files: files, done: make(chan struct{})}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		r.wg.Add(1)
+		go r.watch(interval)
+	}
+	return r, nil
+}
+
+// ServerConfig returns a TLS configuration serving the current certificates.
+// With a client CA, clientAuth sets whether client certificates are
+// requested and required.
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	if r.files.ClientCA == "" {
+		clientAuth = tls.NoClientCert
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The configuration is built per connection so that both the
+		// certificate and the client CAs follow reloads
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    r.clientCA,
+			}, nil
+		},
+	}
+}
+
+// Certificate returns the server certificate currently served
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// Close stops checking the files for changes
+func (r *Reloader) Close() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+func (r *Reloader) watch(interval time.Duration) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				slog.Error("Failed to reload TLS certificates, keeping the current ones", "err", err)
+				continue
+			}
+			slog.Info("Reloaded TLS certificates", "cert", r.files.Cert)
+		}
+	}
+}
+
+// paths returns the files to load, in the order of stamps
+func (r *Reloader) paths() []string {
+	paths := []string{r.files.Cert, r.files.Key}
+	if r.files.ClientCA != "" {
+		paths = append(paths, r.files.ClientCA)
+	}
+	return paths
+}
+
+// changed reports whether any file differs from the loaded version
+func (r *Reloader) changed() bool {
+	stamps, err := stat(r.paths())
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i, stamp := range stamps {
+		if stamp != r.stamps[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) reload() error {
+	// Stat first, so a file changing while it is read is reloaded again
+	stamps, err := stat(r.paths())
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.files.Cert, r.files.Key)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var clientCA *x509.CertPool
+	if r.files.ClientCA != "" {
+		if clientCA, err = LoadCertPool(r.files.ClientCA); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = clientCA
+	r.stamps = stamps
+	r.mu.Unlock()
+	return nil
+}
+
+func stat(paths []string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// LoadCertPool reads the PEM certificates of a CA bundle
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// PeerName returns the name a client certificate identifies: its subject
+// common name, or its first DNS, email or URI name without one
+func PeerName(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/certs/certstest"
+)
+
+func subject(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, dir, "test")
+	certFile, keyFile := ca.Issue(t, dir, "server")
+
+	r, err := NewReloader(Files{Cert: certFile, Key: keyFile, ClientCA: ca.File}, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	defer r.Close()
+	if name := subject(t, r.Certificate()); name != "server" {
+		t.Fatalf("Expected the server certificate, got %s", name)
+	}
+
+	// Rotate the certificate in place
+	newCert, newKey := ca.Issue(t, t.TempDir(), "rotated")
+	for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		os.WriteFile(dst, data, 0o600)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for subject(t, r.Certificate()) != "rotated" {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the certificate to reload")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Connections are served the reloaded certificate
+	config, err := r.ServerConfig(tls.RequireAndVerifyClientCert).GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetConfigForClient failed: %v", err)
+	}
+	if name := subject(t, &config.Certificates[0]); name != "rotated" || config.ClientCAs == nil {
+		t.Fatalf("Expected the rotated certificate and client CAs, got %s", name)
+	}
+}
+
+func TestReloader_KeepsCertificateOnBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, dir, "test")
+	certFile, keyFile := ca.Issue(t, dir, "server")
+
+	r, err := NewReloader(Files{Cert: certFile, Key: keyFile}, 0)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	defer r.Close()
+
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	if !r.changed() {
+		t.Fatal("Expected the key change to be noticed")
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("Expected an invalid key to fail to load")
+	}
+	if name := subject(t, r.Certificate()); name != "server" {
+		t.Fatalf("Expected the previous certificate to be kept, got %s", name)
+	}
+	if !r.changed() {
+		t.Fatal("Expected a failed reload to be retried")
+	}
+
+	if _, err := NewReloader(Files{Cert: certFile, Key: keyFile}, 0); err == nil {
+		t.Fatal("Expected NewReloader to fail with an invalid key")
+	}
+}
+
+func TestReloader_WithoutClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := certstest.NewCA(t, dir, "test").Issue(t, dir, "server")
+
+	r, err := NewReloader(Files{Cert: certFile, Key: keyFile}, 0)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	defer r.Close()
+
+	config, _ := r.ServerConfig(tls.RequireAndVerifyClientCert).GetConfigForClient(&tls.ClientHelloInfo{})
+	if config.ClientAuth != tls.NoClientCert {
+		t.Fatalf("Expected no client certificates without a client CA, got %s", config.ClientAuth)
+	}
+}
@@ -0,0 +1,498 @@
+// Package config loads the server configuration from a YAML file, the
+// environment and command-line flags.
+//
+// Settings are applied in order of precedence, each overriding the ones
+// before it:
+//
+//  1. built-in defaults
+//  2. the YAML file named by -config or TINKERDB_CONFIG
+//  3. environment variables, TINKERDB_<SECTION>_<NAME>
+//  4. command-line flags, -<section>.<name>
+//
+// Every setting has all three forms: the YAML key tls.client_ca is set by
+// TINKERDB_TLS_CLIENT_CA and by -tls.client-ca.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/cdc"
+	"github.com/ayushgala/tinkerdb/internal/logging"
+	"github.com/ayushgala/tinkerdb/internal/metrics"
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"github.com/ayushgala/tinkerdb/internal/tracing"
+	"github.com/ayushgala/tinkerdb/internal/wal"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server. Fields carry their YAML key,
+// their environment variable and the usage shown for their flag.
+type Config struct {
+	Listen        string        `yaml:"listen" env:"TINKERDB_LISTEN" usage:"address to serve gRPC on"`
+	DataDir       string        `yaml:"data_dir" env:"TINKERDB_DATA_DIR" usage:"directory holding the log, snapshots and engine files"`
+	Engine        string        `yaml:"engine" env:"TINKERDB_ENGINE" usage:"storage engine: memory, bitcask, btree or lsm"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"TINKERDB_SWEEP_INTERVAL" usage:"time between expired key sweeps, 0 disables them"`
+
+	WAL      WALConfig      `yaml:"wal"`
+	Snapshot SnapshotConfig `yaml:"snapshot"`
+	Limits   LimitsConfig   `yaml:"limits"`
+	Auth     AuthConfig     `yaml:"auth"`
+	TLS      TLSConfig      `yaml:"tls"`
+	CDC      CDCConfig      `yaml:"cdc"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	Log      LogConfig      `yaml:"log"`
+	Cluster  ClusterConfig  `yaml:"cluster"`
+	Shard    ShardConfig    `yaml:"shard"`
+}
+
+// WALConfig configures the write-ahead log
+type WALConfig struct {
+	Sync         string        `yaml:"sync" env:"TINKERDB_WAL_SYNC" usage:"fsync policy: always, batch or none"`
+	SyncInterval time.Duration `yaml:"sync_interval" env:"TINKERDB_WAL_SYNC_INTERVAL" usage:"time between fsyncs with the batch policy"`
+}
+
+// SnapshotConfig configures periodic snapshots
+type SnapshotConfig struct {
+	Interval time.Duration `yaml:"interval" env:"TINKERDB_SNAPSHOT_INTERVAL" usage:"time between snapshots, 0 disables them"`
+	Retain   int           `yaml:"retain" env:"TINKERDB_SNAPSHOT_RETAIN" usage:"number of snapshots kept"`
+}
+
+// LimitsConfig bounds the requests the server accepts, 0 for no limit
+// beyond the store's own. Limits are reloaded on SIGHUP.
+type LimitsConfig struct {
+	MaxKeySize   int `yaml:"max_key_size" env:"TINKERDB_LIMITS_MAX_KEY_SIZE" usage:"maximum bytes of a key"`
+	MaxValueSize int `yaml:"max_value_size" env:"TINKERDB_LIMITS_MAX_VALUE_SIZE" usage:"maximum bytes of a value"`
+	MaxBatchKeys int `yaml:"max_batch_keys" env:"TINKERDB_LIMITS_MAX_BATCH_KEYS" usage:"maximum keys of an MGet, MSet or MDelete"`
+	MaxScanLimit int `yaml:"max_scan_limit" env:"TINKERDB_LIMITS_MAX_SCAN_LIMIT" usage:"maximum keys of a Scan page"`
+}
+
+// AuthConfig configures authentication
+type AuthConfig struct {
+	Mode       string `yaml:"mode" env:"TINKERDB_AUTH_MODE" usage:"none serves calls without credentials, token requires them"`
+	AdminToken string `yaml:"admin_token" env:"TINKERDB_AUTH_ADMIN_TOKEN" usage:"static admin token" secret:"true"`
+}
+
+// TLSConfig configures TLS, which is enabled by a certificate
+type TLSConfig struct {
+	Cert           string        `yaml:"cert" env:"TINKERDB_TLS_CERT" usage:"server certificate file"`
+	Key            string        `yaml:"key" env:"TINKERDB_TLS_KEY" usage:"server key file"`
+	ClientCA       string        `yaml:"client_ca" env:"TINKERDB_TLS_CLIENT_CA" usage:"CA file verifying client certificates, enables mutual TLS"`
+	ClientAuth     string        `yaml:"client_auth" env:"TINKERDB_TLS_CLIENT_AUTH" usage:"require or optional client certificates"`
+	AdminClients   []string      `yaml:"admin_clients" env:"TINKERDB_TLS_ADMIN_CLIENTS" usage:"client certificate names with admin rights, comma separated"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TINKERDB_TLS_RELOAD_INTERVAL" usage:"time between checks of the certificate files for changes"`
+}
+
+// CDCConfig configures change data capture
+type CDCConfig struct {
+	Sinks        []string `yaml:"sinks" env:"TINKERDB_CDC_SINKS" usage:"sinks to publish changes to: file, stream"`
+	Dir          string   `yaml:"dir" env:"TINKERDB_CDC_DIR" usage:"directory of the cdc state, <data dir>/cdc by default"`
+	FileMaxBytes int64    `yaml:"file_max_bytes" env:"TINKERDB_CDC_FILE_MAX_BYTES" usage:"size past which the file sink starts a new file"`
+	FileRetain   int      `yaml:"file_retain" env:"TINKERDB_CDC_FILE_RETAIN" usage:"number of files the file sink keeps, 0 keeps all"`
+}
+
+// MetricsConfig configures the Prometheus metrics and the HTTP server that
+// also serves the health probes
+type MetricsConfig struct {
+	Listen     string `yaml:"listen" env:"TINKERDB_METRICS_LISTEN" usage:"address to serve /metrics, /healthz and /readyz on over HTTP, empty disables it"`
+	MaxTenants int    `yaml:"max_tenants" env:"TINKERDB_METRICS_MAX_TENANTS" usage:"largest tenants reported under their own label, the rest are summed up"`
+}
+
+// TracingConfig configures OpenTelemetry tracing
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"TINKERDB_TRACING_EXPORTER" usage:"where spans are sent: none, otlp, stdout or file"`
+	Endpoint    string  `yaml:"endpoint" env:"TINKERDB_TRACING_ENDPOINT" usage:"address of the OTLP collector, over gRPC"`
+	Insecure    bool    `yaml:"insecure" env:"TINKERDB_TRACING_INSECURE" usage:"connect to the OTLP collector without TLS"`
+	File        string  `yaml:"file" env:"TINKERDB_TRACING_FILE" usage:"file the file exporter appends spans to"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TINKERDB_TRACING_SAMPLE_RATIO" usage:"fraction of traces started by the server that are recorded, callers' decisions are followed"`
+	ServiceName string  `yaml:"service_name" env:"TINKERDB_TRACING_SERVICE_NAME" usage:"service name spans are reported under"`
+}
+
+// LogConfig configures logging. Everything but the format and the slow log
+// file is reloaded on SIGHUP.
+type LogConfig struct {
+	Level         string        `yaml:"level" env:"TINKERDB_LOG_LEVEL" usage:"minimum level logged: debug, info, warn or error"`
+	Format        string        `yaml:"format" env:"TINKERDB_LOG_FORMAT" usage:"log format: text or json"`
+	Keys          string        `yaml:"keys" env:"TINKERDB_LOG_KEYS" usage:"key names in request logs: redact, sample or show"`
+	KeySample     int           `yaml:"key_sample" env:"TINKERDB_LOG_KEY_SAMPLE" usage:"with log.keys sample, key names are shown for one request in this many"`
+	SlowThreshold time.Duration `yaml:"slow_threshold" env:"TINKERDB_LOG_SLOW_THRESHOLD" usage:"requests taking at least this long go to the slow log, 0 disables it"`
+	SlowFile      string        `yaml:"slow_file" env:"TINKERDB_LOG_SLOW_FILE" usage:"file the slow log is appended to, the main log by default"`
+}
+
+// ClusterConfig configures cluster mode, enabled by a node ID, in which
+// writes are replicated to the peers with Raft
+type ClusterConfig struct {
+	NodeID            string        `yaml:"node_id" env:"TINKERDB_CLUSTER_NODE_ID" usage:"ID of this node in the cluster, enables cluster mode"`
+	Peers             []string      `yaml:"peers" env:"TINKERDB_CLUSTER_PEERS" usage:"every node of a new cluster as id=host:port, this one included, comma separated; later changes go through tinkerctl member"`
+	Join              bool          `yaml:"join" env:"TINKERDB_CLUSTER_JOIN" usage:"start without peers and wait to be added to a running cluster with tinkerctl member add"`
+	ElectionTimeout   time.Duration `yaml:"election_timeout" env:"TINKERDB_CLUSTER_ELECTION_TIMEOUT" usage:"time without a leader after which a node starts an election"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env:"TINKERDB_CLUSTER_HEARTBEAT_INTERVAL" usage:"time between the leader's heartbeats"`
+	Secret            string        `yaml:"secret" env:"TINKERDB_CLUSTER_SECRET" usage:"secret the nodes present to each other" secret:"true"`
+	CA                string        `yaml:"ca" env:"TINKERDB_CLUSTER_CA" usage:"CA file verifying the peers' certificates with TLS, the system roots by default"`
+}
+
+// Enabled reports whether the server runs as a node of a cluster
+func (c *ClusterConfig) Enabled() bool {
+	return c.NodeID != ""
+}
+
+// PeerAddresses parses the peers into their addresses by node ID
+func (c *ClusterConfig) PeerAddresses() (map[string]string, error) {
+	peers := make(map[string]string, len(c.Peers))
+	for _, peer := range c.Peers {
+		id, addr, ok := strings.Cut(peer, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("cluster peer %q must be id=host:port", peer)
+		}
+		if _, dup := peers[id]; dup {
+			return nil, fmt.Errorf("cluster peer %q is listed twice", id)
+		}
+		peers[id] = addr
+	}
+	return peers, nil
+}
+
+// ShardConfig configures sharding, enabled by a shard ID, in which the keys
+// are spread over shard groups by consistent hashing. Every server of every
+// group must list the same groups.
+type ShardConfig struct {
+	ID           string   `yaml:"id" env:"TINKERDB_SHARD_ID" usage:"ID of the shard group this server belongs to, enables sharding"`
+	Groups       []string `yaml:"groups" env:"TINKERDB_SHARD_GROUPS" usage:"every shard group as id=host:port|host:port, listing the addresses of its nodes, comma separated"`
+	VirtualNodes int      `yaml:"virtual_nodes" env:"TINKERDB_SHARD_VIRTUAL_NODES" usage:"points each shard group has on the hash ring"`
+}
+
+// Enabled reports whether the server is part of a sharded deployment
+func (c *ShardConfig) Enabled() bool {
+	return c.ID != ""
+}
+
+// Map parses the groups into the shard map
+func (c *ShardConfig) Map() (*shard.Map, error) {
+	groups := make([]shard.Group, 0, len(c.Groups))
+	for _, group := range c.Groups {
+		id, addrs, ok := strings.Cut(group, "=")
+		if !ok || id == "" || addrs == "" {
+			return nil, fmt.Errorf("shard group %q must be id=host:port|host:port", group)
+		}
+		groups = append(groups, shard.Group{ID: id, Addresses: strings.Split(addrs, "|")})
+	}
+	return shard.New(groups, c.VirtualNodes)
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	storeOpts := storage.DefaultOptions("data")
+	fileOpts := cdc.DefaultFileSinkOptions()
+	return &Config{
+		Listen:        ":50051",
+		DataDir:       storeOpts.DataDir,
+		Engine:        storeOpts.Engine,
+		SweepInterval: storeOpts.SweepInterval,
+		WAL: WALConfig{
+			Sync:         storeOpts.WAL.SyncPolicy.String(),
+			SyncInterval: storeOpts.WAL.SyncInterval,
+		},
+		Snapshot: SnapshotConfig{
+			Interval: storeOpts.SnapshotInterval,
+			Retain:   storeOpts.SnapshotRetain,
+		},
+		Auth: AuthConfig{Mode: "none"},
+		TLS: TLSConfig{
+			ClientAuth:     "require",
+			ReloadInterval: 10 * time.Second,
+		},
+		CDC: CDCConfig{
+			FileMaxBytes: fileOpts.MaxFileSize,
+			FileRetain:   fileOpts.MaxFiles,
+		},
+		Metrics: MetricsConfig{
+			Listen:     ":9090",
+			MaxTenants: metrics.DefaultMaxTenants,
+		},
+		Tracing: TracingConfig{
+			Exporter:    tracing.ExporterNone,
+			Endpoint:    tracing.DefaultEndpoint,
+			SampleRatio: 1,
+			ServiceName: "tinkerdb",
+		},
+		Log: LogConfig{
+			Level:         "info",
+			Format:        "text",
+			Keys:          logging.KeysRedact,
+			KeySample:     100,
+			SlowThreshold: 500 * time.Millisecond,
+		},
+		Cluster: ClusterConfig{
+			ElectionTimeout:   raft.DefaultElectionTimeout,
+			HeartbeatInterval: raft.DefaultHeartbeatInterval,
+		},
+		Shard: ShardConfig{VirtualNodes: shard.DefaultVirtualNodes},
+	}
+}
+
+// Options are the command-line options besides the settings
+type Options struct {
+	Path        string // Configuration file, empty for none
+	PrintConfig bool   // Print the effective configuration and exit
+}
+
+// Load builds the configuration from the file named in args or the
+// environment, the environment and the flags in args, and validates it.
+// lookupEnv is os.LookupEnv outside of tests.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, Options, error) {
+	var opts Options
+	cfg := Default()
+
+	fs := flag.NewFlagSet("tinkerdb-server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.Path, "config", "", "YAML configuration file, or TINKERDB_CONFIG")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	flags := make(map[string]*flagValue)
+	for _, s := range settings(cfg) {
+		flags[s.flag] = &flagValue{isBool: s.field.Kind() == reflect.Bool}
+		fs.Var(flags[s.flag], s.flag, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return nil, opts, err
+	}
+	if fs.NArg() > 0 {
+		return nil, opts, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if opts.Path == "" {
+		opts.Path, _ = lookupEnv("TINKERDB_CONFIG")
+	}
+	if opts.Path != "" {
+		if err := cfg.loadFile(opts.Path); err != nil {
+			return nil, opts, err
+		}
+	}
+
+	// TINKERDB_PORT predates the listen address and only sets the port
+	if port, ok := lookupEnv("TINKERDB_PORT"); ok && port != "" {
+		cfg.Listen = ":" + port
+	}
+	for _, s := range settings(cfg) {
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			if err := s.set(value); err != nil {
+				return nil, opts, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		if value, ok := flags[f.Name]; ok && flagErr == nil {
+			if err := settingByFlag(cfg, f.Name).set(value.value); err != nil {
+				flagErr = fmt.Errorf("invalid -%s: %w", f.Name, err)
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, opts, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, opts, err
+	}
+	return cfg, opts, nil
+}
+
+// loadFile applies the settings of a YAML file. Unknown keys are rejected so
+// that misspelled settings are not silently ignored.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Listen != "", "listen cannot be empty")
+	check(c.DataDir != "", "data_dir cannot be empty")
+	if err := storage.ValidateEngine(c.Engine); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.SweepInterval >= 0, "sweep_interval cannot be negative")
+
+	if _, err := wal.ParseSyncPolicy(c.WAL.Sync); err != nil {
+		errs = append(errs, fmt.Errorf("wal.sync: %w", err))
+	}
+	check(c.WAL.SyncInterval > 0, "wal.sync_interval must be positive")
+	check(c.Snapshot.Interval >= 0, "snapshot.interval cannot be negative")
+	check(c.Snapshot.Retain >= 1, "snapshot.retain must be at least 1")
+
+	check(c.Limits.MaxKeySize >= 0, "limits.max_key_size cannot be negative")
+	check(c.Limits.MaxValueSize >= 0, "limits.max_value_size cannot be negative")
+	check(c.Limits.MaxBatchKeys >= 0 && c.Limits.MaxBatchKeys <= storage.MaxBatchKeys,
+		"limits.max_batch_keys must be between 0 and %d", storage.MaxBatchKeys)
+	check(c.Limits.MaxScanLimit >= 0 && c.Limits.MaxScanLimit <= storage.MaxScanLimit,
+		"limits.max_scan_limit must be between 0 and %d", storage.MaxScanLimit)
+
+	check(c.Auth.Mode == "none" || c.Auth.Mode == "token", "auth.mode must be none or token, got %q", c.Auth.Mode)
+
+	check((c.TLS.Cert == "") == (c.TLS.Key == ""), "tls.cert and tls.key must be set together")
+	check(c.TLS.ClientCA == "" || c.TLS.Cert != "", "tls.client_ca requires tls.cert and tls.key")
+	check(c.TLS.ClientAuth == "require" || c.TLS.ClientAuth == "optional",
+		"tls.client_auth must be require or optional, got %q", c.TLS.ClientAuth)
+	check(c.TLS.ReloadInterval >= 0, "tls.reload_interval cannot be negative")
+
+	for _, sink := range c.CDC.Sinks {
+		check(sink == "file" || sink == "stream", "unknown cdc sink %q, expected file or stream", sink)
+	}
+	check(c.CDC.FileMaxBytes >= 1, "cdc.file_max_bytes must be positive")
+	check(c.CDC.FileRetain >= 0, "cdc.file_retain cannot be negative")
+
+	check(c.Metrics.Listen == "" || c.Metrics.Listen != c.Listen, "metrics.listen cannot be the gRPC listen address")
+	check(c.Metrics.MaxTenants >= 0, "metrics.max_tenants cannot be negative")
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		check(c.Tracing.Endpoint != "", "tracing.endpoint is required by the otlp exporter")
+	case tracing.ExporterFile:
+		check(c.Tracing.File != "", "tracing.file is required by the file exporter")
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, otlp, stdout or file, got %q", c.Tracing.Exporter))
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing.service_name cannot be empty")
+
+	if _, err := ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json, got %q", c.Log.Format)
+	check(c.Log.Keys == logging.KeysRedact || c.Log.Keys == logging.KeysSample || c.Log.Keys == logging.KeysShow,
+		"log.keys must be redact, sample or show, got %q", c.Log.Keys)
+	check(c.Log.KeySample >= 1, "log.key_sample must be positive")
+	check(c.Log.SlowThreshold >= 0, "log.slow_threshold cannot be negative")
+
+	if c.Cluster.Enabled() {
+		peers, err := c.Cluster.PeerAddresses()
+		if err != nil {
+			errs = append(errs, err)
+		} else if _, ok := peers[c.Cluster.NodeID]; !ok && !c.Cluster.Join {
+			errs = append(errs, fmt.Errorf("cluster.peers must include this node, %q", c.Cluster.NodeID))
+		}
+	} else {
+		check(len(c.Cluster.Peers) == 0, "cluster.peers requires cluster.node_id")
+		check(!c.Cluster.Join, "cluster.join requires cluster.node_id")
+	}
+	check(c.Cluster.HeartbeatInterval > 0, "cluster.heartbeat_interval must be positive")
+	check(c.Cluster.ElectionTimeout > c.Cluster.HeartbeatInterval, "cluster.election_timeout must be longer than cluster.heartbeat_interval")
+
+	check(c.Shard.VirtualNodes >= 1, "shard.virtual_nodes must be positive")
+	if c.Shard.Enabled() {
+		m, err := c.Shard.Map()
+		if err != nil {
+			errs = append(errs, err)
+		} else if _, ok := m.Group(c.Shard.ID); !ok {
+			errs = append(errs, fmt.Errorf("shard.groups must include this group, %q", c.Shard.ID))
+		}
+	} else {
+		check(len(c.Shard.Groups) == 0, "shard.groups requires shard.id")
+	}
+
+	return errors.Join(errs...)
+}
+
+// StoreOptions returns the options of the store the configuration describes
+func (c *Config) StoreOptions() storage.Options {
+	opts := storage.DefaultOptions(c.DataDir)
+	opts.Engine = c.Engine
+	// Validated already
+	opts.WAL.SyncPolicy, _ = wal.ParseSyncPolicy(c.WAL.Sync)
+	opts.WAL.SyncInterval = c.WAL.SyncInterval
+	opts.SnapshotInterval = c.Snapshot.Interval
+	opts.SnapshotRetain = c.Snapshot.Retain
+	opts.SweepInterval = c.SweepInterval
+	return opts
+}
+
+// reloadable lists the settings applied on SIGHUP besides the limits
+var reloadable = []string{"log.level", "log.keys", "log.key_sample", "log.slow_threshold"}
+
+// RestartRequired lists the settings that differ from other and only take
+// effect on a restart, everything but the limits and the reloadable log
+// settings
+func (c *Config) RestartRequired(other *Config) []string {
+	var changed []string
+	current, updated := settings(c), settings(other)
+	for i, s := range current {
+		if strings.HasPrefix(s.key, "limits.") || slices.Contains(reloadable, s.key) {
+			continue
+		}
+		if s.get() != updated[i].get() {
+			changed = append(changed, s.key)
+		}
+	}
+	return changed
+}
+
+// Write writes the configuration as YAML, with secrets redacted
+func (c *Config) Write(w io.Writer) error {
+	redacted := *c
+	for _, s := range settings(&redacted) {
+		if s.secret && s.get() != "" {
+			s.set("REDACTED")
+		}
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&redacted); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// ParseLevel parses a log level name
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" && !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "tinkerdb.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, opts, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Listen != ":50051" || cfg.DataDir != "data" || cfg.Engine != "memory" || cfg.WAL.Sync != "always" || cfg.Metrics.Listen != ":9090" {
+		t.Fatalf("Unexpected defaults: %+v", cfg)
+	}
+	if opts.Path != "" || opts.PrintConfig {
+		t.Fatalf("Unexpected options: %+v", opts)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+listen: ":7000"
+data_dir: /var/lib/tinkerdb
+engine: lsm
+snapshot:
+  interval: 1m
+  retain: 3
+limits:
+  max_value_size: 1024
+cdc:
+  sinks: [file, stream]
+`)
+
+	vars := map[string]string{
+		"TINKERDB_CONFIG":                path,
+		"TINKERDB_ENGINE":                "btree",
+		"TINKERDB_LIMITS_MAX_VALUE_SIZE": "2048",
+		"TINKERDB_TLS_ADMIN_CLIENTS":     "ops, backup",
+		"TINKERDB_TRACING_EXPORTER":      "otlp",
+		"TINKERDB_TRACING_SAMPLE_RATIO":  "0.25",
+		"TINKERDB_TRACING_INSECURE":      "true",
+	}
+	cfg, opts, err := Load([]string{"-engine", "bitcask", "-snapshot.retain=5"}, env(vars))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if opts.Path != path {
+		t.Fatalf("Expected the file from TINKERDB_CONFIG, got %q", opts.Path)
+	}
+
+	// File over defaults
+	if cfg.Listen != ":7000" || cfg.DataDir != "/var/lib/tinkerdb" || cfg.Snapshot.Interval != time.Minute {
+		t.Fatalf("Expected the file settings, got %+v", cfg)
+	}
+	if strings.Join(cfg.CDC.Sinks, ",") != "file,stream" {
+		t.Fatalf("Expected the file's sinks, got %v", cfg.CDC.Sinks)
+	}
+	// Environment over the file
+	if cfg.Limits.MaxValueSize != 2048 {
+		t.Fatalf("Expected the environment to override the file, got %d", cfg.Limits.MaxValueSize)
+	}
+	if strings.Join(cfg.TLS.AdminClients, ",") != "ops,backup" {
+		t.Fatalf("Expected a list from the environment, got %v", cfg.TLS.AdminClients)
+	}
+	if cfg.Tracing.Exporter != "otlp" || cfg.Tracing.SampleRatio != 0.25 || !cfg.Tracing.Insecure {
+		t.Fatalf("Expected the environment's tracing settings, got %+v", cfg.Tracing)
+	}
+	// Flags over everything
+	if cfg.Engine != "bitcask" || cfg.Snapshot.Retain != 5 {
+		t.Fatalf("Expected the flags to override, got engine %s and retain %d", cfg.Engine, cfg.Snapshot.Retain)
+	}
+}
+
+func TestLoad_LegacyPort(t *testing.T) {
+	cfg, _, err := Load(nil, env(map[string]string{"TINKERDB_PORT": "8080"}))
+	if err != nil || cfg.Listen != ":8080" {
+		t.Fatalf("Expected TINKERDB_PORT to set the listen port, got %q %v", cfg.Listen, err)
+	}
+
+	cfg, _, err = Load([]string{"-listen", "127.0.0.1:9000"}, env(map[string]string{"TINKERDB_PORT": "8080"}))
+	if err != nil || cfg.Listen != "127.0.0.1:9000" {
+		t.Fatalf("Expected the flag to override TINKERDB_PORT, got %q %v", cfg.Listen, err)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		vars map[string]string
+		want string
+	}{
+		{"engine", []string{"-engine", "paper"}, nil, "paper"},
+		{"duration", nil, map[string]string{"TINKERDB_SNAPSHOT_INTERVAL": "often"}, "TINKERDB_SNAPSHOT_INTERVAL"},
+		{"integer", []string{"-limits.max-key-size", "big"}, nil, "-limits.max-key-size"},
+		{"auth mode", []string{"-auth.mode", "password"}, nil, "auth.mode"},
+		{"tls key", []string{"-tls.cert", "server.pem"}, nil, "tls.key"},
+		{"cdc sink", []string{"-cdc.sinks", "kafka"}, nil, "kafka"},
+		{"log level", []string{"-log.level", "loud"}, nil, "loud"},
+		{"log keys", nil, map[string]string{"TINKERDB_LOG_KEYS": "hash"}, "log.keys"},
+		{"batch limit", []string{"-limits.max-batch-keys", "100000"}, nil, "limits.max_batch_keys"},
+		{"metrics listen", []string{"-metrics.listen", ":50051"}, nil, "metrics.listen"},
+		{"trace exporter", []string{"-tracing.exporter", "jaeger"}, nil, "jaeger"},
+		{"trace file", []string{"-tracing.exporter", "file"}, nil, "tracing.file"},
+		{"sample ratio", nil, map[string]string{"TINKERDB_TRACING_SAMPLE_RATIO": "often"}, "TINKERDB_TRACING_SAMPLE_RATIO"},
+		{"sample ratio range", []string{"-tracing.sample-ratio", "2"}, nil, "tracing.sample_ratio"},
+		{"cluster peer", []string{"-cluster.node-id", "n1", "-cluster.peers", "n1=:7001,n2"}, nil, `"n2"`},
+		{"cluster self", []string{"-cluster.node-id", "n3", "-cluster.peers", "n1=:7001,n2=:7002"}, nil, "n3"},
+		{"cluster node id", nil, map[string]string{"TINKERDB_CLUSTER_PEERS": "n1=:7001"}, "cluster.node_id"},
+		{"cluster timeouts", []string{"-cluster.election-timeout", "100ms", "-cluster.heartbeat-interval", "100ms"}, nil, "cluster.election_timeout"},
+		{"shard group", []string{"-shard.id", "s1", "-shard.groups", "s1=:7001,s2"}, nil, `"s2"`},
+		{"shard self", []string{"-shard.id", "s3", "-shard.groups", "s1=:7001,s2=:7002"}, nil, "s3"},
+		{"shard id", nil, map[string]string{"TINKERDB_SHARD_GROUPS": "s1=:7001"}, "shard.id"},
+		{"shard virtual nodes", []string{"-shard.virtual-nodes", "0"}, nil, "shard.virtual_nodes"},
+		{"unknown flag", []string{"-colour"}, nil, "colour"},
+		{"argument", []string{"serve"}, nil, "serve"},
+	}
+	for _, tt := range tests {
+		_, _, err := Load(tt.args, env(tt.vars))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: expected an error mentioning %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestLoad_Cluster(t *testing.T) {
+	path := writeFile(t, `
+cluster:
+  node_id: n2
+  peers: [n1=10.0.0.1:50051, n2=10.0.0.2:50051, n3=10.0.0.3:50051]
+  election_timeout: 2s
+`)
+	cfg, _, err := Load([]string{"-config", path}, env(map[string]string{"TINKERDB_CLUSTER_SECRET": "s3cret"}))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !cfg.Cluster.Enabled() || cfg.Cluster.ElectionTimeout != 2*time.Second || cfg.Cluster.Secret != "s3cret" {
+		t.Fatalf("Unexpected cluster settings: %+v", cfg.Cluster)
+	}
+	peers, err := cfg.Cluster.PeerAddresses()
+	if err != nil {
+		t.Fatalf("PeerAddresses failed: %v", err)
+	}
+	if len(peers) != 3 || peers["n2"] != "10.0.0.2:50051" {
+		t.Fatalf("Unexpected peers: %v", peers)
+	}
+
+	if cfg, _, _ := Load(nil, env(nil)); cfg.Cluster.Enabled() {
+		t.Fatal("Expected cluster mode to be off by default")
+	}
+
+	// A joining node needs no peers
+	cfg, _, err = Load([]string{"-cluster.join", "-cluster.node-id", "n4"}, env(nil))
+	if err != nil || !cfg.Cluster.Join || cfg.Cluster.NodeID != "n4" {
+		t.Fatalf("Expected a joining node without peers to load, got %v", err)
+	}
+
+	// Bool settings take an explicit value after =
+	cfg, _, err = Load([]string{"-tracing.insecure=false", "-cluster.node-id", "n4", "-cluster.join=true"}, env(map[string]string{"TINKERDB_TRACING_INSECURE": "true"}))
+	if err != nil || cfg.Tracing.Insecure || !cfg.Cluster.Join {
+		t.Fatalf("Expected -name=value bool flags to apply, got %+v %v", cfg.Tracing, err)
+	}
+}
+
+func TestLoad_Shard(t *testing.T) {
+	path := writeFile(t, `
+shard:
+  id: s2
+  groups: ["s1=10.0.0.1:50051|10.0.0.2:50051", "s2=10.0.1.1:50051"]
+  virtual_nodes: 64
+`)
+	cfg, _, err := Load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !cfg.Shard.Enabled() {
+		t.Fatal("Expected sharding to be on")
+	}
+	m, err := cfg.Shard.Map()
+	if err != nil {
+		t.Fatalf("Map failed: %v", err)
+	}
+	if g, ok := m.Group("s1"); !ok || len(g.Addresses) != 2 || g.Addresses[1] != "10.0.0.2:50051" {
+		t.Fatalf("Unexpected group s1: %+v", g)
+	}
+	if m.VirtualNodes() != 64 {
+		t.Fatalf("Expected 64 virtual nodes, got %d", m.VirtualNodes())
+	}
+
+	if cfg, _, _ := Load(nil, env(nil)); cfg.Shard.Enabled() {
+		t.Fatal("Expected sharding to be off by default")
+	}
+}
+
+func TestLoad_RejectsUnknownKeys(t *testing.T) {
+	path := writeFile(t, "listen: \":7000\"\nenigne: lsm\n")
+	if _, _, err := Load([]string{"-config", path}, env(nil)); err == nil || !strings.Contains(err.Error(), "enigne") {
+		t.Fatalf("Expected a misspelled key to be rejected, got %v", err)
+	}
+
+	// An empty file keeps the defaults
+	cfg, _, err := Load([]string{"-config", writeFile(t, "")}, env(nil))
+	if err != nil || cfg.Listen != ":50051" {
+		t.Fatalf("Expected an empty file to be accepted, got %v", err)
+	}
+}
+
+func TestConfig_Write(t *testing.T) {
+	cfg, _, err := Load([]string{"-auth.admin-token", "s3cret", "-cdc.sinks", "file"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Write(&buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "s3cret") || !strings.Contains(buf.String(), "admin_token: REDACTED") {
+		t.Fatalf("Expected the admin token to be redacted, got:\n%s", buf.String())
+	}
+	if cfg.Auth.AdminToken != "s3cret" {
+		t.Fatal("Expected Write to leave the configuration unchanged")
+	}
+
+	// The printed configuration loads back to the same settings
+	printed, _, err := Load([]string{"-config", writeFile(t, buf.String()), "-auth.admin-token", "s3cret"}, env(nil))
+	if err != nil {
+		t.Fatalf("Failed to load the printed configuration: %v\n%s", err, buf.String())
+	}
+	if changed := cfg.RestartRequired(printed); len(changed) > 0 {
+		t.Fatalf("Expected the printed configuration to round-trip, got changes to %v", changed)
+	}
+}
+
+func TestConfig_RestartRequired(t *testing.T) {
+	current := Default()
+	updated := Default()
+	updated.Limits.MaxKeySize = 512
+	updated.Log.Level = "debug"
+	updated.Log.Keys = "show"
+	updated.Log.SlowThreshold = time.Second
+	if changed := current.RestartRequired(updated); len(changed) > 0 {
+		t.Fatalf("Expected limits and request logging to apply without a restart, got %v", changed)
+	}
+
+	updated.Engine = "lsm"
+	updated.TLS.AdminClients = []string{"ops"}
+	if changed := current.RestartRequired(updated); strings.Join(changed, ",") != "engine,tls.admin_clients" {
+		t.Fatalf("Expected engine and tls.admin_clients to need a restart, got %v", changed)
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is one leaf field of a Config, addressable by its YAML key,
+// environment variable and flag
+type setting struct {
+	key    string // Dotted YAML path, e.g. tls.client_ca
+	env    string
+	flag   string // The key with underscores as dashes, e.g. tls.client-ca
+	usage  string
+	secret bool
+	field  reflect.Value
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// settings returns the settings of cfg in declaration order
+func settings(cfg *Config) []setting {
+	return collect(reflect.ValueOf(cfg).Elem(), "", nil)
+}
+
+func collect(v reflect.Value, prefix string, out []setting) []setting {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		key := prefix + strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+			out = collect(v.Field(i), key+".", out)
+			continue
+		}
+		out = append(out, setting{
+			key:    key,
+			env:    f.Tag.Get("env"),
+			flag:   strings.ReplaceAll(key, "_", "-"),
+			usage:  f.Tag.Get("usage"),
+			secret: f.Tag.Get("secret") == "true",
+			field:  v.Field(i),
+		})
+	}
+	return out
+}
+
+// settingByFlag returns the setting of cfg set by a flag
+func settingByFlag(cfg *Config, name string) setting {
+	for _, s := range settings(cfg) {
+		if s.flag == name {
+			return s
+		}
+	}
+	panic("config: no setting for flag " + name)
+}
+
+// set parses value into the setting's field
+func (s setting) set(value string) error {
+	switch {
+	case s.field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		s.field.SetInt(int64(d))
+	case s.field.Kind() == reflect.String:
+		s.field.SetString(value)
+	case s.field.Kind() == reflect.Int || s.field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		s.field.SetInt(n)
+	case s.field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		s.field.SetFloat(f)
+	case s.field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		s.field.SetBool(b)
+	case s.field.Kind() == reflect.Slice:
+		s.field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		panic("config: unsupported setting type " + s.field.Type().String())
+	}
+	return nil
+}
+
+// get formats the setting's value as set would parse it
+func (s setting) get() string {
+	switch {
+	case s.field.Type() == durationType:
+		return time.Duration(s.field.Int()).String()
+	case s.field.Kind() == reflect.Slice:
+		return strings.Join(s.field.Interface().([]string), ",")
+	}
+	return fmt.Sprint(s.field.Interface())
+}
+
+// flagValue holds the value of a setting's flag until the file and the
+// environment have been applied. Bool settings are set by -name alone, like
+// the flag package's own bool flags.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
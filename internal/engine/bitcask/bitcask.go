@@ -0,0 +1,426 @@
+// Package bitcask implements a log-structured on-disk engine in the style of
+// Bitcask: every write is appended to a data file and an in-memory key
+// directory maps each live key to the position of its latest value. A
+// skiplist index over the live keys serves ordered iteration.
+//
+// Record layout in the data file:
+//
+//	crc32c (4B) | flags (1B) | key length (4B) | value length (4B) | key | value
+//
+// The checksum covers everything after it. Deletes append a tombstone record.
+// Once dead records outweigh live ones the file is compacted by rewriting the
+// live records into a fresh file that atomically replaces the old one.
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/skiplist"
+)
+
+const (
+	dataFile    = "data.log"
+	compactFile = "data.log.compact"
+
+	headerSize = 13
+
+	flagTombstone = 1
+
+	// Compaction only kicks in once this many bytes are dead
+	minCompactBytes = 4 * 1024 * 1024
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errTorn = errors.New("torn record")
+
+// entry locates the latest value of a key in the data file
+type entry struct {
+	offset int64 // Offset of the value bytes
+	size   uint32
+}
+
+// recordSize is the full on-disk size of a record
+func recordSize(keyLen, valueLen int) int64 {
+	return int64(headerSize + keyLen + valueLen)
+}
+
+// Engine is a Bitcask-style engine rooted in a directory
+type Engine struct {
+	dir    string
+	file   *os.File
+	size   int64
+	keydir map[string]entry
+	index  *skiplist.List
+
+	liveBytes int64
+	deadBytes int64
+
+	compactions uint64
+
+	closed bool
+}
+
+var _ engine.Persistent = (*Engine)(nil)
+
+// Open opens or creates the engine stored in dir
+func Open(dir string) (*Engine, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create bitcask directory: %w", err)
+	}
+
+	// A leftover compaction file means we crashed mid-compaction, the
+	// original data file is still authoritative
+	os.Remove(filepath.Join(dir, compactFile))
+
+	e := &Engine{
+		dir:    dir,
+		keydir: make(map[string]entry),
+		index:  skiplist.New(),
+	}
+
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// load rebuilds the key directory from the data file, trimming a torn tail
+func (e *Engine) load() error {
+	path := filepath.Join(e.dir, dataFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open bitcask data file: %w", err)
+	}
+
+	var offset int64
+	for {
+		key, value, flags, err := readRecord(f, offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Anything after the last good record is from an interrupted write
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to trim bitcask data file: %w", err)
+			}
+			break
+		}
+
+		size := recordSize(len(key), len(value))
+		if old, exists := e.keydir[key]; exists {
+			e.deadBytes += recordSize(len(key), int(old.size))
+			e.liveBytes -= recordSize(len(key), int(old.size))
+		}
+		if flags&flagTombstone != 0 {
+			delete(e.keydir, key)
+			e.index.Delete(key)
+			e.deadBytes += size
+		} else {
+			e.keydir[key] = entry{offset: offset + headerSize + int64(len(key)), size: uint32(len(value))}
+			e.index.Insert(key)
+			e.liveBytes += size
+		}
+		offset += size
+	}
+
+	e.file = f
+	e.size = offset
+	return nil
+}
+
+// readRecord reads the record at offset
+func readRecord(r io.ReaderAt, offset int64) (string, []byte, byte, error) {
+	var header [headerSize]byte
+	n, err := r.ReadAt(header[:], offset)
+	if n == 0 && err == io.EOF {
+		return "", nil, 0, io.EOF
+	}
+	if n < headerSize {
+		return "", nil, 0, errTorn
+	}
+
+	checksum := binary.LittleEndian.Uint32(header[0:4])
+	flags := header[4]
+	keyLen := binary.LittleEndian.Uint32(header[5:9])
+	valueLen := binary.LittleEndian.Uint32(header[9:13])
+
+	body := make([]byte, int64(keyLen)+int64(valueLen))
+	if _, err := r.ReadAt(body, offset+headerSize); err != nil {
+		return "", nil, 0, errTorn
+	}
+
+	crc := crc32.Update(0, crcTable, header[4:])
+	crc = crc32.Update(crc, crcTable, body)
+	if crc != checksum {
+		return "", nil, 0, errTorn
+	}
+
+	return string(body[:keyLen]), body[keyLen:], flags, nil
+}
+
+// encodeRecord builds the on-disk representation of a record
+func encodeRecord(key string, value []byte, flags byte) []byte {
+	buf := make([]byte, recordSize(len(key), len(value)))
+	buf[4] = flags
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// appendRecord writes a record at the end of the data file and returns its offset
+func (e *Engine) appendRecord(key string, value []byte, flags byte) (int64, error) {
+	buf := encodeRecord(key, value, flags)
+	offset := e.size
+	if _, err := e.file.WriteAt(buf, offset); err != nil {
+		return 0, fmt.Errorf("bitcask write failed: %w", err)
+	}
+	e.size += int64(len(buf))
+	return offset, nil
+}
+
+// Get returns the value stored for key
+func (e *Engine) Get(key string) ([]byte, bool, error) {
+	if e.closed {
+		return nil, false, engine.ErrClosed
+	}
+
+	ent, exists := e.keydir[key]
+	if !exists {
+		return nil, false, nil
+	}
+
+	value, err := e.readValue(ent)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (e *Engine) readValue(ent entry) ([]byte, error) {
+	value := make([]byte, ent.size)
+	if _, err := e.file.ReadAt(value, ent.offset); err != nil {
+		return nil, fmt.Errorf("bitcask read failed: %w", err)
+	}
+	return value, nil
+}
+
+// Set appends a new value for key
+func (e *Engine) Set(key string, value []byte) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	offset, err := e.appendRecord(key, value, 0)
+	if err != nil {
+		return err
+	}
+
+	if old, exists := e.keydir[key]; exists {
+		e.retire(key, old)
+	} else {
+		e.index.Insert(key)
+	}
+	e.keydir[key] = entry{offset: offset + headerSize + int64(len(key)), size: uint32(len(value))}
+	e.liveBytes += recordSize(len(key), len(value))
+
+	return e.maybeCompact()
+}
+
+// Delete appends a tombstone for key
+func (e *Engine) Delete(key string) (bool, error) {
+	if e.closed {
+		return false, engine.ErrClosed
+	}
+
+	old, exists := e.keydir[key]
+	if !exists {
+		return false, nil
+	}
+
+	if _, err := e.appendRecord(key, nil, flagTombstone); err != nil {
+		return false, err
+	}
+
+	e.retire(key, old)
+	e.deadBytes += recordSize(len(key), 0)
+	delete(e.keydir, key)
+	e.index.Delete(key)
+
+	return true, e.maybeCompact()
+}
+
+// retire accounts for a record that has been superseded
+func (e *Engine) retire(key string, old entry) {
+	size := recordSize(len(key), int(old.size))
+	e.liveBytes -= size
+	e.deadBytes += size
+}
+
+// Exists reports whether key is present
+func (e *Engine) Exists(key string) (bool, error) {
+	if e.closed {
+		return false, engine.ErrClosed
+	}
+	_, exists := e.keydir[key]
+	return exists, nil
+}
+
+// Iterate visits keys in [start, end) in ascending order
+func (e *Engine) Iterate(start, end string, fn func(key string, value []byte) bool) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	for n := e.index.Seek(start); n != nil; n = n.Next() {
+		if end != "" && n.Key() >= end {
+			break
+		}
+		value, err := e.readValue(e.keydir[n.Key()])
+		if err != nil {
+			return err
+		}
+		if !fn(n.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// IterateReverse visits keys in [start, end) in descending order
+func (e *Engine) IterateReverse(start, end string, fn func(key string, value []byte) bool) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	n := e.index.Last()
+	if end != "" {
+		n = e.index.SeekBefore(end)
+	}
+	for ; n != nil && n.Key() >= start; n = n.Prev() {
+		value, err := e.readValue(e.keydir[n.Key()])
+		if err != nil {
+			return err
+		}
+		if !fn(n.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Len returns the number of live keys
+func (e *Engine) Len() int {
+	return len(e.keydir)
+}
+
+// Stats reports the size of the data file, dead records included
+func (e *Engine) Stats() engine.Stats {
+	return engine.Stats{Bytes: e.size, Compactions: e.compactions}
+}
+
+// maybeCompact compacts once dead records take more space than live ones
+func (e *Engine) maybeCompact() error {
+	if e.deadBytes < minCompactBytes || e.deadBytes < e.liveBytes {
+		return nil
+	}
+	return e.Compact()
+}
+
+// Compact rewrites the live records into a new data file
+func (e *Engine) Compact() error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	tmpPath := filepath.Join(e.dir, compactFile)
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create compaction file: %w", err)
+	}
+
+	keydir := make(map[string]entry, len(e.keydir))
+	var offset int64
+	for key, ent := range e.keydir {
+		value, err := e.readValue(ent)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		buf := encodeRecord(key, value, 0)
+		if _, err := tmp.WriteAt(buf, offset); err != nil {
+			tmp.Close()
+			return fmt.Errorf("compaction write failed: %w", err)
+		}
+		keydir[key] = entry{offset: offset + headerSize + int64(len(key)), size: ent.size}
+		offset += int64(len(buf))
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync compaction file: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(e.dir, dataFile)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to install compacted data file: %w", err)
+	}
+	if err := syncDir(e.dir); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	e.file.Close()
+	e.file = tmp
+	e.size = offset
+	e.keydir = keydir
+	e.liveBytes = offset
+	e.deadBytes = 0
+	e.compactions++
+	return nil
+}
+
+// Sync flushes the data file to stable storage
+func (e *Engine) Sync() error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+	return e.file.Sync()
+}
+
+// Close syncs and closes the data file
+func (e *Engine) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	syncErr := e.file.Sync()
+	if err := e.file.Close(); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory for sync: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
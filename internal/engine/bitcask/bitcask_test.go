@@ -0,0 +1,74 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/enginetest"
+)
+
+func TestConformance(t *testing.T) {
+	enginetest.Run(t, func(dir string) (engine.Engine, error) {
+		return Open(dir)
+	}, true)
+}
+
+func TestEngine_TornTailIsTrimmed(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir)
+	e.Set("key", []byte("value"))
+	e.Close()
+
+	f, _ := os.OpenFile(filepath.Join(dir, dataFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	f.Write([]byte{0x01, 0x02, 0x03})
+	f.Close()
+
+	e, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer e.Close()
+
+	value, exists, _ := e.Get("key")
+	if !exists || string(value) != "value" {
+		t.Fatalf("Expected 'value', got (%q, %v)", value, exists)
+	}
+
+	e.Set("next", []byte("value"))
+	if e.Len() != 2 {
+		t.Fatalf("Expected 2 keys, got %d", e.Len())
+	}
+}
+
+func TestEngine_Compact(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir)
+	for i := 0; i < 100; i++ {
+		e.Set(fmt.Sprintf("key-%d", i%10), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	before := e.size
+
+	if err := e.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if e.size >= before {
+		t.Fatalf("Expected compaction to shrink the file: %d -> %d", before, e.size)
+	}
+	e.Close()
+
+	e, _ = Open(dir)
+	defer e.Close()
+
+	if e.Len() != 10 {
+		t.Fatalf("Expected 10 keys after compaction, got %d", e.Len())
+	}
+	value, _, _ := e.Get("key-3")
+	if string(value) != "value-93" {
+		t.Fatalf("Expected latest value 'value-93', got %q", value)
+	}
+}
@@ -0,0 +1,950 @@
+// Package btree implements an on-disk engine backed by an append-only,
+// copy-on-write B+tree stored in a single file.
+//
+// Every write appends the new value, the rewritten path from leaf to root and
+// a fixed-size meta record pointing at the new root. The meta record is the
+// commit point: on open the engine reads the last meta record and anything
+// written after it (a torn update) is discarded. Old nodes become garbage and
+// are reclaimed by rebuilding the tree into a fresh file once the file has
+// doubled in size since the last rebuild.
+//
+// Record layout:
+//
+//	crc32c (4B) | type (1B) | payload length (4B) | payload
+//
+// where the checksum covers the type, length and payload.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+)
+
+const (
+	dataFile    = "btree.db"
+	compactFile = "btree.db.compact"
+
+	recordHeaderSize = 9
+
+	typeNode  byte = 'N'
+	typeValue byte = 'V'
+	typeMeta  byte = 'M'
+
+	metaMagic       = "TKBTMETA"
+	metaPayloadSize = 24
+	metaRecordSize  = recordHeaderSize + metaPayloadSize
+
+	// maxKeys is the number of entries after which a node is split
+	maxKeys = 64
+
+	// maxCachedNodes bounds the decoded node cache
+	maxCachedNodes = 4096
+
+	// Rebuilds only kick in once the file is at least this large
+	minCompactSize = 4 * 1024 * 1024
+
+	// maxRecordSize guards against garbage length prefixes
+	maxRecordSize = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errBadRecord = errors.New("bad btree record")
+
+// noRoot marks an empty tree
+const noRoot int64 = -1
+
+// valueRef points at a value record
+type valueRef struct {
+	offset int64 // Offset of the record header
+	length uint32
+}
+
+// node is an immutable B+tree node. In an internal node keys[i] is the
+// smallest key reachable through children[i+1].
+type node struct {
+	leaf     bool
+	keys     []string
+	values   []valueRef // Leaf only
+	children []int64    // Internal only, len(keys)+1 entries
+}
+
+// Engine is a copy-on-write B+tree engine rooted in a directory
+type Engine struct {
+	dir   string
+	file  *os.File
+	size  int64
+	root  int64
+	count uint64
+
+	// Size of the file right after the last rebuild
+	baseSize int64
+
+	rebuilds uint64
+
+	cacheMu sync.Mutex
+	cache   map[int64]*node
+
+	closed bool
+}
+
+var _ engine.Persistent = (*Engine)(nil)
+
+// Open opens or creates the engine stored in dir
+func Open(dir string) (*Engine, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create btree directory: %w", err)
+	}
+	os.Remove(filepath.Join(dir, compactFile))
+
+	f, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open btree file: %w", err)
+	}
+
+	e := &Engine{
+		dir:   dir,
+		file:  f,
+		root:  noRoot,
+		cache: make(map[int64]*node),
+	}
+
+	if err := e.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	e.baseSize = e.size
+	return e, nil
+}
+
+// recover locates the last committed meta record
+func (e *Engine) recover() error {
+	info, err := e.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat btree file: %w", err)
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil
+	}
+
+	// Fast path: the file ends with a complete meta record
+	if size >= metaRecordSize {
+		if typ, payload, err := readRecordAt(e.file, size-metaRecordSize); err == nil && typ == typeMeta {
+			if root, count, ok := decodeMeta(payload); ok {
+				e.root, e.count, e.size = root, count, size
+				return nil
+			}
+		}
+	}
+
+	// Slow path: scan forward for the last meta record and drop the torn update after it
+	var offset, committed int64
+	for offset < size {
+		typ, payload, err := readRecordAt(e.file, offset)
+		if err != nil {
+			break
+		}
+		offset += recordHeaderSize + int64(len(payload))
+		if typ == typeMeta {
+			if root, count, ok := decodeMeta(payload); ok {
+				e.root, e.count, committed = root, count, offset
+			}
+		}
+	}
+
+	if err := e.file.Truncate(committed); err != nil {
+		return fmt.Errorf("failed to trim btree file: %w", err)
+	}
+	e.size = committed
+	return nil
+}
+
+func readRecordAt(r io.ReaderAt, offset int64) (byte, []byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return 0, nil, errBadRecord
+	}
+
+	length := binary.LittleEndian.Uint32(header[5:9])
+	if length > maxRecordSize {
+		return 0, nil, errBadRecord
+	}
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return 0, nil, errBadRecord
+	}
+
+	crc := crc32.Update(0, crcTable, header[4:])
+	crc = crc32.Update(crc, crcTable, payload)
+	if crc != binary.LittleEndian.Uint32(header[0:4]) {
+		return 0, nil, errBadRecord
+	}
+	return header[4], payload, nil
+}
+
+func encodeRecord(typ byte, payload []byte) []byte {
+	buf := make([]byte, recordHeaderSize+len(payload))
+	buf[4] = typ
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(len(payload)))
+	copy(buf[recordHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// appendRecord writes a record to f at offset and returns the offset after it
+func appendRecord(f *os.File, offset int64, typ byte, payload []byte) (int64, error) {
+	buf := encodeRecord(typ, payload)
+	if _, err := f.WriteAt(buf, offset); err != nil {
+		return 0, fmt.Errorf("btree write failed: %w", err)
+	}
+	return offset + int64(len(buf)), nil
+}
+
+func encodeMeta(root int64, count uint64) []byte {
+	payload := make([]byte, metaPayloadSize)
+	copy(payload, metaMagic)
+	binary.LittleEndian.PutUint64(payload[8:16], uint64(root))
+	binary.LittleEndian.PutUint64(payload[16:24], count)
+	return payload
+}
+
+func decodeMeta(payload []byte) (int64, uint64, bool) {
+	if len(payload) != metaPayloadSize || string(payload[:8]) != metaMagic {
+		return 0, 0, false
+	}
+	root := int64(binary.LittleEndian.Uint64(payload[8:16]))
+	count := binary.LittleEndian.Uint64(payload[16:24])
+	return root, count, true
+}
+
+func encodeNode(n *node) []byte {
+	var buf []byte
+	if n.leaf {
+		buf = append(buf, 1)
+		buf = binary.AppendUvarint(buf, uint64(len(n.keys)))
+		for i, key := range n.keys {
+			buf = binary.AppendUvarint(buf, uint64(len(key)))
+			buf = append(buf, key...)
+			buf = binary.AppendUvarint(buf, uint64(n.values[i].offset))
+			buf = binary.AppendUvarint(buf, uint64(n.values[i].length))
+		}
+		return buf
+	}
+
+	buf = append(buf, 0)
+	buf = binary.AppendUvarint(buf, uint64(len(n.children)))
+	for _, child := range n.children {
+		buf = binary.AppendUvarint(buf, uint64(child))
+	}
+	for _, key := range n.keys {
+		buf = binary.AppendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+	}
+	return buf
+}
+
+func decodeNode(payload []byte) (*node, error) {
+	if len(payload) == 0 {
+		return nil, errBadRecord
+	}
+	n := &node{leaf: payload[0] == 1}
+	data := payload[1:]
+
+	readUvarint := func() (uint64, error) {
+		v, size := binary.Uvarint(data)
+		if size <= 0 {
+			return 0, errBadRecord
+		}
+		data = data[size:]
+		return v, nil
+	}
+	readString := func() (string, error) {
+		length, err := readUvarint()
+		if err != nil || uint64(len(data)) < length {
+			return "", errBadRecord
+		}
+		s := string(data[:length])
+		data = data[length:]
+		return s, nil
+	}
+
+	count, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	if n.leaf {
+		for i := uint64(0); i < count; i++ {
+			key, err := readString()
+			if err != nil {
+				return nil, err
+			}
+			offset, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			length, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, key)
+			n.values = append(n.values, valueRef{offset: int64(offset), length: uint32(length)})
+		}
+		return n, nil
+	}
+
+	for i := uint64(0); i < count; i++ {
+		child, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, int64(child))
+	}
+	for i := uint64(1); i < count; i++ {
+		key, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+	}
+	return n, nil
+}
+
+// readNode loads a node, consulting the cache first
+func (e *Engine) readNode(offset int64) (*node, error) {
+	e.cacheMu.Lock()
+	n, cached := e.cache[offset]
+	e.cacheMu.Unlock()
+	if cached {
+		return n, nil
+	}
+
+	typ, payload, err := readRecordAt(e.file, offset)
+	if err != nil || typ != typeNode {
+		return nil, fmt.Errorf("btree node at %d: %w", offset, errBadRecord)
+	}
+	n, err = decodeNode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("btree node at %d: %w", offset, err)
+	}
+
+	e.cacheNode(offset, n)
+	return n, nil
+}
+
+func (e *Engine) cacheNode(offset int64, n *node) {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+
+	// Nodes are immutable, so dropping arbitrary entries is always safe
+	if len(e.cache) >= maxCachedNodes {
+		for off := range e.cache {
+			delete(e.cache, off)
+			if len(e.cache) < maxCachedNodes/2 {
+				break
+			}
+		}
+	}
+	e.cache[offset] = n
+}
+
+// writeNode appends a node and returns its offset
+func (e *Engine) writeNode(n *node) (int64, error) {
+	offset := e.size
+	next, err := appendRecord(e.file, offset, typeNode, encodeNode(n))
+	if err != nil {
+		return 0, err
+	}
+	e.size = next
+	e.cacheNode(offset, n)
+	return offset, nil
+}
+
+func (e *Engine) readValue(ref valueRef) ([]byte, error) {
+	typ, payload, err := readRecordAt(e.file, ref.offset)
+	if err != nil || typ != typeValue || uint32(len(payload)) != ref.length {
+		return nil, fmt.Errorf("btree value at %d: %w", ref.offset, errBadRecord)
+	}
+	return payload, nil
+}
+
+// commit appends a meta record making root the new tree
+func (e *Engine) commit(root int64, count uint64) error {
+	next, err := appendRecord(e.file, e.size, typeMeta, encodeMeta(root, count))
+	if err != nil {
+		return err
+	}
+	e.size = next
+	e.root = root
+	e.count = count
+	return nil
+}
+
+// childIndex returns the child of an internal node that may contain key
+func childIndex(n *node, key string) int {
+	return sort.Search(len(n.keys), func(i int) bool { return n.keys[i] > key })
+}
+
+// find returns the value reference for key
+func (e *Engine) find(key string) (valueRef, bool, error) {
+	offset := e.root
+	for offset != noRoot {
+		n, err := e.readNode(offset)
+		if err != nil {
+			return valueRef{}, false, err
+		}
+		if n.leaf {
+			i := sort.SearchStrings(n.keys, key)
+			if i < len(n.keys) && n.keys[i] == key {
+				return n.values[i], true, nil
+			}
+			return valueRef{}, false, nil
+		}
+		offset = n.children[childIndex(n, key)]
+	}
+	return valueRef{}, false, nil
+}
+
+// Get returns the value stored for key
+func (e *Engine) Get(key string) ([]byte, bool, error) {
+	if e.closed {
+		return nil, false, engine.ErrClosed
+	}
+
+	ref, exists, err := e.find(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	value, err := e.readValue(ref)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Exists reports whether key is present
+func (e *Engine) Exists(key string) (bool, error) {
+	if e.closed {
+		return false, engine.ErrClosed
+	}
+	_, exists, err := e.find(key)
+	return exists, err
+}
+
+// Set writes value and a new path from leaf to root
+func (e *Engine) Set(key string, value []byte) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	ref := valueRef{offset: e.size, length: uint32(len(value))}
+	next, err := appendRecord(e.file, e.size, typeValue, value)
+	if err != nil {
+		return err
+	}
+	e.size = next
+
+	if e.root == noRoot {
+		root, err := e.writeNode(&node{leaf: true, keys: []string{key}, values: []valueRef{ref}})
+		if err != nil {
+			return err
+		}
+		return e.commit(root, 1)
+	}
+
+	res, err := e.insert(e.root, key, ref)
+	if err != nil {
+		return err
+	}
+
+	root := res.left
+	if res.split {
+		root, err = e.writeNode(&node{keys: []string{res.separator}, children: []int64{res.left, res.right}})
+		if err != nil {
+			return err
+		}
+	}
+
+	count := e.count
+	if res.added {
+		count++
+	}
+	if err := e.commit(root, count); err != nil {
+		return err
+	}
+	return e.maybeCompact()
+}
+
+// insertResult is the rewritten subtree after an insert, split in two if it overflowed
+type insertResult struct {
+	left      int64
+	right     int64
+	separator string
+	split     bool
+	added     bool // True if the key did not exist before
+}
+
+func (e *Engine) insert(offset int64, key string, ref valueRef) (insertResult, error) {
+	n, err := e.readNode(offset)
+	if err != nil {
+		return insertResult{}, err
+	}
+
+	var res insertResult
+	var updated *node
+
+	if n.leaf {
+		i := sort.SearchStrings(n.keys, key)
+		updated = &node{leaf: true}
+		if i < len(n.keys) && n.keys[i] == key {
+			updated.keys = append([]string(nil), n.keys...)
+			updated.values = append([]valueRef(nil), n.values...)
+			updated.values[i] = ref
+		} else {
+			res.added = true
+			updated.keys = insertAt(n.keys, i, key)
+			updated.values = insertAt(n.values, i, ref)
+		}
+	} else {
+		i := childIndex(n, key)
+		child, err := e.insert(n.children[i], key, ref)
+		if err != nil {
+			return insertResult{}, err
+		}
+		res.added = child.added
+
+		updated = &node{
+			keys:     append([]string(nil), n.keys...),
+			children: append([]int64(nil), n.children...),
+		}
+		updated.children[i] = child.left
+		if child.split {
+			updated.keys = insertAt(updated.keys, i, child.separator)
+			updated.children = insertAt(updated.children, i+1, child.right)
+		}
+	}
+
+	if len(updated.keys) <= maxKeys {
+		res.left, err = e.writeNode(updated)
+		return res, err
+	}
+
+	left, right, separator := splitNode(updated)
+	if res.left, err = e.writeNode(left); err != nil {
+		return insertResult{}, err
+	}
+	if res.right, err = e.writeNode(right); err != nil {
+		return insertResult{}, err
+	}
+	res.separator = separator
+	res.split = true
+	return res, nil
+}
+
+// splitNode divides an overflowing node in two halves
+func splitNode(n *node) (*node, *node, string) {
+	mid := len(n.keys) / 2
+
+	if n.leaf {
+		left := &node{leaf: true, keys: n.keys[:mid], values: n.values[:mid]}
+		right := &node{leaf: true, keys: n.keys[mid:], values: n.values[mid:]}
+		return left, right, right.keys[0]
+	}
+
+	// The middle key moves up into the parent
+	left := &node{keys: n.keys[:mid], children: n.children[:mid+1]}
+	right := &node{keys: n.keys[mid+1:], children: n.children[mid+1:]}
+	return left, right, n.keys[mid]
+}
+
+// Delete writes a new path without key. Underfull nodes are not merged;
+// empty nodes are dropped and single-child internal nodes collapse.
+func (e *Engine) Delete(key string) (bool, error) {
+	if e.closed {
+		return false, engine.ErrClosed
+	}
+	if e.root == noRoot {
+		return false, nil
+	}
+
+	root, removed, err := e.remove(e.root, key)
+	if err != nil || !removed {
+		return false, err
+	}
+
+	if err := e.commit(root, e.count-1); err != nil {
+		return false, err
+	}
+	return true, e.maybeCompact()
+}
+
+// remove deletes key from the subtree at offset and returns its new root,
+// or noRoot if the subtree became empty
+func (e *Engine) remove(offset int64, key string) (int64, bool, error) {
+	n, err := e.readNode(offset)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if n.leaf {
+		i := sort.SearchStrings(n.keys, key)
+		if i >= len(n.keys) || n.keys[i] != key {
+			return offset, false, nil
+		}
+		if len(n.keys) == 1 {
+			return noRoot, true, nil
+		}
+		updated := &node{leaf: true, keys: removeAt(n.keys, i), values: removeAt(n.values, i)}
+		newOffset, err := e.writeNode(updated)
+		return newOffset, true, err
+	}
+
+	i := childIndex(n, key)
+	child, removed, err := e.remove(n.children[i], key)
+	if err != nil || !removed {
+		return offset, false, err
+	}
+
+	updated := &node{
+		keys:     append([]string(nil), n.keys...),
+		children: append([]int64(nil), n.children...),
+	}
+	if child == noRoot {
+		updated.children = removeAt(updated.children, i)
+		if i == 0 {
+			updated.keys = removeAt(updated.keys, 0)
+		} else {
+			updated.keys = removeAt(updated.keys, i-1)
+		}
+	} else {
+		updated.children[i] = child
+	}
+
+	switch len(updated.children) {
+	case 0:
+		return noRoot, true, nil
+	case 1:
+		return updated.children[0], true, nil
+	}
+
+	newOffset, err := e.writeNode(updated)
+	return newOffset, true, err
+}
+
+// Iterate visits keys in [start, end) in ascending order
+func (e *Engine) Iterate(start, end string, fn func(key string, value []byte) bool) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+	if e.root == noRoot {
+		return nil
+	}
+	_, err := e.walk(e.root, start, end, fn)
+	return err
+}
+
+// walk visits a subtree in order and reports whether iteration should continue
+func (e *Engine) walk(offset int64, start, end string, fn func(key string, value []byte) bool) (bool, error) {
+	n, err := e.readNode(offset)
+	if err != nil {
+		return false, err
+	}
+
+	if n.leaf {
+		for i := sort.SearchStrings(n.keys, start); i < len(n.keys); i++ {
+			if end != "" && n.keys[i] >= end {
+				return false, nil
+			}
+			value, err := e.readValue(n.values[i])
+			if err != nil {
+				return false, err
+			}
+			if !fn(n.keys[i], value) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	for i := childIndex(n, start); i < len(n.children); i++ {
+		if i > 0 && end != "" && n.keys[i-1] >= end {
+			return false, nil
+		}
+		more, err := e.walk(n.children[i], start, end, fn)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// IterateReverse visits keys in [start, end) in descending order
+func (e *Engine) IterateReverse(start, end string, fn func(key string, value []byte) bool) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+	if e.root == noRoot {
+		return nil
+	}
+	_, err := e.walkReverse(e.root, start, end, fn)
+	return err
+}
+
+// walkReverse visits a subtree in reverse order and reports whether
+// iteration should continue
+func (e *Engine) walkReverse(offset int64, start, end string, fn func(key string, value []byte) bool) (bool, error) {
+	n, err := e.readNode(offset)
+	if err != nil {
+		return false, err
+	}
+
+	if n.leaf {
+		i := len(n.keys) - 1
+		if end != "" {
+			i = sort.SearchStrings(n.keys, end) - 1
+		}
+		for ; i >= 0; i-- {
+			if n.keys[i] < start {
+				return false, nil
+			}
+			value, err := e.readValue(n.values[i])
+			if err != nil {
+				return false, err
+			}
+			if !fn(n.keys[i], value) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	last := len(n.children) - 1
+	if end != "" {
+		last = childIndex(n, end)
+	}
+	for i := last; i >= 0; i-- {
+		// Child i only holds keys below n.keys[i]
+		if i < last && n.keys[i] <= start {
+			return false, nil
+		}
+		more, err := e.walkReverse(n.children[i], start, end, fn)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Len returns the number of keys stored
+func (e *Engine) Len() int {
+	return int(e.count)
+}
+
+// Stats reports the size of the file, superseded pages included. Every
+// rebuild counts as a compaction.
+func (e *Engine) Stats() engine.Stats {
+	return engine.Stats{Bytes: e.size, Compactions: e.rebuilds}
+}
+
+func (e *Engine) maybeCompact() error {
+	if e.size < minCompactSize || e.size < 2*e.baseSize {
+		return nil
+	}
+	return e.Compact()
+}
+
+// Compact rebuilds the tree into a fresh file holding only live data
+func (e *Engine) Compact() error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	tmpPath := filepath.Join(e.dir, compactFile)
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create btree rebuild file: %w", err)
+	}
+
+	b := &builder{file: tmp}
+	err = e.Iterate("", "", func(key string, value []byte) bool {
+		b.err = b.add(key, value)
+		return b.err == nil
+	})
+	if err == nil {
+		err = b.err
+	}
+	var root int64 = noRoot
+	if err == nil {
+		root, err = b.finish()
+	}
+	if err == nil {
+		b.size, err = appendRecord(tmp, b.size, typeMeta, encodeMeta(root, b.count))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filepath.Join(e.dir, dataFile))
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("btree rebuild failed: %w", err)
+	}
+
+	e.file.Close()
+	e.file = tmp
+	e.size = b.size
+	e.baseSize = b.size
+	e.root = root
+	e.count = b.count
+	e.rebuilds++
+
+	e.cacheMu.Lock()
+	e.cache = make(map[int64]*node)
+	e.cacheMu.Unlock()
+	return nil
+}
+
+// builder bulk-loads sorted entries into a new file bottom-up
+type builder struct {
+	file  *os.File
+	size  int64
+	count uint64
+	err   error
+
+	leaf   node
+	levels [][]levelEntry // Completed nodes waiting for a parent, per level
+}
+
+// levelEntry is a written node and the smallest key beneath it
+type levelEntry struct {
+	offset int64
+	first  string
+}
+
+func (b *builder) add(key string, value []byte) error {
+	ref := valueRef{offset: b.size, length: uint32(len(value))}
+	next, err := appendRecord(b.file, b.size, typeValue, value)
+	if err != nil {
+		return err
+	}
+	b.size = next
+	b.count++
+
+	b.leaf.leaf = true
+	b.leaf.keys = append(b.leaf.keys, key)
+	b.leaf.values = append(b.leaf.values, ref)
+	if len(b.leaf.keys) == maxKeys {
+		return b.flushLeaf()
+	}
+	return nil
+}
+
+func (b *builder) flushLeaf() error {
+	if len(b.leaf.keys) == 0 {
+		return nil
+	}
+	offset, err := b.write(&b.leaf)
+	if err != nil {
+		return err
+	}
+	b.push(0, levelEntry{offset: offset, first: b.leaf.keys[0]})
+	b.leaf = node{}
+	return nil
+}
+
+func (b *builder) push(level int, entry levelEntry) {
+	for len(b.levels) <= level {
+		b.levels = append(b.levels, nil)
+	}
+	b.levels[level] = append(b.levels[level], entry)
+}
+
+func (b *builder) write(n *node) (int64, error) {
+	offset := b.size
+	next, err := appendRecord(b.file, b.size, typeNode, encodeNode(n))
+	if err != nil {
+		return 0, err
+	}
+	b.size = next
+	return offset, nil
+}
+
+// finish writes the internal levels and returns the root offset
+func (b *builder) finish() (int64, error) {
+	if err := b.flushLeaf(); err != nil {
+		return 0, err
+	}
+	if len(b.levels) == 0 {
+		return noRoot, nil
+	}
+
+	for level := 0; ; level++ {
+		entries := b.levels[level]
+		if len(entries) == 1 {
+			return entries[0].offset, nil
+		}
+		for start := 0; start < len(entries); start += maxKeys {
+			group := entries[start:min(start+maxKeys, len(entries))]
+			n := &node{}
+			for i, entry := range group {
+				n.children = append(n.children, entry.offset)
+				if i > 0 {
+					n.keys = append(n.keys, entry.first)
+				}
+			}
+			offset, err := b.write(n)
+			if err != nil {
+				return 0, err
+			}
+			b.push(level+1, levelEntry{offset: offset, first: group[0].first})
+		}
+	}
+}
+
+// Sync flushes the file to stable storage
+func (e *Engine) Sync() error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+	return e.file.Sync()
+}
+
+// Close syncs and closes the file
+func (e *Engine) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	syncErr := e.file.Sync()
+	if err := e.file.Close(); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	out := make([]T, 0, len(s)+1)
+	out = append(out, s[:i]...)
+	out = append(out, v)
+	return append(out, s[i:]...)
+}
+
+func removeAt[T any](s []T, i int) []T {
+	out := make([]T, 0, len(s)-1)
+	out = append(out, s[:i]...)
+	return append(out, s[i+1:]...)
+}
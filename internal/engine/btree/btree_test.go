@@ -0,0 +1,87 @@
+package btree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/enginetest"
+)
+
+func TestConformance(t *testing.T) {
+	enginetest.Run(t, func(dir string) (engine.Engine, error) {
+		return Open(dir)
+	}, true)
+}
+
+func TestEngine_TornUpdateIsDiscarded(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir)
+	e.Set("committed", []byte("value"))
+	e.Close()
+
+	// An update that never reached its meta record must disappear
+	f, _ := os.OpenFile(filepath.Join(dir, dataFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	f.Write(encodeRecord(typeValue, []byte("orphan")))
+	f.Write([]byte{0xde, 0xad})
+	f.Close()
+
+	e, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer e.Close()
+
+	if e.Len() != 1 {
+		t.Fatalf("Expected 1 key, got %d", e.Len())
+	}
+	value, exists, _ := e.Get("committed")
+	if !exists || string(value) != "value" {
+		t.Fatalf("Expected 'value', got (%q, %v)", value, exists)
+	}
+
+	if err := e.Set("after", []byte("value")); err != nil {
+		t.Fatalf("Set after recovery failed: %v", err)
+	}
+}
+
+func TestEngine_CompactRebuildsTree(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir)
+	for i := 0; i < 500; i++ {
+		e.Set(fmt.Sprintf("key-%04d", i%200), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	before := e.size
+
+	if err := e.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if e.size >= before {
+		t.Fatalf("Expected rebuild to shrink the file: %d -> %d", before, e.size)
+	}
+	e.Close()
+
+	e, _ = Open(dir)
+	defer e.Close()
+
+	if e.Len() != 200 {
+		t.Fatalf("Expected 200 keys after rebuild, got %d", e.Len())
+	}
+	value, _, _ := e.Get("key-0099")
+	if string(value) != "value-499" {
+		t.Fatalf("Expected 'value-499', got %q", value)
+	}
+
+	var count int
+	e.Iterate("", "", func(string, []byte) bool {
+		count++
+		return true
+	})
+	if count != 200 {
+		t.Fatalf("Expected to iterate 200 keys, got %d", count)
+	}
+}
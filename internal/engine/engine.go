@@ -0,0 +1,66 @@
+// Package engine defines the storage backend interface that every per-tenant
+// key-value engine implements.
+//
+// Engines are not required to be safe for concurrent writers: TenantStore
+// serializes mutations with its own lock. They must however allow any number
+// of concurrent readers (Get, Exists, Iterate, Len, Stats) while no write is
+// running.
+package engine
+
+import "errors"
+
+// ErrClosed is returned by engines that are used after Close
+var ErrClosed = errors.New("engine is closed")
+
+// Engine is an ordered key-value store holding the data of one tenant.
+//
+// Values passed to Set belong to the engine once the call returns and must
+// not be modified by the caller. Values returned by Get and Iterate must be
+// treated as read-only.
+type Engine interface {
+	// Get returns the value stored for key and whether it exists
+	Get(key string) ([]byte, bool, error)
+
+	// Set stores value under key, replacing any previous value
+	Set(key string, value []byte) error
+
+	// Delete removes key and reports whether it existed
+	Delete(key string) (bool, error)
+
+	// Exists reports whether key is present
+	Exists(key string) (bool, error)
+
+	// Iterate calls fn for every key in [start, end) in ascending order
+	// until fn returns false. An empty end means no upper bound.
+	Iterate(start, end string, fn func(key string, value []byte) bool) error
+
+	// IterateReverse calls fn for every key in [start, end) in descending
+	// order until fn returns false. An empty end means no upper bound.
+	IterateReverse(start, end string, fn func(key string, value []byte) bool) error
+
+	// Len returns the number of keys stored
+	Len() int
+
+	// Stats reports the space the engine uses without scanning its data
+	Stats() Stats
+
+	// Close releases the engine's resources
+	Close() error
+}
+
+// Stats describe the space an engine uses and its background work
+type Stats struct {
+	// Bytes held in memory, or in the engine's files for on-disk engines
+	// including space not yet reclaimed by compaction
+	Bytes int64
+
+	// Compactions run since the engine was opened
+	Compactions uint64
+}
+
+// Persistent is implemented by engines that keep their data on disk across
+// restarts. Sync makes every completed write durable.
+type Persistent interface {
+	Engine
+	Sync() error
+}
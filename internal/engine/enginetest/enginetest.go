@@ -0,0 +1,447 @@
+// Package enginetest provides the conformance suite every storage engine must pass
+package enginetest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+)
+
+// Opener opens the engine stored in dir. Engines that do not persist data
+// may ignore dir.
+type Opener func(dir string) (engine.Engine, error)
+
+// Run runs the conformance suite against the engine produced by open. When
+// persistent is true the suite also checks that data survives a reopen.
+func Run(t *testing.T, open Opener, persistent bool) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, open Opener)
+	}{
+		{"SetAndGet", testSetAndGet},
+		{"Overwrite", testOverwrite},
+		{"Delete", testDelete},
+		{"Exists", testExists},
+		{"Len", testLen},
+		{"Stats", testStats},
+		{"EmptyAndBinaryValues", testEmptyAndBinaryValues},
+		{"OrderedIteration", testOrderedIteration},
+		{"IterationBounds", testIterationBounds},
+		{"IterationStopsEarly", testIterationStopsEarly},
+		{"ReverseIteration", testReverseIteration},
+		{"ManyKeys", testManyKeys},
+		{"UseAfterClose", testUseAfterClose},
+	}
+	if persistent {
+		tests = append(tests, struct {
+			name string
+			fn   func(t *testing.T, open Opener)
+		}{"Reopen", testReopen})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open)
+		})
+	}
+}
+
+func mustOpen(t *testing.T, open Opener, dir string) engine.Engine {
+	t.Helper()
+
+	e, err := open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return e
+}
+
+func mustSet(t *testing.T, e engine.Engine, key, value string) {
+	t.Helper()
+
+	if err := e.Set(key, []byte(value)); err != nil {
+		t.Fatalf("Set(%q) failed: %v", key, err)
+	}
+}
+
+func expectValue(t *testing.T, e engine.Engine, key, expected string) {
+	t.Helper()
+
+	value, exists, err := e.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q) failed: %v", key, err)
+	}
+	if !exists {
+		t.Fatalf("Expected %q to exist", key)
+	}
+	if string(value) != expected {
+		t.Fatalf("Get(%q) = %q, expected %q", key, value, expected)
+	}
+}
+
+func expectMissing(t *testing.T, e engine.Engine, key string) {
+	t.Helper()
+
+	_, exists, err := e.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q) failed: %v", key, err)
+	}
+	if exists {
+		t.Fatalf("Expected %q to be missing", key)
+	}
+}
+
+func collect(t *testing.T, e engine.Engine, start, end string) []string {
+	t.Helper()
+
+	var keys []string
+	err := e.Iterate(start, end, func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Iterate failed: %v", err)
+	}
+	return keys
+}
+
+func collectReverse(t *testing.T, e engine.Engine, start, end string) []string {
+	t.Helper()
+
+	var keys []string
+	err := e.IterateReverse(start, end, func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("IterateReverse failed: %v", err)
+	}
+	return keys
+}
+
+func testSetAndGet(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	mustSet(t, e, "key", "value")
+	expectValue(t, e, "key", "value")
+	expectMissing(t, e, "other")
+}
+
+func testOverwrite(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	mustSet(t, e, "key", "first")
+	mustSet(t, e, "key", "second")
+	expectValue(t, e, "key", "second")
+
+	if e.Len() != 1 {
+		t.Fatalf("Expected 1 key after overwrite, got %d", e.Len())
+	}
+}
+
+func testDelete(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	mustSet(t, e, "key", "value")
+
+	deleted, err := e.Delete("key")
+	if err != nil || !deleted {
+		t.Fatalf("Delete = (%v, %v), expected (true, nil)", deleted, err)
+	}
+	expectMissing(t, e, "key")
+
+	deleted, err = e.Delete("key")
+	if err != nil || deleted {
+		t.Fatalf("Second delete = (%v, %v), expected (false, nil)", deleted, err)
+	}
+
+	// A deleted key can be written again
+	mustSet(t, e, "key", "again")
+	expectValue(t, e, "key", "again")
+}
+
+func testExists(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	if exists, _ := e.Exists("key"); exists {
+		t.Fatal("Key should not exist initially")
+	}
+	mustSet(t, e, "key", "value")
+	if exists, _ := e.Exists("key"); !exists {
+		t.Fatal("Key should exist after Set")
+	}
+	e.Delete("key")
+	if exists, _ := e.Exists("key"); exists {
+		t.Fatal("Key should not exist after Delete")
+	}
+}
+
+func testLen(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	if e.Len() != 0 {
+		t.Fatalf("Expected empty engine, got %d keys", e.Len())
+	}
+	mustSet(t, e, "a", "1")
+	mustSet(t, e, "b", "2")
+	e.Delete("a")
+	e.Delete("missing")
+	if e.Len() != 1 {
+		t.Fatalf("Expected 1 key, got %d", e.Len())
+	}
+}
+
+func testStats(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	empty := e.Stats().Bytes
+	mustSet(t, e, "key", string(bytes.Repeat([]byte("v"), 4096)))
+	if stats := e.Stats(); stats.Bytes < empty+4096 {
+		t.Fatalf("Expected at least %d bytes after writing a 4KB value, got %d", empty+4096, stats.Bytes)
+	}
+}
+
+func testEmptyAndBinaryValues(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	binary := []byte{0x00, 0x01, 0xfe, 0xff}
+	if err := e.Set("binary", binary); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	mustSet(t, e, "empty", "")
+
+	value, exists, _ := e.Get("binary")
+	if !exists || !bytes.Equal(value, binary) {
+		t.Fatalf("Binary value mismatch: %x", value)
+	}
+
+	value, exists, _ = e.Get("empty")
+	if !exists || len(value) != 0 {
+		t.Fatalf("Expected empty value to exist, got (%q, %v)", value, exists)
+	}
+}
+
+func testOrderedIteration(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	for _, key := range []string{"delta", "alpha", "charlie", "bravo", "echo"} {
+		mustSet(t, e, key, "value-"+key)
+	}
+	e.Delete("charlie")
+
+	var keys []string
+	err := e.Iterate("", "", func(key string, value []byte) bool {
+		if string(value) != "value-"+key {
+			t.Fatalf("Iterate gave %q for %q", value, key)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Iterate failed: %v", err)
+	}
+
+	expected := []string{"alpha", "bravo", "delta", "echo"}
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Fatalf("Iterate = %v, expected %v", keys, expected)
+	}
+}
+
+func testIterationBounds(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		mustSet(t, e, key, key)
+	}
+
+	cases := []struct {
+		start, end string
+		expected   string
+	}{
+		{"b", "d", "[b c]"},
+		{"b", "", "[b c d e]"},
+		{"", "c", "[a b]"},
+		{"bb", "dd", "[c d]"},
+		{"x", "", "[]"},
+	}
+	for _, c := range cases {
+		if keys := fmt.Sprint(collect(t, e, c.start, c.end)); keys != c.expected {
+			t.Fatalf("Iterate(%q, %q) = %s, expected %s", c.start, c.end, keys, c.expected)
+		}
+	}
+}
+
+func testReverseIteration(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		mustSet(t, e, key, "value-"+key)
+	}
+	e.Delete("c")
+
+	cases := []struct {
+		start, end string
+		expected   string
+	}{
+		{"", "", "[e d b a]"},
+		{"b", "d", "[b]"},
+		{"b", "", "[e d b]"},
+		{"", "c", "[b a]"},
+		{"bb", "dd", "[d]"},
+		{"", "a", "[]"},
+		{"x", "", "[]"},
+	}
+	for _, c := range cases {
+		if keys := fmt.Sprint(collectReverse(t, e, c.start, c.end)); keys != c.expected {
+			t.Fatalf("IterateReverse(%q, %q) = %s, expected %s", c.start, c.end, keys, c.expected)
+		}
+	}
+
+	visited := 0
+	e.IterateReverse("", "", func(key string, value []byte) bool {
+		if string(value) != "value-"+key {
+			t.Fatalf("IterateReverse gave %q for %q", value, key)
+		}
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Fatalf("Expected reverse iteration to stop after 2 keys, visited %d", visited)
+	}
+}
+
+func testIterationStopsEarly(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	for i := 0; i < 10; i++ {
+		mustSet(t, e, fmt.Sprintf("key-%d", i), "value")
+	}
+
+	visited := 0
+	e.Iterate("", "", func(string, []byte) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Fatalf("Expected iteration to stop after 3 keys, visited %d", visited)
+	}
+}
+
+func testManyKeys(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+	defer e.Close()
+
+	const n = 2000
+	expected := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		// Insert in a scrambled order to exercise splits
+		key := fmt.Sprintf("key-%05d", (i*7919)%n)
+		mustSet(t, e, key, key)
+		expected = append(expected, key)
+	}
+	for i := 0; i < n; i += 3 {
+		key := fmt.Sprintf("key-%05d", i)
+		if deleted, err := e.Delete(key); err != nil || !deleted {
+			t.Fatalf("Delete(%q) = (%v, %v)", key, deleted, err)
+		}
+	}
+
+	sort.Strings(expected)
+	var live []string
+	for _, key := range expected {
+		var i int
+		fmt.Sscanf(key, "key-%05d", &i)
+		if i%3 != 0 {
+			live = append(live, key)
+		}
+	}
+
+	if e.Len() != len(live) {
+		t.Fatalf("Expected %d keys, got %d", len(live), e.Len())
+	}
+	if keys := collect(t, e, "", ""); fmt.Sprint(keys) != fmt.Sprint(live) {
+		t.Fatalf("Iteration after many writes returned %d keys, expected %d", len(keys), len(live))
+	}
+	reversed := collectReverse(t, e, "key-00500", "key-01500")
+	var inRange []string
+	for _, key := range live {
+		if key >= "key-00500" && key < "key-01500" {
+			inRange = append(inRange, key)
+		}
+	}
+	for i := range reversed {
+		if i >= len(inRange) || reversed[i] != inRange[len(inRange)-1-i] {
+			t.Fatalf("Reverse iteration after many writes differs at %d", i)
+		}
+	}
+	if len(reversed) != len(inRange) {
+		t.Fatalf("Reverse iteration returned %d keys, expected %d", len(reversed), len(inRange))
+	}
+	expectValue(t, e, "key-01999", "key-01999")
+	expectMissing(t, e, "key-01998")
+}
+
+func testUseAfterClose(t *testing.T, open Opener) {
+	e := mustOpen(t, open, t.TempDir())
+
+	mustSet(t, e, "key", "value")
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if err := e.Set("key", []byte("value")); err == nil {
+		t.Fatal("Expected Set after Close to fail")
+	}
+	if _, _, err := e.Get("key"); err == nil {
+		t.Fatal("Expected Get after Close to fail")
+	}
+}
+
+func testReopen(t *testing.T, open Opener) {
+	dir := t.TempDir()
+	e := mustOpen(t, open, dir)
+
+	for i := 0; i < 100; i++ {
+		mustSet(t, e, fmt.Sprintf("key-%03d", i), fmt.Sprintf("value-%d", i))
+	}
+	mustSet(t, e, "key-000", "updated")
+	e.Delete("key-050")
+
+	if p, ok := e.(engine.Persistent); ok {
+		if err := p.Sync(); err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	e = mustOpen(t, open, dir)
+	defer e.Close()
+
+	if e.Len() != 99 {
+		t.Fatalf("Expected 99 keys after reopen, got %d", e.Len())
+	}
+	expectValue(t, e, "key-000", "updated")
+	expectValue(t, e, "key-099", "value-99")
+	expectMissing(t, e, "key-050")
+
+	keys := collect(t, e, "key-048", "key-053")
+	if fmt.Sprint(keys) != "[key-048 key-049 key-051 key-052]" {
+		t.Fatalf("Unexpected keys after reopen: %v", keys)
+	}
+}
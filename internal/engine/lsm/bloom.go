@@ -0,0 +1,64 @@
+package lsm
+
+import "hash/fnv"
+
+// bitsPerKey gives a false positive rate of roughly 1%
+const bitsPerKey = 10
+
+// bloomFilter is a per-SSTable filter that lets lookups skip tables that
+// cannot contain a key. The last byte of the encoding holds the number of
+// hash probes.
+type bloomFilter []byte
+
+// newBloomFilter builds a filter over keys
+func newBloomFilter(keys []string) bloomFilter {
+	bits := len(keys) * bitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+	bytes := (bits + 7) / 8
+	bits = bytes * 8
+
+	// k = bitsPerKey * ln(2) minimises the false positive rate
+	probes := bitsPerKey * 69 / 100
+	if probes < 1 {
+		probes = 1
+	}
+
+	filter := make(bloomFilter, bytes+1)
+	filter[bytes] = byte(probes)
+	for _, key := range keys {
+		h1, h2 := bloomHash(key)
+		for i := 0; i < probes; i++ {
+			bit := (h1 + uint32(i)*h2) % uint32(bits)
+			filter[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return filter
+}
+
+// mayContain reports false only if key is definitely not in the table
+func (f bloomFilter) mayContain(key string) bool {
+	if len(f) < 2 {
+		return true
+	}
+	bits := uint32(len(f)-1) * 8
+	probes := int(f[len(f)-1])
+
+	h1, h2 := bloomHash(key)
+	for i := 0; i < probes; i++ {
+		bit := (h1 + uint32(i)*h2) % bits
+		if f[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash derives the two hashes used for double hashing
+func bloomHash(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
@@ -0,0 +1,202 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+)
+
+// minTierSize groups all small tables into the first tier, so a series of
+// tiny flushes is still merged
+const minTierSize = 256 * 1024
+
+// pickCompaction chooses a run of adjacent tables of similar size to merge.
+// Tables are ordered newest first and sizes grow with age, so the run
+// starting at i takes every following table no more than twice as large as
+// the run's first table.
+func pickCompaction(tables []*table, threshold int) (int, int, bool) {
+	for i := range tables {
+		limit := tables[i].size * 2
+		if limit < minTierSize {
+			limit = minTierSize
+		}
+		j := i + 1
+		for j < len(tables) && tables[j].size <= limit {
+			j++
+		}
+		if j-i >= threshold {
+			return i, j, true
+		}
+	}
+	return 0, 0, false
+}
+
+// scheduleCompaction wakes the compactor without blocking
+func (e *Engine) scheduleCompaction() {
+	select {
+	case e.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+// compactLoop merges tables in the background until the engine is closed
+func (e *Engine) compactLoop() {
+	defer close(e.doneCh)
+
+	for {
+		select {
+		case <-e.compactCh:
+			for {
+				merged, err := e.compactTier()
+				if err != nil {
+					e.logError("Compaction failed", err)
+				}
+				if !merged || err != nil {
+					break
+				}
+			}
+		case <-e.stopCh:
+			return
+		}
+	}
+}
+
+// compactTier merges one run of similarly sized tables if there is one
+func (e *Engine) compactTier() (bool, error) {
+	e.compactMu.Lock()
+	defer e.compactMu.Unlock()
+
+	e.mu.RLock()
+	if e.closed {
+		e.mu.RUnlock()
+		return false, nil
+	}
+	tables := append([]*table(nil), e.tables...)
+	e.mu.RUnlock()
+
+	i, j, ok := pickCompaction(tables, e.opts.CompactionThreshold)
+	if !ok {
+		return false, nil
+	}
+	return true, e.merge(tables[i:j], j == len(tables))
+}
+
+// Compact merges every table into one, dropping all tombstones
+func (e *Engine) Compact() error {
+	e.compactMu.Lock()
+	defer e.compactMu.Unlock()
+
+	e.mu.RLock()
+	if e.closed {
+		e.mu.RUnlock()
+		return engine.ErrClosed
+	}
+	tables := append([]*table(nil), e.tables...)
+	e.mu.RUnlock()
+
+	if len(tables) < 2 {
+		return nil
+	}
+	return e.merge(tables, true)
+}
+
+// merge writes the contents of run into a single table and swaps it in.
+// Tables are immutable, so the merge itself runs without holding e.mu and
+// only the install blocks readers and writers. New tables are only ever
+// added in front of the list, which keeps the run contiguous. When the run
+// includes the oldest table, tombstones have nothing left to shadow and are
+// dropped.
+func (e *Engine) merge(run []*table, dropTombstones bool) error {
+	e.mu.Lock()
+	num := e.nextFile
+	e.nextFile++
+	e.mu.Unlock()
+
+	path := e.tablePath(num)
+	tw, err := newTableWriter(path, e.opts.BlockSize)
+	if err != nil {
+		return err
+	}
+
+	sources := make([]iterator, len(run))
+	for i, t := range run {
+		sources[i] = t.seek("")
+	}
+	it := newMergeIterator(sources, false)
+	for ; it.valid(); it.next() {
+		if dropTombstones && it.entry().deleted {
+			continue
+		}
+		if err := tw.add(it.key(), it.entry()); err != nil {
+			tw.abort()
+			return err
+		}
+	}
+	if err := it.err(); err != nil {
+		tw.abort()
+		return err
+	}
+
+	empty := tw.count == 0
+	if _, err := tw.finish(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	var merged *table
+	if empty {
+		os.Remove(path)
+	} else if merged, err = openTable(path, num); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	discard := func() {
+		if merged != nil {
+			merged.close()
+			os.Remove(path)
+		}
+	}
+
+	if e.closed {
+		discard()
+		return nil
+	}
+
+	start := -1
+	for i, t := range e.tables {
+		if t == run[0] {
+			start = i
+			break
+		}
+	}
+	if start < 0 || start+len(run) > len(e.tables) || e.tables[start+len(run)-1] != run[len(run)-1] {
+		discard()
+		return fmt.Errorf("compaction run is no longer contiguous")
+	}
+
+	tables := make([]*table, 0, len(e.tables)-len(run)+1)
+	tables = append(tables, e.tables[:start]...)
+	if merged != nil {
+		tables = append(tables, merged)
+	}
+	tables = append(tables, e.tables[start+len(run):]...)
+
+	if err := writeManifest(e.dir, manifestFor(e.nextFile, tables)); err != nil {
+		discard()
+		return err
+	}
+	e.tables = tables
+	e.compactions++
+
+	// Readers hold e.mu, so no one is using the old tables anymore
+	for _, t := range run {
+		t.close()
+		os.Remove(e.tablePath(t.num))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package lsm
+
+// iterator walks entries of a memtable or SSTable in key order. Forward
+// iterators ascend; reverse iterators descend, next moving to the next
+// smaller key.
+type iterator interface {
+	valid() bool
+	key() string
+	entry() entry
+	next()
+	err() error
+}
+
+// mergeIterator merges several sources into one ordered stream. Sources are
+// ordered newest first: when several hold the same key, the newest entry
+// wins and the older ones are skipped. A reverse merge takes reverse
+// sources and yields keys in descending order.
+type mergeIterator struct {
+	sources []iterator
+	current int
+	reverse bool
+}
+
+func newMergeIterator(sources []iterator, reverse bool) *mergeIterator {
+	m := &mergeIterator{sources: sources, reverse: reverse}
+	m.find()
+	return m
+}
+
+// find points current at the source holding the next key in order, the
+// smallest one or, for a reverse merge, the largest
+func (m *mergeIterator) find() {
+	m.current = -1
+	for i, it := range m.sources {
+		if !it.valid() {
+			continue
+		}
+		if m.current < 0 {
+			m.current = i
+			continue
+		}
+		key := m.sources[m.current].key()
+		if (!m.reverse && it.key() < key) || (m.reverse && it.key() > key) {
+			m.current = i
+		}
+	}
+}
+
+func (m *mergeIterator) valid() bool  { return m.current >= 0 }
+func (m *mergeIterator) key() string  { return m.sources[m.current].key() }
+func (m *mergeIterator) entry() entry { return m.sources[m.current].entry() }
+
+// next moves past the current key in every source
+func (m *mergeIterator) next() {
+	key := m.key()
+	for _, it := range m.sources {
+		if it.valid() && it.key() == key {
+			it.next()
+		}
+	}
+	m.find()
+}
+
+func (m *mergeIterator) err() error {
+	for _, it := range m.sources {
+		if err := it.err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,569 @@
+// Package lsm implements an on-disk engine built as a log-structured merge
+// tree.
+//
+// Writes go to a sorted in-memory memtable and are appended to a memtable log
+// so they survive a restart. Once the memtable grows past a threshold it is
+// flushed into an immutable SSTable file with a block index and a bloom
+// filter. Deletes are recorded as tombstones that shadow older values until
+// compaction merges them away.
+//
+// Compaction is size-tiered: a background goroutine merges runs of
+// similarly sized tables into one larger table. Tombstones are dropped once
+// the merge reaches the oldest table, since nothing older can be shadowed.
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+)
+
+const (
+	logFile   = "memtable.log"
+	tableExt  = ".sst"
+	logHeader = 13
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errTorn = errors.New("torn record")
+
+// Options tunes an LSM engine
+type Options struct {
+	MemtableSize        int // Bytes buffered in memory before a flush
+	BlockSize           int // Target size of an SSTable data block
+	CompactionThreshold int // Similar-sized tables needed to trigger a merge
+}
+
+// DefaultOptions returns the options used by the storage layer
+func DefaultOptions() Options {
+	return Options{
+		MemtableSize:        4 * 1024 * 1024,
+		BlockSize:           4 * 1024,
+		CompactionThreshold: 4,
+	}
+}
+
+// Engine is an LSM tree rooted in a directory
+type Engine struct {
+	dir  string
+	opts Options
+
+	// mu guards the fields below. Writers are already serialized by the
+	// caller but background compaction swaps tables underneath readers.
+	mu       sync.RWMutex
+	mem      *memtable
+	log      *os.File
+	logSize  int64
+	tables   []*table // Newest first
+	nextFile uint64
+	live     int
+	closed   bool
+
+	compactions uint64
+
+	compactMu sync.Mutex // Serializes compactions
+	compactCh chan struct{}
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+}
+
+var _ engine.Persistent = (*Engine)(nil)
+
+// Open opens or creates the engine stored in dir
+func Open(dir string, opts Options) (*Engine, error) {
+	defaults := DefaultOptions()
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = defaults.MemtableSize
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaults.BlockSize
+	}
+	if opts.CompactionThreshold < 2 {
+		opts.CompactionThreshold = defaults.CompactionThreshold
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lsm directory: %w", err)
+	}
+
+	e := &Engine{
+		dir:       dir,
+		opts:      opts,
+		mem:       newMemtable(),
+		compactCh: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+
+	if err := e.load(); err != nil {
+		e.closeFiles()
+		return nil, err
+	}
+
+	go e.compactLoop()
+	e.scheduleCompaction()
+	return e, nil
+}
+
+// load opens the tables named by the manifest, replays the memtable log and
+// counts the live keys
+func (e *Engine) load() error {
+	m, err := readManifest(e.dir)
+	if err != nil {
+		return err
+	}
+	e.nextFile = m.nextFile
+
+	listed := make(map[uint64]bool, len(m.tables))
+	for _, num := range m.tables {
+		t, err := openTable(e.tablePath(num), num)
+		if err != nil {
+			return err
+		}
+		e.tables = append(e.tables, t)
+		listed[num] = true
+	}
+
+	// Tables missing from the manifest come from an interrupted flush or compaction
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read lsm directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(e.dir, name))
+			continue
+		}
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, tableExt), 10, 64)
+		if err == nil && strings.HasSuffix(name, tableExt) && !listed[num] {
+			os.Remove(filepath.Join(e.dir, name))
+		}
+	}
+
+	if err := e.replayLog(); err != nil {
+		return err
+	}
+
+	it := e.iterator("")
+	for ; it.valid(); it.next() {
+		if !it.entry().deleted {
+			e.live++
+		}
+	}
+	return it.err()
+}
+
+// replayLog rebuilds the memtable from the log, trimming a torn tail
+func (e *Engine) replayLog() error {
+	f, err := os.OpenFile(filepath.Join(e.dir, logFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open memtable log: %w", err)
+	}
+	e.log = f
+
+	var offset int64
+	for {
+		key, ent, size, err := readLogRecord(f, offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Anything after the last good record is from an interrupted write
+			if err := f.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to trim memtable log: %w", err)
+			}
+			break
+		}
+		e.mem.put(key, ent)
+		offset += size
+	}
+	e.logSize = offset
+	return nil
+}
+
+// readLogRecord reads the memtable log record at offset. Records use the
+// layout crc32c (4B) | flags (1B) | key length (4B) | value length (4B) | key | value.
+func readLogRecord(r io.ReaderAt, offset int64) (string, entry, int64, error) {
+	var header [logHeader]byte
+	n, err := r.ReadAt(header[:], offset)
+	if n == 0 && err == io.EOF {
+		return "", entry{}, 0, io.EOF
+	}
+	if n < logHeader {
+		return "", entry{}, 0, errTorn
+	}
+
+	keyLen := binary.LittleEndian.Uint32(header[5:9])
+	valueLen := binary.LittleEndian.Uint32(header[9:13])
+	body := make([]byte, int64(keyLen)+int64(valueLen))
+	if _, err := r.ReadAt(body, offset+logHeader); err != nil {
+		return "", entry{}, 0, errTorn
+	}
+
+	crc := crc32.Update(0, crcTable, header[4:])
+	crc = crc32.Update(crc, crcTable, body)
+	if crc != binary.LittleEndian.Uint32(header[0:4]) {
+		return "", entry{}, 0, errTorn
+	}
+
+	ent := entry{value: body[keyLen:], deleted: header[4]&flagTombstone != 0}
+	return string(body[:keyLen]), ent, logHeader + int64(len(body)), nil
+}
+
+// appendLog records a write in the memtable log
+func (e *Engine) appendLog(key string, ent entry) error {
+	buf := make([]byte, logHeader+len(key)+len(ent.value))
+	if ent.deleted {
+		buf[4] = flagTombstone
+	}
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(len(ent.value)))
+	copy(buf[logHeader:], key)
+	copy(buf[logHeader+len(key):], ent.value)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crcTable))
+
+	if _, err := e.log.WriteAt(buf, e.logSize); err != nil {
+		return fmt.Errorf("memtable log write failed: %w", err)
+	}
+	e.logSize += int64(len(buf))
+	return nil
+}
+
+func (e *Engine) tablePath(num uint64) string {
+	return filepath.Join(e.dir, fmt.Sprintf("%06d%s", num, tableExt))
+}
+
+// lookup finds the newest entry for key, caller must hold e.mu
+func (e *Engine) lookup(key string) (entry, bool, error) {
+	if ent, found := e.mem.get(key); found {
+		return ent, true, nil
+	}
+	for _, t := range e.tables {
+		ent, found, err := t.get(key)
+		if err != nil {
+			return entry{}, false, err
+		}
+		if found {
+			return ent, true, nil
+		}
+	}
+	return entry{}, false, nil
+}
+
+// Get returns the value stored for key
+func (e *Engine) Get(key string) ([]byte, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return nil, false, engine.ErrClosed
+	}
+
+	ent, found, err := e.lookup(key)
+	if err != nil || !found || ent.deleted {
+		return nil, false, err
+	}
+	return ent.value, true, nil
+}
+
+// Exists reports whether key is present
+func (e *Engine) Exists(key string) (bool, error) {
+	_, exists, err := e.Get(key)
+	return exists, err
+}
+
+// Set writes value for key into the memtable
+func (e *Engine) Set(key string, value []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	old, found, err := e.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	ent := entry{value: value}
+	if err := e.appendLog(key, ent); err != nil {
+		return err
+	}
+	e.mem.put(key, ent)
+	if !found || old.deleted {
+		e.live++
+	}
+
+	return e.maybeFlush()
+}
+
+// Delete writes a tombstone for key
+func (e *Engine) Delete(key string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return false, engine.ErrClosed
+	}
+
+	old, found, err := e.lookup(key)
+	if err != nil {
+		return false, err
+	}
+	if !found || old.deleted {
+		return false, nil
+	}
+
+	ent := entry{deleted: true}
+	if err := e.appendLog(key, ent); err != nil {
+		return false, err
+	}
+	e.mem.put(key, ent)
+	e.live--
+
+	return true, e.maybeFlush()
+}
+
+// iterator merges the memtable and every table starting at start, caller
+// must hold e.mu
+func (e *Engine) iterator(start string) *mergeIterator {
+	sources := make([]iterator, 0, len(e.tables)+1)
+	sources = append(sources, e.mem.seek(start))
+	for _, t := range e.tables {
+		sources = append(sources, t.seek(start))
+	}
+	return newMergeIterator(sources, false)
+}
+
+// reverseIterator merges the memtable and every table backwards from the
+// last key before end, caller must hold e.mu
+func (e *Engine) reverseIterator(end string) *mergeIterator {
+	sources := make([]iterator, 0, len(e.tables)+1)
+	sources = append(sources, e.mem.seekBefore(end))
+	for _, t := range e.tables {
+		sources = append(sources, t.seekBefore(end))
+	}
+	return newMergeIterator(sources, true)
+}
+
+// Iterate visits keys in [start, end) in ascending order
+func (e *Engine) Iterate(start, end string, fn func(key string, value []byte) bool) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	it := e.iterator(start)
+	for ; it.valid(); it.next() {
+		if end != "" && it.key() >= end {
+			break
+		}
+		if it.entry().deleted {
+			continue
+		}
+		if !fn(it.key(), it.entry().value) {
+			break
+		}
+	}
+	return it.err()
+}
+
+// IterateReverse visits keys in [start, end) in descending order
+func (e *Engine) IterateReverse(start, end string, fn func(key string, value []byte) bool) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	it := e.reverseIterator(end)
+	for ; it.valid(); it.next() {
+		if it.key() < start {
+			break
+		}
+		if it.entry().deleted {
+			continue
+		}
+		if !fn(it.key(), it.entry().value) {
+			break
+		}
+	}
+	return it.err()
+}
+
+// Len returns the number of live keys
+func (e *Engine) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.live
+}
+
+// Stats reports the size of the memtable log and the SSTables, including
+// superseded entries and tombstones not yet compacted away
+func (e *Engine) Stats() engine.Stats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	stats := engine.Stats{Bytes: e.logSize, Compactions: e.compactions}
+	for _, t := range e.tables {
+		stats.Bytes += int64(t.size)
+	}
+	return stats
+}
+
+// maybeFlush flushes the memtable once it outgrows the configured size
+func (e *Engine) maybeFlush() error {
+	if e.mem.size < e.opts.MemtableSize {
+		return nil
+	}
+	return e.flush()
+}
+
+// flush writes the memtable into a new SSTable, caller must hold e.mu
+func (e *Engine) flush() error {
+	if e.mem.count == 0 {
+		return nil
+	}
+
+	num := e.nextFile
+	tw, err := newTableWriter(e.tablePath(num), e.opts.BlockSize)
+	if err != nil {
+		return err
+	}
+	for it := e.mem.seek(""); it.valid(); it.next() {
+		if err := tw.add(it.key(), it.entry()); err != nil {
+			tw.abort()
+			return err
+		}
+	}
+	if _, err := tw.finish(); err != nil {
+		os.Remove(e.tablePath(num))
+		return err
+	}
+
+	t, err := openTable(e.tablePath(num), num)
+	if err != nil {
+		return err
+	}
+
+	tables := append([]*table{t}, e.tables...)
+	if err := writeManifest(e.dir, manifestFor(num+1, tables)); err != nil {
+		t.close()
+		os.Remove(e.tablePath(num))
+		return err
+	}
+	e.nextFile = num + 1
+	e.tables = tables
+	e.mem = newMemtable()
+
+	// The flushed writes are durable in the table now. Should the truncate
+	// be lost in a crash the log is simply replayed over the table again.
+	if err := e.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to reset memtable log: %w", err)
+	}
+	e.logSize = 0
+
+	e.scheduleCompaction()
+	return nil
+}
+
+func manifestFor(nextFile uint64, tables []*table) manifest {
+	m := manifest{nextFile: nextFile, tables: make([]uint64, len(tables))}
+	for i, t := range tables {
+		m.tables[i] = t.num
+	}
+	return m
+}
+
+// Flush forces the memtable into an SSTable
+func (e *Engine) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return engine.ErrClosed
+	}
+	return e.flush()
+}
+
+// Sync flushes the memtable log to stable storage
+func (e *Engine) Sync() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return engine.ErrClosed
+	}
+	return e.log.Sync()
+}
+
+// Close stops background compaction, syncs the memtable log and closes all files
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	close(e.stopCh)
+	<-e.doneCh
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	syncErr := e.log.Sync()
+	if err := e.closeFiles(); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+func (e *Engine) closeFiles() error {
+	var firstErr error
+	if e.log != nil {
+		firstErr = e.log.Close()
+	}
+	for _, t := range e.tables {
+		if err := t.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory for sync: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// logError reports background failures that have no caller to return to
+func (e *Engine) logError(msg string, err error) {
+	slog.Error(msg, "engine", "lsm", "dir", e.dir, "err", err)
+}
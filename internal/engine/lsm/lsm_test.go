@@ -0,0 +1,219 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/enginetest"
+)
+
+// smallOptions flushes and compacts often so tests exercise the on-disk paths
+func smallOptions() Options {
+	return Options{
+		MemtableSize:        2 * 1024,
+		BlockSize:           256,
+		CompactionThreshold: 4,
+	}
+}
+
+func TestConformance(t *testing.T) {
+	enginetest.Run(t, func(dir string) (engine.Engine, error) {
+		return Open(dir, DefaultOptions())
+	}, true)
+}
+
+func TestConformance_SmallMemtable(t *testing.T) {
+	enginetest.Run(t, func(dir string) (engine.Engine, error) {
+		return Open(dir, smallOptions())
+	}, true)
+}
+
+func tableCount(e *Engine) int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.tables)
+}
+
+func TestEngine_FlushAndReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir, DefaultOptions())
+	e.Set("flushed", []byte("1"))
+	e.Set("deleted", []byte("2"))
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if tableCount(e) != 1 {
+		t.Fatalf("Expected 1 table after flush, got %d", tableCount(e))
+	}
+
+	// Writes after the flush only live in the memtable log
+	e.Delete("deleted")
+	e.Set("buffered", []byte("3"))
+	e.Close()
+
+	e, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer e.Close()
+
+	if e.Len() != 2 {
+		t.Fatalf("Expected 2 keys, got %d", e.Len())
+	}
+	for key, expected := range map[string]string{"flushed": "1", "buffered": "3"} {
+		value, exists, _ := e.Get(key)
+		if !exists || string(value) != expected {
+			t.Fatalf("Get(%q) = (%q, %v), expected %q", key, value, exists, expected)
+		}
+	}
+	if exists, _ := e.Exists("deleted"); exists {
+		t.Fatal("Tombstone in the memtable log should shadow the flushed value")
+	}
+}
+
+func TestEngine_TornLogTailIsTrimmed(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir, DefaultOptions())
+	e.Set("key", []byte("value"))
+	e.Close()
+
+	f, _ := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	f.Write([]byte{0x01, 0x02, 0x03})
+	f.Close()
+
+	e, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer e.Close()
+
+	value, exists, _ := e.Get("key")
+	if !exists || string(value) != "value" {
+		t.Fatalf("Expected 'value', got (%q, %v)", value, exists)
+	}
+}
+
+func TestEngine_BackgroundCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir, smallOptions())
+	for i := 0; i < 2000; i++ {
+		e.Set(fmt.Sprintf("key-%04d", i%500), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	// Flushes keep adding tables, compaction keeps merging them back down
+	deadline := time.Now().Add(5 * time.Second)
+	for tableCount(e) >= 2*smallOptions().CompactionThreshold {
+		if time.Now().After(deadline) {
+			t.Fatalf("Compaction did not keep up, %d tables", tableCount(e))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if e.Len() != 500 {
+		t.Fatalf("Expected 500 keys, got %d", e.Len())
+	}
+	value, _, _ := e.Get("key-0123")
+	if string(value) != "value-1623" {
+		t.Fatalf("Expected latest value 'value-1623', got %q", value)
+	}
+	e.Close()
+
+	// Only tables named by the manifest remain on disk
+	m, _ := readManifest(dir)
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+tableExt))
+	if len(files) != len(m.tables) {
+		t.Fatalf("Expected %d table files, found %d", len(m.tables), len(files))
+	}
+}
+
+func TestEngine_CompactDropsTombstones(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir, DefaultOptions())
+	defer e.Close()
+
+	for i := 0; i < 100; i++ {
+		e.Set(fmt.Sprintf("key-%03d", i), []byte("value"))
+	}
+	e.Flush()
+	for i := 0; i < 100; i += 2 {
+		e.Delete(fmt.Sprintf("key-%03d", i))
+	}
+	e.Flush()
+
+	if err := e.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if tableCount(e) != 1 {
+		t.Fatalf("Expected a single table after compaction, got %d", tableCount(e))
+	}
+	if stats := e.Stats(); stats.Compactions == 0 || stats.Bytes != int64(e.tables[0].size) {
+		t.Fatalf("Expected the compaction and the table size in the stats, got %+v", stats)
+	}
+	if count := e.tables[0].count; count != 50 {
+		t.Fatalf("Expected 50 entries without tombstones, got %d", count)
+	}
+	if e.Len() != 50 {
+		t.Fatalf("Expected 50 keys, got %d", e.Len())
+	}
+	if exists, _ := e.Exists("key-000"); exists {
+		t.Fatal("Deleted key came back after compaction")
+	}
+}
+
+func TestEngine_OrphanTablesAreRemoved(t *testing.T) {
+	dir := t.TempDir()
+
+	e, _ := Open(dir, DefaultOptions())
+	e.Set("key", []byte("value"))
+	e.Flush()
+	e.Close()
+
+	// A table written by a flush that crashed before the manifest was updated
+	orphan := filepath.Join(dir, fmt.Sprintf("%06d%s", 99, tableExt))
+	os.WriteFile(orphan, []byte("partial"), 0o644)
+
+	e, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer e.Close()
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatal("Expected orphaned table to be removed")
+	}
+	if exists, _ := e.Exists("key"); !exists {
+		t.Fatal("Expected flushed key to survive")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	var keys []string
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	filter := newBloomFilter(keys)
+
+	for _, key := range keys {
+		if !filter.mayContain(key) {
+			t.Fatalf("Filter rejected present key %q", key)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.mayContain(fmt.Sprintf("missing-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Fatalf("Too many false positives: %d of 10000", falsePositives)
+	}
+}
@@ -0,0 +1,102 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// The manifest records which SSTables make up the engine:
+//
+//	magic "TKLSMMF1" | next file number (uvarint) | table count (uvarint)
+//	  table numbers (uvarint), newest first
+//	crc32c of everything above (4B LE)
+//
+// It is replaced atomically whenever a flush or compaction installs tables,
+// so SSTable files that it does not list are leftovers and can be removed.
+const (
+	manifestFile  = "MANIFEST"
+	manifestMagic = "TKLSMMF1"
+)
+
+// manifest is the decoded contents of the manifest file
+type manifest struct {
+	nextFile uint64
+	tables   []uint64
+}
+
+// readManifest loads the manifest in dir, an absent file is an empty engine
+func readManifest(dir string) (manifest, error) {
+	m := manifest{nextFile: 1}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return m, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if len(data) < len(manifestMagic)+4 || string(data[:len(manifestMagic)]) != manifestMagic {
+		return m, fmt.Errorf("manifest is corrupt")
+	}
+	body, checksum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, crcTable) != checksum {
+		return m, fmt.Errorf("manifest checksum mismatch")
+	}
+
+	b := body[len(manifestMagic):]
+	var n int
+	if m.nextFile, n = binary.Uvarint(b); n <= 0 {
+		return m, fmt.Errorf("manifest is corrupt")
+	}
+	b = b[n:]
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return m, fmt.Errorf("manifest is corrupt")
+	}
+	b = b[n:]
+	for i := uint64(0); i < count; i++ {
+		num, n := binary.Uvarint(b)
+		if n <= 0 {
+			return m, fmt.Errorf("manifest is corrupt")
+		}
+		m.tables = append(m.tables, num)
+		b = b[n:]
+	}
+	return m, nil
+}
+
+// writeManifest atomically replaces the manifest in dir
+func writeManifest(dir string, m manifest) error {
+	buf := []byte(manifestMagic)
+	buf = binary.AppendUvarint(buf, m.nextFile)
+	buf = binary.AppendUvarint(buf, uint64(len(m.tables)))
+	for _, num := range m.tables {
+		buf = binary.AppendUvarint(buf, num)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+
+	tmpPath := filepath.Join(dir, manifestFile+".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, manifestFile)); err != nil {
+		return fmt.Errorf("failed to install manifest: %w", err)
+	}
+	return syncDir(dir)
+}
@@ -0,0 +1,146 @@
+package lsm
+
+import "math/rand/v2"
+
+const (
+	maxHeight = 12
+
+	// Each level of the skiplist holds roughly a quarter of the level below
+	levelProbability = 4
+)
+
+// entry is the latest write for a key, deleted marks a tombstone
+type entry struct {
+	value   []byte
+	deleted bool
+}
+
+type skipNode struct {
+	key   string
+	entry entry
+	next  []*skipNode
+}
+
+// memtable is a sorted in-memory buffer of recent writes, implemented as a
+// skiplist so flushes and range scans visit keys in order without sorting
+type memtable struct {
+	head   *skipNode
+	height int
+	count  int
+	size   int // Approximate bytes held, used to decide when to flush
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		head:   &skipNode{next: make([]*skipNode, maxHeight)},
+		height: 1,
+	}
+}
+
+func randomHeight() int {
+	height := 1
+	for height < maxHeight && rand.IntN(levelProbability) == 0 {
+		height++
+	}
+	return height
+}
+
+// findGreaterOrEqual returns the first node with key >= key, filling prev
+// with the rightmost node before it on every level when prev is not nil
+func (m *memtable) findGreaterOrEqual(key string, prev []*skipNode) *skipNode {
+	x := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for x.next[level] != nil && x.next[level].key < key {
+			x = x.next[level]
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return x.next[0]
+}
+
+// put records a value or tombstone for key
+func (m *memtable) put(key string, e entry) {
+	var prev [maxHeight]*skipNode
+	x := m.findGreaterOrEqual(key, prev[:])
+	if x != nil && x.key == key {
+		m.size += len(e.value) - len(x.entry.value)
+		x.entry = e
+		return
+	}
+
+	height := randomHeight()
+	if height > m.height {
+		for level := m.height; level < height; level++ {
+			prev[level] = m.head
+		}
+		m.height = height
+	}
+
+	n := &skipNode{key: key, entry: e, next: make([]*skipNode, height)}
+	for level := 0; level < height; level++ {
+		n.next[level] = prev[level].next[level]
+		prev[level].next[level] = n
+	}
+	m.count++
+	m.size += len(key) + len(e.value) + 16
+}
+
+// get returns the entry for key, if the memtable has one
+func (m *memtable) get(key string) (entry, bool) {
+	x := m.findGreaterOrEqual(key, nil)
+	if x != nil && x.key == key {
+		return x.entry, true
+	}
+	return entry{}, false
+}
+
+// memIterator walks the memtable in key order
+type memIterator struct {
+	node *skipNode
+}
+
+func (m *memtable) seek(key string) *memIterator {
+	return &memIterator{node: m.findGreaterOrEqual(key, nil)}
+}
+
+func (it *memIterator) valid() bool  { return it.node != nil }
+func (it *memIterator) key() string  { return it.node.key }
+func (it *memIterator) entry() entry { return it.node.entry }
+func (it *memIterator) next()        { it.node = it.node.next[0] }
+func (it *memIterator) err() error   { return nil }
+
+// findLessThan returns the last node with key < key, or nil if there is
+// none. An empty key finds the last node.
+func (m *memtable) findLessThan(key string) *skipNode {
+	x := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for x.next[level] != nil && (key == "" || x.next[level].key < key) {
+			x = x.next[level]
+		}
+	}
+	if x == m.head {
+		return nil
+	}
+	return x
+}
+
+// memReverseIterator walks the memtable in descending key order. The
+// skiplist has no back links, so every step searches from the head.
+type memReverseIterator struct {
+	m    *memtable
+	node *skipNode
+}
+
+// seekBefore positions a reverse iterator at the last key < key, an empty
+// key starts from the largest key
+func (m *memtable) seekBefore(key string) *memReverseIterator {
+	return &memReverseIterator{m: m, node: m.findLessThan(key)}
+}
+
+func (it *memReverseIterator) valid() bool  { return it.node != nil }
+func (it *memReverseIterator) key() string  { return it.node.key }
+func (it *memReverseIterator) entry() entry { return it.node.entry }
+func (it *memReverseIterator) next()        { it.node = it.m.findLessThan(it.node.key) }
+func (it *memReverseIterator) err() error   { return nil }
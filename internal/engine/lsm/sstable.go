@@ -0,0 +1,469 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+)
+
+// SSTable layout:
+//
+//	data blocks | bloom filter block | index block | footer
+//
+// A data block is a run of sorted entries followed by a crc32c of the block:
+//
+//	flags (1B) | key length (uvarint) | value length (uvarint) | key | value
+//
+// The index block holds, per data block, its last key, offset and length so
+// a lookup reads at most one data block. The fixed-size footer locates the
+// filter and index blocks:
+//
+//	filter offset | filter length | index offset | index length | entry count | magic
+//
+// with every field 8 bytes little endian.
+const (
+	tableMagic = "TKSST001"
+	footerSize = 48
+
+	flagTombstone = 1
+)
+
+var errCorrupt = errors.New("corrupt sstable")
+
+// blockHandle locates a data block and records the last key it holds
+type blockHandle struct {
+	lastKey string
+	offset  uint64
+	length  uint64
+}
+
+// tableWriter streams sorted entries into a new SSTable file
+type tableWriter struct {
+	f         *os.File
+	w         *bufio.Writer
+	offset    uint64
+	blockSize int
+
+	block   []byte
+	lastKey string
+	index   []blockHandle
+	keys    []string
+	count   uint64
+}
+
+func newTableWriter(path string, blockSize int) (*tableWriter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sstable: %w", err)
+	}
+	return &tableWriter{
+		f:         f,
+		w:         bufio.NewWriter(f),
+		blockSize: blockSize,
+	}, nil
+}
+
+// add appends an entry, keys must arrive in ascending order
+func (tw *tableWriter) add(key string, e entry) error {
+	var flags byte
+	if e.deleted {
+		flags = flagTombstone
+	}
+	tw.block = append(tw.block, flags)
+	tw.block = binary.AppendUvarint(tw.block, uint64(len(key)))
+	tw.block = binary.AppendUvarint(tw.block, uint64(len(e.value)))
+	tw.block = append(tw.block, key...)
+	tw.block = append(tw.block, e.value...)
+
+	tw.lastKey = key
+	tw.keys = append(tw.keys, key)
+	tw.count++
+
+	if len(tw.block) >= tw.blockSize {
+		return tw.flushBlock()
+	}
+	return nil
+}
+
+// flushBlock writes the pending data block and indexes it
+func (tw *tableWriter) flushBlock() error {
+	if len(tw.block) == 0 {
+		return nil
+	}
+	handle, err := tw.writeBlock(tw.block)
+	if err != nil {
+		return err
+	}
+	handle.lastKey = tw.lastKey
+	tw.index = append(tw.index, handle)
+	tw.block = tw.block[:0]
+	return nil
+}
+
+// writeBlock writes data followed by its checksum
+func (tw *tableWriter) writeBlock(data []byte) (blockHandle, error) {
+	handle := blockHandle{offset: tw.offset, length: uint64(len(data))}
+	if _, err := tw.w.Write(data); err != nil {
+		return handle, fmt.Errorf("sstable write failed: %w", err)
+	}
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.Checksum(data, crcTable))
+	if _, err := tw.w.Write(crc[:]); err != nil {
+		return handle, fmt.Errorf("sstable write failed: %w", err)
+	}
+	tw.offset += uint64(len(data)) + 4
+	return handle, nil
+}
+
+// finish writes the filter, index and footer, then syncs and closes the file
+func (tw *tableWriter) finish() (uint64, error) {
+	defer tw.f.Close()
+
+	if err := tw.flushBlock(); err != nil {
+		return 0, err
+	}
+
+	filter, err := tw.writeBlock(newBloomFilter(tw.keys))
+	if err != nil {
+		return 0, err
+	}
+
+	var index []byte
+	for _, h := range tw.index {
+		index = binary.AppendUvarint(index, uint64(len(h.lastKey)))
+		index = append(index, h.lastKey...)
+		index = binary.AppendUvarint(index, h.offset)
+		index = binary.AppendUvarint(index, h.length)
+	}
+	indexHandle, err := tw.writeBlock(index)
+	if err != nil {
+		return 0, err
+	}
+
+	footer := make([]byte, 0, footerSize)
+	for _, v := range []uint64{filter.offset, filter.length, indexHandle.offset, indexHandle.length, tw.count} {
+		footer = binary.LittleEndian.AppendUint64(footer, v)
+	}
+	footer = append(footer, tableMagic...)
+	if _, err := tw.w.Write(footer); err != nil {
+		return 0, fmt.Errorf("sstable write failed: %w", err)
+	}
+
+	if err := tw.w.Flush(); err != nil {
+		return 0, fmt.Errorf("sstable write failed: %w", err)
+	}
+	if err := tw.f.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync sstable: %w", err)
+	}
+	return tw.offset + footerSize, nil
+}
+
+// abort discards a partially written table
+func (tw *tableWriter) abort() {
+	tw.f.Close()
+	os.Remove(tw.f.Name())
+}
+
+// table is an open, immutable SSTable. The index and bloom filter are kept
+// in memory; data blocks are read on demand.
+type table struct {
+	num    uint64
+	f      *os.File
+	size   uint64
+	count  uint64
+	index  []blockHandle
+	filter bloomFilter
+}
+
+// openTable opens an SSTable and loads its index and filter
+func openTable(path string, num uint64) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sstable: %w", err)
+	}
+
+	t, err := loadTable(f, num)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to load sstable %s: %w", path, err)
+	}
+	return t, nil
+}
+
+func loadTable(f *os.File, num uint64) (*table, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < footerSize {
+		return nil, errCorrupt
+	}
+
+	footer := make([]byte, footerSize)
+	if _, err := f.ReadAt(footer, stat.Size()-footerSize); err != nil {
+		return nil, err
+	}
+	if string(footer[40:]) != tableMagic {
+		return nil, errCorrupt
+	}
+
+	t := &table{
+		num:   num,
+		f:     f,
+		size:  uint64(stat.Size()),
+		count: binary.LittleEndian.Uint64(footer[32:40]),
+	}
+
+	filter, err := t.readBlock(blockHandle{
+		offset: binary.LittleEndian.Uint64(footer[0:8]),
+		length: binary.LittleEndian.Uint64(footer[8:16]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.filter = filter
+
+	index, err := t.readBlock(blockHandle{
+		offset: binary.LittleEndian.Uint64(footer[16:24]),
+		length: binary.LittleEndian.Uint64(footer[24:32]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for len(index) > 0 {
+		var h blockHandle
+		key, rest, ok := readField(index)
+		if !ok {
+			return nil, errCorrupt
+		}
+		h.lastKey = string(key)
+		var n1, n2 int
+		h.offset, n1 = binary.Uvarint(rest)
+		if n1 <= 0 {
+			return nil, errCorrupt
+		}
+		h.length, n2 = binary.Uvarint(rest[n1:])
+		if n2 <= 0 {
+			return nil, errCorrupt
+		}
+		index = rest[n1+n2:]
+		t.index = append(t.index, h)
+	}
+
+	return t, nil
+}
+
+// readBlock reads a block and verifies its checksum
+func (t *table) readBlock(h blockHandle) ([]byte, error) {
+	if h.offset+h.length+4 > t.size {
+		return nil, errCorrupt
+	}
+	buf := make([]byte, h.length+4)
+	if _, err := t.f.ReadAt(buf, int64(h.offset)); err != nil {
+		return nil, fmt.Errorf("sstable read failed: %w", err)
+	}
+	data := buf[:h.length]
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(buf[h.length:]) {
+		return nil, errCorrupt
+	}
+	return data, nil
+}
+
+// blockFor returns the index of the first block that may hold key
+func (t *table) blockFor(key string) int {
+	return sort.Search(len(t.index), func(i int) bool {
+		return t.index[i].lastKey >= key
+	})
+}
+
+// get looks key up, returning the entry if the table holds one
+func (t *table) get(key string) (entry, bool, error) {
+	if !t.filter.mayContain(key) {
+		return entry{}, false, nil
+	}
+
+	i := t.blockFor(key)
+	if i == len(t.index) {
+		return entry{}, false, nil
+	}
+
+	entries, err := t.readEntries(i)
+	if err != nil {
+		return entry{}, false, err
+	}
+	j := sort.Search(len(entries), func(j int) bool {
+		return entries[j].key >= key
+	})
+	if j < len(entries) && entries[j].key == key {
+		return entries[j].entry, true, nil
+	}
+	return entry{}, false, nil
+}
+
+// blockEntry is a decoded data block entry
+type blockEntry struct {
+	key   string
+	entry entry
+}
+
+// readEntries reads and decodes data block i
+func (t *table) readEntries(i int) ([]blockEntry, error) {
+	data, err := t.readBlock(t.index[i])
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []blockEntry
+	for len(data) > 0 {
+		flags := data[0]
+		keyLen, n1 := binary.Uvarint(data[1:])
+		if n1 <= 0 {
+			return nil, errCorrupt
+		}
+		valueLen, n2 := binary.Uvarint(data[1+n1:])
+		if n2 <= 0 {
+			return nil, errCorrupt
+		}
+		data = data[1+n1+n2:]
+		if uint64(len(data)) < keyLen+valueLen {
+			return nil, errCorrupt
+		}
+
+		entries = append(entries, blockEntry{
+			key: string(data[:keyLen]),
+			entry: entry{
+				value:   data[keyLen : keyLen+valueLen : keyLen+valueLen],
+				deleted: flags&flagTombstone != 0,
+			},
+		})
+		data = data[keyLen+valueLen:]
+	}
+	return entries, nil
+}
+
+// close releases the table's file handle
+func (t *table) close() error {
+	return t.f.Close()
+}
+
+// readField reads a uvarint length-prefixed field
+func readField(b []byte) ([]byte, []byte, bool) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || uint64(len(b)-size) < n {
+		return nil, nil, false
+	}
+	return b[size : size+int(n)], b[size+int(n):], true
+}
+
+// tableIterator walks a table in key order one block at a time
+type tableIterator struct {
+	t       *table
+	block   int
+	entries []blockEntry
+	pos     int
+	failure error
+}
+
+// seek positions a new iterator at the first key >= key
+func (t *table) seek(key string) *tableIterator {
+	it := &tableIterator{t: t, block: t.blockFor(key)}
+	it.load()
+	for it.valid() && it.key() < key {
+		it.next()
+	}
+	return it
+}
+
+// load decodes the current block, skipping ahead past empty ones
+func (it *tableIterator) load() {
+	it.entries, it.pos = nil, 0
+	for it.block < len(it.t.index) {
+		entries, err := it.t.readEntries(it.block)
+		if err != nil {
+			it.failure = err
+			return
+		}
+		if len(entries) > 0 {
+			it.entries = entries
+			return
+		}
+		it.block++
+	}
+}
+
+func (it *tableIterator) valid() bool {
+	return it.failure == nil && it.pos < len(it.entries)
+}
+
+func (it *tableIterator) key() string  { return it.entries[it.pos].key }
+func (it *tableIterator) entry() entry { return it.entries[it.pos].entry }
+func (it *tableIterator) err() error   { return it.failure }
+
+func (it *tableIterator) next() {
+	it.pos++
+	if it.pos == len(it.entries) {
+		it.block++
+		it.load()
+	}
+}
+
+// tableReverseIterator walks a table in descending key order one block at
+// a time
+type tableReverseIterator struct {
+	t       *table
+	block   int
+	entries []blockEntry
+	pos     int
+	failure error
+}
+
+// seekBefore positions a new reverse iterator at the last key < key, an
+// empty key starts from the largest key
+func (t *table) seekBefore(key string) *tableReverseIterator {
+	it := &tableReverseIterator{t: t, block: len(t.index) - 1}
+	if key != "" {
+		it.block = min(t.blockFor(key), len(t.index)-1)
+	}
+	it.load()
+	for it.valid() && key != "" && it.key() >= key {
+		it.next()
+	}
+	return it
+}
+
+// load decodes the current block, skipping back past empty ones
+func (it *tableReverseIterator) load() {
+	it.entries, it.pos = nil, -1
+	for it.block >= 0 {
+		entries, err := it.t.readEntries(it.block)
+		if err != nil {
+			it.failure = err
+			return
+		}
+		if len(entries) > 0 {
+			it.entries, it.pos = entries, len(entries)-1
+			return
+		}
+		it.block--
+	}
+}
+
+func (it *tableReverseIterator) valid() bool {
+	return it.failure == nil && it.pos >= 0
+}
+
+func (it *tableReverseIterator) key() string  { return it.entries[it.pos].key }
+func (it *tableReverseIterator) entry() entry { return it.entries[it.pos].entry }
+func (it *tableReverseIterator) err() error   { return it.failure }
+
+func (it *tableReverseIterator) next() {
+	it.pos--
+	if it.pos < 0 {
+		it.block--
+		it.load()
+	}
+}
@@ -0,0 +1,127 @@
+// Package memory implements an in-memory engine backed by a Go map
+package memory
+
+import (
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/skiplist"
+)
+
+// Engine stores keys in a hash map for point lookups and a skiplist index
+// for ordered iteration
+type Engine struct {
+	data   map[string][]byte
+	index  *skiplist.List
+	bytes  int64 // Keys and values stored
+	closed bool
+}
+
+var _ engine.Engine = (*Engine)(nil)
+
+// New creates an empty in-memory engine
+func New() *Engine {
+	return &Engine{
+		data:  make(map[string][]byte),
+		index: skiplist.New(),
+	}
+}
+
+// Get returns the value stored for key
+func (e *Engine) Get(key string) ([]byte, bool, error) {
+	if e.closed {
+		return nil, false, engine.ErrClosed
+	}
+	value, exists := e.data[key]
+	return value, exists, nil
+}
+
+// Set stores value under key
+func (e *Engine) Set(key string, value []byte) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+	if old, exists := e.data[key]; exists {
+		e.bytes -= int64(len(old))
+	} else {
+		e.index.Insert(key)
+		e.bytes += int64(len(key))
+	}
+	e.data[key] = value
+	e.bytes += int64(len(value))
+	return nil
+}
+
+// Delete removes key
+func (e *Engine) Delete(key string) (bool, error) {
+	if e.closed {
+		return false, engine.ErrClosed
+	}
+	old, exists := e.data[key]
+	if exists {
+		delete(e.data, key)
+		e.index.Delete(key)
+		e.bytes -= int64(len(key) + len(old))
+	}
+	return exists, nil
+}
+
+// Exists reports whether key is present
+func (e *Engine) Exists(key string) (bool, error) {
+	if e.closed {
+		return false, engine.ErrClosed
+	}
+	_, exists := e.data[key]
+	return exists, nil
+}
+
+// Iterate visits keys in [start, end) in ascending order
+func (e *Engine) Iterate(start, end string, fn func(key string, value []byte) bool) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	for n := e.index.Seek(start); n != nil; n = n.Next() {
+		if end != "" && n.Key() >= end {
+			break
+		}
+		if !fn(n.Key(), e.data[n.Key()]) {
+			break
+		}
+	}
+	return nil
+}
+
+// IterateReverse visits keys in [start, end) in descending order
+func (e *Engine) IterateReverse(start, end string, fn func(key string, value []byte) bool) error {
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	n := e.index.Last()
+	if end != "" {
+		n = e.index.SeekBefore(end)
+	}
+	for ; n != nil && n.Key() >= start; n = n.Prev() {
+		if !fn(n.Key(), e.data[n.Key()]) {
+			break
+		}
+	}
+	return nil
+}
+
+// Len returns the number of keys stored
+func (e *Engine) Len() int {
+	return len(e.data)
+}
+
+// Stats reports the bytes of the keys and values stored
+func (e *Engine) Stats() engine.Stats {
+	return engine.Stats{Bytes: e.bytes}
+}
+
+// Close drops the data
+func (e *Engine) Close() error {
+	e.closed = true
+	e.data = nil
+	e.index = nil
+	return nil
+}
@@ -0,0 +1,30 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/enginetest"
+)
+
+func TestConformance(t *testing.T) {
+	enginetest.Run(t, func(string) (engine.Engine, error) {
+		return New(), nil
+	}, false)
+}
+
+func TestStats_CountsLiveBytes(t *testing.T) {
+	e := New()
+	e.Set("key", []byte("value"))
+	e.Set("key", []byte("longer value"))
+	e.Set("other", []byte("x"))
+	if bytes := e.Stats().Bytes; bytes != int64(len("key")+len("longer value")+len("other")+1) {
+		t.Fatalf("Expected the bytes of the live keys and values, got %d", bytes)
+	}
+
+	e.Delete("key")
+	e.Delete("other")
+	if bytes := e.Stats().Bytes; bytes != 0 {
+		t.Fatalf("Expected 0 bytes once every key is deleted, got %d", bytes)
+	}
+}
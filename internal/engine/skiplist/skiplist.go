@@ -0,0 +1,163 @@
+// Package skiplist implements an ordered set of string keys. Engines that
+// keep their data in a hash map use it as a sorted index next to the map, so
+// range scans in either direction visit keys in order without sorting.
+//
+// A List is not safe for concurrent writers but allows any number of
+// concurrent readers while no write is running, like the engines using it.
+package skiplist
+
+import "math/rand/v2"
+
+const (
+	maxHeight = 16
+
+	// Each level holds roughly a quarter of the nodes of the level below
+	levelProbability = 4
+)
+
+// Node is a key in the list
+type Node struct {
+	key  string
+	next []*Node
+	prev *Node // nil for the first node
+}
+
+// Key returns the node's key
+func (n *Node) Key() string { return n.key }
+
+// Next returns the node with the next larger key, or nil at the end
+func (n *Node) Next() *Node { return n.next[0] }
+
+// Prev returns the node with the next smaller key, or nil at the start
+func (n *Node) Prev() *Node { return n.prev }
+
+// List is an ordered set of keys
+type List struct {
+	head   *Node
+	tail   *Node
+	height int
+	len    int
+}
+
+// New creates an empty list
+func New() *List {
+	return &List{
+		head:   &Node{next: make([]*Node, maxHeight)},
+		height: 1,
+	}
+}
+
+func randomHeight() int {
+	height := 1
+	for height < maxHeight && rand.IntN(levelProbability) == 0 {
+		height++
+	}
+	return height
+}
+
+// findGreaterOrEqual returns the first node with key >= key, filling prev
+// with the rightmost node before it on every level when prev is not nil
+func (l *List) findGreaterOrEqual(key string, prev []*Node) *Node {
+	x := l.head
+	for level := l.height - 1; level >= 0; level-- {
+		for x.next[level] != nil && x.next[level].key < key {
+			x = x.next[level]
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return x.next[0]
+}
+
+// Insert adds key and reports whether it was not already present
+func (l *List) Insert(key string) bool {
+	var prev [maxHeight]*Node
+	x := l.findGreaterOrEqual(key, prev[:])
+	if x != nil && x.key == key {
+		return false
+	}
+
+	height := randomHeight()
+	if height > l.height {
+		for level := l.height; level < height; level++ {
+			prev[level] = l.head
+		}
+		l.height = height
+	}
+
+	n := &Node{key: key, next: make([]*Node, height)}
+	for level := 0; level < height; level++ {
+		n.next[level] = prev[level].next[level]
+		prev[level].next[level] = n
+	}
+	if prev[0] != l.head {
+		n.prev = prev[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		l.tail = n
+	}
+	l.len++
+	return true
+}
+
+// Delete removes key and reports whether it was present
+func (l *List) Delete(key string) bool {
+	var prev [maxHeight]*Node
+	x := l.findGreaterOrEqual(key, prev[:])
+	if x == nil || x.key != key {
+		return false
+	}
+
+	for level := 0; level < len(x.next); level++ {
+		prev[level].next[level] = x.next[level]
+	}
+	if x.next[0] != nil {
+		x.next[0].prev = x.prev
+	} else {
+		l.tail = x.prev
+	}
+	for l.height > 1 && l.head.next[l.height-1] == nil {
+		l.height--
+	}
+	l.len--
+	return true
+}
+
+// Contains reports whether key is present
+func (l *List) Contains(key string) bool {
+	x := l.findGreaterOrEqual(key, nil)
+	return x != nil && x.key == key
+}
+
+// Seek returns the first node with a key >= key, or nil if there is none
+func (l *List) Seek(key string) *Node {
+	return l.findGreaterOrEqual(key, nil)
+}
+
+// SeekBefore returns the last node with a key < key, or nil if there is none
+func (l *List) SeekBefore(key string) *Node {
+	var prev [maxHeight]*Node
+	l.findGreaterOrEqual(key, prev[:])
+	if prev[0] == l.head {
+		return nil
+	}
+	return prev[0]
+}
+
+// First returns the node with the smallest key, or nil if the list is empty
+func (l *List) First() *Node {
+	return l.head.next[0]
+}
+
+// Last returns the node with the largest key, or nil if the list is empty
+func (l *List) Last() *Node {
+	return l.tail
+}
+
+// Len returns the number of keys in the list
+func (l *List) Len() int {
+	return l.len
+}
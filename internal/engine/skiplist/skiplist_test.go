@@ -0,0 +1,104 @@
+package skiplist
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+func forward(l *List) []string {
+	var keys []string
+	for n := l.First(); n != nil; n = n.Next() {
+		keys = append(keys, n.Key())
+	}
+	return keys
+}
+
+func backward(l *List) []string {
+	var keys []string
+	for n := l.Last(); n != nil; n = n.Prev() {
+		keys = append(keys, n.Key())
+	}
+	return keys
+}
+
+func TestList_MatchesSortedSet(t *testing.T) {
+	l := New()
+	reference := make(map[string]bool)
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key-%04d", rand.IntN(500))
+		if rand.IntN(3) == 0 {
+			if l.Delete(key) != reference[key] {
+				t.Fatalf("Delete(%q) disagreed with the reference set", key)
+			}
+			delete(reference, key)
+		} else {
+			if l.Insert(key) == reference[key] {
+				t.Fatalf("Insert(%q) disagreed with the reference set", key)
+			}
+			reference[key] = true
+		}
+	}
+
+	expected := make([]string, 0, len(reference))
+	for key := range reference {
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	if l.Len() != len(expected) {
+		t.Fatalf("Expected %d keys, got %d", len(expected), l.Len())
+	}
+	got := forward(l)
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Forward order differs at %d: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+	got = backward(l)
+	for i := range expected {
+		if got[len(got)-1-i] != expected[i] {
+			t.Fatalf("Backward order differs at %d", i)
+		}
+	}
+}
+
+func TestList_Seek(t *testing.T) {
+	l := New()
+	for _, key := range []string{"b", "d", "f"} {
+		l.Insert(key)
+	}
+
+	tests := []struct {
+		key, seek, before string
+	}{
+		{"a", "b", ""},
+		{"b", "b", ""},
+		{"c", "d", "b"},
+		{"f", "f", "d"},
+		{"g", "", "f"},
+	}
+	for _, tt := range tests {
+		if n := l.Seek(tt.key); (n == nil && tt.seek != "") || (n != nil && n.Key() != tt.seek) {
+			t.Fatalf("Seek(%q) returned the wrong node", tt.key)
+		}
+		if n := l.SeekBefore(tt.key); (n == nil && tt.before != "") || (n != nil && n.Key() != tt.before) {
+			t.Fatalf("SeekBefore(%q) returned the wrong node", tt.key)
+		}
+	}
+
+	if !l.Contains("d") || l.Contains("e") {
+		t.Fatal("Contains returned the wrong result")
+	}
+
+	l.Delete("f")
+	if l.Last().Key() != "d" {
+		t.Fatalf("Expected last key d after deleting the tail, got %q", l.Last().Key())
+	}
+	l.Delete("b")
+	if l.First().Key() != "d" || l.First().Prev() != nil {
+		t.Fatal("Expected d to be the first node with no predecessor")
+	}
+}
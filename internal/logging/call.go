@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// redacted replaces key names that are not shown
+const redacted = "[REDACTED]"
+
+// keyName is a key name attached to a call's log record. It logs as
+// redacted unless the interceptor decides to show it.
+type keyName string
+
+// LogValue redacts the key name
+func (keyName) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// Key returns an attribute holding a key name, which is only logged as is
+// when the logging options show key names for the call
+func Key(name, key string) slog.Attr {
+	return slog.Any(name, keyName(key))
+}
+
+// call collects the attributes of one call's log record
+type call struct {
+	requestID string
+	showKeys  bool
+
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+type callKey struct{}
+
+func withCall(ctx context.Context, c *call) context.Context {
+	return context.WithValue(ctx, callKey{}, c)
+}
+
+func callFrom(ctx context.Context) *call {
+	c, _ := ctx.Value(callKey{}).(*call)
+	return c
+}
+
+// Add attaches attributes to the log record of the call ctx belongs to.
+// Arguments are key-value pairs or attributes, as slog.Logger.Info takes
+// them. Outside of a logged call it does nothing.
+func Add(ctx context.Context, args ...any) {
+	c := callFrom(ctx)
+	if c == nil {
+		return
+	}
+
+	attrs := slog.Group("", args...).Value.Group()
+	c.mu.Lock()
+	c.attrs = append(c.attrs, attrs...)
+	c.mu.Unlock()
+}
+
+// RequestID returns the request ID of the call ctx belongs to, or "" outside
+// of a logged call
+func RequestID(ctx context.Context) string {
+	if c := callFrom(ctx); c != nil {
+		return c.requestID
+	}
+	return ""
+}
+
+// record returns the attributes added to the call, with key names resolved
+func (c *call) record() []slog.Attr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	attrs := make([]slog.Attr, len(c.attrs))
+	for i, attr := range c.attrs {
+		if key, ok := attr.Value.Any().(keyName); ok && c.showKeys {
+			attr.Value = slog.StringValue(string(key))
+		}
+		attrs[i] = attr
+	}
+	return attrs
+}
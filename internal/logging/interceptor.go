@@ -0,0 +1,186 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata key carrying request IDs. A client may set
+// it to have its own ID logged; the ID used is returned in the response
+// header either way.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength bounds the request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// Options configures the call log. They can be changed while serving.
+type Options struct {
+	Keys          string        // KeysRedact, KeysSample or KeysShow
+	KeySample     int           // With KeysSample, key names are shown for one call in KeySample
+	SlowThreshold time.Duration // Calls taking at least this long go to the slow log, 0 disables it
+}
+
+// Interceptor logs gRPC calls
+type Interceptor struct {
+	logger *slog.Logger
+	slow   *slog.Logger
+	opts   atomic.Pointer[Options]
+	calls  atomic.Uint64
+}
+
+// NewInterceptor creates an interceptor logging calls to logger and slow
+// calls to slow
+func NewInterceptor(logger, slow *slog.Logger, opts Options) *Interceptor {
+	i := &Interceptor{logger: logger, slow: slow}
+	i.SetOptions(opts)
+	return i
+}
+
+// SetOptions replaces the options, taking effect for the calls that start
+// afterwards
+func (i *Interceptor) SetOptions(opts Options) {
+	i.opts.Store(&opts)
+}
+
+// UnaryServerInterceptor logs unary calls
+func (i *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		c, opts := i.start(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, c.requestID))
+
+		start := time.Now()
+		resp, err := handler(withCall(ctx, c), req)
+		i.finish(ctx, c, opts, info.FullMethod, time.Since(start), err, false)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor logs streams once they end. Streams stay open for
+// as long as their clients need them, so they are never logged as slow.
+func (i *Interceptor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		c, opts := i.start(ctx)
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, c.requestID))
+
+		start := time.Now()
+		err := handler(srv, &loggedStream{ServerStream: ss, ctx: withCall(ctx, c)})
+		i.finish(ctx, c, opts, info.FullMethod, time.Since(start), err, true)
+		return err
+	}
+}
+
+// start sets up the log record of a call
+func (i *Interceptor) start(ctx context.Context) (*call, Options) {
+	opts := *i.opts.Load()
+	c := &call{requestID: requestID(ctx)}
+	switch opts.Keys {
+	case KeysShow:
+		c.showKeys = true
+	case KeysSample:
+		c.showKeys = opts.KeySample > 0 && i.calls.Add(1)%uint64(opts.KeySample) == 0
+	}
+	return c, opts
+}
+
+// finish logs a completed call
+func (i *Interceptor) finish(ctx context.Context, c *call, opts Options, method string, took time.Duration, err error, stream bool) {
+	code := status.Code(err)
+	if code == codes.Unknown {
+		code = status.FromContextError(err).Code()
+	}
+
+	attrs := append([]slog.Attr{
+		slog.String("request_id", c.requestID),
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("duration", took),
+	}, c.record()...)
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	// Ties slow requests to their traces
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+
+	level := levelFor(code)
+	if notLeader(err) {
+		// Clients retry writes on the cluster leader, as a matter of course
+		level = slog.LevelDebug
+	}
+	i.logger.LogAttrs(ctx, level, "Request", attrs...)
+	if !stream && opts.SlowThreshold > 0 && took >= opts.SlowThreshold {
+		i.slow.LogAttrs(ctx, slog.LevelWarn, "Slow request", attrs...)
+	}
+}
+
+// levelFor returns the level calls ending with code are logged at
+func levelFor(code codes.Code) slog.Level {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		return slog.LevelError
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return slog.LevelWarn
+	default:
+		return slog.LevelDebug
+	}
+}
+
+// notLeader reports whether err rejects a write sent to a node that is not
+// the cluster leader
+func notLeader(err error) bool {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason == "NOT_LEADER"
+		}
+	}
+	return false
+}
+
+// requestID returns the request ID sent by the client if it is usable, or
+// a new random one
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(RequestIDHeader); len(ids) > 0 && validRequestID(ids[0]) {
+		return ids[0]
+	}
+
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts short printable ASCII IDs, which are safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// loggedStream hands handlers a context carrying the call's log record
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,200 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newTestInterceptor logs every level to logs and slow calls to slow, as
+// JSON lines
+func newTestInterceptor(t *testing.T, opts Options) (*Interceptor, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+
+	var logs, slow bytes.Buffer
+	handler, err := NewHandler(&logs, "json", slog.LevelDebug)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	slowHandler, _ := NewHandler(&slow, "json", nil)
+	return NewInterceptor(slog.New(handler), slog.New(slowHandler), opts), &logs, &slow
+}
+
+// records decodes the JSON lines in buf
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// callGet runs a unary call that logs a key and fails with err
+func callGet(i *Interceptor, ctx context.Context, err error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/kvstore.KVStore/Get"}
+	i.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		Add(ctx, "tenant", "acme", Key("key", "user:42"))
+		return nil, err
+	})
+}
+
+func TestInterceptor_LogsCalls(t *testing.T) {
+	i, logs, _ := newTestInterceptor(t, Options{Keys: KeysRedact})
+
+	callGet(i, context.Background(), nil)
+	callGet(i, context.Background(), status.Error(codes.Internal, "disk on fire"))
+
+	recs := records(t, logs)
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(recs))
+	}
+	ok, failed := recs[0], recs[1]
+	if ok["level"] != "DEBUG" || ok["method"] != "/kvstore.KVStore/Get" || ok["code"] != "OK" || ok["tenant"] != "acme" {
+		t.Fatalf("Unexpected record of a successful call: %v", ok)
+	}
+	if ok["key"] != redacted {
+		t.Fatalf("Expected the key to be redacted, got %v", ok["key"])
+	}
+	if _, ok := ok["duration"]; !ok {
+		t.Fatal("Expected the duration to be logged")
+	}
+	if id, _ := ok["request_id"].(string); len(id) != 16 || id == failed["request_id"] {
+		t.Fatalf("Expected distinct generated request IDs, got %v and %v", ok["request_id"], failed["request_id"])
+	}
+	if failed["level"] != "ERROR" || failed["code"] != "Internal" || failed["error"] != "disk on fire" {
+		t.Fatalf("Unexpected record of a failed call: %v", failed)
+	}
+}
+
+func TestInterceptor_NotLeaderIsDebug(t *testing.T) {
+	i, logs, _ := newTestInterceptor(t, Options{})
+
+	st, _ := status.New(codes.Unavailable, "not the leader").WithDetails(&errdetails.ErrorInfo{Reason: "NOT_LEADER", Domain: "tinkerdb"})
+	callGet(i, context.Background(), st.Err())
+	callGet(i, context.Background(), status.Error(codes.Unavailable, "shutting down"))
+
+	recs := records(t, logs)
+	if recs[0]["level"] != "DEBUG" || recs[1]["level"] != "ERROR" {
+		t.Fatalf("Expected redirects at DEBUG and other Unavailable errors at ERROR, got %v and %v", recs[0]["level"], recs[1]["level"])
+	}
+}
+
+func TestInterceptor_KeyPolicies(t *testing.T) {
+	i, logs, _ := newTestInterceptor(t, Options{Keys: KeysShow})
+	callGet(i, context.Background(), nil)
+	if key := records(t, logs)[0]["key"]; key != "user:42" {
+		t.Fatalf("Expected the key to be shown, got %v", key)
+	}
+
+	i, logs, _ = newTestInterceptor(t, Options{Keys: KeysSample, KeySample: 3})
+	for range 6 {
+		callGet(i, context.Background(), nil)
+	}
+	shown := 0
+	for _, rec := range records(t, logs) {
+		if rec["key"] == "user:42" {
+			shown++
+		}
+	}
+	if shown != 2 {
+		t.Fatalf("Expected the key to be shown for 2 of 6 calls, got %d", shown)
+	}
+
+	// Options apply to the calls that start afterwards
+	i.SetOptions(Options{Keys: KeysRedact})
+	logs.Reset()
+	for range 3 {
+		callGet(i, context.Background(), nil)
+	}
+	for _, rec := range records(t, logs) {
+		if rec["key"] != redacted {
+			t.Fatalf("Expected keys to be redacted after SetOptions, got %v", rec["key"])
+		}
+	}
+}
+
+func TestInterceptor_ClientRequestID(t *testing.T) {
+	i, logs, _ := newTestInterceptor(t, Options{Keys: KeysRedact})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, "trace-123"))
+	callGet(i, ctx, nil)
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, "bad\nid"))
+	callGet(i, ctx, nil)
+
+	recs := records(t, logs)
+	if recs[0]["request_id"] != "trace-123" {
+		t.Fatalf("Expected the client's request ID, got %v", recs[0]["request_id"])
+	}
+	if recs[1]["request_id"] == "bad\nid" {
+		t.Fatal("Expected an unprintable request ID to be replaced")
+	}
+}
+
+func TestInterceptor_TraceID(t *testing.T) {
+	i, logs, _ := newTestInterceptor(t, Options{Keys: KeysRedact})
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+	callGet(i, trace.ContextWithSpanContext(context.Background(), sc), nil)
+	callGet(i, context.Background(), nil)
+
+	recs := records(t, logs)
+	if recs[0]["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("Expected the trace ID to be logged, got %v", recs[0]["trace_id"])
+	}
+	if _, ok := recs[1]["trace_id"]; ok {
+		t.Fatal("Expected no trace ID for an untraced call")
+	}
+}
+
+func TestInterceptor_SlowLog(t *testing.T) {
+	i, _, slow := newTestInterceptor(t, Options{Keys: KeysRedact, SlowThreshold: 20 * time.Millisecond})
+	info := &grpc.UnaryServerInfo{FullMethod: "/kvstore.KVStore/Scan"}
+
+	i.UnaryServerInterceptor()(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, nil
+	})
+	i.UnaryServerInterceptor()(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		Add(ctx, "tenant", "acme")
+		time.Sleep(30 * time.Millisecond)
+		return nil, nil
+	})
+
+	recs := records(t, slow)
+	if len(recs) != 1 {
+		t.Fatalf("Expected only the slow call in the slow log, got %v", recs)
+	}
+	if recs[0]["level"] != "WARN" || recs[0]["msg"] != "Slow request" || recs[0]["tenant"] != "acme" {
+		t.Fatalf("Unexpected slow log record: %v", recs[0])
+	}
+}
+
+func TestAdd_OutsideOfCall(t *testing.T) {
+	// Handlers called without the interceptor, as in tests, must not panic
+	Add(context.Background(), "tenant", "acme")
+	if id := RequestID(context.Background()); id != "" {
+		t.Fatalf("Expected no request ID outside of a call, got %q", id)
+	}
+}
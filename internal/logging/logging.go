@@ -0,0 +1,40 @@
+// Package logging sets up the server's structured logs and logs gRPC calls.
+//
+// The interceptor gives every call a request ID and logs it once it
+// completes, with its method, status code and duration along with the
+// attributes handlers attach with Add. Successful calls and client errors
+// are logged at debug level, so that serving load does not flood the log;
+// denied calls are logged as warnings and server faults as errors. Calls
+// slower than a threshold are also written to a separate slow log.
+//
+// Key names are sensitive, so handlers wrap them with Key and the
+// interceptor redacts them unless configured to show them, for every call or
+// for a sample of calls.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Key name policies
+const (
+	KeysRedact = "redact" // Never log key names
+	KeysSample = "sample" // Log key names for a sample of calls
+	KeysShow   = "show"   // Always log key names
+)
+
+// NewHandler returns a handler writing records at level or above to w, as
+// logfmt-style text or as JSON lines
+func NewHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
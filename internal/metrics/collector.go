@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	tenantsDesc = prometheus.NewDesc("tinkerdb_tenants",
+		"Tenants in the store.", nil, nil)
+	tenantKeysDesc = prometheus.NewDesc("tinkerdb_tenant_keys",
+		"Keys stored by tenant, expired keys included until they are reclaimed.", []string{"tenant"}, nil)
+	tenantBytesDesc = prometheus.NewDesc("tinkerdb_tenant_bytes",
+		"Bytes used by the storage engine of each tenant.", []string{"tenant"}, nil)
+	compactionsDesc = prometheus.NewDesc("tinkerdb_engine_compactions_total",
+		"Storage engine compactions across all tenants.", nil, nil)
+	revisionDesc = prometheus.NewDesc("tinkerdb_revision",
+		"Revision of the most recent mutation.", nil, nil)
+
+	walAppendsDesc = prometheus.NewDesc("tinkerdb_wal_appends_total",
+		"Records appended to the write-ahead log.", nil, nil)
+	walAppendBytesDesc = prometheus.NewDesc("tinkerdb_wal_append_bytes_total",
+		"Bytes appended to the write-ahead log.", nil, nil)
+	walSyncsDesc = prometheus.NewDesc("tinkerdb_wal_syncs_total",
+		"Fsyncs of the write-ahead log.", nil, nil)
+	walSyncSecondsDesc = prometheus.NewDesc("tinkerdb_wal_sync_seconds_total",
+		"Time spent in fsyncs of the write-ahead log.", nil, nil)
+	walSegmentsDesc = prometheus.NewDesc("tinkerdb_wal_segments",
+		"Segment files in the write-ahead log.", nil, nil)
+
+	snapshotsDesc = prometheus.NewDesc("tinkerdb_snapshots_total",
+		"Snapshots written.", nil, nil)
+	snapshotFailuresDesc = prometheus.NewDesc("tinkerdb_snapshot_failures_total",
+		"Snapshots that failed.", nil, nil)
+	snapshotTimeDesc = prometheus.NewDesc("tinkerdb_snapshot_last_timestamp_seconds",
+		"Time the last snapshot was written.", nil, nil)
+	snapshotDurationDesc = prometheus.NewDesc("tinkerdb_snapshot_last_duration_seconds",
+		"Time taken by the last snapshot.", nil, nil)
+	snapshotSizeDesc = prometheus.NewDesc("tinkerdb_snapshot_last_size_bytes",
+		"Size of the last snapshot file.", nil, nil)
+)
+
+// storeCollector reads the stats of a store on every scrape
+type storeCollector struct {
+	store      *storage.Store
+	maxTenants int
+}
+
+func newStoreCollector(store *storage.Store, maxTenants int) *storeCollector {
+	return &storeCollector{store: store, maxTenants: maxTenants}
+}
+
+// Describe sends the descriptors of every metric of the store
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		tenantsDesc, tenantKeysDesc, tenantBytesDesc, compactionsDesc, revisionDesc,
+		walAppendsDesc, walAppendBytesDesc, walSyncsDesc, walSyncSecondsDesc, walSegmentsDesc,
+		snapshotsDesc, snapshotFailuresDesc, snapshotTimeDesc, snapshotDurationDesc, snapshotSizeDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect sends the current stats of the store
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.store.Stats()
+
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(tenantsDesc, float64(len(stats.Tenants)))
+	gauge(revisionDesc, float64(stats.Revision))
+
+	var compactions uint64
+	for _, tenant := range stats.Tenants {
+		compactions += tenant.Compactions
+	}
+	counter(compactionsDesc, float64(compactions))
+
+	for _, tenant := range boundTenants(stats.Tenants, c.maxTenants) {
+		gauge(tenantKeysDesc, float64(tenant.Keys), tenant.id)
+		gauge(tenantBytesDesc, float64(tenant.Bytes), tenant.id)
+	}
+
+	// In-memory stores have no log
+	if wal := stats.WAL; wal.Segments > 0 {
+		counter(walAppendsDesc, float64(wal.Appends))
+		counter(walAppendBytesDesc, float64(wal.AppendBytes))
+		counter(walSyncsDesc, float64(wal.Syncs))
+		counter(walSyncSecondsDesc, wal.SyncTime.Seconds())
+		gauge(walSegmentsDesc, float64(wal.Segments))
+	}
+
+	snapshots := stats.Snapshots
+	counter(snapshotsDesc, float64(snapshots.Taken))
+	counter(snapshotFailuresDesc, float64(snapshots.Failed))
+	if snapshots.Taken > 0 {
+		gauge(snapshotTimeDesc, float64(snapshots.Last.CreatedAt.UnixNano())/1e9)
+		gauge(snapshotDurationDesc, snapshots.LastDuration.Seconds())
+		gauge(snapshotSizeDesc, float64(snapshots.Last.Size))
+	}
+}
+
+type labeledTenant struct {
+	id string
+	storage.TenantStats
+}
+
+// boundTenants returns the largest maxTenants tenants by bytes, followed by
+// the sum of the others labeled OtherTenants if there are any. A tenant that
+// is itself named OtherTenants is always summed up, so labels never repeat.
+func boundTenants(tenants map[string]storage.TenantStats, maxTenants int) []labeledTenant {
+	other := labeledTenant{id: OtherTenants}
+	summed := false
+	sorted := make([]labeledTenant, 0, len(tenants))
+	for id, stats := range tenants {
+		if id == OtherTenants {
+			other.TenantStats, summed = stats, true
+			continue
+		}
+		sorted = append(sorted, labeledTenant{id: id, TenantStats: stats})
+	}
+	slices.SortFunc(sorted, func(a, b labeledTenant) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.id, b.id))
+	})
+
+	limit := min(max(maxTenants, 0), len(sorted))
+	for _, tenant := range sorted[limit:] {
+		other.Keys += tenant.Keys
+		other.Bytes += tenant.Bytes
+		summed = true
+	}
+	if !summed {
+		return sorted
+	}
+	return append(sorted[:limit], other)
+}
@@ -0,0 +1,114 @@
+// Package metrics exposes the server's metrics in the Prometheus format:
+// request counts, errors and latencies of every gRPC method, the key counts
+// and sizes of tenants, the counters of the write-ahead log, compactions and
+// snapshots, and the Go runtime and process metrics.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxTenants is the default of Options.MaxTenants
+const DefaultMaxTenants = 100
+
+// OtherTenants is the tenant label that sums up the tenants beyond
+// Options.MaxTenants
+const OtherTenants = "_other"
+
+// Options configures the metrics
+type Options struct {
+	// MaxTenants is the number of tenants, largest first, reported under
+	// their own tenant label. The rest are summed up as OtherTenants, which
+	// keeps the number of series bounded however many tenants there are.
+	MaxTenants int
+}
+
+// Metrics records the server's metrics and serves them over HTTP
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// New creates the metrics of a server serving store. The store's metrics
+// are read on every scrape.
+func New(store *storage.Store, opts Options) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tinkerdb_grpc_requests_total",
+			Help: "gRPC calls completed, by method.",
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tinkerdb_grpc_errors_total",
+			Help: "gRPC calls that failed, by method and status code.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tinkerdb_grpc_request_duration_seconds",
+			Help:    "Time taken by gRPC calls, by method. Streams are measured over their lifetime.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10), // 100µs to 26s
+		}, []string{"method"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.errors,
+		m.duration,
+		newStoreCollector(store, opts.MaxTenants),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// UnaryServerInterceptor records the count, status and latency of unary calls
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records the count, status and lifetime of streams
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+// observe records a completed call. Only registered methods reach the
+// interceptors, so the method label is bounded by the services served.
+// Context errors are reported with the code gRPC sends for them.
+func (m *Metrics) observe(method string, start time.Time, err error) {
+	m.requests.WithLabelValues(method).Inc()
+	m.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	code := status.Code(err)
+	if code == codes.Unknown {
+		code = status.FromContextError(err).Code()
+	}
+	if code != codes.OK {
+		m.errors.WithLabelValues(method, code.String()).Inc()
+	}
+}
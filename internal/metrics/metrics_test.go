@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scrape returns the metrics served by m
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, body)
+	}
+	return string(body)
+}
+
+func expectMetric(t *testing.T, body, line string) {
+	t.Helper()
+
+	if !strings.Contains(body, line+"\n") {
+		t.Fatalf("Expected %q in the metrics, got:\n%s", line, body)
+	}
+}
+
+func TestMetrics_Interceptors(t *testing.T) {
+	m := New(storage.NewStore(), Options{MaxTenants: DefaultMaxTenants})
+
+	unary := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/kvstore.KVStore/Get"}
+	unary(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, nil
+	})
+	unary(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.NotFound, "missing")
+	})
+
+	stream := m.StreamServerInterceptor()
+	stream(nil, nil, &grpc.StreamServerInfo{FullMethod: "/kvstore.KVStore/Watch"}, func(any, grpc.ServerStream) error {
+		return context.Canceled
+	})
+
+	body := scrape(t, m)
+	expectMetric(t, body, `tinkerdb_grpc_requests_total{method="/kvstore.KVStore/Get"} 2`)
+	expectMetric(t, body, `tinkerdb_grpc_errors_total{code="NotFound",method="/kvstore.KVStore/Get"} 1`)
+	expectMetric(t, body, `tinkerdb_grpc_request_duration_seconds_count{method="/kvstore.KVStore/Get"} 2`)
+	expectMetric(t, body, `tinkerdb_grpc_errors_total{code="Canceled",method="/kvstore.KVStore/Watch"} 1`)
+
+	// Runtime metrics are served as well
+	expectMetric(t, body, "# TYPE go_goroutines gauge")
+}
+
+func TestMetrics_Store(t *testing.T) {
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.SnapshotInterval = 0
+	store, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("tenant1", "key1", []byte("value"))
+	store.Set("tenant1", "key2", []byte("value"))
+	if _, err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	body := scrape(t, New(store, Options{MaxTenants: DefaultMaxTenants}))
+	expectMetric(t, body, `tinkerdb_tenants 1`)
+	expectMetric(t, body, `tinkerdb_tenant_keys{tenant="tenant1"} 2`)
+	expectMetric(t, body, `tinkerdb_wal_appends_total 2`)
+	expectMetric(t, body, `tinkerdb_snapshots_total 1`)
+	expectMetric(t, body, `tinkerdb_revision 2`)
+	if !strings.Contains(body, `tinkerdb_tenant_bytes{tenant="tenant1"} `) || !strings.Contains(body, "tinkerdb_snapshot_last_size_bytes ") {
+		t.Fatalf("Expected tenant and snapshot sizes in the metrics, got:\n%s", body)
+	}
+}
+
+func TestMetrics_BoundsTenantLabels(t *testing.T) {
+	store := storage.NewStore()
+	for i := 1; i <= 5; i++ {
+		// Tenant i holds i keys of i*100 bytes
+		for k := 0; k < i; k++ {
+			store.Set(fmt.Sprintf("tenant%d", i), fmt.Sprintf("key%d", k), make([]byte, i*100))
+		}
+	}
+	store.Set(OtherTenants, "key", []byte("value"))
+
+	body := scrape(t, New(store, Options{MaxTenants: 2}))
+	expectMetric(t, body, `tinkerdb_tenants 6`)
+	expectMetric(t, body, `tinkerdb_tenant_keys{tenant="tenant5"} 5`)
+	expectMetric(t, body, `tinkerdb_tenant_keys{tenant="tenant4"} 4`)
+	// tenant1 to tenant3 and the tenant named like the aggregate
+	expectMetric(t, body, `tinkerdb_tenant_keys{tenant="_other"} 7`)
+	if strings.Contains(body, `tenant="tenant3"`) || strings.Count(body, `tinkerdb_tenant_keys{`) != 3 {
+		t.Fatalf("Expected only the 2 largest tenants and _other to be labeled, got:\n%s", body)
+	}
+}
@@ -0,0 +1,282 @@
+package raft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ayushgala/tinkerdb/internal/wal"
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+// entry is one entry of the replicated log
+type entry struct {
+	term uint64
+	typ  pb.RaftEntry_Type
+	data []byte
+}
+
+// encode serializes the entry as: term (uvarint) | type (1B) | data
+func (e entry) encode() []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64+1+len(e.data))
+	buf = binary.AppendUvarint(buf, e.term)
+	buf = append(buf, byte(e.typ))
+	return append(buf, e.data...)
+}
+
+var errShortEntry = errors.New("raft log entry truncated")
+
+// decodeEntry parses an entry produced by encode
+func decodeEntry(data []byte) (entry, error) {
+	term, size := binary.Uvarint(data)
+	if size <= 0 || len(data) < size+1 {
+		return entry{}, errShortEntry
+	}
+	e := entry{term: term, typ: pb.RaftEntry_Type(data[size])}
+	if len(data) > size+1 {
+		e.data = data[size+1:]
+	}
+	return e, nil
+}
+
+// hardState is the state a node must not forget across restarts
+type hardState struct {
+	Term          uint64 `json:"term"`
+	Vote          string `json:"vote"`
+	SnapshotIndex uint64 `json:"snapshot_index"` // Last entry compacted away
+	SnapshotTerm  uint64 `json:"snapshot_term"`
+
+	// Applied is an index known to be committed and applied when the state
+	// was saved. It is only a lower bound, saved when convenient.
+	Applied uint64 `json:"applied"`
+
+	// Members is the membership as of the snapshot index, or the initial one
+	// of a new cluster. CONFIG entries in the log replace it.
+	Members []Member `json:"members,omitempty"`
+}
+
+// raftLog is the persistent log and hard state of a node. Entries are
+// appended to a write-ahead log, whose record indexes are the entry indexes,
+// and are also kept in memory from the snapshot index on. It is not safe
+// for concurrent use.
+type raftLog struct {
+	dir     string
+	wal     *wal.Log
+	state   hardState
+	entries []entry // entries[i] has index state.SnapshotIndex+1+i
+}
+
+// openLog opens or creates the log stored in dir
+func openLog(dir string, opts wal.Options) (*raftLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create raft directory: %w", err)
+	}
+
+	l := &raftLog{dir: dir}
+	data, err := os.ReadFile(l.statePath())
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &l.state); err != nil {
+			return nil, fmt.Errorf("failed to parse raft state: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read raft state: %w", err)
+	}
+
+	l.wal, err = wal.Open(filepath.Join(dir, "log"), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft log: %w", err)
+	}
+
+	// A crash while resetting the log can leave entries the snapshot covers
+	snapshot := l.state.SnapshotIndex
+	if l.wal.LastIndex() < snapshot {
+		if err := l.wal.Reset(snapshot + 1); err != nil {
+			l.wal.Close()
+			return nil, err
+		}
+	}
+	if first := l.wal.FirstIndex(); first > snapshot+1 {
+		l.wal.Close()
+		return nil, fmt.Errorf("raft log starts at entry %d but the snapshot ends at %d", first, snapshot)
+	}
+
+	err = l.wal.Replay(snapshot+1, func(index uint64, data []byte) error {
+		e, err := decodeEntry(data)
+		if err != nil {
+			return fmt.Errorf("raft log entry %d: %w", index, err)
+		}
+		l.entries = append(l.entries, e)
+		return nil
+	})
+	if err != nil {
+		l.wal.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *raftLog) statePath() string {
+	return filepath.Join(l.dir, "state")
+}
+
+// saveState writes the hard state atomically
+func (l *raftLog) saveState() error {
+	data, err := json.Marshal(l.state)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.statePath() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write raft state: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write raft state: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync raft state: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write raft state: %w", err)
+	}
+	if err := os.Rename(tmp, l.statePath()); err != nil {
+		return fmt.Errorf("failed to install raft state: %w", err)
+	}
+	return syncDir(l.dir)
+}
+
+// firstIndex returns the index of the oldest entry held
+func (l *raftLog) firstIndex() uint64 {
+	return l.state.SnapshotIndex + 1
+}
+
+// lastIndex returns the index of the newest entry, or the snapshot index
+// when no entry follows it
+func (l *raftLog) lastIndex() uint64 {
+	return l.state.SnapshotIndex + uint64(len(l.entries))
+}
+
+// term returns the term of the entry at index. The entry at the snapshot
+// index is known by its term only; ok is false for entries not held.
+func (l *raftLog) term(index uint64) (uint64, bool) {
+	switch {
+	case index == l.state.SnapshotIndex:
+		return l.state.SnapshotTerm, true
+	case index < l.state.SnapshotIndex || index > l.lastIndex():
+		return 0, false
+	}
+	return l.entries[index-l.firstIndex()].term, true
+}
+
+// lastTerm returns the term of the newest entry
+func (l *raftLog) lastTerm() uint64 {
+	term, _ := l.term(l.lastIndex())
+	return term
+}
+
+// slice returns the entries from lo to hi inclusive, which must be held.
+// The entries are shared and must not be modified.
+func (l *raftLog) slice(lo, hi uint64) []entry {
+	if lo > hi {
+		return nil
+	}
+	first := l.firstIndex()
+	return l.entries[lo-first : hi-first+1 : hi-first+1]
+}
+
+// membership returns the membership in effect at index, which must be held:
+// that of the newest CONFIG entry up to index and the entry's index, or else
+// the one saved with the snapshot and the snapshot index
+func (l *raftLog) membership(index uint64) ([]Member, uint64, error) {
+	for i := index; i > l.state.SnapshotIndex; i-- {
+		e := l.entries[i-l.firstIndex()]
+		if e.typ != pb.RaftEntry_CONFIG {
+			continue
+		}
+		members, err := decodeMembers(e.data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("raft log entry %d: %w", i, err)
+		}
+		return members, i, nil
+	}
+	return l.state.Members, l.state.SnapshotIndex, nil
+}
+
+// append adds entries after the newest one
+func (l *raftLog) append(entries ...entry) error {
+	for _, e := range entries {
+		if _, err := l.wal.Append(e.encode()); err != nil {
+			return fmt.Errorf("failed to append to raft log: %w", err)
+		}
+		l.entries = append(l.entries, e)
+	}
+	return nil
+}
+
+// truncate removes the entries from index on, which conflict with the
+// leader's
+func (l *raftLog) truncate(index uint64) error {
+	if err := l.wal.TruncateBack(index); err != nil {
+		return fmt.Errorf("failed to truncate raft log: %w", err)
+	}
+	l.entries = l.entries[:index-l.firstIndex()]
+	return nil
+}
+
+// compact drops the entries up to index, which a snapshot covers
+func (l *raftLog) compact(index uint64) error {
+	term, ok := l.term(index)
+	if !ok || index <= l.state.SnapshotIndex {
+		return nil
+	}
+	members, _, err := l.membership(index)
+	if err != nil {
+		return err
+	}
+
+	l.entries = append([]entry(nil), l.entries[index-l.firstIndex()+1:]...)
+	l.state.SnapshotIndex = index
+	l.state.SnapshotTerm = term
+	l.state.Members = members
+	if err := l.saveState(); err != nil {
+		return err
+	}
+	// Only segments that end before the snapshot are released, the rest is
+	// skipped on open
+	return l.wal.TruncateFront(index)
+}
+
+// reset drops every entry and continues the log after a snapshot ending
+// at index, with members as its membership
+func (l *raftLog) reset(index, term uint64, members []Member) error {
+	l.entries = nil
+	l.state.SnapshotIndex = index
+	l.state.SnapshotTerm = term
+	l.state.Applied = index
+	l.state.Members = members
+	if err := l.saveState(); err != nil {
+		return err
+	}
+	return l.wal.Reset(index + 1)
+}
+
+// syncDir fsyncs a directory so that renames survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory for sync: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/wal"
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+func command(term uint64, data string) entry {
+	return entry{term: term, typ: pb.RaftEntry_COMMAND, data: []byte(data)}
+}
+
+func TestEntry_EncodeDecode(t *testing.T) {
+	for _, e := range []entry{command(7, "set"), {term: 1, typ: pb.RaftEntry_NOOP}} {
+		decoded, err := decodeEntry(e.encode())
+		if err != nil {
+			t.Fatalf("decodeEntry failed: %v", err)
+		}
+		if decoded.term != e.term || decoded.typ != e.typ || string(decoded.data) != string(e.data) {
+			t.Fatalf("Expected %+v, got %+v", e, decoded)
+		}
+	}
+	if _, err := decodeEntry([]byte{0x80}); err == nil {
+		t.Fatal("Expected a truncated entry to fail to decode")
+	}
+}
+
+func TestLog_AppendTruncateAndReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := openLog(dir, wal.DefaultOptions())
+	if err != nil {
+		t.Fatalf("openLog failed: %v", err)
+	}
+	l.append(command(1, "a"), command(1, "b"), command(2, "c"))
+	if l.lastIndex() != 3 || l.lastTerm() != 2 {
+		t.Fatalf("Expected last entry 3 of term 2, got %d of term %d", l.lastIndex(), l.lastTerm())
+	}
+
+	// A conflicting suffix is replaced
+	if err := l.truncate(3); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+	l.append(command(3, "d"))
+
+	l.state.Term = 3
+	l.state.Vote = "n2"
+	if err := l.saveState(); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+	l.wal.Close()
+
+	l, err = openLog(dir, wal.DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer l.wal.Close()
+
+	if l.state.Term != 3 || l.state.Vote != "n2" {
+		t.Fatalf("Expected term 3 and vote n2, got %d and %q", l.state.Term, l.state.Vote)
+	}
+	entries := l.slice(1, l.lastIndex())
+	if len(entries) != 3 || string(entries[2].data) != "d" || entries[2].term != 3 {
+		t.Fatalf("Unexpected entries after reopen: %+v", entries)
+	}
+}
+
+func TestLog_CompactAndReset(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _ := openLog(dir, wal.DefaultOptions())
+	for i := 0; i < 5; i++ {
+		l.append(command(1, "x"))
+	}
+	l.append(command(2, "y"))
+
+	if err := l.compact(4); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if l.firstIndex() != 5 || l.lastIndex() != 6 {
+		t.Fatalf("Expected entries [5, 6], got [%d, %d]", l.firstIndex(), l.lastIndex())
+	}
+	if term, ok := l.term(4); !ok || term != 1 {
+		t.Fatalf("Expected the snapshot entry to keep term 1, got %d", term)
+	}
+	if _, ok := l.term(3); ok {
+		t.Fatal("Expected compacted entries to be gone")
+	}
+	l.wal.Close()
+
+	// Compacted entries are skipped on open even if their segment remains
+	l, err := openLog(dir, wal.DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if l.firstIndex() != 5 || l.lastIndex() != 6 {
+		t.Fatalf("Expected entries [5, 6] after reopen, got [%d, %d]", l.firstIndex(), l.lastIndex())
+	}
+
+	if err := l.reset(10, 3, nil); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	l.append(command(3, "z"))
+	l.wal.Close()
+
+	l, err = openLog(dir, wal.DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer l.wal.Close()
+	if l.firstIndex() != 11 || l.lastIndex() != 11 || l.state.Applied != 10 {
+		t.Fatalf("Expected entry 11 after a snapshot at 10, got [%d, %d] applied %d", l.firstIndex(), l.lastIndex(), l.state.Applied)
+	}
+	if term, _ := l.term(10); term != 3 {
+		t.Fatalf("Expected snapshot term 3, got %d", term)
+	}
+}
+
+func TestLog_Membership(t *testing.T) {
+	l, _ := openLog(t.TempDir(), wal.DefaultOptions())
+	defer l.wal.Close()
+
+	initial := []Member{{ID: "n1", Address: "a1"}}
+	grown := []Member{{ID: "n1", Address: "a1"}, {ID: "n2", Address: "a2", Learner: true}}
+	l.state.Members = initial
+	l.append(command(1, "a"), entry{term: 1, typ: pb.RaftEntry_CONFIG, data: encodeMembers(grown)}, command(1, "b"))
+
+	if members, index, _ := l.membership(1); len(members) != 1 || index != 0 {
+		t.Fatalf("Expected the initial membership before the CONFIG entry, got %+v at %d", members, index)
+	}
+	if members, index, _ := l.membership(3); len(members) != 2 || !members[1].Learner || index != 2 {
+		t.Fatalf("Expected the CONFIG entry's membership, got %+v at %d", members, index)
+	}
+
+	// Compacting past the CONFIG entry keeps its membership with the snapshot
+	if err := l.compact(3); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if members, index, _ := l.membership(3); len(members) != 2 || index != 3 {
+		t.Fatalf("Expected the membership to survive compaction, got %+v at %d", members, index)
+	}
+}
@@ -0,0 +1,414 @@
+package raft
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrUnknownMember is returned for a change naming a node that is not a
+	// member of the cluster
+	ErrUnknownMember = errors.New("no such cluster member")
+
+	// ErrMemberExists is returned when adding a node whose ID or address is
+	// already a member's
+	ErrMemberExists = errors.New("cluster member already exists")
+
+	// ErrInvalidChange is matched by the errors returned for membership
+	// changes that would leave the cluster unusable or make no sense
+	ErrInvalidChange = errors.New("invalid membership change")
+
+	// ErrChangeInProgress is returned while an earlier membership change is
+	// not committed yet, or the leadership is being transferred
+	ErrChangeInProgress = errors.New("another membership change is in progress")
+
+	// ErrLearnerBehind is returned when promoting a learner that has not
+	// caught up with the log
+	ErrLearnerBehind = errors.New("learner has not caught up with the log")
+
+	// ErrTransferFailed is returned when the leadership could not be handed
+	// over within an election timeout
+	ErrTransferFailed = errors.New("leadership transfer did not complete")
+)
+
+// Member is a node of the cluster
+type Member struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`           // Where the other nodes reach its Raft service
+	Learner bool   `json:"learner,omitempty"` // Receives the log without voting or counting toward a majority
+}
+
+// encodeMembers serializes a membership as the data of a CONFIG entry
+func encodeMembers(members []Member) []byte {
+	data, _ := proto.Marshal(&pb.RaftMembership{Members: membersToProto(members)})
+	return data
+}
+
+// decodeMembers parses the data of a CONFIG entry
+func decodeMembers(data []byte) ([]Member, error) {
+	var msg pb.RaftMembership
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to decode membership: %w", err)
+	}
+	return membersFromProto(msg.Members), nil
+}
+
+func membersToProto(members []Member) []*pb.RaftMember {
+	out := make([]*pb.RaftMember, 0, len(members))
+	for _, m := range members {
+		out = append(out, &pb.RaftMember{Id: m.ID, Address: m.Address, Learner: m.Learner})
+	}
+	return out
+}
+
+func membersFromProto(members []*pb.RaftMember) []Member {
+	out := make([]Member, 0, len(members))
+	for _, m := range members {
+		out = append(out, Member{ID: m.Id, Address: m.Address, Learner: m.Learner})
+	}
+	return out
+}
+
+// transfer is a leadership transfer in progress
+type transfer struct {
+	to       string
+	deadline time.Time
+	sent     bool          // TimeoutNow was sent to the target
+	failed   chan struct{} // Closed when the transfer is given up
+}
+
+// Members returns the members of the cluster as this node knows them,
+// ordered by ID
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.membersLocked()
+}
+
+func (n *Node) membersLocked() []Member {
+	members := slices.Collect(maps.Values(n.members))
+	slices.SortFunc(members, func(a, b Member) int { return cmp.Compare(a.ID, b.ID) })
+	return members
+}
+
+// AddMember adds the node id, reached at addr, as a learner. It returns once
+// the change is committed.
+func (n *Node) AddMember(ctx context.Context, id, addr string) error {
+	if id == "" || addr == "" {
+		return fmt.Errorf("%w: a member needs an ID and an address", ErrInvalidChange)
+	}
+	return n.changeMembership(ctx, func(members map[string]Member) error {
+		for _, m := range members {
+			if m.ID == id || m.Address == addr {
+				return fmt.Errorf("%w: %s at %s", ErrMemberExists, m.ID, m.Address)
+			}
+		}
+		members[id] = Member{ID: id, Address: addr, Learner: true}
+		return nil
+	})
+}
+
+// PromoteMember makes the learner id a voter. It waits for the learner to
+// catch up with the log first, failing with ErrLearnerBehind once ctx is
+// done.
+func (n *Node) PromoteMember(ctx context.Context, id string) error {
+	for {
+		err := n.changeMembership(ctx, func(members map[string]Member) error {
+			m, ok := members[id]
+			switch {
+			case !ok:
+				return fmt.Errorf("%w: %s", ErrUnknownMember, id)
+			case !m.Learner:
+				return fmt.Errorf("%w: %s is already a voter", ErrInvalidChange, id)
+			case !n.caughtUpLocked(id):
+				return ErrLearnerBehind
+			}
+			m.Learner = false
+			members[id] = m
+			return nil
+		})
+		if !errors.Is(err, ErrLearnerBehind) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(n.cfg.HeartbeatInterval):
+		}
+	}
+}
+
+// RemoveMember removes the node id from the cluster. A leader removing
+// itself keeps leading until the change is committed, then steps down
+// without campaigning again.
+func (n *Node) RemoveMember(ctx context.Context, id string) error {
+	return n.changeMembership(ctx, func(members map[string]Member) error {
+		m, ok := members[id]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownMember, id)
+		}
+		if !m.Learner && voters(members) == 1 {
+			return fmt.Errorf("%w: %s is the last voter", ErrInvalidChange, id)
+		}
+		delete(members, id)
+		return nil
+	})
+}
+
+// changeMembership appends, as leader, a CONFIG entry with the membership
+// change makes to a copy of the current one, and returns once it is
+// committed. Only one change is in progress at a time, so that any majority
+// of the old membership overlaps any majority of the new one.
+func (n *Node) changeMembership(ctx context.Context, change func(members map[string]Member) error) error {
+	n.mu.Lock()
+	if n.role == Leader && n.transfer != nil {
+		n.mu.Unlock()
+		return ErrChangeInProgress
+	}
+	if err := n.leadLocked(ctx); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	if n.configIndex > n.commit {
+		n.mu.Unlock()
+		return ErrChangeInProgress
+	}
+
+	members := maps.Clone(n.members)
+	if err := change(members); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	list := slices.Collect(maps.Values(members))
+	slices.SortFunc(list, func(a, b Member) int { return cmp.Compare(a.ID, b.ID) })
+
+	index := n.log.lastIndex() + 1
+	if err := n.log.append(entry{term: n.log.state.Term, typ: pb.RaftEntry_CONFIG, data: encodeMembers(list)}); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	// A membership is in effect as soon as it is in the log
+	n.setMembersLocked(list, index)
+	slog.Info("Changing cluster membership", "index", index, "members", len(list))
+
+	done := make(chan error, 1)
+	n.waiters[index] = done
+	n.advanceCommitLocked()
+	n.triggerPeersLocked()
+	n.mu.Unlock()
+
+	if err := <-done; err != nil {
+		return err
+	}
+	// Like the store's writes, the entry is applied by the writer
+	return n.fsm.ApplyCommitted(index, nil)
+}
+
+// setMembersLocked makes members, appended at index, the membership in
+// effect. A leader starts and stops replicating to the nodes it adds and
+// removes.
+func (n *Node) setMembersLocked(members []Member, index uint64) {
+	n.members = make(map[string]Member, len(members))
+	for _, m := range members {
+		n.members[m.ID] = m
+	}
+	n.configIndex = index
+	if n.role == Leader {
+		n.syncPeersLocked()
+	}
+}
+
+// loadMembershipLocked makes the membership of the newest entry the one in
+// effect, after entries were appended or truncated
+func (n *Node) loadMembershipLocked() {
+	members, index, err := n.log.membership(n.log.lastIndex())
+	if err != nil {
+		slog.Error("Failed to load the cluster membership", "err", err)
+		return
+	}
+	n.setMembersLocked(members, index)
+}
+
+// syncPeersLocked replicates to every other member, and to nothing else
+func (n *Node) syncPeersLocked() {
+	now := time.Now()
+	for id, m := range n.members {
+		if _, ok := n.peers[id]; ok || id == n.cfg.ID {
+			continue
+		}
+		p := &peer{member: m, next: n.log.lastIndex(), lastAck: now, trigger: make(chan struct{}, 1), removed: make(chan struct{})}
+		n.peers[id] = p
+		n.wg.Add(1)
+		go n.replicate(p, n.log.state.Term, n.leaderDone)
+	}
+	for id, p := range n.peers {
+		if _, ok := n.members[id]; !ok {
+			close(p.removed)
+			delete(n.peers, id)
+		}
+	}
+}
+
+// isVoterLocked reports whether node id votes and counts toward a majority
+func (n *Node) isVoterLocked(id string) bool {
+	m, ok := n.members[id]
+	return ok && !m.Learner
+}
+
+// quorumLocked returns the number of voters forming a majority
+func (n *Node) quorumLocked() int {
+	return voters(n.members)/2 + 1
+}
+
+func voters(members map[string]Member) int {
+	count := 0
+	for _, m := range members {
+		if !m.Learner {
+			count++
+		}
+	}
+	return count
+}
+
+// caughtUpLocked reports whether the leader has replicated to node id up to
+// one AppendEntries call short of the commit index
+func (n *Node) caughtUpLocked(id string) bool {
+	p, ok := n.peers[id]
+	return ok && p.match > 0 && p.match+maxAppendEntries >= n.commit
+}
+
+// TransferLeadership hands the leadership to the voter id: writes are
+// rejected while it is brought up to date, then it is told to start an
+// election, which it wins with a log at least as complete as any other.
+// It fails with ErrTransferFailed if no new leader is elected within an
+// election timeout.
+func (n *Node) TransferLeadership(ctx context.Context, id string) error {
+	n.mu.Lock()
+	if n.role == Leader && n.transfer != nil {
+		n.mu.Unlock()
+		return ErrChangeInProgress
+	}
+	if err := n.leadLocked(ctx); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	if id == n.cfg.ID {
+		n.mu.Unlock()
+		return nil
+	}
+	if !n.isVoterLocked(id) {
+		n.mu.Unlock()
+		if _, ok := n.members[id]; ok {
+			return fmt.Errorf("%w: %s is a learner", ErrInvalidChange, id)
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownMember, id)
+	}
+
+	slog.Info("Transferring leadership", "peer", id, "term", n.log.state.Term)
+	t := &transfer{to: id, deadline: time.Now().Add(n.cfg.ElectionTimeout), failed: make(chan struct{})}
+	n.transfer = t
+	done := n.leaderDone
+	p := n.peers[id]
+	n.sendTimeoutNowLocked(p)
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+	n.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-t.failed:
+		return ErrTransferFailed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Wait to hear from the next leader
+	deadline := time.Now().Add(n.cfg.ElectionTimeout)
+	for time.Now().Before(deadline) {
+		n.mu.Lock()
+		leader := n.leader
+		n.mu.Unlock()
+		if leader != "" && leader != id {
+			return fmt.Errorf("%w: %s was elected instead", ErrTransferFailed, leader)
+		}
+		if leader == id {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(n.cfg.HeartbeatInterval):
+		}
+	}
+	return nil
+}
+
+// sendTimeoutNowLocked tells the target of the transfer in progress to
+// start an election, once it holds the whole log
+func (n *Node) sendTimeoutNowLocked(p *peer) {
+	t := n.transfer
+	if t == nil || t.sent || p.member.ID != t.to || p.match != n.log.lastIndex() {
+		return
+	}
+	t.sent = true
+
+	term := n.log.state.Term
+	req := &pb.TimeoutNowRequest{Term: term, LeaderId: n.cfg.ID}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+		defer cancel()
+
+		resp, err := n.transport.TimeoutNow(ctx, p.member, req)
+		if err != nil {
+			slog.Warn("Failed to hand leadership over", "peer", p.member.ID, "err", err)
+			return
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if resp.Term > n.log.state.Term {
+			n.becomeFollowerLocked(resp.Term, "")
+		}
+	}()
+}
+
+// checkTransferLocked gives up on a transfer that did not complete within
+// an election timeout, so that the leader accepts writes again
+func (n *Node) checkTransferLocked(now time.Time) {
+	if t := n.transfer; t != nil && now.After(t.deadline) {
+		slog.Warn("Leadership transfer timed out", "peer", t.to)
+		close(t.failed)
+		n.transfer = nil
+		// The target may still win votes for a while, answers to messages
+		// sent before then no longer back a lease
+		n.leaseStart = now.Add(n.cfg.ElectionTimeout)
+	}
+}
+
+// handleTimeoutNow starts an election on the leader's request
+func (n *Node) handleTimeoutNow(req *pb.TimeoutNowRequest) *pb.TimeoutNowResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &pb.TimeoutNowResponse{Term: n.log.state.Term}
+	if req.Term != n.log.state.Term || n.role != Follower || n.leader != req.LeaderId ||
+		n.installing || n.stopped || !n.isVoterLocked(n.cfg.ID) {
+		return resp
+	}
+	slog.Info("Leadership handed over, starting an election", "leader", req.LeaderId, "term", req.Term)
+	n.campaignLocked(false, true)
+	return resp
+}
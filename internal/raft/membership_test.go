@@ -0,0 +1,193 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// member returns the membership of node id as node on knows it
+func (c *cluster) member(on, id string) (Member, bool) {
+	for _, m := range c.nodes[on].node.Members() {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+// other returns a running node other than the given ones
+func (c *cluster) other(except ...string) string {
+	for id := range c.nodes {
+		if !slices.Contains(except, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+func TestCluster_AddAndPromoteLearner(t *testing.T) {
+	c := newCluster(t, 3)
+	ctx := context.Background()
+
+	c.set("before", "join")
+	addr := c.join("n4")
+	if role := c.nodes["n4"].node.Status().Role; role != Follower {
+		t.Fatalf("Expected a joining node to wait as a follower, got %s", role)
+	}
+
+	leader := c.leader()
+	if err := c.nodes[leader].node.AddMember(ctx, "n4", addr); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	if err := c.nodes[leader].node.AddMember(ctx, "n4", addr); !errors.Is(err, ErrMemberExists) {
+		t.Fatalf("Expected ErrMemberExists adding n4 twice, got %v", err)
+	}
+
+	// The learner receives the log, including its own membership
+	c.waitValue("n4", "before", "join")
+	c.set("after", "join")
+	c.waitValue("n4", "after", "join")
+	if m, ok := c.member("n4", "n4"); !ok || !m.Learner || m.Address != addr {
+		t.Fatalf("Expected n4 to know itself as a learner, got %+v", m)
+	}
+
+	if err := c.nodes[leader].node.PromoteMember(ctx, "n4"); err != nil {
+		t.Fatalf("PromoteMember failed: %v", err)
+	}
+	for id := range c.nodes {
+		waitFor(t, id+" to see n4 as a voter", func() bool {
+			m, ok := c.member(id, "n4")
+			return ok && !m.Learner
+		})
+	}
+
+	// With four voters a majority is three: writes survive losing one node,
+	// and the promoted node keeps its membership across a restart
+	c.stop(c.other(leader, "n4"))
+	c.set("after", "promote")
+	c.stop("n4")
+	c.start("n4")
+	c.waitValue("n4", "after", "promote")
+	if m, ok := c.member("n4", "n4"); !ok || m.Learner {
+		t.Fatalf("Expected n4 to stay a voter after a restart, got %+v", m)
+	}
+}
+
+func TestCluster_PromoteRequiresLearner(t *testing.T) {
+	c := newCluster(t, 3)
+	ctx := context.Background()
+	leader := c.leader()
+	c.set("k", "v")
+
+	node := c.nodes[leader].node
+	if err := node.PromoteMember(ctx, c.other(leader)); !errors.Is(err, ErrInvalidChange) {
+		t.Fatalf("Expected promoting a voter to fail with ErrInvalidChange, got %v", err)
+	}
+	if err := node.PromoteMember(ctx, "n9"); !errors.Is(err, ErrUnknownMember) {
+		t.Fatalf("Expected ErrUnknownMember, got %v", err)
+	}
+
+	// A learner that never answers is not promoted
+	if err := node.AddMember(ctx, "n4", "127.0.0.1:1"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := node.PromoteMember(short, "n4"); !errors.Is(err, ErrLearnerBehind) {
+		t.Fatalf("Expected ErrLearnerBehind, got %v", err)
+	}
+
+	// Learners do not count toward the majority
+	c.stop(c.other(leader))
+	c.set("k", "still written")
+}
+
+func TestCluster_MembershipChangeOnFollower(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	c.set("k", "v")
+
+	follower := c.other(leader)
+	c.waitValue(follower, "k", "v")
+	err := c.nodes[follower].node.RemoveMember(context.Background(), leader)
+	if !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Expected ErrNotLeader from a follower, got %v", err)
+	}
+}
+
+func TestCluster_RemoveMember(t *testing.T) {
+	c := newCluster(t, 3)
+	ctx := context.Background()
+
+	leader := c.leader()
+	removed := c.other(leader)
+	if err := c.nodes[leader].node.RemoveMember(ctx, removed); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	c.stop(removed)
+
+	// Two voters remain, both needed for a majority
+	c.set("k", "v")
+	for id := range c.nodes {
+		c.waitValue(id, "k", "v")
+		if _, ok := c.member(id, removed); ok {
+			t.Fatalf("Expected %s to no longer list %s", id, removed)
+		}
+	}
+
+	// A leader removing itself steps down once the change commits, and the
+	// last voter takes over
+	if err := c.nodes[leader].node.RemoveMember(ctx, leader); err != nil {
+		t.Fatalf("RemoveMember of the leader failed: %v", err)
+	}
+	last := c.other(leader)
+	waitFor(t, last+" to take over", func() bool {
+		return c.nodes[last].node.Status().Role == Leader
+	})
+	if err := c.nodes[last].node.RemoveMember(ctx, last); !errors.Is(err, ErrInvalidChange) {
+		t.Fatalf("Expected removing the last voter to fail, got %v", err)
+	}
+	c.set("k", "v2")
+	time.Sleep(300 * time.Millisecond)
+	if role := c.nodes[leader].node.Status().Role; role == Leader || role == Candidate {
+		t.Fatalf("Expected the removed leader not to campaign, got %s", role)
+	}
+}
+
+func TestCluster_TransferLeadership(t *testing.T) {
+	c := newCluster(t, 3)
+	ctx := context.Background()
+
+	old := c.leader()
+	c.set("k", "v")
+	target := c.other(old)
+	if err := c.nodes[old].node.TransferLeadership(ctx, target); err != nil {
+		t.Fatalf("TransferLeadership failed: %v", err)
+	}
+	if role := c.nodes[target].node.Status().Role; role != Leader {
+		t.Fatalf("Expected %s to lead after the transfer, got %s", target, role)
+	}
+	if leader := c.nodes[old].node.Status().Leader; leader != target {
+		t.Fatalf("Expected %s to follow %s, got %q", old, target, leader)
+	}
+	c.set("k", "v2")
+	c.waitValue(old, "k", "v2")
+
+	// Only voters can take over, and a transfer to an unreachable one gives up
+	node := c.nodes[target].node
+	if err := node.AddMember(ctx, "n4", "127.0.0.1:1"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	if err := node.TransferLeadership(ctx, "n4"); !errors.Is(err, ErrInvalidChange) {
+		t.Fatalf("Expected a transfer to a learner to fail, got %v", err)
+	}
+	c.nw.isolate(old, true)
+	if err := node.TransferLeadership(ctx, old); !errors.Is(err, ErrTransferFailed) {
+		t.Fatalf("Expected a transfer to an isolated node to fail, got %v", err)
+	}
+	c.nw.isolate(old, false)
+	c.set("k", "v3")
+}
@@ -0,0 +1,1262 @@
+// Package raft replicates the write-ahead log of a storage.Store across a
+// cluster with the Raft consensus algorithm.
+//
+// A Node is the store's log: on the leader AppendContext appends a mutation,
+// replicates it to the followers and returns once a majority holds it, after
+// which the store applies it as it would a local write. Followers hand every
+// committed entry to the store through ApplyCommitted, in index order, so
+// entry indexes are the store's revisions on every node. Writes to a follower
+// fail with a NotLeaderError naming the leader.
+//
+// Every node persists its term, vote and log under Config.Dir. A new leader
+// appends a no-op entry and accepts writes once it is applied, so it has
+// applied every entry committed before it. Candidates run a pre-vote first,
+// nodes that hear from a leader ignore candidates, and a leader that loses
+// contact with a majority steps down, so that a write is only ever accepted
+// by a single leader. Followers that fall behind the compacted log are sent
+// the newest snapshot of the leader's store, and the log after it.
+//
+// The membership starts as Config.Peers and then changes one node at a time
+// through CONFIG entries, each in effect as soon as it is appended. New nodes
+// join as learners, which receive the log without voting, and are promoted
+// once they have caught up. The leader can hand its leadership to another
+// voter with TransferLeadership.
+//
+// Reads are served from the store at one of three levels. ReadIndex makes
+// them linearizable: the leader confirms it still leads with a round of
+// heartbeats, and followers ask it for its commit index, before waiting for
+// their store to apply it. LeaseRead skips the round on a leader that a
+// quorum answered within the lease, and StaleRead serves the local state of
+// any node that heard from the leader recently enough.
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/wal"
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+const (
+	// DefaultElectionTimeout is the time without a leader after which a
+	// follower starts an election, randomized up to twice as long
+	DefaultElectionTimeout = time.Second
+
+	// DefaultHeartbeatInterval is the time between the leader's heartbeats
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+
+	// maxAppendEntries bounds the entries of one AppendEntries call
+	maxAppendEntries = 256
+
+	// snapshotTimeout bounds the transfer of a snapshot to a follower
+	snapshotTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrNotLeader is matched by the errors returned for writes to a node
+	// that is not the leader. The write was not made.
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrLeadershipLost is returned for a write whose leader stepped down
+	// before it committed. The write may still be committed by the next
+	// leader.
+	ErrLeadershipLost = errors.New("leadership lost before the write committed, it may or may not be applied")
+
+	// ErrClosed is returned once the node is closed
+	ErrClosed = errors.New("raft node closed")
+
+	// ErrNotReady is returned for writes to a leader that has yet to apply
+	// earlier entries itself, those of earlier terms or ones whose writer
+	// gave up. The write was not made, callers wait with WaitReady and
+	// retry.
+	ErrNotReady = errors.New("leader is not ready for writes yet")
+
+	// ErrOutcomeUnknown is returned for a write whose caller gave up before
+	// it committed. The write may still be committed and applied.
+	ErrOutcomeUnknown = errors.New("gave up waiting for the write to commit, it may or may not be applied")
+)
+
+// NotLeaderError rejects a write to a node that is not the leader
+type NotLeaderError struct {
+	Leader string // Address of the leader, empty if none is known
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not the leader, no leader is known"
+	}
+	return "not the leader, the leader is " + e.Leader
+}
+
+// Is reports whether target is ErrNotLeader
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+// StateMachine is the store the replicated log is applied to
+type StateMachine interface {
+	// ApplyCommitted applies an entry this node did not append as leader,
+	// nil data for an entry without a mutation
+	ApplyCommitted(index uint64, data []byte) error
+
+	// SnapshotTo writes a snapshot of the state and returns its index. It
+	// must not wait for writes, which may be waiting for the follower the
+	// snapshot is for.
+	SnapshotTo(w io.Writer) (uint64, error)
+
+	// Restore replaces the state with a snapshot written by SnapshotTo and
+	// calls the node's Reset to continue the log after it
+	Restore(r io.Reader) (uint64, error)
+}
+
+// Transport carries messages to the other members
+type Transport interface {
+	RequestVote(ctx context.Context, to Member, req *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, to Member, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, to Member, req *pb.InstallSnapshotRequest, data io.Reader) (*pb.InstallSnapshotResponse, error)
+	TimeoutNow(ctx context.Context, to Member, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error)
+	ReadIndex(ctx context.Context, to Member, req *pb.ReadIndexRequest) (*pb.ReadIndexResponse, error)
+}
+
+// Config configures a Node
+type Config struct {
+	ID    string
+	Peers map[string]string // Address of every node by ID, this one included, the voters of a new cluster
+	Join  bool              // Start without members instead of Peers, to be added by the leader of a running cluster
+	Dir   string            // Directory holding the log and the persistent state
+	WAL   wal.Options       // Fsync policy and segment sizing of the log
+
+	ElectionTimeout   time.Duration // 0 for DefaultElectionTimeout
+	HeartbeatInterval time.Duration // 0 for DefaultHeartbeatInterval
+}
+
+// Role is the part a node plays in the cluster
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+// String returns the lower-case name of the role
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Status describes a node at one point in time
+type Status struct {
+	ID         string
+	Role       Role
+	Term       uint64
+	Leader     string // ID of the leader, empty if none is known
+	LastIndex  uint64 // Newest entry in the log
+	Commit     uint64 // Newest entry known to be committed
+	Applied    uint64 // Newest entry applied to the store
+	FirstIndex uint64 // Oldest entry still in the log
+	Members    []Member
+
+	// Match is the newest entry each other member is known to hold, set on
+	// the leader only
+	Match map[string]uint64
+}
+
+// peer is the leader's view of another node
+type peer struct {
+	member    Member
+	next      uint64    // Next entry to send
+	match     uint64    // Newest entry known to be replicated
+	lastAck   time.Time // When the node last answered
+	ackedSent time.Time // When the newest message the node answered was sent
+	trigger   chan struct{}
+	removed   chan struct{} // Closed when the node leaves the cluster
+}
+
+// election counts the votes of a campaign
+type election struct {
+	pre   bool
+	votes int
+}
+
+// Node is a member of a Raft cluster. It implements storage.Log, so a store
+// opened with it replicates its writes.
+type Node struct {
+	cfg       Config
+	transport Transport
+	fsm       StateMachine
+
+	mu       sync.Mutex
+	log      *raftLog
+	role     Role
+	leader   string // ID of the leader, "" if unknown
+	commit   uint64
+	applied  uint64
+	closed   bool
+	started  bool
+	election *election // Campaign in progress, nil for none
+
+	members     map[string]Member // Membership of the newest CONFIG entry, by ID
+	configIndex uint64            // Index of that entry, or of the snapshot holding it
+
+	electionDeadline time.Time
+	lastContact      time.Time // When a leader was last heard from
+
+	// Leader state, reset on every election won
+	peers      map[string]*peer
+	waiters    map[uint64]chan error // Entries appended here, by index
+	readyIndex uint64                // The no-op of this term or an abandoned entry, writes wait for it to apply
+	ready      chan struct{}         // Closed once readyIndex is applied
+	leaderDone chan struct{}         // Closed when the leadership ends
+	transfer   *transfer             // Leadership transfer in progress, nil for none
+
+	installing     bool     // A snapshot from the leader is being restored
+	installTerm    uint64   // Term of the snapshot being restored
+	installMembers []Member // Membership as of the snapshot being restored
+
+	readWait   chan struct{} // Closed on the next answer or applied entry, nil if no read waits
+	leaseStart time.Time     // Answers to messages sent earlier do not extend the lease
+
+	applyCh chan struct{}
+	applyMu sync.Mutex // Held while entries or a snapshot are applied
+
+	stop    chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewNode opens the log in cfg.Dir. The node only takes part in the cluster
+// once Start is called.
+func NewNode(cfg Config, transport Transport) (*Node, error) {
+	if _, ok := cfg.Peers[cfg.ID]; !ok && !cfg.Join {
+		return nil, fmt.Errorf("node %q is not one of the peers", cfg.ID)
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.HeartbeatInterval >= cfg.ElectionTimeout {
+		return nil, fmt.Errorf("heartbeat interval %v must be shorter than the election timeout %v", cfg.HeartbeatInterval, cfg.ElectionTimeout)
+	}
+
+	l, err := openLog(cfg.Dir, cfg.WAL)
+	if err != nil {
+		return nil, err
+	}
+	members, configIndex, err := l.membership(l.lastIndex())
+	if err != nil {
+		l.wal.Close()
+		return nil, err
+	}
+	if len(members) == 0 && !cfg.Join {
+		// A new cluster starts with the peers as voters, from then on the
+		// membership only changes through the log
+		for id, addr := range cfg.Peers {
+			members = append(members, Member{ID: id, Address: addr})
+		}
+		l.state.Members = members
+		if err := l.saveState(); err != nil {
+			l.wal.Close()
+			return nil, err
+		}
+	}
+
+	// Everything up to the saved applied index is committed, the rest is
+	// learned from the leader
+	applied := min(max(l.state.Applied, l.state.SnapshotIndex), l.lastIndex())
+	n := &Node{
+		cfg:       cfg,
+		transport: transport,
+		log:       l,
+		commit:    applied,
+		applied:   applied,
+		waiters:   make(map[uint64]chan error),
+		applyCh:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	n.setMembersLocked(members, configIndex)
+	return n, nil
+}
+
+// Start joins the cluster, applying committed entries to fsm
+func (n *Node) Start(fsm StateMachine) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.started || n.closed {
+		return
+	}
+	n.started = true
+	n.fsm = fsm
+	n.resetElectionTimerLocked()
+
+	n.wg.Add(2)
+	go n.run()
+	go n.applyLoop()
+	n.signalApply()
+}
+
+// Stop leaves the cluster: it stops elections, replication and applying
+// entries, and waits for them. It must be called before the store is
+// closed, since the store closes its log with its locks held.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	n.stepDownLocked(ErrClosed)
+	close(n.stop)
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+// Close stops the node, without waiting for it unless Stop was called
+// first, and closes the log
+func (n *Node) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return nil
+	}
+	n.closed = true
+	if !n.stopped {
+		n.stopped = true
+		n.stepDownLocked(ErrClosed)
+		close(n.stop)
+	}
+
+	n.log.state.Applied = n.applied
+	err := n.log.saveState()
+	if closeErr := n.log.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Status returns the state of the node
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	status := Status{
+		ID:         n.cfg.ID,
+		Role:       n.role,
+		Term:       n.log.state.Term,
+		Leader:     n.leader,
+		LastIndex:  n.log.lastIndex(),
+		Commit:     n.commit,
+		Applied:    n.applied,
+		FirstIndex: n.log.firstIndex(),
+		Members:    n.membersLocked(),
+	}
+	if n.role == Leader {
+		status.Match = make(map[string]uint64, len(n.peers))
+		for id, p := range n.peers {
+			status.Match[id] = p.match
+		}
+	}
+	return status
+}
+
+// LeaderAddress returns the address of the leader, empty if none is known
+func (n *Node) LeaderAddress() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.members[n.leader].Address
+}
+
+// AppendContext appends data to the log as leader and returns its index once
+// the entry is committed. If ctx is done first it fails with
+// ErrOutcomeUnknown: the entry may still commit, and is then applied through
+// ApplyCommitted like one appended elsewhere. On a node that is not the
+// leader it fails with a NotLeaderError, and on a leader that is not ready
+// with ErrNotReady: the caller may hold locks that applying the earlier
+// entries needs, so it must not wait here.
+func (n *Node) AppendContext(ctx context.Context, data []byte) (uint64, error) {
+	n.mu.Lock()
+	if err := n.writableLocked(); err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+
+	index := n.log.lastIndex() + 1
+	if err := n.log.append(entry{term: n.log.state.Term, typ: pb.RaftEntry_COMMAND, data: data}); err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+	done := make(chan error, 1)
+	n.waiters[index] = done
+	leaderDone := n.leaderDone
+	n.advanceCommitLocked()
+	n.triggerPeersLocked()
+	n.mu.Unlock()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-leaderDone:
+		// Stepping down failed the waiter
+		err = <-done
+	case <-ctx.Done():
+		err = n.abandon(ctx, index, done)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// abandon gives up on the entry at index for a writer whose ctx is done.
+// Applying the entry, should it commit, is left to the apply loop, and until
+// it is applied the leader is not ready: later writers could hold the locks
+// that applying it needs.
+func (n *Node) abandon(ctx context.Context, index uint64, done <-chan error) error {
+	n.mu.Lock()
+	if _, waiting := n.waiters[index]; !waiting {
+		// Committed or failed meanwhile, the result is on its way
+		n.mu.Unlock()
+		return <-done
+	}
+	delete(n.waiters, index)
+	n.readyIndex = max(n.readyIndex, index)
+	if n.ready == nil {
+		n.ready = make(chan struct{})
+	}
+	n.mu.Unlock()
+	return fmt.Errorf("%w: %w", ErrOutcomeUnknown, ctx.Err())
+}
+
+// WaitReady waits until this node, if it is the leader, is ready for writes,
+// see ErrNotReady. It returns at once on other nodes, where appends fail
+// anyway.
+func (n *Node) WaitReady(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for n.role == Leader && n.ready != nil {
+		if err := n.waitReadyLocked(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writableLocked returns nil if this node is a leader that accepts writes
+func (n *Node) writableLocked() error {
+	switch {
+	case n.closed || n.stopped:
+		return ErrClosed
+	case n.role != Leader:
+		return n.notLeaderLocked()
+	case n.transfer != nil:
+		// The leadership is being handed over, callers retry once the
+		// next leader is known
+		return &NotLeaderError{}
+	case n.ready != nil:
+		return ErrNotReady
+	}
+	return nil
+}
+
+// leadLocked waits until this node is a leader that accepts writes. It is
+// called and returns with n.mu held, also on error.
+func (n *Node) leadLocked(ctx context.Context) error {
+	for {
+		err := n.writableLocked()
+		if err != ErrNotReady {
+			return err
+		}
+		if err := n.waitReadyLocked(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// waitReadyLocked waits until the leader is ready or its leadership ends.
+// It is called and returns with n.mu held, also on error.
+func (n *Node) waitReadyLocked(ctx context.Context) error {
+	// A new leader accepts writes once it has applied every earlier entry
+	ready, done := n.ready, n.leaderDone
+	n.mu.Unlock()
+	defer n.mu.Lock()
+
+	select {
+	case <-ready:
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Replay calls fn for the applied entries with an index >= from that carry
+// a mutation, in order
+func (n *Node) Replay(from uint64, fn func(index uint64, data []byte) error) error {
+	n.mu.Lock()
+	lo := max(from, n.log.firstIndex())
+	entries := n.log.slice(lo, n.applied)
+	n.mu.Unlock()
+
+	for i, e := range entries {
+		if e.typ != pb.RaftEntry_COMMAND {
+			continue
+		}
+		if err := fn(lo+uint64(i), e.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FirstIndex returns the index of the oldest entry still in the log
+func (n *Node) FirstIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.log.firstIndex()
+}
+
+// LastIndex returns the index of the newest entry applied to the store
+func (n *Node) LastIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.applied
+}
+
+// Rotate seals the active log segment, and saves the applied index so that
+// a restart replays the entries up to it
+func (n *Node) Rotate() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.log.wal.Rotate(); err != nil {
+		return err
+	}
+	n.log.state.Applied = n.applied
+	return n.log.saveState()
+}
+
+// TruncateFront compacts the log up to upTo, which a snapshot of the store
+// covers. Entries not yet applied are kept.
+func (n *Node) TruncateFront(upTo uint64) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.log.compact(min(upTo, n.applied))
+}
+
+// Reset discards the log to continue after a snapshot ending at next-1,
+// which the store has restored
+func (n *Node) Reset(next uint64) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	index := next - 1
+	term, members := n.installTerm, n.installMembers
+	if term == 0 {
+		// Not installing, the store recovered from a snapshot newer than the
+		// saved applied index. The entries after it are kept if the log
+		// holds it.
+		if _, ok := n.log.term(index); ok {
+			if err := n.log.compact(index); err != nil {
+				return err
+			}
+			n.applied = max(n.applied, index)
+			n.commit = max(n.commit, index)
+			n.notifyReadsLocked()
+			return nil
+		}
+	}
+	if len(members) == 0 {
+		members = n.membersLocked()
+	}
+	if err := n.log.reset(index, term, members); err != nil {
+		return err
+	}
+	n.setMembersLocked(members, index)
+	n.applied = index
+	n.commit = max(n.commit, index)
+	n.notifyReadsLocked()
+	return nil
+}
+
+// Stats returns the counters of the log
+func (n *Node) Stats() wal.Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.log.wal.Stats()
+}
+
+func (n *Node) notLeaderLocked() error {
+	return &NotLeaderError{Leader: n.members[n.leader].Address}
+}
+
+func (n *Node) resetElectionTimerLocked() {
+	timeout := n.cfg.ElectionTimeout
+	n.electionDeadline = time.Now().Add(timeout + rand.N(timeout))
+}
+
+// run drives elections and the leader's check on its quorum
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case now := <-ticker.C:
+			n.tick(now)
+		}
+	}
+}
+
+func (n *Node) tick(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return
+	}
+	if n.role == Leader {
+		// A leader cut off from the majority steps down, so that it stops
+		// accepting writes once another leader may have been elected
+		if !n.hasQuorumLocked(now) {
+			slog.Warn("Stepping down as leader, lost contact with a quorum", "term", n.log.state.Term)
+			n.becomeFollowerLocked(n.log.state.Term, "")
+			return
+		}
+		n.checkTransferLocked(now)
+		return
+	}
+	// Learners and nodes outside the cluster never campaign
+	if now.After(n.electionDeadline) && !n.installing && n.isVoterLocked(n.cfg.ID) {
+		n.campaignLocked(true, false)
+	}
+}
+
+// hasQuorumLocked reports whether a majority answered within an election
+// timeout
+func (n *Node) hasQuorumLocked(now time.Time) bool {
+	count := 0
+	if n.isVoterLocked(n.cfg.ID) {
+		count++
+	}
+	for id, p := range n.peers {
+		if n.isVoterLocked(id) && now.Sub(p.lastAck) < n.cfg.ElectionTimeout {
+			count++
+		}
+	}
+	return count >= n.quorumLocked()
+}
+
+// campaignLocked asks the voters for their votes, in a pre-vote first unless
+// the leader handed this node the leadership
+func (n *Node) campaignLocked(pre, transfer bool) {
+	n.resetElectionTimerLocked()
+
+	term := n.log.state.Term + 1
+	if !pre {
+		n.role = Candidate
+		n.leader = ""
+		n.log.state.Term = term
+		n.log.state.Vote = n.cfg.ID
+		if err := n.log.saveState(); err != nil {
+			slog.Error("Failed to save raft state, not campaigning", "err", err)
+			n.role = Follower
+			return
+		}
+	}
+
+	e := &election{pre: pre, votes: 1}
+	n.election = e
+	if e.votes >= n.quorumLocked() {
+		n.wonLocked(e)
+		return
+	}
+
+	req := &pb.RequestVoteRequest{
+		Term:         term,
+		CandidateId:  n.cfg.ID,
+		LastLogIndex: n.log.lastIndex(),
+		LastLogTerm:  n.log.lastTerm(),
+		PreVote:      pre,
+		Transfer:     transfer,
+	}
+	for id, m := range n.members {
+		if id != n.cfg.ID && !m.Learner {
+			go n.requestVote(m, req, e)
+		}
+	}
+}
+
+// requestVote asks one voter for its vote in election e
+func (n *Node) requestVote(to Member, req *pb.RequestVoteRequest, e *election) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	defer cancel()
+
+	resp, err := n.transport.RequestVote(ctx, to, req)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return
+	}
+	if resp.Term > n.log.state.Term && !resp.VoteGranted {
+		n.becomeFollowerLocked(resp.Term, "")
+		return
+	}
+	if n.election != e || !resp.VoteGranted {
+		return
+	}
+	e.votes++
+	if e.votes >= n.quorumLocked() {
+		n.wonLocked(e)
+	}
+}
+
+// wonLocked follows up on a won election
+func (n *Node) wonLocked(e *election) {
+	n.election = nil
+	if e.pre {
+		n.campaignLocked(false, false)
+		return
+	}
+	n.becomeLeaderLocked()
+}
+
+// becomeFollowerLocked follows the leader of term, leader is "" if unknown
+func (n *Node) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.log.state.Term {
+		n.log.state.Term = term
+		n.log.state.Vote = ""
+		if err := n.log.saveState(); err != nil {
+			slog.Error("Failed to save raft state", "err", err)
+		}
+	}
+	if n.role == Leader {
+		n.stepDownLocked(ErrLeadershipLost)
+	}
+	n.role = Follower
+	n.leader = leader
+	n.election = nil
+	n.resetElectionTimerLocked()
+}
+
+// stepDownLocked ends the leadership, failing the writes waiting on it with
+// err. It does nothing on a node that is not the leader.
+func (n *Node) stepDownLocked(err error) {
+	if n.role != Leader {
+		return
+	}
+	n.role = Follower
+	n.leader = ""
+	for index, done := range n.waiters {
+		done <- err
+		delete(n.waiters, index)
+	}
+	n.peers = nil
+	n.ready = nil
+	n.transfer = nil
+	close(n.leaderDone)
+	n.notifyReadsLocked()
+}
+
+// becomeLeaderLocked takes over as leader of the current term
+func (n *Node) becomeLeaderLocked() {
+	term := n.log.state.Term
+	if err := n.log.append(entry{term: term, typ: pb.RaftEntry_NOOP}); err != nil {
+		slog.Error("Failed to append to raft log, not taking leadership", "term", term, "err", err)
+		n.role = Follower
+		return
+	}
+	slog.Info("Elected leader", "term", term)
+
+	n.role = Leader
+	n.leader = n.cfg.ID
+	n.readyIndex = n.log.lastIndex()
+	n.ready = make(chan struct{})
+	n.leaderDone = make(chan struct{})
+
+	n.peers = make(map[string]*peer)
+	n.syncPeersLocked()
+	n.advanceCommitLocked()
+}
+
+// advanceCommitLocked commits the newest entry of the leader's term that a
+// majority of the voters holds
+func (n *Node) advanceCommitLocked() {
+	var matches []uint64
+	if n.isVoterLocked(n.cfg.ID) {
+		matches = append(matches, n.log.lastIndex())
+	}
+	for id, p := range n.peers {
+		if n.isVoterLocked(id) {
+			matches = append(matches, p.match)
+		}
+	}
+	quorum := n.quorumLocked()
+	if len(matches) < quorum {
+		return
+	}
+	slices.Sort(matches)
+	index := matches[len(matches)-quorum]
+
+	if index <= n.commit {
+		return
+	}
+	// Entries of earlier terms are only committed along with one of this term
+	if term, _ := n.log.term(index); term != n.log.state.Term {
+		return
+	}
+	n.commit = index
+	n.signalApply()
+	n.triggerPeersLocked()
+}
+
+func (n *Node) triggerPeersLocked() {
+	for _, p := range n.peers {
+		select {
+		case p.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// replicate sends entries and heartbeats to one node for as long as this
+// node leads term and the node is a member
+func (n *Node) replicate(p *peer, term uint64, done <-chan struct{}) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	more := true // Announce the leadership right away
+	for {
+		if !more {
+			select {
+			case <-done:
+				return
+			case <-p.removed:
+				return
+			case <-p.trigger:
+			case <-ticker.C:
+			}
+		}
+		more = n.sendAppend(p, term)
+	}
+}
+
+// sendAppend sends the node the entries it is missing, or a heartbeat, and
+// reports whether more entries are waiting to be sent
+func (n *Node) sendAppend(p *peer, term uint64) bool {
+	n.mu.Lock()
+	if n.role != Leader || n.log.state.Term != term {
+		n.mu.Unlock()
+		return false
+	}
+	if p.next <= n.log.state.SnapshotIndex {
+		n.mu.Unlock()
+		return n.sendSnapshot(p, term)
+	}
+
+	prev := p.next - 1
+	prevTerm, _ := n.log.term(prev)
+	last := min(n.log.lastIndex(), prev+maxAppendEntries)
+	req := &pb.AppendEntriesRequest{
+		Term:         term,
+		LeaderId:     n.cfg.ID,
+		PrevLogIndex: prev,
+		PrevLogTerm:  prevTerm,
+		LeaderCommit: n.commit,
+	}
+	for _, e := range n.log.slice(p.next, last) {
+		req.Entries = append(req.Entries, &pb.RaftEntry{Term: e.term, Type: e.typ, Data: e.data})
+	}
+	n.mu.Unlock()
+
+	sent := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	resp, err := n.transport.AppendEntries(ctx, p.member, req)
+	cancel()
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if resp.Term > n.log.state.Term {
+		n.becomeFollowerLocked(resp.Term, "")
+		return false
+	}
+	if n.role != Leader || n.log.state.Term != term {
+		return false
+	}
+	p.lastAck = time.Now()
+	p.ackedSent = sent
+	n.notifyReadsLocked()
+
+	if !resp.Success {
+		// Back off to the follower's log, which is behind or conflicts
+		next := max(1, min(prev, resp.LastLogIndex+1))
+		backedOff := next < p.next
+		p.next = next
+		return backedOff
+	}
+	p.match = max(p.match, prev+uint64(len(req.Entries)))
+	p.next = max(p.next, p.match+1)
+	n.advanceCommitLocked()
+	n.sendTimeoutNowLocked(p)
+	return p.next <= n.log.lastIndex()
+}
+
+// sendSnapshot sends a node that needs compacted entries a snapshot of the
+// store
+func (n *Node) sendSnapshot(p *peer, term uint64) bool {
+	var buf bytes.Buffer
+	index, err := n.fsm.SnapshotTo(&buf)
+	if err != nil {
+		slog.Error("Failed to take snapshot for a peer", "peer", p.member.ID, "err", err)
+		return false
+	}
+
+	n.mu.Lock()
+	snapshotTerm, ok := n.log.term(index)
+	var members []Member
+	if ok {
+		members, _, err = n.log.membership(index)
+	}
+	n.mu.Unlock()
+	if !ok || err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	size := buf.Len()
+	sent := time.Now()
+	resp, err := n.transport.InstallSnapshot(ctx, p.member, &pb.InstallSnapshotRequest{
+		Term:              term,
+		LeaderId:          n.cfg.ID,
+		LastIncludedIndex: index,
+		LastIncludedTerm:  snapshotTerm,
+		Members:           membersToProto(members),
+	}, &buf)
+	if err != nil {
+		slog.Warn("Failed to send snapshot", "peer", p.member.ID, "err", err)
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if resp.Term > n.log.state.Term {
+		n.becomeFollowerLocked(resp.Term, "")
+		return false
+	}
+	if n.role != Leader || n.log.state.Term != term {
+		return false
+	}
+	slog.Info("Sent snapshot", "peer", p.member.ID, "index", index, "bytes", size)
+	p.lastAck = time.Now()
+	p.ackedSent = sent
+	n.notifyReadsLocked()
+	p.match = max(p.match, index)
+	p.next = p.match + 1
+	n.advanceCommitLocked()
+	return p.next <= n.log.lastIndex()
+}
+
+// applyLoop applies committed entries until the node stops
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.applyCh:
+			n.applyCommitted()
+		}
+	}
+}
+
+// applyCommitted applies the committed entries not applied yet. Entries
+// this node appended as leader are applied by the writer waiting on them.
+func (n *Node) applyCommitted() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	for {
+		n.mu.Lock()
+		if n.stopped || n.applied >= n.commit {
+			n.mu.Unlock()
+			return
+		}
+		lo := n.applied + 1
+		entries := n.log.slice(lo, n.commit)
+		n.mu.Unlock()
+
+		for i, e := range entries {
+			index := lo + uint64(i)
+
+			n.mu.Lock()
+			done, local := n.waiters[index]
+			delete(n.waiters, index)
+			n.mu.Unlock()
+
+			var err error
+			switch {
+			case local:
+				done <- nil
+			case e.typ == pb.RaftEntry_COMMAND:
+				err = n.fsm.ApplyCommitted(index, e.data)
+			default:
+				err = n.fsm.ApplyCommitted(index, nil)
+			}
+			if err != nil {
+				slog.Error("Failed to apply raft entry", "index", index, "err", err)
+			}
+
+			n.mu.Lock()
+			n.applied = index
+			n.notifyReadsLocked()
+			if n.ready != nil && index >= n.readyIndex {
+				close(n.ready)
+				n.ready = nil
+			}
+			if n.role == Leader && index >= n.configIndex && !n.isVoterLocked(n.cfg.ID) {
+				// The membership without this node is committed, the
+				// remaining voters elect the next leader
+				slog.Info("Stepping down as leader, removed from the voters", "term", n.log.state.Term)
+				n.becomeFollowerLocked(n.log.state.Term, "")
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// handleRequestVote answers a candidate
+func (n *Node) handleRequestVote(req *pb.RequestVoteRequest) *pb.RequestVoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &pb.RequestVoteResponse{Term: n.log.state.Term}
+	if req.Term < n.log.state.Term {
+		return resp
+	}
+
+	// While a leader is heard from, candidates are ignored without even
+	// adopting their term, unless the leader handed over to them
+	if !req.Transfer && (n.role == Leader || (n.leader != "" && time.Since(n.lastContact) < n.cfg.ElectionTimeout)) {
+		return resp
+	}
+
+	lastTerm := n.log.lastTerm()
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= n.log.lastIndex())
+	if req.PreVote {
+		resp.VoteGranted = req.Term > n.log.state.Term && upToDate
+		return resp
+	}
+
+	if req.Term > n.log.state.Term {
+		n.becomeFollowerLocked(req.Term, "")
+	}
+	resp.Term = n.log.state.Term
+
+	vote := n.log.state.Vote
+	if (vote == "" || vote == req.CandidateId) && upToDate {
+		n.log.state.Vote = req.CandidateId
+		if err := n.log.saveState(); err != nil {
+			slog.Error("Failed to save raft state, not voting", "candidate", req.CandidateId, "err", err)
+			n.log.state.Vote = vote
+			return resp
+		}
+		n.resetElectionTimerLocked()
+		resp.VoteGranted = true
+	}
+	return resp
+}
+
+// handleAppendEntries appends the leader's entries to the log
+func (n *Node) handleAppendEntries(req *pb.AppendEntriesRequest) *pb.AppendEntriesResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &pb.AppendEntriesResponse{Term: n.log.state.Term, LastLogIndex: n.log.lastIndex()}
+	if req.Term < n.log.state.Term {
+		return resp
+	}
+	n.heardFromLocked(req.Term, req.LeaderId)
+	resp.Term = n.log.state.Term
+	if n.installing {
+		return resp
+	}
+
+	prev, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	if snapshot := n.log.state.SnapshotIndex; prev < snapshot {
+		// The entries up to the snapshot are committed, so they match
+		skip := min(snapshot-prev, uint64(len(entries)))
+		entries = entries[skip:]
+		prev += skip
+		prevTerm, _ = n.log.term(prev)
+		if prev < snapshot {
+			resp.Success = true
+			return resp
+		}
+	}
+
+	if term, ok := n.log.term(prev); !ok || term != prevTerm {
+		// Hint at the last entry before the conflicting term
+		hint := min(prev-1, n.log.lastIndex())
+		if ok {
+			for hint > n.commit {
+				if t, _ := n.log.term(hint); t != term {
+					break
+				}
+				hint--
+			}
+		}
+		resp.LastLogIndex = hint
+		return resp
+	}
+
+	for i, e := range entries {
+		index := prev + 1 + uint64(i)
+		if index <= n.log.lastIndex() {
+			if term, _ := n.log.term(index); term == e.Term {
+				continue
+			}
+			if index <= n.commit {
+				slog.Error("Refusing to truncate committed raft entry", "index", index, "leader", req.LeaderId)
+				return resp
+			}
+			err := n.log.truncate(index)
+			// A truncated CONFIG entry takes its membership with it
+			n.loadMembershipLocked()
+			if err != nil {
+				slog.Error("Failed to truncate raft log", "index", index, "err", err)
+				return resp
+			}
+		}
+
+		appended := make([]entry, 0, len(entries)-i)
+		config := false
+		for _, e := range entries[i:] {
+			appended = append(appended, entry{term: e.Term, typ: e.Type, data: e.Data})
+			config = config || e.Type == pb.RaftEntry_CONFIG
+		}
+		err := n.log.append(appended...)
+		if config {
+			n.loadMembershipLocked()
+		}
+		if err != nil {
+			slog.Error("Failed to append to raft log", "index", index, "err", err)
+			return resp
+		}
+		break
+	}
+
+	if last := prev + uint64(len(entries)); req.LeaderCommit > n.commit && last > n.commit {
+		n.commit = min(req.LeaderCommit, last)
+		n.signalApply()
+	}
+	resp.Success = true
+	resp.LastLogIndex = n.log.lastIndex()
+	return resp
+}
+
+// heardFromLocked follows the leader of term, which just contacted this node
+func (n *Node) heardFromLocked(term uint64, leader string) {
+	if n.leader != leader {
+		slog.Info("Following leader", "leader", leader, "term", term)
+	}
+	if term > n.log.state.Term || n.role != Follower {
+		n.becomeFollowerLocked(term, leader)
+	}
+	n.leader = leader
+	n.lastContact = time.Now()
+	n.resetElectionTimerLocked()
+}
+
+// handleInstallSnapshot restores the store from the leader's snapshot
+func (n *Node) handleInstallSnapshot(req *pb.InstallSnapshotRequest, data io.Reader) (*pb.InstallSnapshotResponse, error) {
+	n.mu.Lock()
+	resp := &pb.InstallSnapshotResponse{Term: n.log.state.Term}
+	if req.Term < n.log.state.Term {
+		n.mu.Unlock()
+		return resp, nil
+	}
+	n.heardFromLocked(req.Term, req.LeaderId)
+	resp.Term = n.log.state.Term
+	if req.LastIncludedIndex <= n.applied || n.installing || n.stopped {
+		n.mu.Unlock()
+		return resp, nil
+	}
+	n.installing = true
+	n.mu.Unlock()
+
+	// Hold off applying entries while the store is replaced
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	n.installTerm = req.LastIncludedTerm
+	n.installMembers = membersFromProto(req.Members)
+	n.mu.Unlock()
+
+	index, err := n.fsm.Restore(data)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.installing = false
+	n.installTerm = 0
+	n.installMembers = nil
+	n.resetElectionTimerLocked()
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	slog.Info("Installed snapshot", "index", index, "leader", req.LeaderId)
+	n.signalApply()
+	return resp, nil
+}
@@ -0,0 +1,530 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+var errUnreachable = errors.New("node unreachable")
+
+// network delivers messages between the nodes of a test cluster, except to
+// and from the isolated ones
+type network struct {
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	isolated map[string]bool
+}
+
+func (nw *network) node(from, to string) (*Node, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	n, ok := nw.nodes[to]
+	if !ok || nw.isolated[from] || nw.isolated[to] {
+		return nil, errUnreachable
+	}
+	return n, nil
+}
+
+func (nw *network) isolate(id string, isolated bool) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.isolated[id] = isolated
+}
+
+// memTransport is the transport of one node of a network
+type memTransport struct {
+	nw   *network
+	from string
+}
+
+func (t *memTransport) RequestVote(ctx context.Context, to Member, req *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error) {
+	n, err := t.nw.node(t.from, to.ID)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleRequestVote(req), nil
+}
+
+func (t *memTransport) AppendEntries(ctx context.Context, to Member, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	n, err := t.nw.node(t.from, to.ID)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleAppendEntries(req), nil
+}
+
+func (t *memTransport) InstallSnapshot(ctx context.Context, to Member, req *pb.InstallSnapshotRequest, data io.Reader) (*pb.InstallSnapshotResponse, error) {
+	n, err := t.nw.node(t.from, to.ID)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleInstallSnapshot(req, data)
+}
+
+func (t *memTransport) TimeoutNow(ctx context.Context, to Member, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error) {
+	n, err := t.nw.node(t.from, to.ID)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleTimeoutNow(req), nil
+}
+
+func (t *memTransport) ReadIndex(ctx context.Context, to Member, req *pb.ReadIndexRequest) (*pb.ReadIndexResponse, error) {
+	n, err := t.nw.node(t.from, to.ID)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleReadIndex(ctx, req)
+}
+
+type testNode struct {
+	node  *Node
+	store *storage.Store
+}
+
+// cluster is a set of nodes, each with a store, on an in-memory network
+type cluster struct {
+	t     *testing.T
+	dir   string
+	peers map[string]string
+	nw    *network
+	nodes map[string]*testNode
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	c := &cluster{
+		t:     t,
+		dir:   t.TempDir(),
+		peers: make(map[string]string),
+		nw:    &network{nodes: make(map[string]*Node), isolated: make(map[string]bool)},
+		nodes: make(map[string]*testNode),
+	}
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		c.peers[id] = fmt.Sprintf("127.0.0.1:%d", 50050+i)
+	}
+	for id := range c.peers {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for id := range c.nodes {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+// start opens the store of node id, replicated by its node
+func (c *cluster) start(id string) *testNode {
+	return c.startNode(id, false)
+}
+
+// join starts node id without members, to be added to the cluster, and
+// returns its address
+func (c *cluster) join(id string) string {
+	addr := fmt.Sprintf("127.0.0.1:%d", 50050+len(c.peers)+1)
+	c.peers[id] = addr
+	c.startNode(id, true)
+	return addr
+}
+
+func (c *cluster) startNode(id string, join bool) *testNode {
+	dir := filepath.Join(c.dir, id)
+	node, err := NewNode(Config{
+		ID:                id,
+		Peers:             c.peers,
+		Join:              join,
+		Dir:               filepath.Join(dir, "raft"),
+		ElectionTimeout:   150 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+	}, &memTransport{nw: c.nw, from: id})
+	if err != nil {
+		c.t.Fatalf("NewNode failed: %v", err)
+	}
+
+	opts := storage.DefaultOptions(dir)
+	opts.SnapshotInterval = 0
+	opts.SnapshotRetain = 1
+	opts.SweepInterval = 0
+	store, err := storage.OpenWithLog(opts, node)
+	if err != nil {
+		c.t.Fatalf("OpenWithLog failed: %v", err)
+	}
+	node.Start(store)
+
+	c.nw.mu.Lock()
+	c.nw.nodes[id] = node
+	c.nw.mu.Unlock()
+
+	tn := &testNode{node: node, store: store}
+	c.nodes[id] = tn
+	return tn
+}
+
+// stop shuts node id down, the node before its store
+func (c *cluster) stop(id string) {
+	tn := c.nodes[id]
+	c.nw.mu.Lock()
+	delete(c.nw.nodes, id)
+	c.nw.mu.Unlock()
+
+	tn.node.Stop()
+	if err := tn.store.Close(); err != nil {
+		c.t.Errorf("Close of %s failed: %v", id, err)
+	}
+	delete(c.nodes, id)
+}
+
+// leader waits for a leader among the reachable nodes and returns its ID
+func (c *cluster) leader() string {
+	c.t.Helper()
+
+	var id string
+	waitFor(c.t, "a leader to be elected", func() bool {
+		for candidate, tn := range c.nodes {
+			if c.nw.isolated[candidate] {
+				continue
+			}
+			if tn.node.Status().Role == Leader {
+				id = candidate
+				return true
+			}
+		}
+		return false
+	})
+	return id
+}
+
+// set writes through the leader, retrying while the leadership settles
+func (c *cluster) set(key, value string) {
+	c.t.Helper()
+
+	var err error
+	for range 20 {
+		if err = c.nodes[c.leader()].store.Set("t1", key, []byte(value)); err == nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.t.Fatalf("Set through the leader failed: %v", err)
+}
+
+// waitValue waits until node id holds value for key
+func (c *cluster) waitValue(id, key, value string) {
+	c.t.Helper()
+
+	waitFor(c.t, fmt.Sprintf("%s to hold %s=%s", id, key, value), func() bool {
+		got, ok := c.nodes[id].store.Get("t1", key)
+		return ok && string(got) == value
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCluster_ElectsOneLeaderAndReplicates(t *testing.T) {
+	c := newCluster(t, 3)
+
+	leader := c.leader()
+	c.set("k", "v")
+	for id := range c.nodes {
+		c.waitValue(id, "k", "v")
+	}
+
+	leaders := 0
+	for _, tn := range c.nodes {
+		if tn.node.Status().Role == Leader {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Fatalf("Expected exactly one leader, got %d", leaders)
+	}
+
+	// Entry indexes are the revisions of every store
+	revision := c.nodes[leader].store.Revision()
+	for id, tn := range c.nodes {
+		waitFor(t, id+" to apply the write", func() bool { return tn.store.Revision() == revision })
+	}
+}
+
+func TestCluster_FollowerRejectsWrites(t *testing.T) {
+	c := newCluster(t, 3)
+
+	leader := c.leader()
+	c.set("k", "v")
+
+	for id, tn := range c.nodes {
+		if id == leader {
+			continue
+		}
+		c.waitValue(id, "k", "v")
+
+		err := tn.store.Set("t1", "k", []byte("other"))
+		var notLeader *NotLeaderError
+		if !errors.As(err, &notLeader) || !errors.Is(err, ErrNotLeader) {
+			t.Fatalf("Expected a NotLeaderError from follower %s, got %v", id, err)
+		}
+		if notLeader.Leader != c.peers[leader] {
+			t.Fatalf("Expected leader address %s, got %s", c.peers[leader], notLeader.Leader)
+		}
+		if got, _ := tn.store.Get("t1", "k"); string(got) != "v" {
+			t.Fatalf("Expected the rejected write not to apply, got %s", got)
+		}
+	}
+}
+
+func TestCluster_LeaderFailover(t *testing.T) {
+	c := newCluster(t, 3)
+
+	old := c.leader()
+	c.set("a", "1")
+	c.stop(old)
+
+	// The others elect a new leader, which has the committed write
+	leader := c.leader()
+	if leader == old {
+		t.Fatalf("Expected a new leader, got %s again", leader)
+	}
+	c.waitValue(leader, "a", "1")
+	c.set("b", "2")
+
+	// The old leader catches up on restart
+	c.start(old)
+	c.waitValue(old, "a", "1")
+	c.waitValue(old, "b", "2")
+}
+
+func TestCluster_NewLeaderAppliesBeforeWrites(t *testing.T) {
+	c := newCluster(t, 3)
+
+	old := c.leader()
+	c.set("k", "v1")
+	target := c.other(old)
+	c.waitValue(target, "k", "v1")
+
+	// The target holds the next write committed but not applied
+	node := c.nodes[target].node
+	node.applyMu.Lock()
+	c.set("k", "v2")
+	waitFor(t, target+" to learn of the commit", func() bool {
+		status := node.Status()
+		return status.Commit > status.Applied
+	})
+	if err := c.nodes[old].node.TransferLeadership(context.Background(), target); err != nil {
+		node.applyMu.Unlock()
+		t.Fatalf("TransferLeadership failed: %v", err)
+	}
+
+	// A write to the same tenant waits for the earlier entries to apply,
+	// without holding the locks that applying them needs
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.nodes[target].store.Set("t1", "k", []byte("v3"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	node.applyMu.Unlock()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Set on the new leader failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the write to the new leader to complete")
+	}
+	c.waitValue(target, "k", "v3")
+	c.waitValue(old, "k", "v3")
+}
+
+func TestCluster_WriteGivesUpAtDeadline(t *testing.T) {
+	c := newCluster(t, 3)
+
+	leader := c.leader()
+	c.set("k", "v1")
+	for id := range c.nodes {
+		c.nw.isolate(id, id != leader)
+	}
+
+	// Without the followers the write cannot commit before its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := c.nodes[leader].store.WithContext(ctx).Set("t1", "k", []byte("v2"))
+	if !errors.Is(err, ErrOutcomeUnknown) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected ErrOutcomeUnknown at the deadline, got %v", err)
+	}
+
+	// It commits once they are back, and the leader applies it all the same
+	for id := range c.nodes {
+		c.nw.isolate(id, false)
+	}
+	c.waitValue(leader, "k", "v2")
+	c.set("k", "v3")
+	for id := range c.nodes {
+		c.waitValue(id, "k", "v3")
+	}
+}
+
+func TestCluster_IsolatedLeaderStepsDown(t *testing.T) {
+	c := newCluster(t, 3)
+
+	old := c.leader()
+	c.set("k", "v1")
+	c.nw.isolate(old, true)
+
+	// A write the isolated leader cannot commit fails once it steps down
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.nodes[old].store.Set("t1", "k", []byte("lost"))
+	}()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrLeadershipLost) {
+			t.Fatalf("Expected ErrLeadershipLost, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the isolated leader to give up on the write")
+	}
+
+	leader := c.leader()
+	c.set("k", "v2")
+
+	// Once healed the old leader drops its uncommitted entry
+	c.nw.isolate(old, false)
+	c.waitValue(old, "k", "v2")
+	if c.nodes[old].node.Status().Role == Leader {
+		t.Fatalf("Expected %s to follow %s", old, leader)
+	}
+}
+
+func TestCluster_RestartKeepsState(t *testing.T) {
+	c := newCluster(t, 3)
+
+	c.set("k", "v")
+	terms := make(map[string]uint64)
+	for id, tn := range c.nodes {
+		c.waitValue(id, "k", "v")
+		terms[id] = tn.node.Status().Term
+	}
+
+	for id := range terms {
+		c.stop(id)
+	}
+	for id := range terms {
+		c.start(id)
+	}
+
+	for id, tn := range c.nodes {
+		c.waitValue(id, "k", "v")
+		if term := tn.node.Status().Term; term < terms[id] {
+			t.Fatalf("Expected %s to keep term %d, got %d", id, terms[id], term)
+		}
+	}
+	c.set("k", "v2")
+	for id := range c.nodes {
+		c.waitValue(id, "k", "v2")
+	}
+}
+
+func TestCluster_LaggingFollowerGetsSnapshot(t *testing.T) {
+	c := newCluster(t, 3)
+
+	leader := c.leader()
+	var lagging string
+	for id := range c.nodes {
+		if id != leader {
+			lagging = id
+			break
+		}
+	}
+	c.nw.isolate(lagging, true)
+
+	for i := 0; i < 10; i++ {
+		c.set(fmt.Sprintf("k%d", i), "v")
+	}
+	// Compact the leader's log past everything the follower has
+	leader = c.leader()
+	if _, err := c.nodes[leader].store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	c.set("after", "snapshot")
+	if first := c.nodes[leader].node.FirstIndex(); first <= c.nodes[lagging].node.Status().LastIndex+1 {
+		t.Fatalf("Expected the leader's log to start after the follower's, got %d", first)
+	}
+
+	c.nw.isolate(lagging, false)
+	for i := 0; i < 10; i++ {
+		c.waitValue(lagging, fmt.Sprintf("k%d", i), "v")
+	}
+	c.waitValue(lagging, "after", "snapshot")
+
+	// The restored follower keeps replicating, and recovers from its snapshot
+	c.set("later", "write")
+	c.waitValue(lagging, "later", "write")
+	c.stop(lagging)
+	c.start(lagging)
+	c.waitValue(lagging, "k0", "v")
+	c.waitValue(lagging, "later", "write")
+}
+
+func TestCluster_SnapshotWhileWritesWait(t *testing.T) {
+	c := newCluster(t, 3)
+
+	leader := c.leader()
+	lagging := c.other(leader)
+	c.nw.isolate(lagging, true)
+	for i := 0; i < 10; i++ {
+		c.set(fmt.Sprintf("k%d", i), "v")
+	}
+	leader = c.leader()
+	if _, err := c.nodes[leader].store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	c.set("after", "snapshot")
+
+	// With the third node gone a write needs the lagging follower, which
+	// needs a snapshot first
+	for id := range c.nodes {
+		if id != leader && id != lagging {
+			c.stop(id)
+		}
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.nodes[leader].store.Set("t1", "during", []byte("snapshot"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.nw.isolate(lagging, false)
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Set while the follower catches up failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the write to commit once the follower caught up")
+	}
+	c.waitValue(lagging, "k9", "v")
+	c.waitValue(lagging, "after", "snapshot")
+	c.waitValue(lagging, "during", "snapshot")
+}
@@ -0,0 +1,236 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+// ErrStale is matched by the errors returned for stale reads from a node
+// that has not heard from the leader within the allowed lag
+var ErrStale = errors.New("too far behind the leader")
+
+// StaleError rejects a stale read from a node that is too far behind
+type StaleError struct {
+	Leader string        // Address of the leader, empty if none is known
+	Lag    time.Duration // Time since the leader was last heard from, 0 if never
+}
+
+func (e *StaleError) Error() string {
+	lag := "the leader was never heard from"
+	if e.Lag > 0 {
+		lag = fmt.Sprintf("the leader was last heard from %v ago", e.Lag.Round(time.Millisecond))
+	}
+	if e.Leader == "" {
+		return fmt.Sprintf("%s, %s", ErrStale, lag)
+	}
+	return fmt.Sprintf("%s, %s, the leader is %s", ErrStale, lag, e.Leader)
+}
+
+// Is reports whether target is ErrStale
+func (e *StaleError) Is(target error) bool {
+	return target == ErrStale
+}
+
+// ReadIndex waits until the store has applied every entry committed before
+// the call, so that a read made next sees every write that completed
+// before it. The leader confirms with a quorum that it still leads, a
+// follower asks the leader for its commit index. Without a reachable leader
+// it fails with a NotLeaderError.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed || n.stopped {
+		return ErrClosed
+	}
+	if n.role == Leader {
+		index, err := n.readIndexLocked(ctx)
+		if err != nil {
+			return err
+		}
+		return n.waitAppliedLocked(ctx, index)
+	}
+
+	leader, ok := n.members[n.leader]
+	if !ok {
+		return &NotLeaderError{}
+	}
+	n.mu.Unlock()
+	resp, err := n.transport.ReadIndex(ctx, leader, &pb.ReadIndexRequest{NodeId: n.cfg.ID})
+	n.mu.Lock()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Reads the leader cannot confirm are sent to it directly
+		return &NotLeaderError{Leader: leader.Address}
+	}
+	return n.waitAppliedLocked(ctx, resp.Index)
+}
+
+// LeaseRead waits until the leader's store has applied every committed
+// entry. While a quorum answered the leader within the lease, no other
+// leader can have been elected, so the read needs no round trip; once the
+// lease lapsed it is confirmed like ReadIndex. On a node that is not the
+// leader it fails with a NotLeaderError.
+func (n *Node) LeaseRead(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.leadLocked(ctx); err != nil {
+		return err
+	}
+	index := n.commit
+	if !n.hasLeaseLocked(time.Now()) {
+		var err error
+		if index, err = n.readIndexLocked(ctx); err != nil {
+			return err
+		}
+	}
+	return n.waitAppliedLocked(ctx, index)
+}
+
+// StaleRead checks that the node heard from the leader within maxLag, 0 for
+// any lag, and waits until its store has applied the entries it knows to be
+// committed. A node too far behind fails with a StaleError.
+func (n *Node) StaleRead(ctx context.Context, maxLag time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed || n.stopped {
+		return ErrClosed
+	}
+	if maxLag <= 0 {
+		return nil
+	}
+	if lag, ok := n.lagLocked(time.Now()); !ok || lag > maxLag {
+		return &StaleError{Leader: n.members[n.leader].Address, Lag: lag}
+	}
+	return n.waitAppliedLocked(ctx, n.commit)
+}
+
+// readIndexLocked returns the commit index of a leader once a quorum
+// answered a message sent after the call. It is called and returns with
+// n.mu held, also on error.
+func (n *Node) readIndexLocked(ctx context.Context) (uint64, error) {
+	if err := n.leadLocked(ctx); err != nil {
+		return 0, err
+	}
+	// leadLocked waited for the no-op of this term, so the commit index
+	// covers every entry committed by earlier leaders
+	index, term := n.commit, n.log.state.Term
+	start := time.Now()
+	n.triggerPeersLocked()
+	for n.quorumAckLocked(time.Now()).Before(start) {
+		if err := n.waitReadLocked(ctx); err != nil {
+			return 0, err
+		}
+		if n.role != Leader || n.log.state.Term != term {
+			return 0, n.notLeaderLocked()
+		}
+	}
+	return index, nil
+}
+
+// waitAppliedLocked waits until the store applied the entry at index. It is
+// called and returns with n.mu held, also on error.
+func (n *Node) waitAppliedLocked(ctx context.Context, index uint64) error {
+	for n.applied < index {
+		if err := n.waitReadLocked(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitReadLocked waits until an answer or an applied entry may let a read
+// proceed. It is called and returns with n.mu held.
+func (n *Node) waitReadLocked(ctx context.Context) error {
+	if n.readWait == nil {
+		n.readWait = make(chan struct{})
+	}
+	wait, stop := n.readWait, n.stop
+	n.mu.Unlock()
+	defer n.mu.Lock()
+
+	select {
+	case <-wait:
+		return nil
+	case <-stop:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notifyReadsLocked wakes the reads waiting in waitReadLocked
+func (n *Node) notifyReadsLocked() {
+	if n.readWait != nil {
+		close(n.readWait)
+		n.readWait = nil
+	}
+}
+
+// quorumAckLocked returns the time from which a majority of the voters
+// answered the leader's messages, the zero time if they never did
+func (n *Node) quorumAckLocked(now time.Time) time.Time {
+	var acks []time.Time
+	if n.isVoterLocked(n.cfg.ID) {
+		acks = append(acks, now)
+	}
+	for id, p := range n.peers {
+		if n.isVoterLocked(id) {
+			acks = append(acks, p.ackedSent)
+		}
+	}
+	quorum := n.quorumLocked()
+	if len(acks) < quorum {
+		return time.Time{}
+	}
+	// Newest first
+	slices.SortFunc(acks, func(a, b time.Time) int { return b.Compare(a) })
+	return acks[quorum-1]
+}
+
+// hasLeaseLocked reports whether no other leader can have been elected: a
+// majority answered within the lease, a little shorter than the election
+// timeout during which they ignore candidates to allow for clock drift
+func (n *Node) hasLeaseLocked(now time.Time) bool {
+	ack := n.quorumAckLocked(now)
+	lease := n.cfg.ElectionTimeout - n.cfg.ElectionTimeout/10
+	return !ack.IsZero() && !ack.Before(n.leaseStart) && now.Sub(ack) < lease
+}
+
+// lagLocked returns how long ago the node's state was known to be current:
+// when a majority last answered the leader, or a follower last heard from
+// it. It reports false if that never happened.
+func (n *Node) lagLocked(now time.Time) (time.Duration, bool) {
+	if n.role == Leader {
+		ack := n.quorumAckLocked(now)
+		if ack.IsZero() {
+			return 0, false
+		}
+		return now.Sub(ack), true
+	}
+	if n.lastContact.IsZero() {
+		return 0, false
+	}
+	return now.Sub(n.lastContact), true
+}
+
+// handleReadIndex answers a follower's ReadIndex
+func (n *Node) handleReadIndex(ctx context.Context, req *pb.ReadIndexRequest) (*pb.ReadIndexResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	index, err := n.readIndexLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReadIndexResponse{Term: n.log.state.Term, Index: index}, nil
+}
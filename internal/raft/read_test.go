@@ -0,0 +1,104 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCluster_ReadIndexOnFollower(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	c.set("k", "v1")
+	follower := c.other(leader)
+	c.waitValue(follower, "k", "v1")
+
+	// Hold off the follower's applying, its read must wait for the write
+	node := c.nodes[follower].node
+	node.applyMu.Lock()
+	c.set("k", "v2")
+
+	done := make(chan error, 1)
+	go func() { done <- node.ReadIndex(context.Background()) }()
+	select {
+	case err := <-done:
+		node.applyMu.Unlock()
+		t.Fatalf("Expected ReadIndex to wait for the write to apply, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	node.applyMu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+	if got, _ := c.nodes[follower].store.Get("t1", "k"); string(got) != "v2" {
+		t.Fatalf("Expected v2 after ReadIndex, got %q", got)
+	}
+}
+
+func TestCluster_ReadIndexOnIsolatedLeader(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	c.set("k", "v")
+
+	if err := c.nodes[leader].node.ReadIndex(context.Background()); err != nil {
+		t.Fatalf("ReadIndex on the leader failed: %v", err)
+	}
+
+	// Cut off from the quorum the leader cannot confirm it still leads
+	c.nw.isolate(leader, true)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.nodes[leader].node.ReadIndex(ctx); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Expected ErrNotLeader from an isolated leader, got %v", err)
+	}
+	if err := c.nodes[leader].node.LeaseRead(ctx); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Expected ErrNotLeader for a lease read, got %v", err)
+	}
+}
+
+func TestCluster_LeaseRead(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	c.set("k", "v")
+
+	node := c.nodes[leader].node
+	waitFor(t, "the leader to hold a lease", func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return node.hasLeaseLocked(time.Now())
+	})
+	if err := node.LeaseRead(context.Background()); err != nil {
+		t.Fatalf("LeaseRead failed: %v", err)
+	}
+
+	err := c.nodes[c.other(leader)].node.LeaseRead(context.Background())
+	var notLeader *NotLeaderError
+	if !errors.As(err, &notLeader) || notLeader.Leader != c.peers[leader] {
+		t.Fatalf("Expected a follower to name the leader, got %v", err)
+	}
+}
+
+func TestCluster_StaleRead(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	c.set("k", "v")
+
+	follower := c.other(leader)
+	node := c.nodes[follower].node
+	waitFor(t, follower+" to hear from the leader", func() bool {
+		return node.StaleRead(context.Background(), time.Second) == nil
+	})
+
+	c.nw.isolate(follower, true)
+	time.Sleep(100 * time.Millisecond)
+	err := node.StaleRead(context.Background(), 50*time.Millisecond)
+	var stale *StaleError
+	if !errors.As(err, &stale) || !errors.Is(err, ErrStale) || stale.Lag < 50*time.Millisecond {
+		t.Fatalf("Expected a StaleError from an isolated follower, got %v", err)
+	}
+	if err := node.StaleRead(context.Background(), 0); err != nil {
+		t.Fatalf("Expected a read without a bound to be served, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package raft
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// SecretHeader is the metadata key carrying the cluster secret
+const SecretHeader = "x-cluster-secret"
+
+// snapshotChunkSize is the data carried by one InstallSnapshot message
+const snapshotChunkSize = 256 << 10
+
+// GRPCTransport carries the messages of a node to the Raft service of the
+// other members
+type GRPCTransport struct {
+	opts []grpc.DialOption
+
+	mu      sync.Mutex
+	conns   map[string]*grpc.ClientConn // By address
+	clients map[string]pb.RaftClient
+}
+
+// NewGRPCTransport creates a transport connecting with creds and sending
+// secret, if not empty, with every message
+func NewGRPCTransport(creds credentials.TransportCredentials, secret string) *GRPCTransport {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if secret != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(secretCredentials(secret)))
+	}
+	return &GRPCTransport{
+		opts:    opts,
+		conns:   make(map[string]*grpc.ClientConn),
+		clients: make(map[string]pb.RaftClient),
+	}
+}
+
+// client returns the client of a member, connecting on first use
+func (t *GRPCTransport) client(to Member) (pb.RaftClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.clients[to.Address]; ok {
+		return c, nil
+	}
+	conn, err := grpc.NewClient(to.Address, t.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to raft peer %s: %w", to.ID, err)
+	}
+	t.conns[to.Address] = conn
+	t.clients[to.Address] = pb.NewRaftClient(conn)
+	return t.clients[to.Address], nil
+}
+
+// RequestVote sends a vote request to a member
+func (t *GRPCTransport) RequestVote(ctx context.Context, to Member, req *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error) {
+	c, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestVote(ctx, req)
+}
+
+// AppendEntries sends entries or a heartbeat to a member
+func (t *GRPCTransport) AppendEntries(ctx context.Context, to Member, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	c, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	return c.AppendEntries(ctx, req)
+}
+
+// TimeoutNow tells a member to start an election
+func (t *GRPCTransport) TimeoutNow(ctx context.Context, to Member, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error) {
+	c, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	return c.TimeoutNow(ctx, req)
+}
+
+// ReadIndex asks the leader for the index a linearizable read waits for
+func (t *GRPCTransport) ReadIndex(ctx context.Context, to Member, req *pb.ReadIndexRequest) (*pb.ReadIndexResponse, error) {
+	c, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadIndex(ctx, req)
+}
+
+// InstallSnapshot streams the snapshot read from data to a member, the first
+// chunk carrying req
+func (t *GRPCTransport) InstallSnapshot(ctx context.Context, to Member, req *pb.InstallSnapshotRequest, data io.Reader) (*pb.InstallSnapshotResponse, error) {
+	c, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.InstallSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, snapshotChunkSize)
+	chunk := req
+	for {
+		n, err := io.ReadFull(data, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		if chunk == nil {
+			chunk = &pb.InstallSnapshotRequest{}
+		}
+		chunk.Data = buf[:n]
+		if sendErr := stream.Send(chunk); sendErr != nil {
+			// The server ended the call, its status is returned by CloseAndRecv
+			break
+		}
+		chunk = nil
+		if err != nil {
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// Close closes the connections to the members
+func (t *GRPCTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var firstErr error
+	for addr, conn := range t.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(t.conns, addr)
+		delete(t.clients, addr)
+	}
+	return firstErr
+}
+
+// secretCredentials sends the cluster secret with every message. It is
+// allowed over plaintext connections, like bearer tokens.
+type secretCredentials string
+
+func (s secretCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{SecretHeader: string(s)}, nil
+}
+
+func (s secretCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// Server serves the Raft service of a node
+type Server struct {
+	pb.UnimplementedRaftServer
+	node   *Node
+	secret []byte
+}
+
+// NewServer creates the Raft service of node. With a secret every message
+// must carry it.
+func NewServer(node *Node, secret string) *Server {
+	s := &Server{node: node}
+	if secret != "" {
+		s.secret = []byte(secret)
+	}
+	return s
+}
+
+// checkSecret rejects calls without the cluster secret
+func (s *Server) checkSecret(ctx context.Context) error {
+	if s.secret == nil {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(SecretHeader)
+	if len(values) == 0 || subtle.ConstantTimeCompare([]byte(values[0]), s.secret) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid cluster secret")
+	}
+	return nil
+}
+
+// RequestVote implements the RequestVote RPC method
+func (s *Server) RequestVote(ctx context.Context, req *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error) {
+	if err := s.checkSecret(ctx); err != nil {
+		return nil, err
+	}
+	return s.node.handleRequestVote(req), nil
+}
+
+// AppendEntries implements the AppendEntries RPC method
+func (s *Server) AppendEntries(ctx context.Context, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	if err := s.checkSecret(ctx); err != nil {
+		return nil, err
+	}
+	return s.node.handleAppendEntries(req), nil
+}
+
+// TimeoutNow implements the TimeoutNow RPC method
+func (s *Server) TimeoutNow(ctx context.Context, req *pb.TimeoutNowRequest) (*pb.TimeoutNowResponse, error) {
+	if err := s.checkSecret(ctx); err != nil {
+		return nil, err
+	}
+	return s.node.handleTimeoutNow(req), nil
+}
+
+// ReadIndex implements the ReadIndex RPC method
+func (s *Server) ReadIndex(ctx context.Context, req *pb.ReadIndexRequest) (*pb.ReadIndexResponse, error) {
+	if err := s.checkSecret(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := s.node.handleReadIndex(ctx, req)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return resp, nil
+}
+
+// InstallSnapshot implements the InstallSnapshot RPC method
+func (s *Server) InstallSnapshot(stream pb.Raft_InstallSnapshotServer) error {
+	if err := s.checkSecret(stream.Context()); err != nil {
+		return err
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	// Feed the chunks to the store as it reads them
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		data := first.Data
+		for {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			chunk, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				w.CloseWithError(err)
+				return
+			}
+			data = chunk.Data
+		}
+	}()
+
+	resp, err := s.node.handleInstallSnapshot(first, r)
+	r.Close()
+	<-done
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return stream.SendAndClose(resp)
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/ayushgala/tinkerdb/internal/auth"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+)
+
+// AdminServer implements the gRPC Admin service
+type AdminServer struct {
+	pb.UnimplementedAdminServer
+	store  *storage.Store
+	tokens *auth.Tokens
+	rbac   *auth.RBAC
+}
+
+// NewAdminServer creates an Admin service for the given store. tokens and
+// rbac may be nil, the RPCs managing them then fail with FailedPrecondition.
+func NewAdminServer(store *storage.Store, tokens *auth.Tokens, rbac *auth.RBAC) *AdminServer {
+	return &AdminServer{
+		store:  store,
+		tokens: tokens,
+		rbac:   rbac,
+	}
+}
+
+// Snapshot implements the Snapshot RPC method
+func (s *AdminServer) Snapshot(ctx context.Context, req *pb.SnapshotRequest) (*pb.SnapshotResponse, error) {
+	slog.Info("Snapshot requested")
+
+	info, err := s.store.WithContext(ctx).Snapshot()
+	if err != nil {
+		return &pb.SnapshotResponse{
+			Success: false,
+			Message: fmt.Sprintf("snapshot failed: %v", err),
+		}, nil
+	}
+
+	return &pb.SnapshotResponse{
+		Success:   true,
+		Message:   fmt.Sprintf("snapshot written to %s", info.Path),
+		Index:     info.Index,
+		SizeBytes: info.Size,
+		Tenants:   int32(info.Tenants),
+		Keys:      int64(info.Keys),
+	}, nil
+}
+
+// IssueToken implements the IssueToken RPC method
+func (s *AdminServer) IssueToken(ctx context.Context, req *pb.IssueTokenRequest) (*pb.IssueTokenResponse, error) {
+	if s.tokens == nil {
+		return nil, tokensDisabled()
+	}
+	if req.User != "" && (s.rbac == nil || s.rbac.User(req.User) == nil) {
+		return nil, userNotFound(req.User)
+	}
+
+	token, rec, err := s.tokens.Issue(auth.Token{Name: req.Name, Tenants: req.Tenants, User: req.User, Admin: req.Admin})
+	if err != nil {
+		return nil, storageError(err, "issue token")
+	}
+	slog.Info("Issued token", "token_id", rec.ID, "name", rec.Name, "tenants", rec.Tenants, "user", rec.User, "admin", rec.Admin)
+
+	return &pb.IssueTokenResponse{
+		Success: true,
+		Message: fmt.Sprintf("token %s issued", rec.ID),
+		Token:   token,
+		Info:    tokenToProto(rec),
+	}, nil
+}
+
+// RevokeToken implements the RevokeToken RPC method
+func (s *AdminServer) RevokeToken(ctx context.Context, req *pb.RevokeTokenRequest) (*pb.RevokeTokenResponse, error) {
+	if s.tokens == nil {
+		return nil, tokensDisabled()
+	}
+	if req.TokenId == "" {
+		return nil, invalidArgument("token ID cannot be empty")
+	}
+
+	err := s.tokens.Revoke(req.TokenId)
+	if errors.Is(err, auth.ErrTokenNotFound) {
+		return nil, statusError(codes.NotFound, reasonTokenNotFound, map[string]string{"token_id": req.TokenId}, "token not found")
+	}
+	if err != nil {
+		return nil, storageError(err, "revoke token")
+	}
+	slog.Info("Revoked token", "token_id", req.TokenId)
+
+	return &pb.RevokeTokenResponse{
+		Success: true,
+		Message: fmt.Sprintf("token %s revoked", req.TokenId),
+	}, nil
+}
+
+// ListTokens implements the ListTokens RPC method
+func (s *AdminServer) ListTokens(ctx context.Context, req *pb.ListTokensRequest) (*pb.ListTokensResponse, error) {
+	if s.tokens == nil {
+		return nil, tokensDisabled()
+	}
+
+	resp := &pb.ListTokensResponse{}
+	for _, rec := range s.tokens.List() {
+		resp.Tokens = append(resp.Tokens, tokenToProto(rec))
+	}
+	return resp, nil
+}
+
+func tokenToProto(rec *auth.Token) *pb.TokenInfo {
+	return &pb.TokenInfo{
+		TokenId:   rec.ID,
+		Name:      rec.Name,
+		Tenants:   rec.Tenants,
+		User:      rec.User,
+		Admin:     rec.Admin,
+		CreatedAt: rec.CreatedAt.UnixMilli(),
+	}
+}
+
+// PutRole implements the PutRole RPC method
+func (s *AdminServer) PutRole(ctx context.Context, req *pb.PutRoleRequest) (*pb.PutRoleResponse, error) {
+	if s.rbac == nil {
+		return nil, rbacDisabled()
+	}
+	if req.Role == nil {
+		return nil, invalidArgument("role cannot be empty")
+	}
+
+	role := roleFromProto(req.Role)
+	if err := checkManages(ctx, &role); err != nil {
+		return nil, err
+	}
+	// Tenant admins may not take over a role that reaches beyond their tenants
+	if current := s.rbac.Role(role.Name); current != nil {
+		if err := checkManages(ctx, current); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.rbac.PutRole(role); err != nil {
+		return nil, storageError(err, "store role")
+	}
+	slog.Info("Stored role", "role", role.Name, "permissions", role.Permissions)
+
+	return &pb.PutRoleResponse{
+		Success: true,
+		Message: fmt.Sprintf("role %s stored", role.Name),
+	}, nil
+}
+
+// DeleteRole implements the DeleteRole RPC method
+func (s *AdminServer) DeleteRole(ctx context.Context, req *pb.DeleteRoleRequest) (*pb.DeleteRoleResponse, error) {
+	if s.rbac == nil {
+		return nil, rbacDisabled()
+	}
+
+	role := s.rbac.Role(req.Name)
+	if role == nil {
+		return nil, roleNotFound(req.Name)
+	}
+	if err := checkManages(ctx, role); err != nil {
+		return nil, err
+	}
+
+	err := s.rbac.DeleteRole(req.Name)
+	if errors.Is(err, auth.ErrRoleNotFound) {
+		return nil, roleNotFound(req.Name)
+	}
+	if err != nil {
+		return nil, storageError(err, "delete role")
+	}
+	slog.Info("Deleted role", "role", req.Name)
+
+	return &pb.DeleteRoleResponse{
+		Success: true,
+		Message: fmt.Sprintf("role %s deleted", req.Name),
+	}, nil
+}
+
+// ListRoles implements the ListRoles RPC method
+func (s *AdminServer) ListRoles(ctx context.Context, req *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	if s.rbac == nil {
+		return nil, rbacDisabled()
+	}
+
+	resp := &pb.ListRolesResponse{}
+	for _, role := range s.rbac.Roles() {
+		if checkManages(ctx, role) == nil {
+			resp.Roles = append(resp.Roles, roleToProto(role))
+		}
+	}
+	return resp, nil
+}
+
+// GrantRole implements the GrantRole RPC method
+func (s *AdminServer) GrantRole(ctx context.Context, req *pb.GrantRoleRequest) (*pb.GrantRoleResponse, error) {
+	if s.rbac == nil {
+		return nil, rbacDisabled()
+	}
+
+	role := s.rbac.Role(req.Role)
+	if role == nil {
+		return nil, roleNotFound(req.Role)
+	}
+	if err := checkManages(ctx, role); err != nil {
+		return nil, err
+	}
+
+	err := s.rbac.Grant(req.User, req.Role)
+	if errors.Is(err, auth.ErrRoleNotFound) {
+		return nil, roleNotFound(req.Role)
+	}
+	if err != nil {
+		return nil, storageError(err, "grant role")
+	}
+	slog.Info("Granted role", "role", req.Role, "user", req.User)
+
+	return &pb.GrantRoleResponse{
+		Success: true,
+		Message: fmt.Sprintf("role %s granted to %s", req.Role, req.User),
+	}, nil
+}
+
+// RevokeRole implements the RevokeRole RPC method
+func (s *AdminServer) RevokeRole(ctx context.Context, req *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	if s.rbac == nil {
+		return nil, rbacDisabled()
+	}
+
+	// A deleted role was already taken away from every user
+	role := s.rbac.Role(req.Role)
+	if role == nil {
+		return nil, roleNotFound(req.Role)
+	}
+	if err := checkManages(ctx, role); err != nil {
+		return nil, err
+	}
+
+	err := s.rbac.Revoke(req.User, req.Role)
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		return nil, userNotFound(req.User)
+	case errors.Is(err, auth.ErrRoleNotFound):
+		return nil, statusError(codes.NotFound, reasonRoleNotFound, map[string]string{"role": req.Role, "user": req.User}, "user does not have role")
+	case err != nil:
+		return nil, storageError(err, "revoke role")
+	}
+	slog.Info("Revoked role", "role", req.Role, "user", req.User)
+
+	return &pb.RevokeRoleResponse{
+		Success: true,
+		Message: fmt.Sprintf("role %s revoked from %s", req.Role, req.User),
+	}, nil
+}
+
+// ListUsers implements the ListUsers RPC method
+func (s *AdminServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	if s.rbac == nil {
+		return nil, rbacDisabled()
+	}
+
+	resp := &pb.ListUsersResponse{}
+	for _, user := range s.rbac.Users() {
+		resp.Users = append(resp.Users, &pb.User{Name: user.Name, Roles: user.Roles})
+	}
+	return resp, nil
+}
+
+// DeleteUser implements the DeleteUser RPC method
+func (s *AdminServer) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	if s.rbac == nil {
+		return nil, rbacDisabled()
+	}
+
+	err := s.rbac.DeleteUser(req.Name)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		return nil, userNotFound(req.Name)
+	}
+	if err != nil {
+		return nil, storageError(err, "delete user")
+	}
+	slog.Info("Deleted user", "user", req.Name)
+
+	return &pb.DeleteUserResponse{
+		Success: true,
+		Message: fmt.Sprintf("user %s deleted", req.Name),
+	}, nil
+}
+
+// checkManages checks the caller may manage role: admins manage every role,
+// tenant admins the roles whose permissions are all on tenants they
+// administer
+func checkManages(ctx context.Context, role *auth.Role) error {
+	id, ok := auth.FromContext(ctx)
+	if !ok || id.Admin {
+		return nil
+	}
+	tenants := role.Tenants()
+	if len(tenants) == 0 {
+		return permissionDenied(map[string]string{"role": role.Name}, "only admins may manage roles without permissions")
+	}
+	for _, tenant := range tenants {
+		if tenant == auth.AllTenants || !id.Administers(tenant) {
+			return permissionDenied(map[string]string{"role": role.Name, "tenant": tenant}, fmt.Sprintf("no admin access to tenant %q", tenant))
+		}
+	}
+	return nil
+}
+
+// roleFromProto converts a role. The proto and auth access levels share
+// their numbering.
+func roleFromProto(r *pb.Role) auth.Role {
+	role := auth.Role{Name: r.Name}
+	for _, p := range r.Permissions {
+		role.Permissions = append(role.Permissions, auth.Permission{
+			Tenant: p.TenantId,
+			Prefix: p.Prefix,
+			Access: auth.Access(p.Access),
+		})
+	}
+	return role
+}
+
+func roleToProto(role *auth.Role) *pb.Role {
+	r := &pb.Role{Name: role.Name}
+	for _, p := range role.Permissions {
+		r.Permissions = append(r.Permissions, &pb.Permission{
+			TenantId: p.Tenant,
+			Prefix:   p.Prefix,
+			Access:   pb.Permission_Access(p.Access),
+		})
+	}
+	return r
+}
+
+func roleNotFound(name string) error {
+	return statusError(codes.NotFound, reasonRoleNotFound, map[string]string{"role": name}, "role not found")
+}
+
+func userNotFound(name string) error {
+	return statusError(codes.NotFound, reasonUserNotFound, map[string]string{"user": name}, "user not found")
+}
+
+// rbacDisabled rejects role RPCs on a server without users and roles
+func rbacDisabled() error {
+	return statusError(codes.FailedPrecondition, reasonNotEnabled, nil, "roles are not enabled on this server")
+}
+
+// tokensDisabled rejects token RPCs on a server without a token store
+func tokensDisabled() error {
+	return statusError(codes.FailedPrecondition, reasonNotEnabled, nil, "tokens are not enabled on this server")
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+func TestAdminServer_Snapshot(t *testing.T) {
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.SnapshotInterval = 0
+	store, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	kv := NewKVStoreServerWithStore(store)
+	kv.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "key", Value: []byte("value")})
+
+	admin := NewAdminServer(store, nil, nil)
+	resp, err := admin.Snapshot(ctx, &pb.SnapshotRequest{})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	if !resp.Success {
+		t.Fatalf("Expected success, got: %s", resp.Message)
+	}
+
+	if resp.Index != 1 || resp.Keys != 1 || resp.Tenants != 1 {
+		t.Fatalf("Unexpected snapshot info: index=%d keys=%d tenants=%d", resp.Index, resp.Keys, resp.Tenants)
+	}
+}
+
+func TestAdminServer_SnapshotInMemoryStore(t *testing.T) {
+	admin := NewAdminServer(storage.NewStore(), nil, nil)
+
+	resp, err := admin.Snapshot(context.Background(), &pb.SnapshotRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if resp.Success {
+		t.Fatal("Expected snapshot of an in-memory store to fail")
+	}
+}
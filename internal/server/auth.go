@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ayushgala/tinkerdb/internal/auth"
+	"github.com/ayushgala/tinkerdb/internal/certs"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// publicMethods are method prefixes served without authentication. Health
+// checks come from orchestrators, which hold no credentials. Cluster peers
+// hold no tokens either, the Raft service checks the cluster secret itself.
+var publicMethods = []string{
+	"/grpc.reflection.",
+	"/grpc.health.v1.",
+	"/kvstore.Raft/",
+}
+
+// adminServices are the services only admin identities may call
+var adminServices = []string{
+	"/kvstore.Admin/",
+	"/kvstore.CDC/",
+	"/kvstore.Cluster/",
+}
+
+// tenantAdminMethods are the Admin methods open to tenant admins, which the
+// Admin service limits to the tenants they administer
+var tenantAdminMethods = map[string]bool{
+	"/kvstore.Admin/PutRole":    true,
+	"/kvstore.Admin/DeleteRole": true,
+	"/kvstore.Admin/ListRoles":  true,
+	"/kvstore.Admin/GrantRole":  true,
+	"/kvstore.Admin/RevokeRole": true,
+}
+
+// AuthOptions configures an Authenticator
+type AuthOptions struct {
+	// Required rejects calls without a token or verified client certificate.
+	// Otherwise they run unauthenticated with the tenant they name, as
+	// without authentication.
+	Required bool
+
+	// AdminToken is a static token with admin rights on every tenant, used to
+	// issue the first tokens. Empty for none.
+	AdminToken string
+
+	// AdminClients are the names of client certificates with admin rights.
+	// Other verified client certificates act as the user they name.
+	AdminClients []string
+}
+
+// Authenticator checks the bearer token or client certificate of every call
+// and binds the call to the tenants the caller may use. The services check
+// what the caller may do with them.
+type Authenticator struct {
+	tokens       *auth.Tokens
+	rbac         *auth.RBAC
+	required     bool
+	adminHash    []byte // SHA-256 of the static admin token, nil for none
+	adminClients []string
+}
+
+// NewAuthenticator creates an Authenticator verifying tokens against tokens,
+// with the users and roles of rbac
+func NewAuthenticator(tokens *auth.Tokens, rbac *auth.RBAC, opts AuthOptions) *Authenticator {
+	a := &Authenticator{
+		tokens:       tokens,
+		rbac:         rbac,
+		required:     opts.Required,
+		adminClients: opts.AdminClients,
+	}
+	if opts.AdminToken != "" {
+		hash := sha256.Sum256([]byte(opts.AdminToken))
+		a.adminHash = hash[:]
+	}
+	return a
+}
+
+// UnaryInterceptor authenticates unary calls
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, id, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if err := bindTenant(req, id); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates streaming calls
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx, id: id})
+	}
+}
+
+// authorize authenticates a call to method and returns its context carrying
+// the caller's identity, nil for an unauthenticated call
+func (a *Authenticator) authorize(ctx context.Context, method string) (context.Context, *auth.Identity, error) {
+	for _, prefix := range publicMethods {
+		if strings.HasPrefix(method, prefix) {
+			return ctx, nil, nil
+		}
+	}
+
+	id, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Admin services need an admin identity even when credentials are not
+	// required
+	for _, prefix := range adminServices {
+		if !strings.HasPrefix(method, prefix) {
+			continue
+		}
+		if id == nil {
+			return nil, nil, unauthenticated("an admin token is required")
+		}
+		if !id.Admin && !tenantAdminMethods[method] {
+			return nil, nil, permissionDenied(nil, "an admin token is required")
+		}
+	}
+	if id == nil {
+		return ctx, nil, nil
+	}
+	return auth.NewContext(ctx, id), id, nil
+}
+
+// authenticate returns the identity of the call's bearer token, or else of
+// its verified client certificate. A call without either has no identity,
+// unless credentials are required.
+func (a *Authenticator) authenticate(ctx context.Context) (*auth.Identity, error) {
+	var token string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		scheme, value, ok := strings.Cut(values[0], " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") {
+			return nil, unauthenticated("authorization must be a bearer token")
+		}
+		token = strings.TrimSpace(value)
+	}
+
+	if token == "" {
+		if name, ok := clientCertName(ctx); ok {
+			if slices.Contains(a.adminClients, name) {
+				return &auth.Identity{Name: name, Admin: true}, nil
+			}
+			return a.rbac.UserIdentity(name), nil
+		}
+		if a.required {
+			return nil, unauthenticated("missing bearer token or client certificate")
+		}
+		return nil, nil
+	}
+
+	if a.adminHash != nil {
+		hash := sha256.Sum256([]byte(token))
+		if subtle.ConstantTimeCompare(hash[:], a.adminHash) == 1 {
+			return &auth.Identity{Name: "admin", Admin: true}, nil
+		}
+	}
+
+	rec, err := a.tokens.Authenticate(token)
+	if err != nil {
+		return nil, unauthenticated("invalid or revoked token")
+	}
+	return a.rbac.TokenIdentity(rec), nil
+}
+
+// clientCertName returns the name of the call's client certificate, if the
+// TLS handshake verified one
+func clientCertName(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.PeerCertificates) == 0 {
+		return "", false
+	}
+	name := certs.PeerName(info.State.PeerCertificates[0])
+	return name, name != ""
+}
+
+// bindTenant sets the tenant of a request that has one to the tenant the
+// caller is allowed to use. A request naming no tenant gets the identity's
+// only tenant. Without an identity the request keeps the tenant it names,
+// except for the system tenants.
+func bindTenant(req any, id *auth.Identity) error {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	r := msg.ProtoReflect()
+	field := r.Descriptor().Fields().ByName("tenant_id")
+	if field == nil || field.Kind() != protoreflect.StringKind {
+		return nil
+	}
+
+	tenantID := r.Get(field).String()
+	if storage.IsSystemTenant(tenantID) {
+		return permissionDenied(map[string]string{"tenant": tenantID}, "tenant is reserved")
+	}
+	if id == nil {
+		return nil
+	}
+
+	if tenantID == "" {
+		tenant, ok := id.DefaultTenant()
+		if !ok {
+			return invalidArgument("tenant ID cannot be empty unless the caller has access to exactly one tenant")
+		}
+		r.Set(field, protoreflect.ValueOfString(tenant))
+		return nil
+	}
+	if !id.Allows(tenantID) {
+		return permissionDenied(map[string]string{"tenant": tenantID}, "no access to tenant")
+	}
+	return nil
+}
+
+// authStream carries the caller's identity and binds every received request
+// to its tenant
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	id  *auth.Identity
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return bindTenant(m, s.id)
+}
+
+// authorizeKeys checks the caller may access keys of tenantID. Calls served
+// without authentication are not checked.
+func authorizeKeys(ctx context.Context, tenantID string, access auth.Access, keys ...string) error {
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	for _, key := range keys {
+		if !id.Can(tenantID, key, access) {
+			return permissionDenied(map[string]string{"tenant": tenantID, "key": key, "access": access.String()},
+				fmt.Sprintf("no %s access to key %q", access, key))
+		}
+	}
+	return nil
+}
+
+// authorizeRange checks the caller may access every key of tenantID in
+// [start, end) that starts with prefix
+func authorizeRange(ctx context.Context, tenantID, start, end, prefix string, access auth.Access) error {
+	id, ok := auth.FromContext(ctx)
+	if !ok || id.CanRange(tenantID, start, end, prefix, access) {
+		return nil
+	}
+	return permissionDenied(map[string]string{"tenant": tenantID, "start": start, "end": end, "prefix": prefix, "access": access.String()},
+		fmt.Sprintf("no %s access to the whole range", access))
+}
+
+// authorizeTxn checks the caller may read the keys a transaction compares or
+// gets, and write the keys it sets or deletes
+func authorizeTxn(ctx context.Context, tenantID string, txn storage.Txn) error {
+	for _, cmp := range txn.Compares {
+		if err := authorizeKeys(ctx, tenantID, auth.AccessRead, cmp.Key); err != nil {
+			return err
+		}
+	}
+	for _, op := range slices.Concat(txn.Success, txn.Failure) {
+		access := auth.AccessWrite
+		if op.Type == storage.TxnGet {
+			access = auth.AccessRead
+		}
+		if err := authorizeKeys(ctx, tenantID, access, op.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unauthenticated rejects a call without valid credentials
+func unauthenticated(msg string) error {
+	return statusError(codes.Unauthenticated, reasonUnauthenticated, nil, msg)
+}
+
+// permissionDenied rejects a call the caller is not allowed to make
+func permissionDenied(metadata map[string]string, msg string) error {
+	return statusError(codes.PermissionDenied, reasonPermissionDenied, metadata, msg)
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/auth"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func newTestAuthenticator(t *testing.T, opts AuthOptions) (*Authenticator, *auth.Tokens, *auth.RBAC) {
+	t.Helper()
+
+	store := storage.NewStore()
+	tokens, err := auth.OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	t.Cleanup(tokens.Close)
+	rbac, err := auth.OpenRBAC(store)
+	if err != nil {
+		t.Fatalf("OpenRBAC failed: %v", err)
+	}
+	t.Cleanup(rbac.Close)
+	return NewAuthenticator(tokens, rbac, opts), tokens, rbac
+}
+
+// callUnary runs req through the interceptor and returns the tenant the
+// handler saw, if req has one
+func callUnary(a *Authenticator, method, token string, req any) (string, error) {
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	var tenant string
+	_, err := a.UnaryInterceptor()(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		if get, ok := req.(*pb.GetRequest); ok {
+			tenant = get.TenantId
+		}
+		return nil, nil
+	})
+	return tenant, err
+}
+
+func TestAuthenticator_RequiresToken(t *testing.T) {
+	a, tokens, _ := newTestAuthenticator(t, AuthOptions{Required: true})
+
+	_, err := callUnary(a, "/kvstore.KVStore/Get", "", &pb.GetRequest{TenantId: "tenant", Key: "key"})
+	expectCode(t, err, codes.Unauthenticated)
+
+	_, err = callUnary(a, "/kvstore.KVStore/Get", "tdb_bogus_token", &pb.GetRequest{TenantId: "tenant", Key: "key"})
+	expectCode(t, err, codes.Unauthenticated)
+
+	token, rec, _ := tokens.Issue(auth.Token{Name: "app", Tenants: []string{"tenant"}})
+	if _, err := callUnary(a, "/kvstore.KVStore/Get", token, &pb.GetRequest{TenantId: "tenant", Key: "key"}); err != nil {
+		t.Fatalf("Expected a valid token to be accepted, got %v", err)
+	}
+
+	tokens.Revoke(rec.ID)
+	_, err = callUnary(a, "/kvstore.KVStore/Get", token, &pb.GetRequest{TenantId: "tenant", Key: "key"})
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+func TestAuthenticator_BindsTenant(t *testing.T) {
+	a, tokens, _ := newTestAuthenticator(t, AuthOptions{Required: true})
+	single, _, _ := tokens.Issue(auth.Token{Name: "single", Tenants: []string{"tenant1"}})
+	multi, _, _ := tokens.Issue(auth.Token{Name: "multi", Tenants: []string{"tenant1", "tenant2"}})
+
+	// A token bound to one tenant fills in the tenant
+	tenant, err := callUnary(a, "/kvstore.KVStore/Get", single, &pb.GetRequest{Key: "key"})
+	if err != nil || tenant != "tenant1" {
+		t.Fatalf("Expected the call bound to tenant1, got %q %v", tenant, err)
+	}
+
+	// Other tenants are denied
+	_, err = callUnary(a, "/kvstore.KVStore/Get", single, &pb.GetRequest{TenantId: "tenant2", Key: "key"})
+	expectCode(t, err, codes.PermissionDenied)
+	if info := errorInfo(t, err); info.Reason != reasonPermissionDenied || info.Metadata["tenant"] != "tenant2" {
+		t.Fatalf("Unexpected error info: %v", info)
+	}
+
+	// A token bound to several tenants must name one
+	tenant, err = callUnary(a, "/kvstore.KVStore/Get", multi, &pb.GetRequest{TenantId: "tenant2", Key: "key"})
+	if err != nil || tenant != "tenant2" {
+		t.Fatalf("Expected the call bound to tenant2, got %q %v", tenant, err)
+	}
+	_, err = callUnary(a, "/kvstore.KVStore/Get", multi, &pb.GetRequest{Key: "key"})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestAuthenticator_AdminServices(t *testing.T) {
+	a, tokens, _ := newTestAuthenticator(t, AuthOptions{Required: true, AdminToken: "bootstrap"})
+	user, _, _ := tokens.Issue(auth.Token{Name: "user", Tenants: []string{"tenant"}})
+	admin, _, _ := tokens.Issue(auth.Token{Name: "admin", Admin: true})
+
+	_, err := callUnary(a, "/kvstore.Admin/IssueToken", user, &pb.IssueTokenRequest{})
+	expectCode(t, err, codes.PermissionDenied)
+
+	for _, token := range []string{admin, "bootstrap"} {
+		if _, err := callUnary(a, "/kvstore.Admin/IssueToken", token, &pb.IssueTokenRequest{}); err != nil {
+			t.Fatalf("Expected an admin token to be accepted, got %v", err)
+		}
+	}
+
+	// The bootstrap token may use every tenant
+	tenant, err := callUnary(a, "/kvstore.KVStore/Get", "bootstrap", &pb.GetRequest{TenantId: "any", Key: "key"})
+	if err != nil || tenant != "any" {
+		t.Fatalf("Expected the bootstrap token to access any tenant, got %q %v", tenant, err)
+	}
+}
+
+func TestAuthenticator_Optional(t *testing.T) {
+	a, _, _ := newTestAuthenticator(t, AuthOptions{})
+
+	// Without a token the request keeps its tenant
+	tenant, err := callUnary(a, "/kvstore.KVStore/Get", "", &pb.GetRequest{TenantId: "tenant", Key: "key"})
+	if err != nil || tenant != "tenant" {
+		t.Fatalf("Expected an unauthenticated call to be served, got %q %v", tenant, err)
+	}
+
+	// An invalid token is still rejected
+	_, err = callUnary(a, "/kvstore.KVStore/Get", "tdb_bogus_token", &pb.GetRequest{TenantId: "tenant", Key: "key"})
+	expectCode(t, err, codes.Unauthenticated)
+
+	// System tenants are never reachable
+	_, err = callUnary(a, "/kvstore.KVStore/Get", "", &pb.GetRequest{TenantId: auth.Tenant, Key: "token/x"})
+	expectCode(t, err, codes.PermissionDenied)
+
+	// Nor are the admin services without an admin token
+	for _, method := range []string{"/kvstore.Admin/IssueToken", "/kvstore.Admin/PutRole", "/kvstore.Cluster/AddMember", "/kvstore.CDC/Subscribe"} {
+		_, err = callUnary(a, method, "", &pb.IssueTokenRequest{})
+		expectCode(t, err, codes.Unauthenticated)
+	}
+}
+
+func TestAuthenticator_ClientCertificate(t *testing.T) {
+	a, _, rbac := newTestAuthenticator(t, AuthOptions{Required: true, AdminClients: []string{"ops"}})
+	rbac.PutRole(auth.Role{Name: "reader", Permissions: []auth.Permission{{Tenant: "orders", Access: auth.AccessRead}}})
+	rbac.Grant("alice", "reader")
+
+	withCert := func(name string, verified bool) context.Context {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+		state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			state.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	}
+
+	id, err := a.authenticate(withCert("alice", true))
+	if err != nil || id.User != "alice" || !id.Can("orders", "key", auth.AccessRead) || id.Can("orders", "key", auth.AccessWrite) {
+		t.Fatalf("Expected alice's certificate to act with her roles, got %+v %v", id, err)
+	}
+
+	id, err = a.authenticate(withCert("ops", true))
+	if err != nil || !id.Admin {
+		t.Fatalf("Expected the ops certificate to be an admin, got %+v %v", id, err)
+	}
+
+	// Certificates the handshake did not verify are ignored
+	_, err = a.authenticate(withCert("ops", false))
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+func TestAdminServer_Tokens(t *testing.T) {
+	store := storage.NewStore()
+	tokens, err := auth.OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+	admin := NewAdminServer(store, tokens, nil)
+	ctx := context.Background()
+
+	resp, err := admin.IssueToken(ctx, &pb.IssueTokenRequest{Name: "app", Tenants: []string{"tenant"}})
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	if resp.Token == "" || resp.Info.TokenId == "" {
+		t.Fatalf("Expected a token and its ID, got %v", resp)
+	}
+
+	_, err = admin.IssueToken(ctx, &pb.IssueTokenRequest{Name: "bad"})
+	expectCode(t, err, codes.InvalidArgument)
+
+	list, err := admin.ListTokens(ctx, &pb.ListTokensRequest{})
+	if err != nil || len(list.Tokens) != 1 || list.Tokens[0].Name != "app" {
+		t.Fatalf("Expected the issued token to be listed, got %v %v", list, err)
+	}
+
+	if _, err := admin.RevokeToken(ctx, &pb.RevokeTokenRequest{TokenId: resp.Info.TokenId}); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	_, err = admin.RevokeToken(ctx, &pb.RevokeTokenRequest{TokenId: resp.Info.TokenId})
+	expectCode(t, err, codes.NotFound)
+
+	_, err = NewAdminServer(store, nil, nil).ListTokens(ctx, &pb.ListTokensRequest{})
+	expectCode(t, err, codes.FailedPrecondition)
+	if info := errorInfo(t, err); info.Reason != reasonNotEnabled {
+		t.Fatalf("Expected %s, got %+v", reasonNotEnabled, info)
+	}
+}
+
+func TestKVStoreServer_EnforcesPermissions(t *testing.T) {
+	server := NewKVStoreServer()
+	server.Set(context.Background(), &pb.SetRequest{TenantId: "orders", Key: "eu:1", Value: []byte("v")})
+
+	analyst := auth.NewContext(context.Background(), &auth.Identity{Name: "analyst", Permissions: []auth.Permission{
+		{Tenant: "orders", Access: auth.AccessRead},
+	}})
+	writer := auth.NewContext(context.Background(), &auth.Identity{Name: "writer", Permissions: []auth.Permission{
+		{Tenant: "orders", Prefix: "eu:", Access: auth.AccessWrite},
+	}})
+
+	// Read-only callers read but do not write
+	if _, err := server.Get(analyst, &pb.GetRequest{TenantId: "orders", Key: "eu:1"}); err != nil {
+		t.Fatalf("Expected the analyst to read, got %v", err)
+	}
+	_, err := server.Set(analyst, &pb.SetRequest{TenantId: "orders", Key: "eu:1", Value: []byte("v")})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = server.Delete(analyst, &pb.DeleteRequest{TenantId: "orders", Key: "eu:1"})
+	expectCode(t, err, codes.PermissionDenied)
+
+	// Prefix writers stay within their prefix
+	if _, err := server.Set(writer, &pb.SetRequest{TenantId: "orders", Key: "eu:2", Value: []byte("v")}); err != nil {
+		t.Fatalf("Expected the writer to write within its prefix, got %v", err)
+	}
+	_, err = server.Set(writer, &pb.SetRequest{TenantId: "orders", Key: "us:1", Value: []byte("v")})
+	expectCode(t, err, codes.PermissionDenied)
+	if info := errorInfo(t, err); info.Metadata["key"] != "us:1" || info.Metadata["access"] != "write" {
+		t.Fatalf("Unexpected error info: %v", info)
+	}
+	_, err = server.MSet(writer, &pb.MSetRequest{TenantId: "orders", Items: []*pb.MSetItem{
+		{Key: "eu:3", Value: []byte("v")},
+		{Key: "us:3", Value: []byte("v")},
+	}})
+	expectCode(t, err, codes.PermissionDenied)
+
+	// Scans must stay within the prefix
+	if _, err := server.Scan(writer, &pb.ScanRequest{TenantId: "orders", Prefix: "eu:"}); err != nil {
+		t.Fatalf("Expected the writer to scan its prefix, got %v", err)
+	}
+	_, err = server.Scan(writer, &pb.ScanRequest{TenantId: "orders"})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = server.Scan(writer, &pb.ScanRequest{TenantId: "orders", Start: "eu:", End: "ev"})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = server.Keys(writer, &pb.KeysRequest{TenantId: "orders"})
+	expectCode(t, err, codes.PermissionDenied)
+
+	// Transactions need read access to what they compare
+	_, err = server.Txn(writer, &pb.TxnRequest{
+		TenantId: "orders",
+		Compare:  []*pb.Compare{{Key: "us:1", Target: pb.Compare_VERSION, Result: pb.Compare_EQUAL}},
+		Success:  []*pb.TxnOp{{Type: pb.TxnOp_SET, Key: "eu:1", Value: []byte("v")}},
+	})
+	expectCode(t, err, codes.PermissionDenied)
+}
+
+func TestAdminServer_Roles(t *testing.T) {
+	store := storage.NewStore()
+	rbac, err := auth.OpenRBAC(store)
+	if err != nil {
+		t.Fatalf("OpenRBAC failed: %v", err)
+	}
+	defer rbac.Close()
+	admin := NewAdminServer(store, nil, rbac)
+	ctx := context.Background()
+
+	reader := &pb.Role{Name: "reader", Permissions: []*pb.Permission{{TenantId: "orders", Access: pb.Permission_READ}}}
+	if _, err := admin.PutRole(ctx, &pb.PutRoleRequest{Role: reader}); err != nil {
+		t.Fatalf("PutRole failed: %v", err)
+	}
+	_, err = admin.PutRole(ctx, &pb.PutRoleRequest{Role: &pb.Role{Name: "bad", Permissions: []*pb.Permission{{TenantId: "orders"}}}})
+	expectCode(t, err, codes.InvalidArgument)
+
+	if _, err := admin.GrantRole(ctx, &pb.GrantRoleRequest{User: "alice", Role: "reader"}); err != nil {
+		t.Fatalf("GrantRole failed: %v", err)
+	}
+	_, err = admin.GrantRole(ctx, &pb.GrantRoleRequest{User: "alice", Role: "missing"})
+	expectCode(t, err, codes.NotFound)
+
+	users, err := admin.ListUsers(ctx, &pb.ListUsersRequest{})
+	if err != nil || len(users.Users) != 1 || users.Users[0].Roles[0] != "reader" {
+		t.Fatalf("Expected alice to be listed with her role, got %v %v", users, err)
+	}
+
+	// Tenant admins manage the roles of their tenants only
+	tenantAdmin := auth.NewContext(ctx, &auth.Identity{Name: "ops", Permissions: []auth.Permission{
+		{Tenant: "orders", Access: auth.AccessAdmin},
+	}})
+	writer := &pb.Role{Name: "writer", Permissions: []*pb.Permission{{TenantId: "orders", Prefix: "eu:", Access: pb.Permission_WRITE}}}
+	if _, err := admin.PutRole(tenantAdmin, &pb.PutRoleRequest{Role: writer}); err != nil {
+		t.Fatalf("Expected a tenant admin to manage its tenant, got %v", err)
+	}
+	billing := &pb.Role{Name: "billing", Permissions: []*pb.Permission{{TenantId: "billing", Access: pb.Permission_READ}}}
+	_, err = admin.PutRole(tenantAdmin, &pb.PutRoleRequest{Role: billing})
+	expectCode(t, err, codes.PermissionDenied)
+
+	admin.PutRole(ctx, &pb.PutRoleRequest{Role: billing})
+	_, err = admin.GrantRole(tenantAdmin, &pb.GrantRoleRequest{User: "bob", Role: "billing"})
+	expectCode(t, err, codes.PermissionDenied)
+	roles, err := admin.ListRoles(tenantAdmin, &pb.ListRolesRequest{})
+	if err != nil || len(roles.Roles) != 2 {
+		t.Fatalf("Expected the tenant admin to see the two orders roles, got %v %v", roles, err)
+	}
+
+	if _, err := admin.DeleteRole(ctx, &pb.DeleteRoleRequest{Name: "reader"}); err != nil {
+		t.Fatalf("DeleteRole failed: %v", err)
+	}
+	if user := rbac.User("alice"); len(user.Roles) != 0 {
+		t.Fatalf("Expected the deleted role to be taken away, got %v", user.Roles)
+	}
+
+	_, err = NewAdminServer(store, nil, nil).ListRoles(ctx, &pb.ListRolesRequest{})
+	expectCode(t, err, codes.FailedPrecondition)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ayushgala/tinkerdb/internal/cdc"
+	"github.com/ayushgala/tinkerdb/internal/logging"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CDCServer implements the gRPC CDC service on top of a stream sink
+type CDCServer struct {
+	pb.UnimplementedCDCServer
+	sink *cdc.StreamSink
+}
+
+// NewCDCServer creates a CDC service serving the changes of sink
+func NewCDCServer(sink *cdc.StreamSink) *CDCServer {
+	return &CDCServer{
+		sink: sink,
+	}
+}
+
+// Subscribe implements the Subscribe RPC method. The stream stays open until
+// the client cancels it or the server shuts down.
+func (s *CDCServer) Subscribe(req *pb.SubscribeRequest, stream grpc.ServerStreamingServer[pb.ChangeBatch]) error {
+	logging.Add(stream.Context(), "consumer", req.ConsumerId, "start_revision", req.StartRevision)
+
+	sub, err := s.sink.Subscribe(req.ConsumerId, req.StartRevision)
+	if err != nil {
+		return storageError(err, "subscribe")
+	}
+
+	// Tell the client where the stream starts before any change arrives
+	if err := stream.Send(&pb.ChangeBatch{Revision: sub.Revision()}); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	for {
+		changes, err := sub.Next(ctx)
+		switch {
+		case errors.Is(err, cdc.ErrSinkClosed):
+			return status.Error(codes.Unavailable, err.Error())
+		case ctx.Err() != nil:
+			return status.FromContextError(ctx.Err()).Err()
+		case err != nil:
+			return storageError(err, "read changes")
+		}
+
+		// Split large batches between revisions, as Watch does
+		resp := &pb.ChangeBatch{}
+		size := 0
+		for i, c := range changes {
+			resp.Changes = append(resp.Changes, changeToProto(c))
+			size += len(c.Key) + len(c.OldValue) + len(c.NewValue)
+
+			last := i == len(changes)-1
+			if !last && (size < watchBatchBytes || changes[i+1].Revision == c.Revision) {
+				continue
+			}
+			resp.Revision = c.Revision
+			if last {
+				// Also covers trailing revisions that made no change
+				resp.Revision = sub.Revision()
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			resp = &pb.ChangeBatch{}
+			size = 0
+		}
+	}
+}
+
+// Commit implements the Commit RPC method
+func (s *CDCServer) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	if req.ConsumerId == "" {
+		return nil, invalidArgument("consumer ID cannot be empty")
+	}
+
+	if err := s.sink.Commit(req.ConsumerId, req.Revision); err != nil {
+		return nil, storageError(err, "commit offset")
+	}
+
+	return &pb.CommitResponse{
+		Success: true,
+		Message: fmt.Sprintf("consumer %s committed revision %d", req.ConsumerId, req.Revision),
+	}, nil
+}
+
+func changeToProto(c storage.Change) *pb.Change {
+	return &pb.Change{
+		Revision:        c.Revision,
+		TenantId:        c.Tenant,
+		Key:             c.Key,
+		Op:              pb.Change_Op(c.Op),
+		OldValue:        c.OldValue,
+		HadOldValue:     c.OldValue != nil,
+		NewValue:        c.NewValue,
+		ExpireAt:        c.ExpireAt,
+		OldValueUnknown: c.OldValueUnknown,
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ClusterServer implements the gRPC Cluster service of a cluster node
+type ClusterServer struct {
+	pb.UnimplementedClusterServer
+	node *raft.Node
+}
+
+// NewClusterServer creates the Cluster service managing the members of
+// node's cluster
+func NewClusterServer(node *raft.Node) *ClusterServer {
+	return &ClusterServer{node: node}
+}
+
+// ListMembers implements the ListMembers RPC method
+func (s *ClusterServer) ListMembers(ctx context.Context, req *pb.ListMembersRequest) (*pb.ListMembersResponse, error) {
+	st := s.node.Status()
+	return &pb.ListMembersResponse{
+		Members:     clusterMembers(st),
+		LeaderId:    st.Leader,
+		Term:        st.Term,
+		CommitIndex: st.Commit,
+	}, nil
+}
+
+// AddMember implements the AddMember RPC method
+func (s *ClusterServer) AddMember(ctx context.Context, req *pb.AddMemberRequest) (*pb.AddMemberResponse, error) {
+	if req.Id == "" || req.Address == "" {
+		return nil, invalidArgument("member ID and address cannot be empty")
+	}
+	if err := s.node.AddMember(ctx, req.Id, req.Address); err != nil {
+		return nil, clusterError(err, "add member")
+	}
+	slog.Info("Added member to the cluster as a learner", "member", req.Id, "address", req.Address)
+
+	return &pb.AddMemberResponse{
+		Success: true,
+		Message: fmt.Sprintf("%s added as a learner", req.Id),
+		Members: clusterMembers(s.node.Status()),
+	}, nil
+}
+
+// PromoteMember implements the PromoteMember RPC method
+func (s *ClusterServer) PromoteMember(ctx context.Context, req *pb.PromoteMemberRequest) (*pb.PromoteMemberResponse, error) {
+	if req.Id == "" {
+		return nil, invalidArgument("member ID cannot be empty")
+	}
+	if err := s.node.PromoteMember(ctx, req.Id); err != nil {
+		return nil, clusterError(err, "promote member")
+	}
+	slog.Info("Promoted member to voter", "member", req.Id)
+
+	return &pb.PromoteMemberResponse{
+		Success: true,
+		Message: fmt.Sprintf("%s promoted to voter", req.Id),
+		Members: clusterMembers(s.node.Status()),
+	}, nil
+}
+
+// RemoveMember implements the RemoveMember RPC method
+func (s *ClusterServer) RemoveMember(ctx context.Context, req *pb.RemoveMemberRequest) (*pb.RemoveMemberResponse, error) {
+	if req.Id == "" {
+		return nil, invalidArgument("member ID cannot be empty")
+	}
+	if err := s.node.RemoveMember(ctx, req.Id); err != nil {
+		return nil, clusterError(err, "remove member")
+	}
+	slog.Info("Removed member from the cluster", "member", req.Id)
+
+	return &pb.RemoveMemberResponse{
+		Success: true,
+		Message: fmt.Sprintf("%s removed", req.Id),
+		Members: clusterMembers(s.node.Status()),
+	}, nil
+}
+
+// TransferLeadership implements the TransferLeadership RPC method
+func (s *ClusterServer) TransferLeadership(ctx context.Context, req *pb.TransferLeadershipRequest) (*pb.TransferLeadershipResponse, error) {
+	if req.Id == "" {
+		return nil, invalidArgument("member ID cannot be empty")
+	}
+	if err := s.node.TransferLeadership(ctx, req.Id); err != nil {
+		return nil, clusterError(err, "transfer leadership")
+	}
+	leader := s.node.Status().Leader
+	slog.Info("Transferred leadership", "member", req.Id)
+
+	return &pb.TransferLeadershipResponse{
+		Success:  true,
+		Message:  fmt.Sprintf("leadership transferred to %s", req.Id),
+		LeaderId: leader,
+	}, nil
+}
+
+// clusterMembers converts the members of a node's status
+func clusterMembers(st raft.Status) []*pb.ClusterMember {
+	members := make([]*pb.ClusterMember, 0, len(st.Members))
+	for _, m := range st.Members {
+		member := &pb.ClusterMember{
+			Id:      m.ID,
+			Address: m.Address,
+			Learner: m.Learner,
+			Leader:  m.ID == st.Leader,
+		}
+		switch {
+		case m.ID == st.ID && st.Role == raft.Leader:
+			member.MatchIndex = st.LastIndex
+		case st.Match != nil:
+			member.MatchIndex = st.Match[m.ID]
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// clusterError converts an error returned by a membership change
+func clusterError(err error, what string) error {
+	switch {
+	case errors.Is(err, raft.ErrUnknownMember):
+		return statusError(codes.NotFound, reasonMemberNotFound, nil, err.Error())
+	case errors.Is(err, raft.ErrMemberExists):
+		return statusError(codes.AlreadyExists, reasonMemberExists, nil, err.Error())
+	case errors.Is(err, raft.ErrChangeInProgress):
+		return statusError(codes.Aborted, reasonChangeInProgress, nil, err.Error())
+	case errors.Is(err, raft.ErrTransferFailed):
+		return statusError(codes.Aborted, reasonTransferFailed, nil, err.Error())
+	case errors.Is(err, raft.ErrInvalidChange), errors.Is(err, raft.ErrLearnerBehind):
+		return statusError(codes.FailedPrecondition, reasonInvalidChange, nil, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return storageError(err, what)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newSingleNode starts a cluster of one node, which elects itself, and
+// returns it with its store
+func newSingleNode(t *testing.T) (*raft.Node, *storage.Store) {
+	t.Helper()
+
+	dir := t.TempDir()
+	transport := raft.NewGRPCTransport(insecure.NewCredentials(), "")
+	node, err := raft.NewNode(raft.Config{
+		ID:                "n1",
+		Peers:             map[string]string{"n1": "127.0.0.1:1"},
+		Dir:               filepath.Join(dir, "raft"),
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+	}, transport)
+	if err != nil {
+		t.Fatalf("NewNode failed: %v", err)
+	}
+	opts := storage.DefaultOptions(dir)
+	opts.SnapshotInterval = 0
+	store, err := storage.OpenWithLog(opts, node)
+	if err != nil {
+		t.Fatalf("OpenWithLog failed: %v", err)
+	}
+	node.Start(store)
+	t.Cleanup(func() {
+		node.Stop()
+		store.Close()
+		transport.Close()
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for node.Status().Role != raft.Leader {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the node to lead")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return node, store
+}
+
+func TestClusterServer_Membership(t *testing.T) {
+	node, _ := newSingleNode(t)
+	server := NewClusterServer(node)
+	ctx := context.Background()
+
+	list, err := server.ListMembers(ctx, &pb.ListMembersRequest{})
+	if err != nil {
+		t.Fatalf("ListMembers failed: %v", err)
+	}
+	if len(list.Members) != 1 || !list.Members[0].Leader || list.LeaderId != "n1" {
+		t.Fatalf("Expected n1 as the only member and leader, got %+v", list)
+	}
+
+	added, err := server.AddMember(ctx, &pb.AddMemberRequest{Id: "n2", Address: "127.0.0.1:2"})
+	if err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	if len(added.Members) != 2 || !added.Members[1].Learner {
+		t.Fatalf("Expected n2 as a learner, got %+v", added.Members)
+	}
+	_, err = server.AddMember(ctx, &pb.AddMemberRequest{Id: "n3", Address: "127.0.0.1:2"})
+	expectCode(t, err, codes.AlreadyExists)
+	_, err = server.AddMember(ctx, &pb.AddMemberRequest{Id: "n3"})
+	expectCode(t, err, codes.InvalidArgument)
+
+	// n2 is unreachable, so it never catches up
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = server.PromoteMember(short, &pb.PromoteMemberRequest{Id: "n2"})
+	expectCode(t, err, codes.FailedPrecondition)
+	_, err = server.TransferLeadership(ctx, &pb.TransferLeadershipRequest{Id: "n2"})
+	expectCode(t, err, codes.FailedPrecondition)
+	if info := errorInfo(t, err); info.Reason != reasonInvalidChange {
+		t.Fatalf("Expected %s, got %+v", reasonInvalidChange, info)
+	}
+
+	_, err = server.RemoveMember(ctx, &pb.RemoveMemberRequest{Id: "n9"})
+	expectCode(t, err, codes.NotFound)
+	if info := errorInfo(t, err); info.Reason != reasonMemberNotFound {
+		t.Fatalf("Expected %s, got %+v", reasonMemberNotFound, info)
+	}
+	_, err = server.RemoveMember(ctx, &pb.RemoveMemberRequest{Id: "n1"})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	removed, err := server.RemoveMember(ctx, &pb.RemoveMemberRequest{Id: "n2"})
+	if err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	if len(removed.Members) != 1 {
+		t.Fatalf("Expected n2 to be gone, got %+v", removed.Members)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetNode serves reads from the cluster of node at the consistency each
+// requests. Without a node every read sees the store's latest state. It
+// must be called before the server is started.
+func (s *KVStoreServer) SetNode(node *raft.Node) {
+	s.node = node
+}
+
+// waitReadable waits until the store can serve a read at consistency,
+// maxLagMs bounding the staleness of STALE reads
+func (s *KVStoreServer) waitReadable(ctx context.Context, consistency pb.ReadConsistency, maxLagMs int64) error {
+	if maxLagMs < 0 {
+		return invalidArgument("max lag cannot be negative")
+	}
+	if _, ok := pb.ReadConsistency_name[int32(consistency)]; !ok {
+		return invalidArgument(fmt.Sprintf("unknown read consistency %d", consistency))
+	}
+	if s.node == nil {
+		return nil
+	}
+
+	var err error
+	switch consistency {
+	case pb.ReadConsistency_LINEARIZABLE:
+		err = s.node.ReadIndex(ctx)
+	case pb.ReadConsistency_LEASE:
+		err = s.node.LeaseRead(ctx)
+	case pb.ReadConsistency_STALE:
+		err = s.node.StaleRead(ctx, time.Duration(maxLagMs)*time.Millisecond)
+	}
+	if err != nil {
+		return readError(err)
+	}
+	return nil
+}
+
+// readError converts an error returned while waiting to serve a read
+func readError(err error) error {
+	var stale *raft.StaleError
+	switch {
+	case errors.As(err, &stale):
+		// The leader is always current enough, clients retry there
+		var metadata map[string]string
+		if stale.Leader != "" {
+			metadata = map[string]string{"leader": stale.Leader}
+		}
+		return statusError(codes.Unavailable, reasonReplicaStale, metadata, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return storageError(err, "read")
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+)
+
+func TestKVStoreServer_ReadConsistency(t *testing.T) {
+	node, store := newSingleNode(t)
+	server := NewKVStoreServerWithStore(store)
+	server.SetNode(node)
+	ctx := context.Background()
+
+	if _, err := server.Set(ctx, &pb.SetRequest{TenantId: "t1", Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for _, consistency := range []pb.ReadConsistency{pb.ReadConsistency_LINEARIZABLE, pb.ReadConsistency_LEASE, pb.ReadConsistency_STALE} {
+		resp, err := server.Get(ctx, &pb.GetRequest{TenantId: "t1", Key: "k", Consistency: consistency, MaxLagMs: 1000})
+		if err != nil {
+			t.Fatalf("%s Get failed: %v", consistency, err)
+		}
+		if string(resp.Value) != "v" {
+			t.Fatalf("Expected v from a %s Get, got %q", consistency, resp.Value)
+		}
+		scan, err := server.Scan(ctx, &pb.ScanRequest{TenantId: "t1", Consistency: consistency})
+		if err != nil {
+			t.Fatalf("%s Scan failed: %v", consistency, err)
+		}
+		if len(scan.Items) != 1 {
+			t.Fatalf("Expected 1 key from a %s Scan, got %d", consistency, len(scan.Items))
+		}
+	}
+
+	_, err := server.Get(ctx, &pb.GetRequest{TenantId: "t1", Key: "k", Consistency: pb.ReadConsistency_STALE, MaxLagMs: -1})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = server.Scan(ctx, &pb.ScanRequest{TenantId: "t1", Consistency: pb.ReadConsistency(7)})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestReadError(t *testing.T) {
+	err := readError(&raft.StaleError{Leader: "127.0.0.1:50051"})
+	expectCode(t, err, codes.Unavailable)
+	info := errorInfo(t, err)
+	if info.Reason != reasonReplicaStale || info.Metadata["leader"] != "127.0.0.1:50051" {
+		t.Fatalf("Expected %s naming the leader, got %+v", reasonReplicaStale, info)
+	}
+
+	expectCode(t, readError(context.DeadlineExceeded), codes.DeadlineExceeded)
+	if info := errorInfo(t, readError(&raft.NotLeaderError{})); info.Reason != reasonNotLeader {
+		t.Fatalf("Expected %s, got %+v", reasonNotLeader, info)
+	}
+}
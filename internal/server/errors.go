@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the ErrorInfo domain of every error the server returns
+const errorDomain = "tinkerdb"
+
+// Reasons reported in the ErrorInfo detail of failed calls. Clients may
+// match on them, so they must not change.
+const (
+	reasonInvalidArgument    = "INVALID_ARGUMENT"
+	reasonKeyNotFound        = "KEY_NOT_FOUND"
+	reasonPreconditionFailed = "PRECONDITION_FAILED"
+	reasonLimitExceeded      = "LIMIT_EXCEEDED"
+	reasonCompacted          = "REVISION_COMPACTED"
+	reasonWatcherTooSlow     = "WATCHER_TOO_SLOW"
+	reasonInternal           = "INTERNAL"
+	reasonNotEnabled         = "NOT_ENABLED"
+	reasonUnauthenticated    = "UNAUTHENTICATED"
+	reasonPermissionDenied   = "PERMISSION_DENIED"
+	reasonTokenNotFound      = "TOKEN_NOT_FOUND"
+	reasonRoleNotFound       = "ROLE_NOT_FOUND"
+	reasonUserNotFound       = "USER_NOT_FOUND"
+	reasonNotLeader          = "NOT_LEADER"
+	reasonLeadershipLost     = "LEADERSHIP_LOST"
+	reasonOutcomeUnknown     = "OUTCOME_UNKNOWN"
+	reasonMemberNotFound     = "MEMBER_NOT_FOUND"
+	reasonMemberExists       = "MEMBER_EXISTS"
+	reasonInvalidChange      = "INVALID_CHANGE"
+	reasonChangeInProgress   = "CHANGE_IN_PROGRESS"
+	reasonTransferFailed     = "TRANSFER_FAILED"
+	reasonReplicaStale       = "REPLICA_STALE"
+	reasonWrongShard         = "WRONG_SHARD"
+	reasonShardedRange       = "SHARDED_RANGE"
+)
+
+// statusError builds a status error carrying an ErrorInfo detail
+func statusError(code codes.Code, reason string, metadata map[string]string, msg string) error {
+	st := status.New(code, msg)
+	withInfo, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return withInfo.Err()
+}
+
+// invalidArgument rejects a malformed request
+func invalidArgument(msg string) error {
+	return statusError(codes.InvalidArgument, reasonInvalidArgument, nil, msg)
+}
+
+// keyNotFound reports a key that does not exist
+func keyNotFound(tenantID, key string) error {
+	return statusError(codes.NotFound, reasonKeyNotFound, map[string]string{"tenant": tenantID, "key": key}, "key not found")
+}
+
+// storageError converts an error returned by the store. what describes the
+// failed operation for errors that are the server's fault.
+func storageError(err error, what string) error {
+	var notLeader *raft.NotLeaderError
+	switch {
+	case errors.As(err, &notLeader):
+		// The write was not made, clients retry it on the leader
+		var metadata map[string]string
+		if notLeader.Leader != "" {
+			metadata = map[string]string{"leader": notLeader.Leader}
+		}
+		return statusError(codes.Unavailable, reasonNotLeader, metadata, err.Error())
+	case errors.Is(err, raft.ErrNotReady):
+		// Clients back off and retry on this node
+		return statusError(codes.Unavailable, reasonNotLeader, nil, err.Error())
+	case errors.Is(err, raft.ErrLeadershipLost):
+		return statusError(codes.Aborted, reasonLeadershipLost, nil, err.Error())
+	case errors.Is(err, raft.ErrOutcomeUnknown):
+		return statusError(status.FromContextError(err).Code(), reasonOutcomeUnknown, nil, err.Error())
+	case errors.Is(err, raft.ErrClosed):
+		return status.Error(codes.Unavailable, "server is shutting down")
+	case errors.Is(err, storage.ErrInvalidArgument):
+		return invalidArgument(err.Error())
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		return statusError(codes.FailedPrecondition, reasonPreconditionFailed, nil, err.Error())
+	case errors.Is(err, storage.ErrLimitExceeded):
+		return statusError(codes.ResourceExhausted, reasonLimitExceeded, nil, err.Error())
+	case errors.Is(err, storage.ErrCompacted):
+		return statusError(codes.OutOfRange, reasonCompacted, nil, err.Error())
+	}
+	return statusError(codes.Internal, reasonInternal, nil, fmt.Sprintf("failed to %s: %v", what, err))
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// expectCode fails the test unless err is a status error with code
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if status.Code(err) != code {
+		t.Fatalf("Expected %v, got %v", code, err)
+	}
+}
+
+// errorInfo returns the ErrorInfo detail of a status error
+func errorInfo(t *testing.T, err error) *errdetails.ErrorInfo {
+	t.Helper()
+
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	t.Fatalf("Expected an ErrorInfo detail on %v", err)
+	return nil
+}
+
+func TestKVStoreServer_ErrorInfo(t *testing.T) {
+	server := NewKVStoreServer()
+	ctx := context.Background()
+
+	_, err := server.Get(ctx, &pb.GetRequest{TenantId: "tenant", Key: "missing"})
+	expectCode(t, err, codes.NotFound)
+	info := errorInfo(t, err)
+	if info.Reason != reasonKeyNotFound || info.Domain != errorDomain {
+		t.Fatalf("Expected %s in domain %s, got %+v", reasonKeyNotFound, errorDomain, info)
+	}
+	if info.Metadata["tenant"] != "tenant" || info.Metadata["key"] != "missing" {
+		t.Fatalf("Expected the tenant and key in the metadata, got %v", info.Metadata)
+	}
+
+	_, err = server.Set(ctx, &pb.SetRequest{TenantId: "tenant"})
+	expectCode(t, err, codes.InvalidArgument)
+	if info := errorInfo(t, err); info.Reason != reasonInvalidArgument {
+		t.Fatalf("Expected %s, got %+v", reasonInvalidArgument, info)
+	}
+
+	server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "key", Value: []byte("v")})
+	_, err = server.Set(ctx, &pb.SetRequest{
+		TenantId:     "tenant",
+		Key:          "key",
+		Precondition: &pb.Precondition{Condition: &pb.Precondition_IfAbsent{IfAbsent: true}},
+	})
+	expectCode(t, err, codes.FailedPrecondition)
+	if info := errorInfo(t, err); info.Reason != reasonPreconditionFailed {
+		t.Fatalf("Expected %s, got %+v", reasonPreconditionFailed, info)
+	}
+
+	compares := make([]*pb.Compare, storage.MaxTxnOps+1)
+	for i := range compares {
+		compares[i] = &pb.Compare{Key: "key", Target: pb.Compare_EXISTS, Exists: true}
+	}
+	_, err = server.Txn(ctx, &pb.TxnRequest{TenantId: "tenant", Compare: compares})
+	expectCode(t, err, codes.ResourceExhausted)
+	if info := errorInfo(t, err); info.Reason != reasonLimitExceeded {
+		t.Fatalf("Expected %s, got %+v", reasonLimitExceeded, info)
+	}
+}
+
+func TestStorageError_NotLeader(t *testing.T) {
+	err := storageError(fmt.Errorf("failed to log set: %w", &raft.NotLeaderError{Leader: "127.0.0.1:50052"}), "set")
+	expectCode(t, err, codes.Unavailable)
+	info := errorInfo(t, err)
+	if info.Reason != reasonNotLeader || info.Metadata["leader"] != "127.0.0.1:50052" {
+		t.Fatalf("Expected %s with the leader address, got %+v", reasonNotLeader, info)
+	}
+
+	err = storageError(&raft.NotLeaderError{}, "set")
+	if info := errorInfo(t, err); info.Reason != reasonNotLeader || len(info.Metadata) != 0 {
+		t.Fatalf("Expected %s without a leader, got %+v", reasonNotLeader, info)
+	}
+
+	err = storageError(raft.ErrLeadershipLost, "set")
+	expectCode(t, err, codes.Aborted)
+	if info := errorInfo(t, err); info.Reason != reasonLeadershipLost {
+		t.Fatalf("Expected %s, got %+v", reasonLeadershipLost, info)
+	}
+
+	err = storageError(fmt.Errorf("failed to log set: %w: %w", raft.ErrOutcomeUnknown, context.DeadlineExceeded), "set")
+	expectCode(t, err, codes.DeadlineExceeded)
+	if info := errorInfo(t, err); info.Reason != reasonOutcomeUnknown {
+		t.Fatalf("Expected %s, got %+v", reasonOutcomeUnknown, info)
+	}
+}
@@ -2,115 +2,149 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"slices"
+	"sync/atomic"
+	"time"
 
+	"github.com/ayushgala/tinkerdb/internal/auth"
+	"github.com/ayushgala/tinkerdb/internal/logging"
+	"github.com/ayushgala/tinkerdb/internal/raft"
+	"github.com/ayushgala/tinkerdb/internal/shard"
 	"github.com/ayushgala/tinkerdb/internal/storage"
 	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// KVStoreServer implements the gRPC KVStore service
+const (
+	// scanMaxBytes bounds the keys and values of one Scan page, keeping the
+	// response well below gRPC's default 4MB message limit
+	scanMaxBytes = 2 * 1024 * 1024
+
+	// streamBatchKeys and streamBatchBytes bound the keys read from storage
+	// at a time and the size of each ScanStream message
+	streamBatchKeys  = 256
+	streamBatchBytes = 256 * 1024
+
+	// watchBatchBytes is the size past which queued watch events are split
+	// over several messages, always between revisions
+	watchBatchBytes = 1024 * 1024
+)
+
+// KVStoreServer implements the gRPC KVStore service. Every RPC checks the
+// caller's permissions on the keys it touches, see authorizeKeys.
 type KVStoreServer struct {
 	pb.UnimplementedKVStoreServer
-	store *storage.Store
+	store   *storage.Store
+	node    *raft.Node // Cluster the store is replicated in, nil for none
+	shards  *shard.Map // Placement of the keys when sharded, nil for none
+	shardID string     // Shard group of this server
+	limits  atomic.Pointer[Limits]
 }
 
-// NewKVStoreServer creates a new gRPC server instance
+// NewKVStoreServer creates a new gRPC server instance backed by an in-memory store
 func NewKVStoreServer() *KVStoreServer {
+	return NewKVStoreServerWithStore(storage.NewStore())
+}
+
+// NewKVStoreServerWithStore creates a gRPC server instance on top of an existing store
+func NewKVStoreServerWithStore(store *storage.Store) *KVStoreServer {
 	return &KVStoreServer{
-		store: storage.NewStore(),
+		store: store,
 	}
 }
 
 // Set implements the Set RPC method
 func (s *KVStoreServer) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
-	log.Printf("Set: tenant=%s, key=%s, value_size=%d bytes", req.TenantId, req.Key, len(req.Value))
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("key", req.Key), "value_size", len(req.Value), "ttl_ms", req.TtlMs)
 
-	if req.TenantId == "" {
-		return &pb.SetResponse{
-			Success: false,
-			Message: "tenant ID cannot be empty",
-		}, nil
+	if err := s.validateKey(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessWrite, req.Key); err != nil {
+		return nil, err
 	}
 
-	if req.Key == "" {
-		return &pb.SetResponse{
-			Success: false,
-			Message: "key cannot be empty",
-		}, nil
+	if err := s.currentLimits().checkValue(req.Value); err != nil {
+		return nil, err
+	}
+	if req.TtlMs < 0 {
+		return nil, invalidArgument("ttl cannot be negative")
 	}
 
-	err := s.store.Set(req.TenantId, req.Key, req.Value)
+	version, err := s.store.WithContext(ctx).SetWithOptions(req.TenantId, req.Key, req.Value, storage.SetOptions{
+		TTL:          time.Duration(req.TtlMs) * time.Millisecond,
+		Precondition: preconditionFromProto(req.Precondition),
+	})
 	if err != nil {
-		return &pb.SetResponse{
-			Success: false,
-			Message: fmt.Sprintf("failed to set key: %v", err),
-		}, nil
+		return nil, storageError(err, "set key")
 	}
 
 	return &pb.SetResponse{
 		Success: true,
 		Message: "key set successfully",
+		Version: version,
 	}, nil
 }
 
 // Get implements the Get RPC method
 func (s *KVStoreServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
-	log.Printf("Get: tenant=%s, key=%s", req.TenantId, req.Key)
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("key", req.Key), "consistency", req.Consistency.String())
 
-	if req.TenantId == "" {
-		return &pb.GetResponse{
-			Found:   false,
-			Message: "tenant ID cannot be empty",
-		}, nil
+	if err := s.validateKey(req.TenantId, req.Key); err != nil {
+		return nil, err
 	}
-
-	if req.Key == "" {
-		return &pb.GetResponse{
-			Found:   false,
-			Message: "key cannot be empty",
-		}, nil
+	if err := s.checkShard(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessRead, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.waitReadable(ctx, req.Consistency, req.MaxLagMs); err != nil {
+		return nil, err
 	}
 
-	value, found := s.store.Get(req.TenantId, req.Key)
+	value, version, found := s.store.WithContext(ctx).GetWithVersion(req.TenantId, req.Key)
 	if !found {
-		return &pb.GetResponse{
-			Found:   false,
-			Message: "key not found",
-		}, nil
+		return nil, keyNotFound(req.TenantId, req.Key)
 	}
 
 	return &pb.GetResponse{
 		Found:   true,
 		Value:   value,
 		Message: "key found",
+		Version: version,
 	}, nil
 }
 
 // Delete implements the Delete RPC method
 func (s *KVStoreServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
-	log.Printf("Delete: tenant=%s, key=%s", req.TenantId, req.Key)
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("key", req.Key))
 
-	if req.TenantId == "" {
-		return &pb.DeleteResponse{
-			Success: false,
-			Message: "tenant ID cannot be empty",
-		}, nil
+	if err := s.validateKey(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessWrite, req.Key); err != nil {
+		return nil, err
 	}
 
-	if req.Key == "" {
-		return &pb.DeleteResponse{
-			Success: false,
-			Message: "key cannot be empty",
-		}, nil
+	deleted, err := s.store.WithContext(ctx).DeleteWithPrecondition(req.TenantId, req.Key, preconditionFromProto(req.Precondition))
+	if err != nil {
+		return nil, storageError(err, "delete key")
 	}
 
-	deleted := s.store.Delete(req.TenantId, req.Key)
 	if !deleted {
-		return &pb.DeleteResponse{
-			Success: false,
-			Message: "key not found",
-		}, nil
+		return nil, keyNotFound(req.TenantId, req.Key)
 	}
 
 	return &pb.DeleteResponse{
@@ -121,15 +155,19 @@ func (s *KVStoreServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.
 
 // Exists implements the Exists RPC method
 func (s *KVStoreServer) Exists(ctx context.Context, req *pb.ExistsRequest) (*pb.ExistsResponse, error) {
-	log.Printf("Exists: tenant=%s, key=%s", req.TenantId, req.Key)
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("key", req.Key))
 
-	if req.TenantId == "" || req.Key == "" {
-		return &pb.ExistsResponse{
-			Exists: false,
-		}, nil
+	if err := s.validateKey(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessRead, req.Key); err != nil {
+		return nil, err
 	}
 
-	exists := s.store.Exists(req.TenantId, req.Key)
+	exists := s.store.WithContext(ctx).Exists(req.TenantId, req.Key)
 	return &pb.ExistsResponse{
 		Exists: exists,
 	}, nil
@@ -137,16 +175,567 @@ func (s *KVStoreServer) Exists(ctx context.Context, req *pb.ExistsRequest) (*pb.
 
 // Keys implements the Keys RPC method
 func (s *KVStoreServer) Keys(ctx context.Context, req *pb.KeysRequest) (*pb.KeysResponse, error) {
-	log.Printf("Keys: tenant=%s", req.TenantId)
+	logging.Add(ctx, "tenant", req.TenantId)
 
 	if req.TenantId == "" {
-		return &pb.KeysResponse{
-			Keys: []string{},
-		}, nil
+		return nil, invalidArgument("tenant ID cannot be empty")
+	}
+	if err := authorizeRange(ctx, req.TenantId, "", "", "", auth.AccessRead); err != nil {
+		return nil, err
+	}
+	if err := s.checkShardRange(ctx); err != nil {
+		return nil, err
 	}
 
-	keys := s.store.Keys(req.TenantId)
+	keys := s.store.WithContext(ctx).Keys(req.TenantId)
 	return &pb.KeysResponse{
 		Keys: keys,
 	}, nil
 }
+
+// Scan implements the Scan RPC method
+func (s *KVStoreServer) Scan(ctx context.Context, req *pb.ScanRequest) (*pb.ScanResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("start", req.Start), logging.Key("end", req.End), logging.Key("prefix", req.Prefix), "limit", req.Limit, "reverse", req.Reverse, "consistency", req.Consistency.String())
+
+	if req.TenantId == "" {
+		return nil, invalidArgument("tenant ID cannot be empty")
+	}
+	if err := authorizeRange(ctx, req.TenantId, req.Start, req.End, req.Prefix, auth.AccessRead); err != nil {
+		return nil, err
+	}
+	if err := s.checkShardRange(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.waitReadable(ctx, req.Consistency, req.MaxLagMs); err != nil {
+		return nil, err
+	}
+
+	result, err := s.store.WithContext(ctx).Scan(req.TenantId, storage.ScanOptions{
+		Start:    req.Start,
+		End:      req.End,
+		Prefix:   req.Prefix,
+		Limit:    s.currentLimits().scanLimit(int(req.Limit)),
+		MaxBytes: scanMaxBytes,
+		Reverse:  req.Reverse,
+		KeysOnly: req.KeysOnly,
+		Cursor:   req.Cursor,
+	})
+	if err != nil {
+		return nil, storageError(err, "scan")
+	}
+
+	items := make([]*pb.KeyValue, len(result.Items))
+	for i, item := range result.Items {
+		items[i] = &pb.KeyValue{
+			Key:     item.Key,
+			Value:   item.Value,
+			Version: item.Version,
+		}
+	}
+	return &pb.ScanResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d keys", len(items)),
+		Items:   items,
+		Cursor:  result.Cursor,
+	}, nil
+}
+
+// ScanStream implements the ScanStream RPC method. Batches are read from a
+// point-in-time view of the tenant and sent as they are read; Send blocks
+// while the client's flow control window is full, so a slow reader holds
+// back the scan rather than buffering it in memory.
+func (s *KVStoreServer) ScanStream(req *pb.ScanRequest, stream grpc.ServerStreamingServer[pb.ScanStreamResponse]) error {
+	logging.Add(stream.Context(), "tenant", req.TenantId, logging.Key("start", req.Start), logging.Key("end", req.End), logging.Key("prefix", req.Prefix), "limit", req.Limit, "reverse", req.Reverse, "consistency", req.Consistency.String())
+
+	if req.TenantId == "" {
+		return invalidArgument("tenant ID cannot be empty")
+	}
+	if err := authorizeRange(stream.Context(), req.TenantId, req.Start, req.End, req.Prefix, auth.AccessRead); err != nil {
+		return err
+	}
+	if err := s.checkShardRange(stream.Context()); err != nil {
+		return err
+	}
+	if err := s.waitReadable(stream.Context(), req.Consistency, req.MaxLagMs); err != nil {
+		return err
+	}
+
+	scanner, err := s.store.WithContext(stream.Context()).NewScanner(req.TenantId, storage.ScanOptions{
+		Start:    req.Start,
+		End:      req.End,
+		Prefix:   req.Prefix,
+		Limit:    int(req.Limit),
+		Reverse:  req.Reverse,
+		KeysOnly: req.KeysOnly,
+		Cursor:   req.Cursor,
+	})
+	if err != nil {
+		return storageError(err, "scan")
+	}
+	defer scanner.Close()
+
+	ctx := stream.Context()
+	resp := &pb.ScanStreamResponse{Revision: scanner.Revision()}
+	size := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		batch, err := scanner.Next(streamBatchKeys)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return storageError(err, "scan")
+		}
+
+		for _, item := range batch {
+			resp.Items = append(resp.Items, &pb.KeyValue{
+				Key:     item.Key,
+				Value:   item.Value,
+				Version: item.Version,
+			})
+			size += len(item.Key) + len(item.Value)
+			if size < streamBatchBytes {
+				continue
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			resp = &pb.ScanStreamResponse{Revision: scanner.Revision()}
+			size = 0
+		}
+	}
+
+	// Always send a final message so the client learns the revision even
+	// for an empty range
+	return stream.Send(resp)
+}
+
+// Expire implements the Expire RPC method
+func (s *KVStoreServer) Expire(ctx context.Context, req *pb.ExpireRequest) (*pb.ExpireResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("key", req.Key), "ttl_ms", req.TtlMs)
+
+	if err := s.validateKey(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessWrite, req.Key); err != nil {
+		return nil, err
+	}
+
+	if req.TtlMs <= 0 {
+		return nil, invalidArgument("ttl must be positive")
+	}
+
+	exists, err := s.store.WithContext(ctx).Expire(req.TenantId, req.Key, time.Duration(req.TtlMs)*time.Millisecond)
+	if err != nil {
+		return nil, storageError(err, "set expiry")
+	}
+
+	if !exists {
+		return nil, keyNotFound(req.TenantId, req.Key)
+	}
+
+	return &pb.ExpireResponse{
+		Success: true,
+		Message: "expiry set successfully",
+	}, nil
+}
+
+// Persist implements the Persist RPC method
+func (s *KVStoreServer) Persist(ctx context.Context, req *pb.PersistRequest) (*pb.PersistResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("key", req.Key))
+
+	if err := s.validateKey(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessWrite, req.Key); err != nil {
+		return nil, err
+	}
+
+	exists, err := s.store.WithContext(ctx).Persist(req.TenantId, req.Key)
+	if err != nil {
+		return nil, storageError(err, "remove expiry")
+	}
+
+	if !exists {
+		return nil, keyNotFound(req.TenantId, req.Key)
+	}
+
+	return &pb.PersistResponse{
+		Success: true,
+		Message: "expiry removed successfully",
+	}, nil
+}
+
+// TTL implements the TTL RPC method
+func (s *KVStoreServer) TTL(ctx context.Context, req *pb.TTLRequest) (*pb.TTLResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, logging.Key("key", req.Key))
+
+	if err := s.validateKey(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Key); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessRead, req.Key); err != nil {
+		return nil, err
+	}
+
+	ttl, exists := s.store.WithContext(ctx).TTL(req.TenantId, req.Key)
+	if !exists {
+		return nil, keyNotFound(req.TenantId, req.Key)
+	}
+
+	if ttl == 0 {
+		return &pb.TTLResponse{
+			Found:   true,
+			TtlMs:   -1,
+			Message: "key does not expire",
+		}, nil
+	}
+
+	// Round up so a live key never reports 0ms left
+	return &pb.TTLResponse{
+		Found:   true,
+		TtlMs:   int64((ttl + time.Millisecond - 1) / time.Millisecond),
+		Message: "key found",
+	}, nil
+}
+
+// validateKey rejects requests without a tenant ID or key, or with a key
+// over the size limit
+func (s *KVStoreServer) validateKey(tenantID, key string) error {
+	if tenantID == "" {
+		return invalidArgument("tenant ID cannot be empty")
+	}
+	if key == "" {
+		return invalidArgument("key cannot be empty")
+	}
+	return s.currentLimits().checkKey(key)
+}
+
+// checkKeys applies the batch and key size limits to the keys of a batch
+func (s *KVStoreServer) checkKeys(keys []string) error {
+	limits := s.currentLimits()
+	if err := limits.checkBatch(len(keys)); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := limits.checkKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preconditionFromProto converts a request precondition, nil means none
+func preconditionFromProto(p *pb.Precondition) storage.Precondition {
+	switch c := p.GetCondition().(type) {
+	case *pb.Precondition_IfVersionEquals:
+		return storage.IfVersion(c.IfVersionEquals)
+	case *pb.Precondition_IfAbsent:
+		if c.IfAbsent {
+			return storage.IfAbsent()
+		}
+	case *pb.Precondition_IfPresent:
+		if c.IfPresent {
+			return storage.IfPresent()
+		}
+	}
+	return storage.Precondition{}
+}
+
+// Txn implements the Txn RPC method
+func (s *KVStoreServer) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, "compares", len(req.Compare), "success_ops", len(req.Success), "failure_ops", len(req.Failure))
+
+	if req.TenantId == "" {
+		return nil, invalidArgument("tenant ID cannot be empty")
+	}
+
+	txn := storage.Txn{
+		Compares: make([]storage.Compare, len(req.Compare)),
+		Success:  make([]storage.TxnOp, len(req.Success)),
+		Failure:  make([]storage.TxnOp, len(req.Failure)),
+	}
+	for i, cmp := range req.Compare {
+		txn.Compares[i] = storage.Compare{
+			Key:     cmp.Key,
+			Target:  storage.CompareTarget(cmp.Target),
+			Op:      storage.CompareOp(cmp.Result),
+			Value:   cmp.Value,
+			Version: cmp.Version,
+			Exists:  cmp.Exists,
+		}
+	}
+	for i, op := range req.Success {
+		txn.Success[i] = txnOpFromProto(op)
+	}
+	for i, op := range req.Failure {
+		txn.Failure[i] = txnOpFromProto(op)
+	}
+	if err := s.checkTxn(txn); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, txnKeys(txn)...); err != nil {
+		return nil, err
+	}
+	if err := authorizeTxn(ctx, req.TenantId, txn); err != nil {
+		return nil, err
+	}
+
+	result, err := s.store.WithContext(ctx).Txn(req.TenantId, txn)
+	if err != nil {
+		return nil, storageError(err, "execute transaction")
+	}
+
+	resp := &pb.TxnResponse{
+		Success:   true,
+		Message:   "transaction executed",
+		Succeeded: result.Succeeded,
+		Revision:  result.Revision,
+		Results:   make([]*pb.TxnOpResult, len(result.Results)),
+	}
+	for i, r := range result.Results {
+		resp.Results[i] = &pb.TxnOpResult{
+			Type:    pb.TxnOp_Type(r.Type),
+			Key:     r.Key,
+			Found:   r.Found,
+			Value:   r.Value,
+			Version: r.Version,
+		}
+	}
+	return resp, nil
+}
+
+// checkTxn applies the key and value size limits to a transaction
+func (s *KVStoreServer) checkTxn(txn storage.Txn) error {
+	limits := s.currentLimits()
+	for _, cmp := range txn.Compares {
+		if err := limits.checkKey(cmp.Key); err != nil {
+			return err
+		}
+	}
+	for _, op := range slices.Concat(txn.Success, txn.Failure) {
+		if err := limits.checkKey(op.Key); err != nil {
+			return err
+		}
+		if err := limits.checkValue(op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txnOpFromProto converts a transaction operation. The proto and storage
+// enums share their numbering.
+func txnOpFromProto(op *pb.TxnOp) storage.TxnOp {
+	return storage.TxnOp{
+		Type:  storage.TxnOpType(op.Type),
+		Key:   op.Key,
+		Value: op.Value,
+		TTL:   time.Duration(op.TtlMs) * time.Millisecond,
+	}
+}
+
+// MGet implements the MGet RPC method
+func (s *KVStoreServer) MGet(ctx context.Context, req *pb.MGetRequest) (*pb.MGetResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, "keys", len(req.Keys))
+
+	if err := s.checkKeys(req.Keys); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Keys...); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessRead, req.Keys...); err != nil {
+		return nil, err
+	}
+
+	results, err := s.store.WithContext(ctx).MGet(req.TenantId, req.Keys)
+	if err != nil {
+		return nil, storageError(err, "get keys")
+	}
+
+	resp := &pb.MGetResponse{
+		Success: true,
+		Message: fmt.Sprintf("read %d keys", len(results)),
+		Results: make([]*pb.MGetResult, len(results)),
+	}
+	for i, r := range results {
+		resp.Results[i] = &pb.MGetResult{
+			Key:     r.Key,
+			Found:   r.Found,
+			Value:   r.Value,
+			Version: r.Version,
+		}
+	}
+	return resp, nil
+}
+
+// MSet implements the MSet RPC method
+func (s *KVStoreServer) MSet(ctx context.Context, req *pb.MSetRequest) (*pb.MSetResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, "items", len(req.Items), "atomic", req.Atomic)
+
+	items := make([]storage.SetItem, len(req.Items))
+	keys := make([]string, len(req.Items))
+	for i, item := range req.Items {
+		items[i] = storage.SetItem{
+			Key:   item.Key,
+			Value: item.Value,
+			TTL:   time.Duration(item.TtlMs) * time.Millisecond,
+		}
+		keys[i] = item.Key
+		if err := s.currentLimits().checkValue(item.Value); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkKeys(keys); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, keys...); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessWrite, keys...); err != nil {
+		return nil, err
+	}
+
+	results, err := s.store.WithContext(ctx).MSet(req.TenantId, items, req.Atomic)
+	if err != nil {
+		return nil, storageError(err, "set keys")
+	}
+
+	resp := &pb.MSetResponse{
+		Success: true,
+		Results: make([]*pb.MSetResult, len(results)),
+	}
+	written := 0
+	for i, r := range results {
+		result := &pb.MSetResult{Key: r.Key, Success: r.Err == nil, Version: r.Version}
+		if r.Err != nil {
+			result.Message = fmt.Sprintf("failed to set: %v", r.Err)
+		} else {
+			written++
+		}
+		resp.Results[i] = result
+	}
+	resp.Message = fmt.Sprintf("wrote %d of %d keys", written, len(results))
+	return resp, nil
+}
+
+// MDelete implements the MDelete RPC method
+func (s *KVStoreServer) MDelete(ctx context.Context, req *pb.MDeleteRequest) (*pb.MDeleteResponse, error) {
+	logging.Add(ctx, "tenant", req.TenantId, "keys", len(req.Keys))
+
+	if err := s.checkKeys(req.Keys); err != nil {
+		return nil, err
+	}
+	if err := s.checkShard(req.TenantId, req.Keys...); err != nil {
+		return nil, err
+	}
+	if err := authorizeKeys(ctx, req.TenantId, auth.AccessWrite, req.Keys...); err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.store.WithContext(ctx).MDelete(req.TenantId, req.Keys)
+	if err != nil {
+		return nil, storageError(err, "delete keys")
+	}
+
+	resp := &pb.MDeleteResponse{
+		Success: true,
+		Results: make([]*pb.MDeleteResult, len(deleted)),
+	}
+	count := 0
+	for i, found := range deleted {
+		resp.Results[i] = &pb.MDeleteResult{Key: req.Keys[i], Found: found}
+		if found {
+			count++
+		}
+	}
+	resp.Message = fmt.Sprintf("deleted %d keys", count)
+	return resp, nil
+}
+
+// Watch implements the Watch RPC method. The stream stays open until the
+// client cancels it or the watcher falls too far behind, in which case the
+// client can resume from the revision after the last one it received.
+func (s *KVStoreServer) Watch(req *pb.WatchRequest, stream grpc.ServerStreamingServer[pb.WatchResponse]) error {
+	logging.Add(stream.Context(), "tenant", req.TenantId, logging.Key("key", req.Key), "prefix", req.Prefix, "start_revision", req.StartRevision)
+
+	authErr := authorizeKeys(stream.Context(), req.TenantId, auth.AccessRead, req.Key)
+	if req.Prefix {
+		authErr = authorizeRange(stream.Context(), req.TenantId, "", "", req.Key, auth.AccessRead)
+	}
+	if authErr != nil {
+		return authErr
+	}
+	shardErr := s.checkShard(req.TenantId, req.Key)
+	if req.Prefix {
+		shardErr = s.checkShardRange(stream.Context())
+	}
+	if shardErr != nil {
+		return shardErr
+	}
+
+	watcher, err := s.store.Watch(req.TenantId, storage.WatchOptions{
+		Key:          req.Key,
+		Prefix:       req.Prefix,
+		FromRevision: req.StartRevision,
+	})
+	if err != nil {
+		return storageError(err, "watch")
+	}
+	defer watcher.Close()
+
+	// Tell the client where the watch starts before any change arrives
+	revision := watcher.Revision()
+	if err := stream.Send(&pb.WatchResponse{Revision: revision}); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	for {
+		events, err := watcher.Next(ctx)
+		switch {
+		case errors.Is(err, storage.ErrWatcherTooSlow):
+			return statusError(codes.ResourceExhausted, reasonWatcherTooSlow, nil, err.Error())
+		case ctx.Err() != nil:
+			return status.FromContextError(ctx.Err()).Err()
+		case err != nil:
+			return storageError(err, "watch")
+		}
+
+		resp := &pb.WatchResponse{}
+		size := 0
+		for i, ev := range events {
+			resp.Events = append(resp.Events, &pb.Event{
+				Type:     pb.Event_Type(ev.Type),
+				Key:      ev.Key,
+				Value:    ev.Value,
+				Revision: ev.Revision,
+			})
+			revision = ev.Revision
+			size += len(ev.Key) + len(ev.Value)
+
+			last := i == len(events)-1
+			if !last && (size < watchBatchBytes || events[i+1].Revision == ev.Revision) {
+				continue
+			}
+			resp.Revision = revision
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			resp = &pb.WatchResponse{}
+			size = 0
+		}
+	}
+}
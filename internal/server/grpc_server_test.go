@@ -2,9 +2,14 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/ayushgala/tinkerdb/internal/storage"
 	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestKVStoreServer_Set(t *testing.T) {
@@ -27,33 +32,25 @@ func TestKVStoreServer_Set(t *testing.T) {
 	}
 
 	// Test with empty tenant ID
-	resp, err = server.Set(ctx, &pb.SetRequest{
+	_, err = server.Set(ctx, &pb.SetRequest{
 		TenantId: "",
 		Key:      "key",
 		Value:    []byte("value"),
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if resp.Success {
-		t.Fatal("Expected failure for empty tenant ID")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for empty tenant ID, got %v", err)
 	}
 
 	// Test with empty key
-	resp, err = server.Set(ctx, &pb.SetRequest{
+	_, err = server.Set(ctx, &pb.SetRequest{
 		TenantId: "tenant",
 		Key:      "",
 		Value:    []byte("value"),
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if resp.Success {
-		t.Fatal("Expected failure for empty key")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for empty key, got %v", err)
 	}
 }
 
@@ -91,31 +88,23 @@ func TestKVStoreServer_Get(t *testing.T) {
 	}
 
 	// Test get nonexistent key
-	resp, err = server.Get(ctx, &pb.GetRequest{
+	_, err = server.Get(ctx, &pb.GetRequest{
 		TenantId: tenantID,
 		Key:      "nonexistent",
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if resp.Found {
-		t.Fatal("Should not find nonexistent key")
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound for nonexistent key, got %v", err)
 	}
 
 	// Test with empty tenant ID
-	resp, err = server.Get(ctx, &pb.GetRequest{
+	_, err = server.Get(ctx, &pb.GetRequest{
 		TenantId: "",
 		Key:      key,
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if resp.Found {
-		t.Fatal("Should not find key with empty tenant ID")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for empty tenant ID, got %v", err)
 	}
 }
 
@@ -148,41 +137,33 @@ func TestKVStoreServer_Delete(t *testing.T) {
 	}
 
 	// Verify key is deleted
-	getResp, _ := server.Get(ctx, &pb.GetRequest{
+	_, err = server.Get(ctx, &pb.GetRequest{
 		TenantId: tenantID,
 		Key:      key,
 	})
 
-	if getResp.Found {
-		t.Fatal("Key should not exist after deletion")
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Key should not exist after deletion, got %v", err)
 	}
 
 	// Test delete nonexistent key
-	resp, err = server.Delete(ctx, &pb.DeleteRequest{
+	_, err = server.Delete(ctx, &pb.DeleteRequest{
 		TenantId: tenantID,
 		Key:      "nonexistent",
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if resp.Success {
-		t.Fatal("Should not successfully delete nonexistent key")
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound deleting nonexistent key, got %v", err)
 	}
 
 	// Test with empty tenant ID
-	resp, err = server.Delete(ctx, &pb.DeleteRequest{
+	_, err = server.Delete(ctx, &pb.DeleteRequest{
 		TenantId: "",
 		Key:      key,
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if resp.Success {
-		t.Fatal("Should not delete with empty tenant ID")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for empty tenant ID, got %v", err)
 	}
 }
 
@@ -229,17 +210,13 @@ func TestKVStoreServer_Exists(t *testing.T) {
 	}
 
 	// Test with empty tenant ID
-	resp, err = server.Exists(ctx, &pb.ExistsRequest{
+	_, err = server.Exists(ctx, &pb.ExistsRequest{
 		TenantId: "",
 		Key:      key,
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if resp.Exists {
-		t.Fatal("Should return false for empty tenant ID")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for empty tenant ID, got %v", err)
 	}
 }
 
@@ -286,16 +263,12 @@ func TestKVStoreServer_Keys(t *testing.T) {
 	}
 
 	// Test with empty tenant ID
-	resp, err = server.Keys(ctx, &pb.KeysRequest{
+	_, err = server.Keys(ctx, &pb.KeysRequest{
 		TenantId: "",
 	})
 
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if len(resp.Keys) != 0 {
-		t.Fatal("Should return empty slice for empty tenant ID")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for empty tenant ID, got %v", err)
 	}
 }
 
@@ -403,3 +376,271 @@ func TestKVStoreServer_BinaryData(t *testing.T) {
 		}
 	}
 }
+
+func TestKVStoreServer_TTL(t *testing.T) {
+	server := NewKVStoreServer()
+	ctx := context.Background()
+
+	// Negative TTLs are rejected
+	_, err := server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "key", Value: []byte("value"), TtlMs: -1})
+	expectCode(t, err, codes.InvalidArgument)
+
+	server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "session", Value: []byte("token"), TtlMs: 60000})
+	server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "forever", Value: []byte("value")})
+
+	ttlResp, _ := server.TTL(ctx, &pb.TTLRequest{TenantId: "tenant", Key: "session"})
+	if !ttlResp.Found || ttlResp.TtlMs <= 0 || ttlResp.TtlMs > 60000 {
+		t.Fatalf("Unexpected TTL response: %+v", ttlResp)
+	}
+
+	ttlResp, _ = server.TTL(ctx, &pb.TTLRequest{TenantId: "tenant", Key: "forever"})
+	if !ttlResp.Found || ttlResp.TtlMs != -1 {
+		t.Fatalf("Expected -1 for a key without expiry, got %+v", ttlResp)
+	}
+
+	_, err = server.TTL(ctx, &pb.TTLRequest{TenantId: "tenant", Key: "missing"})
+	expectCode(t, err, codes.NotFound)
+
+	if _, err := server.Expire(ctx, &pb.ExpireRequest{TenantId: "tenant", Key: "forever", TtlMs: 5000}); err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	ttlResp, _ = server.TTL(ctx, &pb.TTLRequest{TenantId: "tenant", Key: "forever"})
+	if ttlResp.TtlMs <= 0 || ttlResp.TtlMs > 5000 {
+		t.Fatalf("Expected ttl up to 5000ms, got %d", ttlResp.TtlMs)
+	}
+
+	_, err = server.Expire(ctx, &pb.ExpireRequest{TenantId: "tenant", Key: "missing", TtlMs: 5000})
+	expectCode(t, err, codes.NotFound)
+	_, err = server.Expire(ctx, &pb.ExpireRequest{TenantId: "tenant", Key: "forever", TtlMs: 0})
+	expectCode(t, err, codes.InvalidArgument)
+
+	if _, err := server.Persist(ctx, &pb.PersistRequest{TenantId: "tenant", Key: "forever"}); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	_, err = server.Persist(ctx, &pb.PersistRequest{TenantId: "tenant", Key: "missing"})
+	expectCode(t, err, codes.NotFound)
+	ttlResp, _ = server.TTL(ctx, &pb.TTLRequest{TenantId: "tenant", Key: "forever"})
+	if ttlResp.TtlMs != -1 {
+		t.Fatalf("Expected persisted key to have no expiry, got %d", ttlResp.TtlMs)
+	}
+
+	// A key past its TTL is gone
+	server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "brief", Value: []byte("value"), TtlMs: 1})
+	time.Sleep(5 * time.Millisecond)
+	_, err = server.Get(ctx, &pb.GetRequest{TenantId: "tenant", Key: "brief"})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestKVStoreServer_Preconditions(t *testing.T) {
+	server := NewKVStoreServer()
+	ctx := context.Background()
+
+	ifAbsent := &pb.Precondition{Condition: &pb.Precondition_IfAbsent{IfAbsent: true}}
+
+	setResp, _ := server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "key", Value: []byte("a"), Precondition: ifAbsent})
+	if !setResp.Success || setResp.Version == 0 {
+		t.Fatalf("Expected create with a version, got: %+v", setResp)
+	}
+	version := setResp.Version
+
+	_, err := server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "key", Value: []byte("b"), Precondition: ifAbsent})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	getResp, _ := server.Get(ctx, &pb.GetRequest{TenantId: "tenant", Key: "key"})
+	if getResp.Version != version || string(getResp.Value) != "a" {
+		t.Fatalf("Expected value 'a' at version %d, got: %+v", version, getResp)
+	}
+
+	ifVersion := &pb.Precondition{Condition: &pb.Precondition_IfVersionEquals{IfVersionEquals: version}}
+	setResp, _ = server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "key", Value: []byte("b"), Precondition: ifVersion})
+	if !setResp.Success || setResp.Version <= version {
+		t.Fatalf("Expected compare-and-swap to succeed, got: %+v", setResp)
+	}
+
+	// The old version is now stale for deletes too
+	_, err = server.Delete(ctx, &pb.DeleteRequest{TenantId: "tenant", Key: "key", Precondition: ifVersion})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	ifPresent := &pb.Precondition{Condition: &pb.Precondition_IfPresent{IfPresent: true}}
+	if _, err := server.Delete(ctx, &pb.DeleteRequest{TenantId: "tenant", Key: "key", Precondition: ifPresent}); err != nil {
+		t.Fatalf("Expected delete to succeed, got: %v", err)
+	}
+
+	// A plain miss is not a precondition failure
+	_, err = server.Delete(ctx, &pb.DeleteRequest{TenantId: "tenant", Key: "key"})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestKVStoreServer_Txn(t *testing.T) {
+	server := NewKVStoreServer()
+	ctx := context.Background()
+
+	server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "from", Value: []byte("10")})
+
+	req := &pb.TxnRequest{
+		TenantId: "tenant",
+		Compare: []*pb.Compare{
+			{Key: "from", Target: pb.Compare_VALUE, Result: pb.Compare_EQUAL, Value: []byte("10")},
+			{Key: "to", Target: pb.Compare_EXISTS, Result: pb.Compare_EQUAL, Exists: false},
+		},
+		Success: []*pb.TxnOp{
+			{Type: pb.TxnOp_SET, Key: "from", Value: []byte("0")},
+			{Type: pb.TxnOp_SET, Key: "to", Value: []byte("10")},
+		},
+		Failure: []*pb.TxnOp{
+			{Type: pb.TxnOp_GET, Key: "to"},
+		},
+	}
+
+	resp, err := server.Txn(ctx, req)
+	if err != nil {
+		t.Fatalf("Txn failed: %v", err)
+	}
+	if !resp.Success || !resp.Succeeded {
+		t.Fatalf("Expected the success branch to run, got: %+v", resp)
+	}
+	if len(resp.Results) != 2 || resp.Results[1].Version != resp.Revision {
+		t.Fatalf("Expected two sets at revision %d, got: %+v", resp.Revision, resp.Results)
+	}
+
+	// Running it again fails the compares and reads the moved value
+	resp, _ = server.Txn(ctx, req)
+	if !resp.Success || resp.Succeeded {
+		t.Fatalf("Expected the failure branch to run, got: %+v", resp)
+	}
+	if len(resp.Results) != 1 || string(resp.Results[0].Value) != "10" {
+		t.Fatalf("Expected to read 'to' = 10, got: %+v", resp.Results)
+	}
+
+	// Invalid transactions are rejected
+	_, err = server.Txn(ctx, &pb.TxnRequest{TenantId: "tenant", Success: []*pb.TxnOp{{Type: pb.TxnOp_SET}}})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = server.Txn(ctx, &pb.TxnRequest{})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestKVStoreServer_Scan(t *testing.T) {
+	server := NewKVStoreServer()
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: fmt.Sprintf("user:%d", i), Value: []byte("v")})
+	}
+	server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "order:1", Value: []byte("v")})
+
+	var keys []string
+	req := &pb.ScanRequest{TenantId: "tenant", Prefix: "user:", Limit: 2, Reverse: true, KeysOnly: true}
+	for {
+		resp, err := server.Scan(ctx, req)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if !resp.Success {
+			t.Fatalf("Expected success, got: %s", resp.Message)
+		}
+		for _, item := range resp.Items {
+			if item.Value != nil {
+				t.Fatalf("Expected no value for a keys-only scan on %q", item.Key)
+			}
+			keys = append(keys, item.Key)
+		}
+		if resp.Cursor == "" {
+			break
+		}
+		req.Cursor = resp.Cursor
+	}
+
+	if fmt.Sprint(keys) != "[user:4 user:3 user:2 user:1 user:0]" {
+		t.Fatalf("Unexpected scan order: %v", keys)
+	}
+
+	_, err := server.Scan(ctx, &pb.ScanRequest{})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = server.Scan(ctx, &pb.ScanRequest{TenantId: "tenant", Limit: -1})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestKVStoreServer_Batch(t *testing.T) {
+	server := NewKVStoreServer()
+	ctx := context.Background()
+
+	setResp, err := server.MSet(ctx, &pb.MSetRequest{
+		TenantId: "tenant",
+		Items: []*pb.MSetItem{
+			{Key: "a", Value: []byte("1")},
+			{Key: "", Value: []byte("invalid")},
+			{Key: "b", Value: []byte("2")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+	if !setResp.Success || len(setResp.Results) != 3 {
+		t.Fatalf("Expected 3 results, got: %s", setResp.Message)
+	}
+	if !setResp.Results[0].Success || setResp.Results[1].Success || !setResp.Results[2].Success {
+		t.Fatalf("Expected only the empty key to fail, got %v", setResp.Results)
+	}
+
+	_, err = server.MSet(ctx, &pb.MSetRequest{
+		TenantId: "tenant",
+		Items:    []*pb.MSetItem{{Key: "c", Value: []byte("3")}, {Key: ""}},
+		Atomic:   true,
+	})
+	expectCode(t, err, codes.InvalidArgument)
+
+	getResp, err := server.MGet(ctx, &pb.MGetRequest{TenantId: "tenant", Keys: []string{"b", "c", "a"}})
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if len(getResp.Results) != 3 || string(getResp.Results[0].Value) != "2" || getResp.Results[1].Found || !getResp.Results[2].Found {
+		t.Fatalf("Unexpected MGet results: %v", getResp.Results)
+	}
+
+	delResp, err := server.MDelete(ctx, &pb.MDeleteRequest{TenantId: "tenant", Keys: []string{"a", "c"}})
+	if err != nil {
+		t.Fatalf("MDelete failed: %v", err)
+	}
+	if !delResp.Results[0].Found || delResp.Results[1].Found {
+		t.Fatalf("Unexpected MDelete results: %v", delResp.Results)
+	}
+
+	_, err = server.MGet(ctx, &pb.MGetRequest{})
+	expectCode(t, err, codes.InvalidArgument)
+
+	// Batches over the key limit exhaust a resource rather than being malformed
+	_, err = server.MDelete(ctx, &pb.MDeleteRequest{TenantId: "tenant", Keys: make([]string, storage.MaxBatchKeys+1)})
+	expectCode(t, err, codes.ResourceExhausted)
+}
+
+func TestKVStoreServer_Limits(t *testing.T) {
+	server := NewKVStoreServer()
+	ctx := context.Background()
+	server.SetLimits(Limits{MaxKeySize: 8, MaxValueSize: 16, MaxBatchKeys: 2, MaxScanLimit: 3})
+
+	_, err := server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "a-long-key", Value: []byte("v")})
+	expectCode(t, err, codes.ResourceExhausted)
+	if info := errorInfo(t, err); info.Reason != reasonLimitExceeded || info.Metadata["limit"] != "max_key_size" {
+		t.Fatalf("Unexpected error info: %v", info)
+	}
+	_, err = server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "key", Value: make([]byte, 17)})
+	expectCode(t, err, codes.ResourceExhausted)
+	_, err = server.MGet(ctx, &pb.MGetRequest{TenantId: "tenant", Keys: []string{"a", "b", "c"}})
+	expectCode(t, err, codes.ResourceExhausted)
+	_, err = server.Txn(ctx, &pb.TxnRequest{TenantId: "tenant", Success: []*pb.TxnOp{{Key: "key", Value: make([]byte, 17)}}})
+	expectCode(t, err, codes.ResourceExhausted)
+
+	for i := 0; i < 5; i++ {
+		server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: fmt.Sprintf("key:%d", i), Value: []byte("v")})
+	}
+	resp, err := server.Scan(ctx, &pb.ScanRequest{TenantId: "tenant", Limit: 100})
+	if err != nil || len(resp.Items) != 3 || resp.Cursor == "" {
+		t.Fatalf("Expected a page lowered to 3 keys, got %v %v", resp, err)
+	}
+
+	// New limits apply to the next calls
+	server.SetLimits(Limits{})
+	if _, err := server.Set(ctx, &pb.SetRequest{TenantId: "tenant", Key: "a-long-key", Value: make([]byte, 17)}); err != nil {
+		t.Fatalf("Expected no limits after clearing them, got %v", err)
+	}
+}
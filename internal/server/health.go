@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Health reports whether the server's services are serving, over the
+// grpc.health.v1 service and over HTTP. Every service starts out
+// NOT_SERVING; the empty service name stands for the whole server.
+type Health struct {
+	*health.Server
+	services []string
+}
+
+// NewHealth creates the health service of the named gRPC services
+func NewHealth(services ...string) *Health {
+	h := &Health{Server: health.NewServer(), services: services}
+	h.SetReady(false)
+	return h
+}
+
+// SetReady marks every service SERVING or NOT_SERVING
+func (h *Health) SetReady(ready bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	h.SetServingStatus("", status)
+	for _, service := range h.services {
+		h.SetServingStatus(service, status)
+	}
+}
+
+// LiveHandler serves /healthz, which succeeds for as long as the process
+// answers
+func (h *Health) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadyHandler serves /readyz, which mirrors the gRPC status of the service
+// named by the service query parameter, the whole server by default. Only
+// SERVING succeeds; an unknown service is not found.
+func (h *Health) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: r.URL.Query().Get("service")})
+		if err != nil {
+			http.Error(w, "unknown service", http.StatusNotFound)
+			return
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			http.Error(w, resp.Status.String(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, resp.Status)
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// readyz returns the status code of /readyz?service=service
+func readyz(h *Health, service string) int {
+	rec := httptest.NewRecorder()
+	h.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz?service="+service, nil))
+	return rec.Code
+}
+
+func checkStatus(t *testing.T, h *Health, service string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check of %q failed: %v", service, err)
+	}
+	if resp.Status != want {
+		t.Fatalf("Expected %q to be %s, got %s", service, want, resp.Status)
+	}
+}
+
+func TestHealth_Readiness(t *testing.T) {
+	h := NewHealth("kvstore.KVStore", "kvstore.Admin")
+
+	// Not ready until recovery completes
+	checkStatus(t, h, "", healthpb.HealthCheckResponse_NOT_SERVING)
+	checkStatus(t, h, "kvstore.KVStore", healthpb.HealthCheckResponse_NOT_SERVING)
+	if code := readyz(h, ""); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected /readyz to fail before recovery, got %d", code)
+	}
+
+	h.SetReady(true)
+	checkStatus(t, h, "", healthpb.HealthCheckResponse_SERVING)
+	checkStatus(t, h, "kvstore.Admin", healthpb.HealthCheckResponse_SERVING)
+	if code := readyz(h, "kvstore.KVStore"); code != http.StatusOK {
+		t.Fatalf("Expected /readyz to succeed once ready, got %d", code)
+	}
+	if code := readyz(h, "kvstore.CDC"); code != http.StatusNotFound {
+		t.Fatalf("Expected an unregistered service to be unknown, got %d", code)
+	}
+
+	// Draining stays NOT_SERVING
+	h.Shutdown()
+	h.SetReady(true)
+	checkStatus(t, h, "kvstore.KVStore", healthpb.HealthCheckResponse_NOT_SERVING)
+	if code := readyz(h, ""); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected /readyz to fail while draining, got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.LiveHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected /healthz to succeed while draining, got %d", rec.Code)
+	}
+}
+
+func TestAuthenticator_HealthIsPublic(t *testing.T) {
+	a, _, _ := newTestAuthenticator(t, AuthOptions{Required: true})
+
+	if _, err := callUnary(a, "/grpc.health.v1.Health/Check", "", &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Expected health checks without credentials to be served, got %v", err)
+	}
+	_, err := callUnary(a, "/kvstore.KVStore/Get", "", nil)
+	expectCode(t, err, codes.Unauthenticated)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"google.golang.org/grpc/codes"
+)
+
+// Limits bound the requests the KVStore service accepts. A zero field sets
+// no limit beyond the store's own.
+type Limits struct {
+	MaxKeySize   int // Bytes of a key
+	MaxValueSize int // Bytes of a value
+	MaxBatchKeys int // Keys of one MGet, MSet or MDelete
+	MaxScanLimit int // Keys of one Scan page; larger requested limits are lowered to it
+}
+
+// SetLimits replaces the limits of the service, taking effect for the calls
+// that start afterwards
+func (s *KVStoreServer) SetLimits(limits Limits) {
+	s.limits.Store(&limits)
+}
+
+// currentLimits returns the limits in effect
+func (s *KVStoreServer) currentLimits() Limits {
+	if l := s.limits.Load(); l != nil {
+		return *l
+	}
+	return Limits{}
+}
+
+func (l Limits) checkKey(key string) error {
+	if l.MaxKeySize > 0 && len(key) > l.MaxKeySize {
+		return limitExceeded("max_key_size", l.MaxKeySize, fmt.Sprintf("key of %d bytes exceeds %d bytes", len(key), l.MaxKeySize))
+	}
+	return nil
+}
+
+func (l Limits) checkValue(value []byte) error {
+	if l.MaxValueSize > 0 && len(value) > l.MaxValueSize {
+		return limitExceeded("max_value_size", l.MaxValueSize, fmt.Sprintf("value of %d bytes exceeds %d bytes", len(value), l.MaxValueSize))
+	}
+	return nil
+}
+
+func (l Limits) checkBatch(keys int) error {
+	if l.MaxBatchKeys > 0 && keys > l.MaxBatchKeys {
+		return limitExceeded("max_batch_keys", l.MaxBatchKeys, fmt.Sprintf("batch of %d keys exceeds %d keys", keys, l.MaxBatchKeys))
+	}
+	return nil
+}
+
+// scanLimit returns the page size of a scan requesting limit keys
+func (l Limits) scanLimit(limit int) int {
+	// Negative limits are left for the store to reject
+	if l.MaxScanLimit == 0 || limit < 0 {
+		return limit
+	}
+	if limit == 0 {
+		limit = storage.DefaultScanLimit
+	}
+	return min(limit, l.MaxScanLimit)
+}
+
+// limitExceeded rejects a request over one of the configured limits
+func limitExceeded(limit string, value int, msg string) error {
+	return statusError(codes.ResourceExhausted, reasonLimitExceeded, map[string]string{"limit": limit, "value": strconv.Itoa(value)}, msg)
+}
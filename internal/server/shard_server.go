@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+// ShardServer implements the gRPC Shard service of a sharded deployment
+type ShardServer struct {
+	pb.UnimplementedShardServer
+	shards *shard.Map
+	id     string
+}
+
+// NewShardServer creates the Shard service publishing m from a server of
+// the shard group id
+func NewShardServer(m *shard.Map, id string) *ShardServer {
+	return &ShardServer{shards: m, id: id}
+}
+
+// GetShardMap implements the GetShardMap RPC method
+func (s *ShardServer) GetShardMap(ctx context.Context, req *pb.GetShardMapRequest) (*pb.GetShardMapResponse, error) {
+	return &pb.GetShardMapResponse{
+		Map:     s.shards.ToProto(),
+		ShardId: s.id,
+	}, nil
+}
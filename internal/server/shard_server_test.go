@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// shardKeys returns a key of tenant t1 owned by each of the groups
+func shardKeys(m *shard.Map) map[string]string {
+	keys := make(map[string]string)
+	for i := 0; len(keys) < len(m.Groups()); i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if g := m.Locate("t1", key); keys[g.ID] == "" {
+			keys[g.ID] = key
+		}
+	}
+	return keys
+}
+
+func TestKVStoreServer_Shards(t *testing.T) {
+	m, err := shard.New([]shard.Group{
+		{ID: "s1", Addresses: []string{"127.0.0.1:50011"}},
+		{ID: "s2", Addresses: []string{"127.0.0.1:50021", "127.0.0.1:50022"}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	server := NewKVStoreServer()
+	server.SetShards(m, "s1")
+	ctx := context.Background()
+	keys := shardKeys(m)
+	local, remote := keys["s1"], keys["s2"]
+
+	if _, err := server.Set(ctx, &pb.SetRequest{TenantId: "t1", Key: local, Value: []byte("v")}); err != nil {
+		t.Fatalf("Set of a local key failed: %v", err)
+	}
+
+	_, err = server.Get(ctx, &pb.GetRequest{TenantId: "t1", Key: remote})
+	expectCode(t, err, codes.OutOfRange)
+	info := errorInfo(t, err)
+	if info.Reason != reasonWrongShard || info.Metadata["shard"] != "s2" ||
+		info.Metadata["addresses"] != "127.0.0.1:50021,127.0.0.1:50022" ||
+		info.Metadata["map_version"] != strconv.FormatUint(m.Version(), 10) {
+		t.Fatalf("Expected %s naming s2, got %+v", reasonWrongShard, info)
+	}
+
+	// Batches and transactions must stay within one shard
+	_, err = server.MGet(ctx, &pb.MGetRequest{TenantId: "t1", Keys: []string{local, remote}})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = server.MDelete(ctx, &pb.MDeleteRequest{TenantId: "t1", Keys: []string{remote}})
+	expectCode(t, err, codes.OutOfRange)
+	_, err = server.Txn(ctx, &pb.TxnRequest{
+		TenantId: "t1",
+		Compare:  []*pb.Compare{{Key: local, Target: pb.Compare_VERSION, Version: 1}},
+		Success:  []*pb.TxnOp{{Type: pb.TxnOp_SET, Key: remote, Value: []byte("v")}},
+	})
+	expectCode(t, err, codes.InvalidArgument)
+
+	// Range calls are served only when made on every group
+	_, err = server.Scan(ctx, &pb.ScanRequest{TenantId: "t1"})
+	expectCode(t, err, codes.FailedPrecondition)
+	if info := errorInfo(t, err); info.Reason != reasonShardedRange {
+		t.Fatalf("Expected %s, got %+v", reasonShardedRange, info)
+	}
+	rangeCtx := metadata.NewIncomingContext(ctx, metadata.Pairs(shard.RangeHeader, shard.RangeValue("s1", m.Version())))
+	scan, err := server.Scan(rangeCtx, &pb.ScanRequest{TenantId: "t1"})
+	if err != nil || len(scan.Items) != 1 || scan.Items[0].Key != local {
+		t.Fatalf("Expected the scan of s1 to return %s, got %v, %v", local, scan, err)
+	}
+	if _, err := server.Keys(rangeCtx, &pb.KeysRequest{TenantId: "t1"}); err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	_, err = server.Keys(metadata.NewIncomingContext(ctx, metadata.Pairs(shard.RangeHeader, shard.RangeValue("s2", m.Version()))), &pb.KeysRequest{TenantId: "t1"})
+	expectCode(t, err, codes.OutOfRange)
+	if info := errorInfo(t, err); info.Reason != reasonWrongShard || info.Metadata["shard"] != "s2" {
+		t.Fatalf("Expected %s naming s2, got %+v", reasonWrongShard, info)
+	}
+	_, err = server.Keys(metadata.NewIncomingContext(ctx, metadata.Pairs(shard.RangeHeader, shard.RangeValue("s1", m.Version()+1))), &pb.KeysRequest{TenantId: "t1"})
+	expectCode(t, err, codes.OutOfRange)
+
+	shardServer := NewShardServer(m, "s1")
+	resp, err := shardServer.GetShardMap(ctx, &pb.GetShardMapRequest{})
+	if err != nil {
+		t.Fatalf("GetShardMap failed: %v", err)
+	}
+	if resp.ShardId != "s1" || resp.Map.Version != m.Version() || len(resp.Map.Groups) != 2 {
+		t.Fatalf("Unexpected shard map: %+v", resp)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	"github.com/ayushgala/tinkerdb/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// SetShards makes the server the shard group id of m, serving only the keys
+// the map places on it. Calls with a key of another group fail with
+// WRONG_SHARD, naming the group to retry at. Scans, key listings and prefix
+// watches, which see the keys of this group only, fail with SHARDED_RANGE
+// unless the client makes them on every group and merges the results.
+// Tenant deletions delete the keys of this group only. It must be called
+// before the server is started.
+func (s *KVStoreServer) SetShards(m *shard.Map, id string) {
+	s.shards = m
+	s.shardID = id
+}
+
+// checkShard fails unless keys of tenantID all belong to this server's
+// shard group
+func (s *KVStoreServer) checkShard(tenantID string, keys ...string) error {
+	if s.shards == nil || len(keys) == 0 {
+		return nil
+	}
+	owner := s.shards.Locate(tenantID, keys[0])
+	for _, key := range keys[1:] {
+		if g := s.shards.Locate(tenantID, key); g.ID != owner.ID {
+			return invalidArgument(fmt.Sprintf("keys of one call must belong to one shard, %q is in %s and %q in %s",
+				keys[0], owner.ID, key, g.ID))
+		}
+	}
+	if owner.ID == s.shardID {
+		return nil
+	}
+	return s.wrongShard(owner, fmt.Sprintf("key belongs to shard %s", owner.ID))
+}
+
+// checkShardRange fails a range call unless its RangeHeader metadata names
+// this server's shard group at the version of its map, the client then
+// makes the call on every group of the same map
+func (s *KVStoreServer) checkShardRange(ctx context.Context) error {
+	if s.shards == nil {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(shard.RangeHeader)
+	if len(values) == 0 {
+		return statusError(codes.FailedPrecondition, reasonShardedRange, map[string]string{
+			"map_version": strconv.FormatUint(s.shards.Version(), 10),
+		}, fmt.Sprintf("server is shard %s of %d, range calls must be made on every shard", s.shardID, len(s.shards.Groups())))
+	}
+	id, version, ok := shard.ParseRangeValue(values[0])
+	if !ok {
+		return invalidArgument(fmt.Sprintf("malformed %s metadata %q", shard.RangeHeader, values[0]))
+	}
+	if id == s.shardID && version == s.shards.Version() {
+		return nil
+	}
+	g, _ := s.shards.Group(id)
+	return s.wrongShard(g, fmt.Sprintf("range call for shard %s of map version %d reached shard %s of map version %d",
+		id, version, s.shardID, s.shards.Version()))
+}
+
+// wrongShard rejects a call that belongs to group g, the zero Group if the
+// server's map does not have it
+func (s *KVStoreServer) wrongShard(g shard.Group, msg string) error {
+	return statusError(codes.OutOfRange, reasonWrongShard, map[string]string{
+		"shard":       g.ID,
+		"addresses":   strings.Join(g.Addresses, ","),
+		"map_version": strconv.FormatUint(s.shards.Version(), 10),
+	}, msg)
+}
+
+// txnKeys returns every key txn compares or operates on
+func txnKeys(txn storage.Txn) []string {
+	var keys []string
+	for _, cmp := range txn.Compares {
+		keys = append(keys, cmp.Key)
+	}
+	for _, op := range slices.Concat(txn.Success, txn.Failure) {
+		keys = append(keys, op.Key)
+	}
+	return keys
+}
@@ -0,0 +1,233 @@
+// Package shard partitions the key space across shard groups with
+// consistent hashing. Every group owns a number of virtual nodes, points on
+// a 64-bit hash ring, and a key belongs to the group owning the first point
+// at or after the hash of its tenant and key. Adding a group to N others
+// only moves the keys falling just before its points, about 1/(N+1) of them.
+//
+// Servers and clients place keys with the same Map, so the hashing is part
+// of the wire contract: FNV-1a of the tenant, a zero byte and the key,
+// finished with the MurmurHash3 mix, and the same for "<group ID>#<i>" for
+// the i-th virtual node of a group.
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+// DefaultVirtualNodes is the number of points each group has on the ring,
+// enough for the groups to own shares of the keys within a few percent
+const DefaultVirtualNodes = 128
+
+// RangeHeader is the gRPC metadata key of range calls, which clients make
+// on every group: its value, built by RangeValue, names the group the call
+// is for and the version of the client's map
+const RangeHeader = "tinkerdb-shard"
+
+// ErrInvalidMap is matched by the errors returned for an invalid shard map
+var ErrInvalidMap = errors.New("invalid shard map")
+
+// Group is a shard group: a standalone server or the nodes of a cluster
+// holding the keys of one part of the ring
+type Group struct {
+	ID        string
+	Addresses []string // Nodes of the group, any of which serves or redirects its calls
+}
+
+// Map places keys on the shard groups. It is immutable.
+type Map struct {
+	groups       []Group // By ID
+	virtualNodes int
+	version      uint64
+	ring         []point // By hash
+}
+
+// point is a virtual node of a group
+type point struct {
+	hash  uint64
+	group int // Index in Map.groups
+}
+
+// New builds the map of groups, each with virtualNodes points on the ring,
+// 0 for DefaultVirtualNodes
+func New(groups []Group, virtualNodes int) (*Map, error) {
+	if virtualNodes == 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	if virtualNodes < 0 {
+		return nil, fmt.Errorf("%w: virtual nodes cannot be negative", ErrInvalidMap)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("%w: no shard groups", ErrInvalidMap)
+	}
+
+	m := &Map{groups: make([]Group, len(groups)), virtualNodes: virtualNodes}
+	for i, g := range groups {
+		if g.ID == "" || strings.ContainsAny(g.ID, "=|,") {
+			return nil, fmt.Errorf("%w: shard group ID %q must be non-empty and without '=', '|' or ','", ErrInvalidMap, g.ID)
+		}
+		if len(g.Addresses) == 0 || slices.Contains(g.Addresses, "") {
+			return nil, fmt.Errorf("%w: shard group %q needs at least one address", ErrInvalidMap, g.ID)
+		}
+		m.groups[i] = Group{ID: g.ID, Addresses: slices.Clone(g.Addresses)}
+	}
+	slices.SortFunc(m.groups, func(a, b Group) int { return strings.Compare(a.ID, b.ID) })
+	for i := 1; i < len(m.groups); i++ {
+		if m.groups[i].ID == m.groups[i-1].ID {
+			return nil, fmt.Errorf("%w: shard group %q is listed twice", ErrInvalidMap, m.groups[i].ID)
+		}
+	}
+
+	m.ring = make([]point, 0, len(m.groups)*virtualNodes)
+	for i, g := range m.groups {
+		for v := range virtualNodes {
+			m.ring = append(m.ring, point{hash: hash(g.ID + "#" + strconv.Itoa(v)), group: i})
+		}
+	}
+	// Ties go to the group first by ID, so every map places keys alike
+	slices.SortFunc(m.ring, func(a, b point) int {
+		if a.hash != b.hash {
+			if a.hash < b.hash {
+				return -1
+			}
+			return 1
+		}
+		return a.group - b.group
+	})
+
+	m.version = m.computeVersion()
+	return m, nil
+}
+
+// computeVersion hashes everything that places keys, so that maps built
+// from the same groups have the same version
+func (m *Map) computeVersion() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\n", m.virtualNodes)
+	for _, g := range m.groups {
+		fmt.Fprintf(h, "%s=%s\n", g.ID, strings.Join(g.Addresses, "|"))
+	}
+	return mix(h.Sum64())
+}
+
+// Version identifies the map: two maps with the same version place every
+// key alike and list the same addresses
+func (m *Map) Version() uint64 {
+	return m.version
+}
+
+// VirtualNodes returns the number of points each group has on the ring
+func (m *Map) VirtualNodes() int {
+	return m.virtualNodes
+}
+
+// Groups returns the groups by ID
+func (m *Map) Groups() []Group {
+	groups := make([]Group, len(m.groups))
+	for i, g := range m.groups {
+		groups[i] = Group{ID: g.ID, Addresses: slices.Clone(g.Addresses)}
+	}
+	return groups
+}
+
+// Group returns the group id
+func (m *Map) Group(id string) (Group, bool) {
+	i, ok := slices.BinarySearchFunc(m.groups, id, func(g Group, id string) int { return strings.Compare(g.ID, id) })
+	if !ok {
+		return Group{}, false
+	}
+	return m.groups[i], true
+}
+
+// Locate returns the group owning key of tenantID
+func (m *Map) Locate(tenantID, key string) Group {
+	h := hash(tenantID + "\x00" + key)
+	i, _ := slices.BinarySearchFunc(m.ring, h, func(p point, h uint64) int {
+		if p.hash < h {
+			return -1
+		}
+		if p.hash > h {
+			return 1
+		}
+		return 0
+	})
+	if i == len(m.ring) {
+		// Past the last point the ring wraps around to the first
+		i = 0
+	}
+	return m.groups[m.ring[i].group]
+}
+
+// ToProto converts the map for the wire
+func (m *Map) ToProto() *pb.ShardMap {
+	p := &pb.ShardMap{
+		Version:      m.version,
+		VirtualNodes: uint32(m.virtualNodes),
+		Groups:       make([]*pb.ShardGroup, len(m.groups)),
+	}
+	for i, g := range m.groups {
+		p.Groups[i] = &pb.ShardGroup{Id: g.ID, Addresses: slices.Clone(g.Addresses)}
+	}
+	return p
+}
+
+// FromProto builds the map a server sent. It fails if the map does not have
+// the version the server computed, as it would place keys differently.
+func FromProto(p *pb.ShardMap) (*Map, error) {
+	groups := make([]Group, len(p.GetGroups()))
+	for i, g := range p.GetGroups() {
+		groups[i] = Group{ID: g.Id, Addresses: g.Addresses}
+	}
+	m, err := New(groups, int(p.GetVirtualNodes()))
+	if err != nil {
+		return nil, err
+	}
+	if m.version != p.GetVersion() {
+		return nil, fmt.Errorf("%w: version %d does not match its groups, %d", ErrInvalidMap, p.GetVersion(), m.version)
+	}
+	return m, nil
+}
+
+// RangeValue returns the RangeHeader value of a range call made on group id
+// by the map at version
+func RangeValue(id string, version uint64) string {
+	return id + "@" + strconv.FormatUint(version, 10)
+}
+
+// ParseRangeValue returns the group and the map version of a RangeHeader
+// value, false if it is malformed
+func ParseRangeValue(value string) (string, uint64, bool) {
+	i := strings.LastIndexByte(value, '@')
+	if i < 0 {
+		return "", 0, false
+	}
+	version, err := strconv.ParseUint(value[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return value[:i], version, true
+}
+
+// hash places s on the ring
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return mix(h.Sum64())
+}
+
+// mix is the finalizer of MurmurHash3, which spreads the FNV hashes of
+// similar strings, such as the virtual nodes of a group, over the ring
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,140 @@
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testGroups(n int) []Group {
+	groups := make([]Group, n)
+	for i := range groups {
+		groups[i] = Group{ID: fmt.Sprintf("s%d", i+1), Addresses: []string{fmt.Sprintf("127.0.0.1:%d", 50061+i)}}
+	}
+	return groups
+}
+
+func TestMap_Balance(t *testing.T) {
+	m, err := New(testGroups(4), 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if m.VirtualNodes() != DefaultVirtualNodes {
+		t.Fatalf("Expected %d virtual nodes by default, got %d", DefaultVirtualNodes, m.VirtualNodes())
+	}
+
+	const keys = 40000
+	counts := make(map[string]int)
+	for i := range keys {
+		counts[m.Locate("t1", fmt.Sprintf("key-%d", i)).ID]++
+	}
+	for _, g := range m.Groups() {
+		share := float64(counts[g.ID]) / keys
+		if share < 0.18 || share > 0.32 {
+			t.Fatalf("Expected %s to own about a quarter of the keys, got %.3f", g.ID, share)
+		}
+	}
+}
+
+func TestMap_AddGroupMovesFewKeys(t *testing.T) {
+	before, _ := New(testGroups(3), 0)
+	after, _ := New(testGroups(4), 0)
+
+	const keys = 20000
+	moved := 0
+	for i := range keys {
+		key := fmt.Sprintf("key-%d", i)
+		from, to := before.Locate("t1", key), after.Locate("t1", key)
+		if from.ID != to.ID {
+			if to.ID != "s4" {
+				t.Fatalf("Expected %s to move to the new group only, it moved from %s to %s", key, from.ID, to.ID)
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / keys; share < 0.15 || share > 0.35 {
+		t.Fatalf("Expected about a quarter of the keys to move, got %.3f", share)
+	}
+}
+
+func TestMap_Placement(t *testing.T) {
+	groups := testGroups(3)
+	m, _ := New(groups, 64)
+	reversed, _ := New([]Group{groups[2], groups[1], groups[0]}, 64)
+	if m.Version() != reversed.Version() {
+		t.Fatal("Expected the order of the groups not to change the version")
+	}
+
+	// The tenant is part of the hash
+	spread := make(map[string]bool)
+	for i := range 20 {
+		g := m.Locate(fmt.Sprintf("tenant-%d", i), "key")
+		if g.ID != reversed.Locate(fmt.Sprintf("tenant-%d", i), "key").ID {
+			t.Fatal("Expected maps of the same groups to place keys alike")
+		}
+		spread[g.ID] = true
+	}
+	if len(spread) < 2 {
+		t.Fatal("Expected the same key of different tenants to spread over the groups")
+	}
+
+	moved, _ := New([]Group{groups[0], groups[1], {ID: "s3", Addresses: []string{"127.0.0.1:1"}}}, 64)
+	if moved.Version() == m.Version() {
+		t.Fatal("Expected a changed address to change the version")
+	}
+	if g, ok := m.Group("s2"); !ok || g.Addresses[0] != "127.0.0.1:50062" {
+		t.Fatalf("Expected s2 at 127.0.0.1:50062, got %+v", g)
+	}
+	if _, ok := m.Group("s9"); ok {
+		t.Fatal("Expected no group s9")
+	}
+}
+
+func TestMap_Proto(t *testing.T) {
+	m, _ := New(testGroups(2), 16)
+	decoded, err := FromProto(m.ToProto())
+	if err != nil {
+		t.Fatalf("FromProto failed: %v", err)
+	}
+	if decoded.Version() != m.Version() || decoded.Locate("t1", "k").ID != m.Locate("t1", "k").ID {
+		t.Fatal("Expected the decoded map to match")
+	}
+
+	p := m.ToProto()
+	p.Version++
+	if _, err := FromProto(p); !errors.Is(err, ErrInvalidMap) {
+		t.Fatalf("Expected a map with the wrong version to be rejected, got %v", err)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []Group
+		vnodes int
+	}{
+		{"no groups", nil, 0},
+		{"empty ID", []Group{{Addresses: []string{"a:1"}}}, 0},
+		{"separator in ID", []Group{{ID: "s|1", Addresses: []string{"a:1"}}}, 0},
+		{"no address", []Group{{ID: "s1"}}, 0},
+		{"duplicate", []Group{{ID: "s1", Addresses: []string{"a:1"}}, {ID: "s1", Addresses: []string{"b:1"}}}, 0},
+		{"negative virtual nodes", testGroups(1), -1},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.groups, tt.vnodes); !errors.Is(err, ErrInvalidMap) {
+			t.Fatalf("%s: expected ErrInvalidMap, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestRangeValue(t *testing.T) {
+	id, version, ok := ParseRangeValue(RangeValue("s@1", 42))
+	if !ok || id != "s@1" || version != 42 {
+		t.Fatalf("Expected s@1 at 42, got %q at %d (%v)", id, version, ok)
+	}
+	for _, value := range []string{"", "s1", "s1@", "s1@x"} {
+		if _, _, ok := ParseRangeValue(value); ok {
+			t.Fatalf("Expected %q to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxBatchKeys bounds the keys of a single MGet, MSet or MDelete, keeping
+// the time the tenant lock is held predictable
+const MaxBatchKeys = 1000
+
+// GetResult is the outcome of reading one key of an MGet
+type GetResult struct {
+	Key     string
+	Value   []byte
+	Version uint64
+	Found   bool
+}
+
+// SetItem is one key of an MSet
+type SetItem struct {
+	Key   string
+	Value []byte
+	TTL   time.Duration // Expire the key after this long, 0 keeps it until deleted
+}
+
+// SetResult is the outcome of writing one key of an MSet
+type SetResult struct {
+	Key     string
+	Version uint64 // Version the key was written at, 0 if it was not written
+	Err     error  // Why the key was not written
+}
+
+// MGet reads several keys of a tenant under a single acquisition of the
+// tenant lock, so the results are consistent with each other. Results are
+// returned in the order of keys.
+func (s *Store) MGet(tenantID string, keys []string) ([]GetResult, error) {
+	s, span := s.startOp("MGet", tenantID)
+	defer span.End()
+
+	if tenantID == "" {
+		return nil, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if len(keys) > MaxBatchKeys {
+		return nil, errorf(ErrLimitExceeded, "batch exceeds %d keys", MaxBatchKeys)
+	}
+
+	results := make([]GetResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+	}
+
+	s.mu.RLock()
+	tenantStore, exists := s.tenants[tenantID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return results, nil
+	}
+
+	var expired []string
+	now := timeNow().UnixNano()
+	tenantStore.rlock(s.context())
+	for i, key := range keys {
+		e, exists := tenantStore.readLocked(key)
+		if !exists {
+			continue
+		}
+		if e.expired(now) {
+			expired = append(expired, key)
+			continue
+		}
+		results[i].Value = append([]byte{}, e.value...)
+		results[i].Version = e.version
+		results[i].Found = true
+	}
+	tenantStore.mu.RUnlock()
+
+	if len(expired) > 0 {
+		tenantStore.reclaim(expired)
+	}
+	return results, nil
+}
+
+// MSet writes several keys of a tenant under a single acquisition of the
+// tenant lock. Results are returned in the order of items.
+//
+// Without atomic, each key is logged and applied on its own: an invalid key
+// fails alone, and the keys written get successive versions. With atomic,
+// the whole batch is rejected if any item is invalid, and otherwise it is
+// logged as a single WAL record so every key shares one version and the
+// batch recovers together or not at all.
+func (s *Store) MSet(tenantID string, items []SetItem, atomic bool) ([]SetResult, error) {
+	s, span := s.startOp("MSet", tenantID)
+	defer span.End()
+
+	if tenantID == "" {
+		return nil, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if len(items) > MaxBatchKeys {
+		return nil, errorf(ErrLimitExceeded, "batch exceeds %d keys", MaxBatchKeys)
+	}
+
+	results := make([]SetResult, len(items))
+	valid := 0
+	for i, item := range items {
+		results[i].Key = item.Key
+		results[i].Err = item.validate()
+		if results[i].Err == nil {
+			valid++
+		} else if atomic {
+			return nil, fmt.Errorf("invalid item %d: %w", i, results[i].Err)
+		}
+	}
+	if valid == 0 {
+		return results, nil
+	}
+
+	if err := s.waitReady(); err != nil {
+		return nil, err
+	}
+	s.rlockCommit()
+	defer s.commitMu.RUnlock()
+
+	tenantStore, err := s.getTenantStore(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	tenantStore.lock(s.context())
+	defer tenantStore.mu.Unlock()
+
+	if atomic {
+		ops := make([]walRecord, len(items))
+		for i, item := range items {
+			ops[i] = walRecord{op: opSet, key: item.Key, value: item.Value, expireAt: expireAtFor(item.TTL)}
+		}
+		revision, err := s.commitLocked(tenantStore, &walRecord{op: opBatch, tenant: tenantID, ops: ops}, "batch", func(revision uint64) error {
+			return tenantStore.applyBatchLocked(ops, revision)
+		})
+		if revision == 0 {
+			return nil, err
+		}
+		for i := range results {
+			results[i].Version = revision
+		}
+		return results, err
+	}
+
+	for i, item := range items {
+		if results[i].Err != nil {
+			continue
+		}
+		rec := &walRecord{op: opSet, tenant: tenantID, key: item.Key, value: item.Value, expireAt: expireAtFor(item.TTL)}
+		revision, err := s.commitLocked(tenantStore, rec, "set", func(revision uint64) error {
+			return tenantStore.setLocked(item.Key, entry{value: item.Value, expireAt: rec.expireAt, version: revision})
+		})
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Version = revision
+	}
+	return results, nil
+}
+
+// validate checks a single item of an MSet
+func (item SetItem) validate() error {
+	if item.Key == "" {
+		return errorf(ErrInvalidArgument, "key cannot be empty")
+	}
+	if item.TTL < 0 {
+		return errorf(ErrInvalidArgument, "ttl cannot be negative")
+	}
+	return nil
+}
+
+// MDelete removes several keys of a tenant under a single acquisition of the
+// tenant lock and reports, in the order of keys, which of them existed. The
+// deletions are logged as a single WAL record.
+func (s *Store) MDelete(tenantID string, keys []string) ([]bool, error) {
+	s, span := s.startOp("MDelete", tenantID)
+	defer span.End()
+
+	if tenantID == "" {
+		return nil, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if len(keys) > MaxBatchKeys {
+		return nil, errorf(ErrLimitExceeded, "batch exceeds %d keys", MaxBatchKeys)
+	}
+
+	deleted := make([]bool, len(keys))
+
+	if err := s.waitReady(); err != nil {
+		return nil, err
+	}
+	s.rlockCommit()
+	defer s.commitMu.RUnlock()
+
+	s.mu.RLock()
+	tenantStore, exists := s.tenants[tenantID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return deleted, nil
+	}
+
+	tenantStore.lock(s.context())
+	defer tenantStore.mu.Unlock()
+
+	// A key repeated in the batch is only deleted, and reported, once
+	var ops []walRecord
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, exists := tenantStore.getLocked(key); exists {
+			deleted[i] = true
+			ops = append(ops, walRecord{op: opDelete, key: key})
+		}
+	}
+	if len(ops) == 0 {
+		return deleted, nil
+	}
+
+	revision, err := s.commitLocked(tenantStore, &walRecord{op: opBatch, tenant: tenantID, ops: ops}, "batch", func(revision uint64) error {
+		return tenantStore.applyBatchLocked(ops, revision)
+	})
+	if revision == 0 {
+		return nil, err
+	}
+	return deleted, err
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStore_MGet(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+	store.Set("tenant", "a", []byte("1"))
+	store.Set("tenant", "b", []byte("2"))
+	store.SetWithTTL("tenant", "expiring", []byte("x"), time.Second)
+	clock.advance(2 * time.Second)
+
+	results, err := store.MGet("tenant", []string{"b", "missing", "a", "expiring"})
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	if !results[0].Found || string(results[0].Value) != "2" || results[0].Key != "b" {
+		t.Fatalf("Expected b = 2, got %+v", results[0])
+	}
+	if results[1].Found || results[3].Found {
+		t.Fatalf("Expected missing and expired keys to be reported as not found, got %+v", results)
+	}
+	if _, version, _ := store.GetWithVersion("tenant", "a"); results[2].Version != version {
+		t.Fatalf("Expected a at version %d, got %d", version, results[2].Version)
+	}
+
+	results, err = store.MGet("unknown", []string{"a"})
+	if err != nil || len(results) != 1 || results[0].Found {
+		t.Fatalf("Expected a not found result for an unknown tenant, got %+v, %v", results, err)
+	}
+
+	if _, err := store.MGet("tenant", make([]string, MaxBatchKeys+1)); err == nil {
+		t.Fatal("Expected an oversized batch to be rejected")
+	}
+}
+
+func TestStore_MSet(t *testing.T) {
+	store := NewStore()
+
+	results, err := store.MSet("tenant", []SetItem{
+		{Key: "a", Value: []byte("1")},
+		{Key: "", Value: []byte("invalid")},
+		{Key: "b", Value: []byte("2"), TTL: time.Hour},
+	}, false)
+	if err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+	if results[0].Err != nil || results[2].Err != nil || results[1].Err == nil {
+		t.Fatalf("Expected only the empty key to fail, got %+v", results)
+	}
+	if results[0].Version == 0 || results[2].Version <= results[0].Version {
+		t.Fatalf("Expected successive versions for a non-atomic batch, got %+v", results)
+	}
+	if value, _ := store.Get("tenant", "b"); string(value) != "2" {
+		t.Fatalf("Expected b = 2, got %q", value)
+	}
+
+	// An atomic batch with an invalid item writes nothing
+	if _, err := store.MSet("tenant", []SetItem{{Key: "c", Value: []byte("3")}, {Key: "d", TTL: -1}}, true); err == nil {
+		t.Fatal("Expected the atomic batch to be rejected")
+	}
+	if store.Exists("tenant", "c") {
+		t.Fatal("Expected no key of a rejected atomic batch to be written")
+	}
+
+	results, err = store.MSet("tenant", []SetItem{{Key: "c", Value: []byte("3")}, {Key: "d", Value: []byte("4")}}, true)
+	if err != nil {
+		t.Fatalf("Atomic MSet failed: %v", err)
+	}
+	if results[0].Version != results[1].Version || results[0].Version != store.Revision() {
+		t.Fatalf("Expected every key of an atomic batch at revision %d, got %+v", store.Revision(), results)
+	}
+}
+
+func TestStore_MDelete(t *testing.T) {
+	store := NewStore()
+	store.Set("tenant", "a", []byte("1"))
+	store.Set("tenant", "b", []byte("2"))
+
+	deleted, err := store.MDelete("tenant", []string{"a", "missing", "b", "a"})
+	if err != nil {
+		t.Fatalf("MDelete failed: %v", err)
+	}
+	if !deleted[0] || deleted[1] || !deleted[2] || deleted[3] {
+		t.Fatalf("Expected a and b to be deleted once, got %v", deleted)
+	}
+	if store.Exists("tenant", "a") || store.Exists("tenant", "b") {
+		t.Fatal("Expected a and b to be gone")
+	}
+
+	revision := store.Revision()
+	if deleted, _ := store.MDelete("tenant", []string{"a"}); deleted[0] || store.Revision() != revision {
+		t.Fatal("Expected deleting only missing keys to change nothing")
+	}
+}
+
+func TestStore_BatchRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	items := make([]SetItem, 50)
+	for i := range items {
+		items[i] = SetItem{Key: fmt.Sprintf("key-%02d", i), Value: []byte("v")}
+	}
+	store.MSet("tenant", items, true)
+	store.MSet("tenant", items[:10], false)
+	store.MDelete("tenant", []string{"key-20", "key-21"})
+	store.Close()
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	results, _ := store.MGet("tenant", keys)
+	for i, result := range results {
+		if result.Found == (i == 20 || i == 21) {
+			t.Fatalf("Unexpected state for %s after recovery: %+v", result.Key, result)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ChangeOp identifies the mutation a Change records
+type ChangeOp int
+
+const (
+	ChangePut          ChangeOp = iota
+	ChangeDelete                // The key was removed
+	ChangeExpire                // Only the key's expiry changed
+	ChangeDeleteTenant          // The whole tenant was removed, Key is empty
+)
+
+// String returns the name used for the operation in change records
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "delete"
+	case ChangeExpire:
+		return "expire"
+	case ChangeDeleteTenant:
+		return "delete_tenant"
+	}
+	return fmt.Sprintf("ChangeOp(%d)", int(op))
+}
+
+// Change is a committed mutation as published for change data capture. Keys
+// removed because their TTL passed are not changes: expiry is decided by the
+// ExpireAt recorded with the put or expire that set it.
+type Change struct {
+	Revision uint64
+	Tenant   string
+	Key      string
+	Op       ChangeOp
+
+	OldValue []byte // Value before the change, nil if the key did not exist
+	NewValue []byte // Value written by a put
+	ExpireAt int64  // Unix nanoseconds the key expires at after a put or expire, 0 for never
+
+	// OldValueUnknown marks a change read back from the WAL, which does not
+	// keep the values that mutations replaced
+	OldValueUnknown bool
+}
+
+// ChangeHandler receives committed changes in revision order. It is called
+// while the store's change feed is locked, so it must return quickly, for
+// instance by queueing the changes.
+type ChangeHandler func(changes []Change)
+
+// changeFeed puts the changes of concurrent commits in revision order.
+// Revisions are assigned when a mutation is logged, but tenants commit in
+// parallel, so the changes of a revision can arrive after later ones.
+type changeFeed struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64][]Change
+	handler ChangeHandler
+}
+
+// SetChangeHandler installs handler to receive every change committed from
+// now on, replacing any previous handler, and returns the revision of the
+// first change it will receive. A nil handler stops the feed. Old values are
+// only read while a handler is installed.
+func (s *Store) SetChangeHandler(handler ChangeHandler) uint64 {
+	// With commitMu held exclusively no commit is in flight, so the feed
+	// starts exactly after the current revision
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	next := s.revisionLocked() + 1
+	if handler == nil {
+		s.feed = nil
+		return next
+	}
+	s.feed = &changeFeed{
+		next:    next,
+		pending: make(map[uint64][]Change),
+		handler: handler,
+	}
+	return next
+}
+
+// deliver hands the changes of revision, and of any later revisions that
+// were waiting for it, to the handler. Every logged revision must be
+// delivered exactly once, even if it made no change.
+func (f *changeFeed) deliver(revision uint64, changes []Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pending[revision] = changes
+	var ready []Change
+	for {
+		next, ok := f.pending[f.next]
+		if !ok {
+			break
+		}
+		delete(f.pending, f.next)
+		ready = append(ready, next...)
+		f.next++
+	}
+	if len(ready) > 0 {
+		f.handler(ready)
+	}
+}
+
+// skip drops the changes before revision next, which will never be delivered
+func (f *changeFeed) skip(next uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for revision := range f.pending {
+		if revision < next {
+			delete(f.pending, revision)
+		}
+	}
+	f.next = max(f.next, next)
+}
+
+// ReplayChanges reads the changes of revisions from to to back from the WAL
+// and calls fn for each, in order. Old values are not logged, so every
+// change has OldValueUnknown set. It returns an error wrapping ErrCompacted
+// if the WAL no longer holds revision from.
+func (s *Store) ReplayChanges(from, to uint64, fn func(Change) error) error {
+	if from > to {
+		return nil
+	}
+
+	s.commitMu.RLock()
+	defer s.commitMu.RUnlock()
+
+	if s.wal == nil || s.wal.FirstIndex() > from {
+		return fmt.Errorf("%w: changes from revision %d are not retained", ErrCompacted, from)
+	}
+	if last := s.wal.LastIndex(); to > last {
+		to = last
+	}
+
+	err := s.wal.Replay(from, func(index uint64, data []byte) error {
+		// A replicated log skips the indexes that carry no mutation
+		if index > to {
+			return errStopReplay
+		}
+		rec, err := decodeWALRecord(data)
+		if err != nil {
+			return fmt.Errorf("wal record %d: %w", index, err)
+		}
+		for _, change := range rec.changes(nil) {
+			change.Revision = index
+			if err := fn(change); err != nil {
+				return err
+			}
+		}
+		// Stop before reaching records that may still be being written
+		if index >= to {
+			return errStopReplay
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopReplay) {
+		return err
+	}
+	return nil
+}
+
+// RetainedRevision returns the oldest revision whose changes ReplayChanges
+// can still read, 0 if it cannot read any
+func (s *Store) RetainedRevision() uint64 {
+	s.commitMu.RLock()
+	defer s.commitMu.RUnlock()
+
+	if s.wal == nil {
+		return 0
+	}
+	return s.wal.FirstIndex()
+}
+
+// changes describes the changes the record makes. old returns the current
+// value of a key, nil if it does not exist; without it old values are
+// reported as unknown. Revisions are left for the caller to fill in. System
+// tenants make no changes.
+func (r *walRecord) changes(old func(key string) []byte) []Change {
+	if IsSystemTenant(r.tenant) {
+		return nil
+	}
+
+	switch r.op {
+	case opDeleteTenant:
+		return []Change{{Tenant: r.tenant, Op: ChangeDeleteTenant}}
+	case opBatch:
+		// Later operations of a batch see the values of earlier ones
+		written := make(map[string][]byte) // nil for keys the batch deleted
+		current := func(key string) []byte {
+			if value, ok := written[key]; ok {
+				return value
+			}
+			return old(key)
+		}
+		if old == nil {
+			current = nil
+		}
+
+		var changes []Change
+		for i := range r.ops {
+			op := r.ops[i]
+			op.tenant = r.tenant
+			changes = append(changes, op.changes(current)...)
+			written[op.key] = nil
+			if op.op == opSet {
+				written[op.key] = append([]byte{}, op.value...)
+			}
+		}
+		return changes
+	}
+
+	change := Change{Tenant: r.tenant, Key: r.key, ExpireAt: r.expireAt}
+	switch r.op {
+	case opSet:
+		change.Op = ChangePut
+		change.NewValue = append([]byte{}, r.value...)
+	case opDelete:
+		change.Op = ChangeDelete
+	case opExpire:
+		change.Op = ChangeExpire
+	default:
+		return nil
+	}
+	if old == nil {
+		change.OldValueUnknown = true
+	} else if change.Op != ChangeExpire {
+		change.OldValue = old(r.key)
+	}
+	return []Change{change}
+}
+
+// oldValueLocked returns a copy of the live value of key, nil if it does not
+// exist, caller must hold ts.mu
+func (ts *TenantStore) oldValueLocked(key string) []byte {
+	e, exists := ts.getLocked(key)
+	if !exists {
+		return nil
+	}
+	return append([]byte{}, e.value...)
+}
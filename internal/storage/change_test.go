@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collectChanges installs a change handler recording everything it receives
+func collectChanges(store *Store) (func() []Change, uint64) {
+	var mu sync.Mutex
+	var changes []Change
+	first := store.SetChangeHandler(func(batch []Change) {
+		mu.Lock()
+		changes = append(changes, batch...)
+		mu.Unlock()
+	})
+	return func() []Change {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Change(nil), changes...)
+	}, first
+}
+
+func TestStore_ChangeFeedOldAndNewValues(t *testing.T) {
+	store := NewStore()
+	store.Set("tenant", "key", []byte("v1"))
+
+	changes, first := collectChanges(store)
+	if first != store.Revision()+1 {
+		t.Fatalf("Expected the feed to start at %d, got %d", store.Revision()+1, first)
+	}
+
+	store.Set("tenant", "key", []byte("v2"))
+	store.Expire("tenant", "key", time.Hour)
+	store.Txn("tenant", Txn{Success: []TxnOp{
+		{Type: TxnSet, Key: "key", Value: []byte("v3")},
+		{Type: TxnSet, Key: "key", Value: []byte("v4")},
+	}})
+	store.Delete("tenant", "key")
+	store.Delete("tenant", "missing")
+	store.DeleteTenant("tenant")
+
+	got := changes()
+	want := []struct {
+		op       ChangeOp
+		old, new string
+	}{
+		{ChangePut, "v1", "v2"},
+		{ChangeExpire, "", ""},
+		{ChangePut, "v2", "v3"},
+		{ChangePut, "v3", "v4"},
+		{ChangeDelete, "v4", ""},
+		{ChangeDeleteTenant, "", ""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d changes, got %+v", len(want), got)
+	}
+	for i, w := range want {
+		c := got[i]
+		if c.Op != w.op || string(c.OldValue) != w.old || string(c.NewValue) != w.new || c.Tenant != "tenant" {
+			t.Fatalf("Change %d: expected %v %q -> %q, got %+v", i, w.op, w.old, w.new, c)
+		}
+		if i > 0 && c.Revision < got[i-1].Revision {
+			t.Fatalf("Expected changes in revision order, got %+v", got)
+		}
+	}
+	if got[2].Revision != got[3].Revision {
+		t.Fatalf("Expected the transaction's changes to share a revision, got %+v", got[2:4])
+	}
+	if got[1].ExpireAt == 0 {
+		t.Fatalf("Expected the expire change to carry its expiry")
+	}
+
+	store.SetChangeHandler(nil)
+	store.Set("tenant", "key", []byte("after"))
+	if len(changes()) != len(want) {
+		t.Fatalf("Expected no changes after the handler was removed")
+	}
+}
+
+func TestStore_ChangeFeedConcurrentOrder(t *testing.T) {
+	store := NewStore()
+	changes, first := collectChanges(store)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.Set(fmt.Sprintf("tenant-%d", i), fmt.Sprintf("key-%d", j), []byte("v"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got := changes()
+	if len(got) != 800 {
+		t.Fatalf("Expected 800 changes, got %d", len(got))
+	}
+	for i, c := range got {
+		if c.Revision != first+uint64(i) {
+			t.Fatalf("Expected revision %d at position %d, got %d", first+uint64(i), i, c.Revision)
+		}
+	}
+}
+
+func TestStore_ReplayChanges(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer store.Close()
+
+	store.Set("tenant", "a", []byte("1"))
+	store.MSet("tenant", []SetItem{{Key: "b", Value: []byte("2")}, {Key: "c", Value: []byte("3")}}, true)
+	store.Delete("tenant", "a")
+
+	var got []Change
+	err := store.ReplayChanges(1, store.Revision(), func(c Change) error {
+		got = append(got, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayChanges failed: %v", err)
+	}
+	if len(got) != 4 || got[1].Revision != got[2].Revision || got[3].Op != ChangeDelete {
+		t.Fatalf("Expected a put, a batch of two and a delete, got %+v", got)
+	}
+	for _, c := range got {
+		if !c.OldValueUnknown {
+			t.Fatalf("Expected replayed changes to have unknown old values, got %+v", c)
+		}
+	}
+
+	got = nil
+	store.ReplayChanges(2, 2, func(c Change) error {
+		got = append(got, c)
+		return nil
+	})
+	if len(got) != 2 {
+		t.Fatalf("Expected only revision 2, got %+v", got)
+	}
+
+	if err := NewStore().ReplayChanges(1, 1, func(Change) error { return nil }); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("Expected ErrCompacted without a WAL, got %v", err)
+	}
+}
+
+func TestStore_ChangeFeedSkipsSystemTenants(t *testing.T) {
+	store := NewStore()
+	changes, _ := collectChanges(store)
+
+	store.Set(SystemTenantPrefix+"auth", "token/1", []byte("secret"))
+	store.Set("tenant", "key", []byte("value"))
+
+	got := changes()
+	if len(got) != 1 || got[0].Tenant != "tenant" {
+		t.Fatalf("Expected only the change to tenant, got %+v", got)
+	}
+	if got[0].Revision != store.Revision() {
+		t.Fatalf("Expected revision %d, got %d", store.Revision(), got[0].Revision)
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/bitcask"
+	"github.com/ayushgala/tinkerdb/internal/engine/btree"
+	"github.com/ayushgala/tinkerdb/internal/engine/lsm"
+	"github.com/ayushgala/tinkerdb/internal/engine/memory"
+)
+
+// Names of the storage engines a Store can be configured with
+const (
+	EngineMemory  = "memory"  // Hash map held in RAM, made durable by the WAL and snapshots
+	EngineBitcask = "bitcask" // Log-structured data file with an in-memory key directory
+	EngineBTree   = "btree"   // Append-only copy-on-write B+tree file
+	EngineLSM     = "lsm"     // Log-structured merge tree of memtable and SSTables
+)
+
+// Engines lists the supported engine names
+var Engines = []string{EngineMemory, EngineBitcask, EngineBTree, EngineLSM}
+
+// ValidateEngine checks that name is a supported engine
+func ValidateEngine(name string) error {
+	for _, known := range Engines {
+		if name == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown storage engine %q (want one of %v)", name, Engines)
+}
+
+// engineName returns the configured engine, defaulting to memory
+func (s *Store) engineName() string {
+	if s.opts.Engine == "" {
+		return EngineMemory
+	}
+	return s.opts.Engine
+}
+
+// enginePersistent reports whether the configured engine keeps data on disk
+func (s *Store) enginePersistent() bool {
+	return s.engineName() != EngineMemory
+}
+
+// engineDir is where a tenant's on-disk engine lives. Tenant IDs are hex
+// encoded so any string is a safe directory name.
+func (s *Store) engineDir(tenantID string) string {
+	return filepath.Join(s.opts.DataDir, "engines", s.engineName(), hex.EncodeToString([]byte(tenantID)))
+}
+
+// openEngine opens the engine holding a tenant's data
+func (s *Store) openEngine(tenantID string) (engine.Engine, error) {
+	switch s.engineName() {
+	case EngineMemory:
+		return memory.New(), nil
+	case EngineBitcask:
+		return bitcask.Open(s.engineDir(tenantID))
+	case EngineBTree:
+		return btree.Open(s.engineDir(tenantID))
+	case EngineLSM:
+		return lsm.Open(s.engineDir(tenantID), lsm.DefaultOptions())
+	default:
+		return nil, ValidateEngine(s.engineName())
+	}
+}
+
+// destroyEngine removes a tenant's on-disk data
+func (s *Store) destroyEngine(tenantID string) error {
+	if !s.enginePersistent() {
+		return nil
+	}
+	if err := os.RemoveAll(s.engineDir(tenantID)); err != nil {
+		return fmt.Errorf("failed to remove engine data for tenant %q: %w", tenantID, err)
+	}
+	return nil
+}
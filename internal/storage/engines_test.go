@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func openEngineStore(t *testing.T, dir, engineName string) *Store {
+	t.Helper()
+
+	opts := DefaultOptions(dir)
+	opts.Engine = engineName
+	opts.SnapshotInterval = 0
+	opts.WAL.SegmentSize = 256
+
+	store, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open with %s engine failed: %v", engineName, err)
+	}
+	return store
+}
+
+func TestStore_PersistentEngines(t *testing.T) {
+	for _, engineName := range []string{EngineBitcask, EngineBTree, EngineLSM} {
+		t.Run(engineName, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openEngineStore(t, dir, engineName)
+
+			for i := 0; i < 30; i++ {
+				store.Set("tenant1", fmt.Sprintf("key-%02d", i), []byte(fmt.Sprintf("value-%d", i)))
+			}
+			store.Set("tenant2", "key", []byte("value"))
+
+			info, err := store.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+			if info.Keys != 31 || info.Tenants != 2 {
+				t.Fatalf("Unexpected snapshot info: %+v", info)
+			}
+
+			// Changes after the snapshot come back from the engine and the wal
+			store.Set("tenant1", "key-00", []byte("after-snapshot"))
+			store.Delete("tenant1", "key-01")
+			store.DeleteTenant("tenant2")
+			store.Close()
+
+			store = openEngineStore(t, dir, engineName)
+			defer store.Close()
+
+			value, _ := store.Get("tenant1", "key-00")
+			if string(value) != "after-snapshot" {
+				t.Fatalf("Expected 'after-snapshot', got %q", value)
+			}
+			if store.Exists("tenant1", "key-01") {
+				t.Fatal("key-01 should stay deleted")
+			}
+			value, _ = store.Get("tenant1", "key-29")
+			if string(value) != "value-29" {
+				t.Fatalf("Expected 'value-29', got %q", value)
+			}
+			if store.TenantCount() != 1 {
+				t.Fatalf("Expected 1 tenant, got %d", store.TenantCount())
+			}
+
+			keys := store.Keys("tenant1")
+			if len(keys) != 29 || keys[0] != "key-00" || keys[1] != "key-02" {
+				t.Fatalf("Unexpected keys: %v", keys)
+			}
+
+			if _, err := os.Stat(store.engineDir("tenant2")); !os.IsNotExist(err) {
+				t.Fatalf("Expected tenant2 engine files to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_SwitchEngineFromInlineSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	store := openEngineStore(t, dir, EngineMemory)
+	store.Set("tenant", "key", []byte("value"))
+	store.Snapshot()
+	store.Set("tenant", "later", []byte("value"))
+	store.Close()
+
+	// Inline snapshot data is loaded into the new engine
+	store = openEngineStore(t, dir, EngineBitcask)
+	for _, key := range []string{"key", "later"} {
+		if !store.Exists("tenant", key) {
+			t.Fatalf("Expected %s to be migrated into bitcask", key)
+		}
+	}
+	store.Snapshot()
+	store.Close()
+
+	// Data that lives in engine files cannot be opened with another engine
+	opts := DefaultOptions(dir)
+	opts.Engine = EngineBTree
+	if _, err := Open(opts); err == nil {
+		t.Fatal("Expected opening a bitcask snapshot with the btree engine to fail")
+	}
+}
+
+func TestValidateEngine(t *testing.T) {
+	for _, name := range Engines {
+		if err := ValidateEngine(name); err != nil {
+			t.Fatalf("ValidateEngine(%q) failed: %v", name, err)
+		}
+	}
+	if err := ValidateEngine("rocksdb"); err == nil {
+		t.Fatal("Expected error for unknown engine")
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Values are stored in the engine wrapped with their metadata:
+//
+//	flags (1B) | [expire at, unix nanoseconds (8B LE)] | [version (uvarint)] | value
+//
+// The expiry is only present when flagExpires is set and the version only
+// when flagVersion is set. Entries written before versions existed read as
+// version 0.
+const (
+	flagExpires byte = 1 << iota
+	flagVersion
+
+	knownFlags = flagExpires | flagVersion
+)
+
+// timeNow is the clock used for expiry, replaced in tests
+var timeNow = time.Now
+
+// entry is a decoded engine value
+type entry struct {
+	value    []byte
+	expireAt int64  // Unix nanoseconds, 0 if the key never expires
+	version  uint64 // Store revision of the last write to the key
+}
+
+// expired reports whether the entry's TTL has run out at now
+func (e entry) expired(now int64) bool {
+	return e.expireAt != 0 && e.expireAt <= now
+}
+
+// encodeEntry builds the engine representation of an entry
+func encodeEntry(e entry) []byte {
+	var flags byte
+	size := 1 + len(e.value)
+	if e.expireAt != 0 {
+		flags |= flagExpires
+		size += 8
+	}
+	if e.version != 0 {
+		flags |= flagVersion
+		size += binary.MaxVarintLen64
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, flags)
+	if e.expireAt != 0 {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.expireAt))
+	}
+	if e.version != 0 {
+		buf = binary.AppendUvarint(buf, e.version)
+	}
+	return append(buf, e.value...)
+}
+
+// decodeEntry parses an engine value. The returned value aliases data.
+func decodeEntry(data []byte) (entry, error) {
+	var e entry
+	if len(data) == 0 {
+		return e, fmt.Errorf("stored entry is empty")
+	}
+
+	flags := data[0]
+	data = data[1:]
+	if flags&^knownFlags != 0 {
+		return e, fmt.Errorf("stored entry has unknown flags %#x", flags)
+	}
+	if flags&flagExpires != 0 {
+		if len(data) < 8 {
+			return e, fmt.Errorf("stored entry is truncated")
+		}
+		e.expireAt = int64(binary.LittleEndian.Uint64(data))
+		data = data[8:]
+	}
+	if flags&flagVersion != 0 {
+		version, n := binary.Uvarint(data)
+		if n <= 0 {
+			return e, fmt.Errorf("stored entry is truncated")
+		}
+		e.version = version
+		data = data[n:]
+	}
+	e.value = data
+	return e, nil
+}
+
+// expireAtFor converts a TTL into an absolute expiry, 0 means no expiry
+func expireAtFor(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return timeNow().Add(ttl).UnixNano()
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidArgument is matched by the errors returned for malformed
+	// requests, such as an empty tenant ID or key. Check for it with
+	// errors.Is.
+	ErrInvalidArgument = errors.New("invalid argument")
+
+	// ErrLimitExceeded is matched by the errors returned for requests over
+	// a size limit, such as a batch of more than MaxBatchKeys keys
+	ErrLimitExceeded = errors.New("limit exceeded")
+)
+
+// kindError is an error with its own message that matches a sentinel error
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// errorf formats an error that matches kind without repeating its text
+func errorf(kind error, format string, args ...any) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// Errorf is errorf for packages that build on the store and report their
+// errors the same way
+func Errorf(kind error, format string, args ...any) error {
+	return errorf(kind, format, args...)
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"time"
+)
+
+const (
+	// sweepBatch is how many keys the sweeper examines per lock acquisition
+	sweepBatch = 128
+
+	// sweepBudget bounds the keys examined per tenant on each sweep, the
+	// sweeper resumes from where it stopped on the next tick
+	sweepBudget = 32 * sweepBatch
+)
+
+// Expire sets a key to expire after ttl. It reports whether the key exists.
+func (s *Store) Expire(tenantID, key string, ttl time.Duration) (bool, error) {
+	s, span := s.startOp("Expire", tenantID)
+	defer span.End()
+
+	if ttl <= 0 {
+		return false, errorf(ErrInvalidArgument, "ttl must be positive")
+	}
+	return s.setExpiry(tenantID, key, expireAtFor(ttl))
+}
+
+// Persist removes the expiry from a key. It reports whether the key exists.
+func (s *Store) Persist(tenantID, key string) (bool, error) {
+	s, span := s.startOp("Persist", tenantID)
+	defer span.End()
+
+	return s.setExpiry(tenantID, key, 0)
+}
+
+// setExpiry logs and applies a new expiry for an existing key
+func (s *Store) setExpiry(tenantID, key string, expireAt int64) (bool, error) {
+	if tenantID == "" {
+		return false, nil
+	}
+
+	if err := s.waitReady(); err != nil {
+		return false, err
+	}
+	s.rlockCommit()
+	defer s.commitMu.RUnlock()
+
+	s.mu.RLock()
+	tenantStore, exists := s.tenants[tenantID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return false, nil
+	}
+
+	tenantStore.lock(s.context())
+	defer tenantStore.mu.Unlock()
+
+	e, exists := tenantStore.getLocked(key)
+	if !exists {
+		return false, nil
+	}
+	if e.expireAt == expireAt {
+		return true, nil
+	}
+
+	// The value is unchanged, so the key keeps its version
+	revision, err := s.commitLocked(tenantStore, &walRecord{op: opExpire, tenant: tenantID, key: key, expireAt: expireAt}, "expire", func(uint64) error {
+		e.expireAt = expireAt
+		return tenantStore.setLocked(key, e)
+	})
+	return revision != 0, err
+}
+
+// TTL returns the time left before a key expires, or 0 if it never expires.
+// The second result reports whether the key exists.
+func (s *Store) TTL(tenantID, key string) (time.Duration, bool) {
+	s, span := s.startOp("TTL", tenantID)
+	defer span.End()
+
+	if tenantID == "" {
+		return 0, false
+	}
+
+	s.mu.RLock()
+	tenantStore, exists := s.tenants[tenantID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return 0, false
+	}
+
+	e, exists := tenantStore.lookup(s.context(), key)
+	if !exists || e.expireAt == 0 {
+		return 0, exists
+	}
+	return time.Duration(e.expireAt - timeNow().UnixNano()), true
+}
+
+// expireLocked replays an expiry change, caller must hold ts.mu. The change
+// was logged while the key was live, so it applies even if the key's old
+// expiry has passed by the time the record is replayed.
+func (ts *TenantStore) expireLocked(key string, expireAt int64) (bool, error) {
+	e, exists := ts.readLocked(key)
+	if !exists {
+		return false, nil
+	}
+	e.expireAt = expireAt
+	return true, ts.setLocked(key, e)
+}
+
+// sweepLoop reclaims expired keys every interval until stop is closed
+func (s *Store) sweepLoop(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweep examines a bounded slice of every tenant's keys and reclaims the
+// expired ones. It returns the number of keys reclaimed.
+func (s *Store) sweep() int {
+	s.mu.RLock()
+	tenantIDs := make([]string, 0, len(s.tenants))
+	for tenantID := range s.tenants {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	s.mu.RUnlock()
+
+	reclaimed := 0
+	for _, tenantID := range tenantIDs {
+		for examined := 0; examined < sweepBudget; {
+			n, removed, wrapped := s.sweepBatch(tenantID)
+			examined += n
+			reclaimed += removed
+			if wrapped {
+				break
+			}
+		}
+	}
+	return reclaimed
+}
+
+// sweepBatch examines up to sweepBatch keys of a tenant from its sweep
+// cursor. Keys are collected under the read lock and removed under a short
+// write lock, so readers and writers only ever wait for one batch.
+func (s *Store) sweepBatch(tenantID string) (examined, reclaimed int, wrapped bool) {
+	// Keep DeleteTenant and Close from releasing the engine mid-batch
+	s.commitMu.RLock()
+	defer s.commitMu.RUnlock()
+
+	s.mu.RLock()
+	ts, exists := s.tenants[tenantID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return 0, 0, true
+	}
+
+	var expired []string
+	now := timeNow().UnixNano()
+	next := ""
+
+	ts.mu.RLock()
+	err := ts.engine.Iterate(ts.sweepCursor, "", func(key string, data []byte) bool {
+		if examined == sweepBatch {
+			next = key
+			return false
+		}
+		examined++
+		if e, err := decodeEntry(data); err == nil && e.expired(now) {
+			expired = append(expired, key)
+		}
+		return true
+	})
+	ts.mu.RUnlock()
+
+	if err != nil {
+		return examined, 0, true
+	}
+
+	// Only the sweeper touches the cursor
+	ts.sweepCursor = next
+	if len(expired) > 0 {
+		reclaimed = ts.reclaim(expired)
+	}
+	return examined, reclaimed, next == ""
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock replaces timeNow for the duration of a test
+type fakeClock struct {
+	now time.Time
+}
+
+func useFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	timeNow = func() time.Time { return clock.now }
+	t.Cleanup(func() { timeNow = time.Now })
+	return clock
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestStore_SetWithTTL(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+
+	store.SetWithTTL("tenant", "session", []byte("token"), 10*time.Second)
+	store.Set("tenant", "forever", []byte("value"))
+
+	ttl, exists := store.TTL("tenant", "session")
+	if !exists || ttl != 10*time.Second {
+		t.Fatalf("Expected ttl 10s, got (%v, %v)", ttl, exists)
+	}
+	if ttl, exists := store.TTL("tenant", "forever"); !exists || ttl != 0 {
+		t.Fatalf("Expected no expiry, got (%v, %v)", ttl, exists)
+	}
+
+	clock.advance(10 * time.Second)
+
+	if _, found := store.Get("tenant", "session"); found {
+		t.Fatal("Expired key should not be returned by Get")
+	}
+	if store.Exists("tenant", "session") {
+		t.Fatal("Expired key should not exist")
+	}
+	if keys := store.Keys("tenant"); len(keys) != 1 || keys[0] != "forever" {
+		t.Fatalf("Expected only 'forever', got %v", keys)
+	}
+	if deleted, _ := store.Delete("tenant", "session"); deleted {
+		t.Fatal("Deleting an expired key should report it missing")
+	}
+}
+
+func TestStore_SetClearsTTL(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+
+	store.SetWithTTL("tenant", "key", []byte("v1"), time.Second)
+	store.Set("tenant", "key", []byte("v2"))
+	clock.advance(time.Hour)
+
+	value, found := store.Get("tenant", "key")
+	if !found || string(value) != "v2" {
+		t.Fatalf("Expected plain Set to drop the ttl, got (%q, %v)", value, found)
+	}
+}
+
+func TestStore_ExpireAndPersist(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+
+	if exists, _ := store.Expire("tenant", "missing", time.Second); exists {
+		t.Fatal("Expire on a missing key should report false")
+	}
+	if _, err := store.Expire("tenant", "key", 0); err == nil {
+		t.Fatal("Expected error for non-positive ttl")
+	}
+
+	store.Set("tenant", "key", []byte("value"))
+	if exists, err := store.Expire("tenant", "key", time.Minute); !exists || err != nil {
+		t.Fatalf("Expire = (%v, %v)", exists, err)
+	}
+	clock.advance(30 * time.Second)
+	if ttl, _ := store.TTL("tenant", "key"); ttl != 30*time.Second {
+		t.Fatalf("Expected 30s left, got %v", ttl)
+	}
+
+	if exists, err := store.Persist("tenant", "key"); !exists || err != nil {
+		t.Fatalf("Persist = (%v, %v)", exists, err)
+	}
+	clock.advance(time.Hour)
+	value, found := store.Get("tenant", "key")
+	if !found || string(value) != "value" {
+		t.Fatalf("Persisted key should survive, got (%q, %v)", value, found)
+	}
+}
+
+func TestStore_LazyExpiryReclaims(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+
+	store.SetWithTTL("tenant", "key", []byte("value"), time.Second)
+	clock.advance(2 * time.Second)
+
+	ts := store.tenants["tenant"]
+	if ts.Size() != 1 {
+		t.Fatalf("Expected the expired key to linger until accessed, size %d", ts.Size())
+	}
+	store.Get("tenant", "key")
+	if ts.Size() != 0 {
+		t.Fatalf("Expected Get to reclaim the expired key, size %d", ts.Size())
+	}
+}
+
+func TestStore_SweepReclaimsExpiredKeys(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+
+	for i := 0; i < 3*sweepBatch; i++ {
+		ttl := time.Duration(0)
+		if i%2 == 0 {
+			ttl = time.Second
+		}
+		store.SetWithTTL("tenant", fmt.Sprintf("key-%04d", i), []byte("value"), ttl)
+	}
+	clock.advance(time.Minute)
+
+	if reclaimed := store.sweep(); reclaimed != 3*sweepBatch/2 {
+		t.Fatalf("Expected %d keys reclaimed, got %d", 3*sweepBatch/2, reclaimed)
+	}
+	if size := store.tenants["tenant"].Size(); size != 3*sweepBatch/2 {
+		t.Fatalf("Expected %d keys left, got %d", 3*sweepBatch/2, size)
+	}
+	if store.tenants["tenant"].sweepCursor != "" {
+		t.Fatal("Expected the sweep to wrap around")
+	}
+}
+
+func TestStore_TTLSurvivesRecovery(t *testing.T) {
+	clock := useFakeClock(t)
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	store.SetWithTTL("tenant", "short", []byte("value"), 10*time.Second)
+	store.Snapshot()
+	store.SetWithTTL("tenant", "long", []byte("value"), time.Hour)
+	store.Expire("tenant", "short", time.Hour)
+	store.Close()
+
+	// The key's original expiry passes before the Expire record is replayed
+	clock.advance(time.Minute)
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+
+	for _, key := range []string{"short", "long"} {
+		ttl, exists := store.TTL("tenant", key)
+		if !exists || ttl <= 0 || ttl > time.Hour {
+			t.Fatalf("Expected %s to keep a ttl under an hour, got (%v, %v)", key, ttl, exists)
+		}
+	}
+
+	clock.advance(2 * time.Hour)
+	if store.Exists("tenant", "long") || store.Exists("tenant", "short") {
+		t.Fatal("Expected keys to expire after recovery")
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPreconditionFailed is returned when a conditional write finds the key
+// in a different state than the caller expected
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// PreconditionKind selects the check a Precondition performs
+type PreconditionKind int
+
+const (
+	PreconditionNone    PreconditionKind = iota // Write unconditionally
+	PreconditionVersion                         // Key must exist at exactly Version
+	PreconditionAbsent                          // Key must not exist
+	PreconditionPresent                         // Key must exist
+)
+
+// Precondition guards a write for optimistic concurrency control. Keys
+// past their TTL count as absent.
+type Precondition struct {
+	Kind    PreconditionKind
+	Version uint64
+}
+
+// IfVersion requires the key to exist at exactly version
+func IfVersion(version uint64) Precondition {
+	return Precondition{Kind: PreconditionVersion, Version: version}
+}
+
+// IfAbsent requires the key not to exist
+func IfAbsent() Precondition {
+	return Precondition{Kind: PreconditionAbsent}
+}
+
+// IfPresent requires the key to exist
+func IfPresent() Precondition {
+	return Precondition{Kind: PreconditionPresent}
+}
+
+// check tests the precondition against the key's current live entry
+func (p Precondition) check(current entry, exists bool) error {
+	switch p.Kind {
+	case PreconditionNone:
+		return nil
+	case PreconditionVersion:
+		if !exists {
+			return fmt.Errorf("%w: key does not exist, expected version %d", ErrPreconditionFailed, p.Version)
+		}
+		if current.version != p.Version {
+			return fmt.Errorf("%w: key is at version %d, expected %d", ErrPreconditionFailed, current.version, p.Version)
+		}
+		return nil
+	case PreconditionAbsent:
+		if exists {
+			return fmt.Errorf("%w: key already exists", ErrPreconditionFailed)
+		}
+		return nil
+	case PreconditionPresent:
+		if !exists {
+			return fmt.Errorf("%w: key does not exist", ErrPreconditionFailed)
+		}
+		return nil
+	default:
+		return errorf(ErrInvalidArgument, "unknown precondition kind %d", p.Kind)
+	}
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore_VersionsIncrease(t *testing.T) {
+	store := NewStore()
+
+	v1, err := store.SetWithOptions("tenant", "key", []byte("a"), SetOptions{})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	store.Set("tenant", "other", []byte("x"))
+	v2, _ := store.SetWithOptions("tenant", "key", []byte("b"), SetOptions{})
+
+	if v1 == 0 || v2 <= v1 {
+		t.Fatalf("Expected increasing versions, got %d then %d", v1, v2)
+	}
+
+	value, version, found := store.GetWithVersion("tenant", "key")
+	if !found || string(value) != "b" || version != v2 {
+		t.Fatalf("GetWithVersion = (%q, %d, %v), expected (b, %d, true)", value, version, found, v2)
+	}
+	if store.Revision() != v2 {
+		t.Fatalf("Expected store revision %d, got %d", v2, store.Revision())
+	}
+}
+
+func TestStore_SetPreconditions(t *testing.T) {
+	store := NewStore()
+
+	version, err := store.SetWithOptions("tenant", "key", []byte("a"), SetOptions{Precondition: IfAbsent()})
+	if err != nil {
+		t.Fatalf("IfAbsent on a new key failed: %v", err)
+	}
+
+	_, err = store.SetWithOptions("tenant", "key", []byte("b"), SetOptions{Precondition: IfAbsent()})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("Expected ErrPreconditionFailed for IfAbsent, got %v", err)
+	}
+
+	_, err = store.SetWithOptions("tenant", "key", []byte("b"), SetOptions{Precondition: IfVersion(version + 100)})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("Expected ErrPreconditionFailed for a stale version, got %v", err)
+	}
+
+	newVersion, err := store.SetWithOptions("tenant", "key", []byte("b"), SetOptions{Precondition: IfVersion(version)})
+	if err != nil {
+		t.Fatalf("IfVersion with the current version failed: %v", err)
+	}
+
+	_, err = store.SetWithOptions("tenant", "missing", []byte("c"), SetOptions{Precondition: IfPresent()})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("Expected ErrPreconditionFailed for IfPresent, got %v", err)
+	}
+	if store.Exists("tenant", "missing") {
+		t.Fatal("Failed precondition must not write")
+	}
+
+	if _, err := store.SetWithOptions("tenant", "key", []byte("c"), SetOptions{Precondition: IfPresent()}); err != nil {
+		t.Fatalf("IfPresent on an existing key failed: %v", err)
+	}
+
+	_, version, _ = store.GetWithVersion("tenant", "key")
+	if version <= newVersion {
+		t.Fatalf("Expected version past %d, got %d", newVersion, version)
+	}
+}
+
+func TestStore_ExpiredKeyIsAbsent(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+
+	store.SetWithTTL("tenant", "lock", []byte("holder-1"), time.Second)
+	if _, err := store.SetWithOptions("tenant", "lock", []byte("holder-2"), SetOptions{Precondition: IfAbsent()}); err == nil {
+		t.Fatal("Expected the live lock to block IfAbsent")
+	}
+
+	clock.advance(2 * time.Second)
+	if _, err := store.SetWithOptions("tenant", "lock", []byte("holder-2"), SetOptions{Precondition: IfAbsent()}); err != nil {
+		t.Fatalf("Expected IfAbsent to succeed once the lock expired: %v", err)
+	}
+}
+
+func TestStore_DeletePreconditions(t *testing.T) {
+	store := NewStore()
+
+	version, _ := store.SetWithOptions("tenant", "key", []byte("a"), SetOptions{})
+
+	_, err := store.DeleteWithPrecondition("tenant", "key", IfVersion(version+1))
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("Expected ErrPreconditionFailed, got %v", err)
+	}
+	if !store.Exists("tenant", "key") {
+		t.Fatal("Failed precondition must not delete")
+	}
+
+	deleted, err := store.DeleteWithPrecondition("tenant", "key", IfVersion(version))
+	if err != nil || !deleted {
+		t.Fatalf("Delete = (%v, %v), expected (true, nil)", deleted, err)
+	}
+
+	_, err = store.DeleteWithPrecondition("tenant", "key", IfPresent())
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("Expected ErrPreconditionFailed for a missing key, got %v", err)
+	}
+	_, err = store.DeleteWithPrecondition("unknown-tenant", "key", IfVersion(1))
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("Expected ErrPreconditionFailed for a missing tenant, got %v", err)
+	}
+}
+
+func TestStore_CompareAndSwapIsAtomic(t *testing.T) {
+	store := NewStore()
+	store.Set("tenant", "counter", []byte("0"))
+
+	const workers = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+
+	_, version, _ := store.GetWithVersion("tenant", "counter")
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.SetWithOptions("tenant", "counter", []byte("1"), SetOptions{Precondition: IfVersion(version)})
+			if err == nil {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if winners != 1 {
+		t.Fatalf("Expected exactly one writer to win, got %d", winners)
+	}
+}
+
+func TestStore_VersionsSurviveRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	store.Set("tenant", "a", []byte("1"))
+	store.Snapshot()
+	version, _ := store.SetWithOptions("tenant", "b", []byte("2"), SetOptions{})
+	store.Expire("tenant", "b", time.Hour)
+	_, versionA, _ := store.GetWithVersion("tenant", "a")
+	store.Close()
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+
+	if _, v, _ := store.GetWithVersion("tenant", "a"); v != versionA {
+		t.Fatalf("Expected version %d from the snapshot, got %d", versionA, v)
+	}
+	if _, v, _ := store.GetWithVersion("tenant", "b"); v != version {
+		t.Fatalf("Expected Expire to keep version %d, got %d", version, v)
+	}
+
+	// New writes continue past every recovered version
+	next, _ := store.SetWithOptions("tenant", "c", []byte("3"), SetOptions{})
+	if next <= version {
+		t.Fatalf("Expected new version past %d, got %d", version, next)
+	}
+}
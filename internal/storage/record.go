@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// opType identifies the mutation carried by a WAL record
+type opType uint8
+
+const (
+	opSet opType = iota + 1
+	opDelete
+	opDeleteTenant
+	opExpire // Changes the expiry of an existing key, 0 removes it
+	opBatch  // Several sets and deletes in one tenant, applied atomically
+)
+
+// walRecord is a single mutation as persisted in the write-ahead log
+type walRecord struct {
+	op     opType
+	tenant string
+	key    string
+	value  []byte
+
+	expireAt int64 // Unix nanoseconds for opSet and opExpire, 0 for no expiry
+
+	ops []walRecord // Sets and deletes of an opBatch, which share its tenant
+}
+
+var errShortRecord = errors.New("wal record truncated")
+
+// encode serializes the record as: op | tenant | key | value | [expire at],
+// where every variable-length field is prefixed with its uvarint length. The
+// trailing uvarint expiry is omitted when zero, so records written before
+// TTLs existed decode unchanged. An opBatch carries its operations in the
+// value field, each one an encoded record of its own.
+func (r *walRecord) encode() []byte {
+	value := r.value
+	if r.op == opBatch {
+		value = nil
+		for i := range r.ops {
+			value = appendBytes(value, r.ops[i].encode())
+		}
+	}
+
+	buf := make([]byte, 0, 1+4*binary.MaxVarintLen64+len(r.tenant)+len(r.key)+len(value))
+	buf = append(buf, byte(r.op))
+	buf = appendBytes(buf, []byte(r.tenant))
+	buf = appendBytes(buf, []byte(r.key))
+	buf = appendBytes(buf, value)
+	if r.expireAt != 0 {
+		buf = binary.AppendUvarint(buf, uint64(r.expireAt))
+	}
+	return buf
+}
+
+// decodeWALRecord parses a record produced by encode
+func decodeWALRecord(data []byte) (walRecord, error) {
+	var r walRecord
+	if len(data) == 0 {
+		return r, errShortRecord
+	}
+
+	r.op = opType(data[0])
+	data = data[1:]
+
+	tenant, data, err := readBytes(data)
+	if err != nil {
+		return r, err
+	}
+	key, data, err := readBytes(data)
+	if err != nil {
+		return r, err
+	}
+	value, data, err := readBytes(data)
+	if err != nil {
+		return r, err
+	}
+	if len(data) > 0 {
+		expireAt, size := binary.Uvarint(data)
+		if size <= 0 {
+			return r, errShortRecord
+		}
+		r.expireAt = int64(expireAt)
+	}
+
+	r.tenant = string(tenant)
+	r.key = string(key)
+	r.value = value
+
+	switch r.op {
+	case opSet, opDelete, opDeleteTenant, opExpire:
+		return r, nil
+	case opBatch:
+		return r, r.decodeOps()
+	default:
+		return r, fmt.Errorf("unknown wal record op %d", r.op)
+	}
+}
+
+// decodeOps parses the operations held in a batch record's value
+func (r *walRecord) decodeOps() error {
+	data := r.value
+	r.value = nil
+	for len(data) > 0 {
+		var encoded []byte
+		var err error
+		encoded, data, err = readBytes(data)
+		if err != nil {
+			return err
+		}
+		op, err := decodeWALRecord(encoded)
+		if err != nil {
+			return err
+		}
+		if op.op != opSet && op.op != opDelete {
+			return fmt.Errorf("wal batch cannot hold op %d", op.op)
+		}
+		r.ops = append(r.ops, op)
+	}
+	return nil
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || uint64(len(data)-size) < n {
+		return nil, nil, errShortRecord
+	}
+	data = data[size:]
+
+	b := make([]byte, n)
+	copy(b, data[:n])
+	return b, data[n:], nil
+}
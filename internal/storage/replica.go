@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+)
+
+// ApplyCommitted applies a record that a replicated log committed at index
+// without this store having logged it, as on a follower. A nil data marks an
+// index that carries no mutation. Records must be applied in index order.
+func (s *Store) ApplyCommitted(index uint64, data []byte) error {
+	if data == nil {
+		s.commitMu.RLock()
+		defer s.commitMu.RUnlock()
+
+		s.advanceRevision(index)
+		if s.feed != nil {
+			s.feed.deliver(index, nil)
+		}
+		return nil
+	}
+
+	rec, err := decodeWALRecord(data)
+	if err != nil {
+		return fmt.Errorf("record %d: %w", index, err)
+	}
+
+	if rec.op == opDeleteTenant {
+		s.commitMu.Lock()
+		defer s.commitMu.Unlock()
+
+		err := s.apply(&rec, index)
+		s.advanceRevision(index)
+		s.publishLocked(&rec, index, s.changesLocked(nil, &rec))
+		return err
+	}
+
+	s.commitMu.RLock()
+	defer s.commitMu.RUnlock()
+
+	ts, err := s.getTenantStore(rec.tenant)
+	if err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	changes := s.changesLocked(ts, &rec)
+	err = s.apply(&rec, index)
+	s.advanceRevision(index)
+	s.publishLocked(&rec, index, changes)
+	return err
+}
+
+// SnapshotTo writes the newest snapshot on disk to w and returns its
+// revision. It takes no snapshot and no lock: a replicated log sends it to a
+// follower while writes may be waiting for that follower, and the log after
+// the snapshot brings the follower up to date. Snapshots of a replicated
+// store hold the data of every tenant inline whatever the engine.
+func (s *Store) SnapshotTo(w io.Writer) (uint64, error) {
+	s, span := s.startOp("SnapshotTo", "")
+	defer span.End()
+
+	snapshots, err := listSnapshots(s.snapshotDir())
+	if err != nil {
+		return 0, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		data, err := readSnapshot(snapshots[i].path)
+		if err != nil {
+			// Corrupt, or pruned since it was listed
+			slog.Warn("Skipping unreadable snapshot", "snapshot", filepath.Base(snapshots[i].path), "err", err)
+			continue
+		}
+		for tenantID, entries := range data.tenants {
+			if entries == nil {
+				return 0, fmt.Errorf("snapshot %d holds tenant %q in %s engine files, not inline", data.index, tenantID, data.engine)
+			}
+		}
+		if err := encodeSnapshot(w, data); err != nil {
+			return 0, err
+		}
+		return data.index, nil
+	}
+	return 0, fmt.Errorf("no snapshot on disk to send")
+}
+
+// Restore replaces the contents of the store with a snapshot written by
+// SnapshotTo and returns its revision. The snapshot is saved to disk first
+// and the log is then reset to continue after it. Watchers are ended with
+// ErrCompacted, and the change feed skips the changes the snapshot covers.
+func (s *Store) Restore(r io.Reader) (uint64, error) {
+	s, span := s.startOp("Restore", "")
+	defer span.End()
+
+	data, err := decodeSnapshot(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	s.lockCommit()
+	defer s.commitMu.Unlock()
+
+	if s.wal == nil {
+		return 0, fmt.Errorf("snapshots require a durable store")
+	}
+
+	s.mu.RLock()
+	tenantIDs := sortedKeys(s.tenants)
+	s.mu.RUnlock()
+	for _, tenantID := range tenantIDs {
+		if err := s.dropTenant(tenantID); err != nil {
+			return 0, fmt.Errorf("failed to drop tenant %q: %w", tenantID, err)
+		}
+	}
+	if err := s.restoreSnapshot(data); err != nil {
+		return 0, err
+	}
+
+	// Once the snapshot is on disk the store recovers from it, even if the
+	// log is not reset below
+	if _, err := writeSnapshot(s.snapshotDir(), data); err != nil {
+		return 0, err
+	}
+	if err := s.wal.Reset(data.index + 1); err != nil {
+		return 0, fmt.Errorf("failed to reset wal: %w", err)
+	}
+	s.revision.Store(data.index)
+
+	s.watch.reset(data.index + 1)
+	if s.feed != nil {
+		slog.Warn("Change feed skips revisions restored from a snapshot", "from", s.feed.next, "to", data.index)
+		s.feed.skip(data.index + 1)
+	}
+	return data.index, nil
+}
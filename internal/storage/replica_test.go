@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStore_ApplyCommitted(t *testing.T) {
+	leader := openTestStore(t, t.TempDir())
+	defer leader.Close()
+	follower := openTestStore(t, t.TempDir())
+	defer follower.Close()
+
+	leader.Set("tenant1", "a", []byte("1"))
+	leader.Set("tenant1", "b", []byte("2"))
+	leader.Delete("tenant1", "a")
+
+	w, err := follower.Watch("tenant1", WatchOptions{Key: "", Prefix: true})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Close()
+
+	// Replicate the leader's log, with an index that carries no mutation
+	// in between
+	var next uint64 = 1
+	err = leader.wal.Replay(1, func(index uint64, data []byte) error {
+		next = index + 1
+		return follower.ApplyCommitted(index, data)
+	})
+	if err != nil {
+		t.Fatalf("ApplyCommitted failed: %v", err)
+	}
+	if err := follower.ApplyCommitted(next, nil); err != nil {
+		t.Fatalf("ApplyCommitted of an empty index failed: %v", err)
+	}
+
+	if _, ok := follower.Get("tenant1", "a"); ok {
+		t.Fatal("Expected the replicated delete to apply")
+	}
+	if value, _ := follower.Get("tenant1", "b"); string(value) != "2" {
+		t.Fatalf("Expected the replicated value, got %q", value)
+	}
+	if follower.Revision() != next {
+		t.Fatalf("Expected revision %d, got %d", next, follower.Revision())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var events []Event
+	for len(events) < 3 {
+		batch, err := w.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		events = append(events, batch...)
+	}
+	if events[2].Type != EventDelete || events[2].Key != "a" || events[2].Revision != 3 {
+		t.Fatalf("Expected watchers to see the replicated delete at revision 3, got %+v", events[2])
+	}
+}
+
+func TestStore_SnapshotToAndRestore(t *testing.T) {
+	source := openTestStore(t, t.TempDir())
+	defer source.Close()
+	source.Set("tenant1", "k1", []byte("v1"))
+	source.Set("tenant2", "k2", []byte("v2"))
+
+	var buf bytes.Buffer
+	if _, err := source.SnapshotTo(&buf); err == nil {
+		t.Fatal("Expected SnapshotTo to fail without a snapshot on disk")
+	}
+
+	// The newest snapshot on disk is sent, later writes follow in the log
+	info, err := source.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	source.Set("tenant1", "later", []byte("v"))
+	index, err := source.SnapshotTo(&buf)
+	if err != nil {
+		t.Fatalf("SnapshotTo failed: %v", err)
+	}
+	if index != info.Index {
+		t.Fatalf("Expected the snapshot at revision %d, got %d", info.Index, index)
+	}
+
+	dir := t.TempDir()
+	target := openTestStore(t, dir)
+	target.Set("stale", "key", []byte("gone"))
+	w, _ := target.Watch("stale", WatchOptions{Key: "key"})
+	defer w.Close()
+
+	restored, err := target.Restore(&buf)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored != index || target.Revision() != index {
+		t.Fatalf("Expected revision %d after restore, got %d and %d", index, restored, target.Revision())
+	}
+	if _, ok := target.Get("stale", "key"); ok {
+		t.Fatal("Expected the restore to drop the previous contents")
+	}
+	if value, _ := target.Get("tenant2", "k2"); string(value) != "v2" {
+		t.Fatalf("Expected the snapshot contents, got %q", value)
+	}
+	if _, ok := target.Get("tenant1", "later"); ok {
+		t.Fatal("Expected no write made after the snapshot")
+	}
+	if _, err := w.Next(context.Background()); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("Expected watchers to end with ErrCompacted, got %v", err)
+	}
+
+	// The log continues after the snapshot, and a restart recovers from it
+	if err := target.Set("tenant1", "k3", []byte("v3")); err != nil {
+		t.Fatalf("Set after restore failed: %v", err)
+	}
+	target.Close()
+
+	target = openTestStore(t, dir)
+	defer target.Close()
+	for key, tenant := range map[string]string{"k1": "tenant1", "k2": "tenant2", "k3": "tenant1"} {
+		if _, ok := target.Get(tenant, key); !ok {
+			t.Fatalf("Expected %s/%s after a restart", tenant, key)
+		}
+	}
+	if target.Revision() != index+1 {
+		t.Fatalf("Expected revision %d after a restart, got %d", index+1, target.Revision())
+	}
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/ayushgala/tinkerdb/internal/logging"
+)
+
+const (
+	// DefaultScanLimit is the page size used when a scan sets no limit
+	DefaultScanLimit = 100
+
+	// MaxScanLimit caps the number of keys a single scan page returns
+	MaxScanLimit = 10000
+)
+
+// ScanOptions selects the keys a scan returns. Keys are matched in
+// [Start, End), further narrowed to Prefix when it is set.
+type ScanOptions struct {
+	Start  string // First key of the range, inclusive
+	End    string // Key past the range, exclusive; empty means no upper bound
+	Prefix string // Only return keys with this prefix
+
+	Limit    int  // Maximum keys per page, 0 means DefaultScanLimit
+	MaxBytes int  // Stop once keys and values exceed this many bytes, 0 means no byte limit
+	Reverse  bool // Return keys in descending order
+	KeysOnly bool // Omit values
+
+	// Cursor resumes a scan after the last key of the previous page. It
+	// must be the Cursor of that page's result, used with the same options.
+	Cursor string
+}
+
+// KeyValue is a key returned by a scan
+type KeyValue struct {
+	Key     string
+	Value   []byte // nil for keys-only scans
+	Version uint64
+}
+
+// ScanResult is one page of a scan
+type ScanResult struct {
+	Items  []KeyValue
+	Cursor string // Pass as ScanOptions.Cursor to fetch the next page, empty when the scan is done
+}
+
+// Scan returns one page of a tenant's live keys in lexicographic order, or
+// reverse order when requested. Each page is read under the tenant's read
+// lock, so it is consistent, but pages may observe writes made between them.
+func (s *Store) Scan(tenantID string, opts ScanOptions) (*ScanResult, error) {
+	s, span := s.startOp("Scan", tenantID)
+	defer span.End()
+
+	if tenantID == "" {
+		return nil, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if opts.Limit < 0 {
+		return nil, errorf(ErrInvalidArgument, "limit cannot be negative")
+	}
+	if opts.MaxBytes < 0 {
+		return nil, errorf(ErrInvalidArgument, "byte limit cannot be negative")
+	}
+
+	s.mu.RLock()
+	tenantStore, exists := s.tenants[tenantID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return &ScanResult{}, nil
+	}
+	return tenantStore.Scan(opts)
+}
+
+// scanBounds resolves the options into the [start, end) range still to scan
+func (opts ScanOptions) scanBounds() (string, string) {
+	start, end := opts.Start, opts.End
+	if opts.Prefix != "" {
+		if start < opts.Prefix {
+			start = opts.Prefix
+		}
+		if prefixEnd := PrefixEnd(opts.Prefix); prefixEnd != "" && (end == "" || prefixEnd < end) {
+			end = prefixEnd
+		}
+	}
+
+	if opts.Cursor != "" {
+		if opts.Reverse {
+			if end == "" || opts.Cursor < end {
+				end = opts.Cursor
+			}
+		} else if next := opts.Cursor + "\x00"; next > start {
+			start = next
+		}
+	}
+	return start, end
+}
+
+// PrefixEnd returns the smallest key greater than every key with prefix,
+// or "" if there is none
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// Scan returns one page of live keys, see Store.Scan
+func (ts *TenantStore) Scan(opts ScanOptions) (*ScanResult, error) {
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultScanLimit
+	}
+	limit = min(limit, MaxScanLimit)
+
+	start, end := opts.scanBounds()
+	result := &ScanResult{}
+	if end != "" && start >= end {
+		return result, nil
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	now := timeNow().UnixNano()
+	size := 0
+	visit := func(key string, data []byte) bool {
+		e, err := decodeEntry(data)
+		if err != nil {
+			slog.Error("Failed to decode entry", logging.Key("key", key), "err", err)
+			return true
+		}
+		if e.expired(now) {
+			return true
+		}
+		if len(result.Items) == limit || (opts.MaxBytes > 0 && len(result.Items) > 0 && size >= opts.MaxBytes) {
+			// A further key exists, so the page ends with a cursor
+			result.Cursor = result.Items[len(result.Items)-1].Key
+			return false
+		}
+
+		item := KeyValue{Key: key, Version: e.version}
+		if !opts.KeysOnly {
+			item.Value = append([]byte(nil), e.value...)
+		}
+		size += len(key) + len(item.Value)
+		result.Items = append(result.Items, item)
+		return true
+	}
+
+	var err error
+	if opts.Reverse {
+		err = ts.engine.IterateReverse(start, end, visit)
+	} else {
+		err = ts.engine.Iterate(start, end, visit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan: %w", err)
+	}
+	return result, nil
+}
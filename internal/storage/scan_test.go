@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func scanKeys(result *ScanResult) string {
+	keys := make([]string, len(result.Items))
+	for i, item := range result.Items {
+		keys[i] = item.Key
+	}
+	return fmt.Sprint(keys)
+}
+
+func TestStore_ScanRanges(t *testing.T) {
+	store := NewStore()
+	for _, key := range []string{"user:3", "user:1", "order:1", "user:2", "userx", "a"} {
+		store.Set("tenant", key, []byte("value-"+key))
+	}
+
+	tests := []struct {
+		name     string
+		opts     ScanOptions
+		expected string
+	}{
+		{"all", ScanOptions{}, "[a order:1 user:1 user:2 user:3 userx]"},
+		{"range", ScanOptions{Start: "order:", End: "user:3"}, "[order:1 user:1 user:2]"},
+		{"prefix", ScanOptions{Prefix: "user:"}, "[user:1 user:2 user:3]"},
+		{"prefix and start", ScanOptions{Prefix: "user:", Start: "user:2"}, "[user:2 user:3]"},
+		{"reverse prefix", ScanOptions{Prefix: "user:", Reverse: true}, "[user:3 user:2 user:1]"},
+		{"reverse range", ScanOptions{Start: "b", End: "user:2", Reverse: true}, "[user:1 order:1]"},
+		{"limit", ScanOptions{Limit: 2}, "[a order:1]"},
+		{"empty range", ScanOptions{Start: "z", End: "b"}, "[]"},
+	}
+
+	for _, tt := range tests {
+		result, err := store.Scan("tenant", tt.opts)
+		if err != nil {
+			t.Fatalf("%s: Scan failed: %v", tt.name, err)
+		}
+		if keys := scanKeys(result); keys != tt.expected {
+			t.Fatalf("%s: got %s, expected %s", tt.name, keys, tt.expected)
+		}
+	}
+
+	result, _ := store.Scan("tenant", ScanOptions{Prefix: "order:"})
+	if string(result.Items[0].Value) != "value-order:1" || result.Items[0].Version == 0 {
+		t.Fatalf("Expected value and version, got %+v", result.Items[0])
+	}
+	result, _ = store.Scan("tenant", ScanOptions{Prefix: "order:", KeysOnly: true})
+	if result.Items[0].Value != nil {
+		t.Fatalf("Expected no value for a keys-only scan, got %q", result.Items[0].Value)
+	}
+
+	result, _ = store.Scan("unknown", ScanOptions{})
+	if len(result.Items) != 0 || result.Cursor != "" {
+		t.Fatalf("Expected an empty page for an unknown tenant, got %+v", result)
+	}
+	if _, err := store.Scan("tenant", ScanOptions{Limit: -1}); err == nil {
+		t.Fatal("Expected a negative limit to be rejected")
+	}
+}
+
+func TestStore_ScanPagination(t *testing.T) {
+	store := NewStore()
+	var expected []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		store.Set("tenant", key, []byte("v"))
+		expected = append(expected, key)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		var keys []string
+		opts := ScanOptions{Limit: 10, Reverse: reverse}
+		pages := 0
+		for {
+			result, err := store.Scan("tenant", opts)
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			pages++
+			for _, item := range result.Items {
+				keys = append(keys, item.Key)
+			}
+			if result.Cursor == "" {
+				break
+			}
+			opts.Cursor = result.Cursor
+		}
+
+		if pages != 3 || len(keys) != len(expected) {
+			t.Fatalf("reverse=%v: expected 25 keys in 3 pages, got %d in %d", reverse, len(keys), pages)
+		}
+		for i := range keys {
+			want := expected[i]
+			if reverse {
+				want = expected[len(expected)-1-i]
+			}
+			if keys[i] != want {
+				t.Fatalf("reverse=%v: key %d is %q, expected %q", reverse, i, keys[i], want)
+			}
+		}
+	}
+
+	// An exactly full last page does not hand out a cursor
+	result, _ := store.Scan("tenant", ScanOptions{Start: "key-20", Limit: 5})
+	if len(result.Items) != 5 || result.Cursor != "" {
+		t.Fatalf("Expected 5 keys and no cursor, got %d and %q", len(result.Items), result.Cursor)
+	}
+}
+
+func TestStore_ScanByteLimit(t *testing.T) {
+	store := NewStore()
+	for i := 0; i < 10; i++ {
+		store.Set("tenant", fmt.Sprintf("key-%d", i), make([]byte, 100))
+	}
+
+	result, _ := store.Scan("tenant", ScanOptions{MaxBytes: 250})
+	if len(result.Items) != 3 || result.Cursor != "key-2" {
+		t.Fatalf("Expected 3 keys ending at key-2, got %d ending at %q", len(result.Items), result.Cursor)
+	}
+
+	// A single oversized value is still returned
+	result, _ = store.Scan("tenant", ScanOptions{MaxBytes: 10})
+	if len(result.Items) != 1 || result.Cursor != "key-0" {
+		t.Fatalf("Expected one key per page, got %d", len(result.Items))
+	}
+}
+
+func TestStore_ScanSkipsExpiredKeys(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+
+	store.Set("tenant", "a", []byte("1"))
+	store.SetWithTTL("tenant", "b", []byte("2"), time.Second)
+	store.Set("tenant", "c", []byte("3"))
+	clock.advance(2 * time.Second)
+
+	result, _ := store.Scan("tenant", ScanOptions{})
+	if keys := scanKeys(result); keys != "[a c]" {
+		t.Fatalf("Expected expired keys to be skipped, got %s", keys)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"abc":         "abd",
+		"a\xff":       "b",
+		"\xff\xff":    "",
+		"user:":       "user;",
+		"key\xff\xfe": "key\xff\xff",
+	}
+	for prefix, expected := range tests {
+		if got := PrefixEnd(prefix); got != expected {
+			t.Fatalf("PrefixEnd(%q) = %q, expected %q", prefix, got, expected)
+		}
+	}
+}
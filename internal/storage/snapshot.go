@@ -0,0 +1,490 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot files hold a point-in-time copy of every tenant along with the
+// index of the last WAL record they include:
+//
+//	magic "TKSNAP03" | wal index (8B LE) | engine | tenant count (uvarint)
+//	  per tenant: tenant | inline flag (1B) | [key count (uvarint) | key, entry pairs]
+//	crc32c of everything above (4B LE)
+//
+// Entries are stored exactly as the engine holds them, value plus metadata.
+// Tenants backed by a persistent engine are not copied; their engine was
+// synced at the snapshot index and the flag is 0. Version 2 files hold bare
+// values, and version 1 files additionally carry no engine and always hold
+// tenant data inline.
+//
+// Strings and byte slices are prefixed with their uvarint length, as in WAL records.
+const (
+	snapshotMagic   = "TKSNAP03"
+	snapshotMagicV2 = "TKSNAP02"
+	snapshotMagicV1 = "TKSNAP01"
+	snapshotExt     = ".snap"
+	snapshotTempExt = ".tmp"
+)
+
+var snapshotCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errSnapshotCorrupt is returned for snapshots with a bad header or checksum
+var errSnapshotCorrupt = errors.New("snapshot is corrupt")
+
+// SnapshotInfo describes a snapshot written to disk
+type SnapshotInfo struct {
+	Index     uint64 // Last WAL record included in the snapshot
+	Path      string
+	Size      int64
+	Tenants   int
+	Keys      int
+	CreatedAt time.Time
+}
+
+// snapshotData is the frozen state written into a snapshot file. A nil
+// entry map means the tenant's data lives in the named engine's files.
+type snapshotData struct {
+	index   uint64
+	engine  string
+	tenants map[string]map[string][]byte
+	keys    int
+
+	bareValues bool // Read from an older snapshot whose engine files hold bare values
+}
+
+// Snapshot writes a point-in-time copy of the store to disk and truncates
+// the write-ahead log up to the oldest snapshot still retained.
+func (s *Store) Snapshot() (SnapshotInfo, error) {
+	s, span := s.startOp("Snapshot", "")
+	defer span.End()
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	start := time.Now()
+	info, err := s.snapshot()
+	s.recordSnapshot(info, time.Since(start), err)
+	return info, err
+}
+
+// snapshot writes a snapshot and prunes older ones, caller must hold
+// snapshotMu
+func (s *Store) snapshot() (SnapshotInfo, error) {
+	data, err := s.freeze()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	info, err := writeSnapshot(s.snapshotDir(), data)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if err := s.pruneSnapshots(); err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+// freeze captures the store contents and the WAL position they correspond
+// to. Writes are paused while in-memory tenants are copied or persistent
+// engines are synced; stored values are never mutated in place, so a
+// shallow copy is enough.
+func (s *Store) freeze() (*snapshotData, error) {
+	s.lockCommit()
+	defer s.commitMu.Unlock()
+
+	if s.wal == nil {
+		return nil, fmt.Errorf("snapshots require a durable store")
+	}
+
+	// Seal the active segment so the records covered by this snapshot can be truncated
+	if err := s.wal.Rotate(); err != nil {
+		return nil, fmt.Errorf("failed to rotate wal: %w", err)
+	}
+
+	return s.captureLocked()
+}
+
+// captureLocked copies the store contents at the current revision. Tenants
+// in persistent engines are synced instead of copied, unless the store is
+// replicated. Caller must hold commitMu exclusively.
+func (s *Store) captureLocked() (*snapshotData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := &snapshotData{
+		index:   s.revisionLocked(),
+		engine:  s.engineName(),
+		tenants: make(map[string]map[string][]byte, len(s.tenants)),
+	}
+	for tenantID, ts := range s.tenants {
+		entries, keys, err := ts.freeze(s.replicated)
+		if err != nil {
+			return nil, fmt.Errorf("failed to freeze tenant %q: %w", tenantID, err)
+		}
+		data.tenants[tenantID] = entries
+		data.keys += keys
+	}
+
+	return data, nil
+}
+
+// pruneSnapshots removes snapshots beyond the retention count and releases
+// WAL segments that every retained snapshot already covers
+func (s *Store) pruneSnapshots() error {
+	snapshots, err := listSnapshots(s.snapshotDir())
+	if err != nil {
+		return err
+	}
+
+	retain := s.opts.SnapshotRetain
+	if retain < 1 {
+		retain = 1
+	}
+
+	for len(snapshots) > retain {
+		if err := os.Remove(snapshots[0].path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old snapshot: %w", err)
+		}
+		snapshots = snapshots[1:]
+	}
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+	if err := s.wal.TruncateFront(snapshots[0].index); err != nil {
+		return fmt.Errorf("failed to truncate wal: %w", err)
+	}
+	return nil
+}
+
+// snapshotLoop takes a snapshot every interval until stop is closed
+func (s *Store) snapshotLoop(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastIndex uint64
+	for {
+		select {
+		case <-ticker.C:
+			// Skip the snapshot if nothing was written since the last one
+			if index := s.Revision(); index == lastIndex {
+				continue
+			}
+			info, err := s.Snapshot()
+			if err != nil {
+				slog.Error("Periodic snapshot failed", "err", err)
+				continue
+			}
+			lastIndex = info.Index
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *Store) snapshotDir() string {
+	return filepath.Join(s.opts.DataDir, "snapshots")
+}
+
+// snapshotFile is a snapshot found on disk
+type snapshotFile struct {
+	index uint64
+	path  string
+}
+
+// listSnapshots returns snapshot files sorted oldest first
+func listSnapshots(dir string) ([]snapshotFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var snapshots []snapshotFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, snapshotExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshotFile{index: index, path: filepath.Join(dir, name)})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].index < snapshots[j].index
+	})
+	return snapshots, nil
+}
+
+// writeSnapshot writes data atomically: into a temp file that is fsynced and
+// then renamed into place
+func writeSnapshot(dir string, data *snapshotData) (SnapshotInfo, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%016x%s", data.index, snapshotExt)
+	path := filepath.Join(dir, name)
+
+	tmp, err := os.CreateTemp(dir, name+"-*"+snapshotTempExt)
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	info := SnapshotInfo{
+		Index:     data.index,
+		Path:      path,
+		Tenants:   len(data.tenants),
+		Keys:      data.keys,
+		CreatedAt: time.Now(),
+	}
+
+	if err := encodeSnapshot(tmp, data); err != nil {
+		tmp.Close()
+		return SnapshotInfo{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return SnapshotInfo{}, fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+
+	stat, err := tmp.Stat()
+	if err == nil {
+		info.Size = stat.Size()
+	}
+	if err := tmp.Close(); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to install snapshot: %w", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	return info, nil
+}
+
+// encodeSnapshot writes data to dst in the snapshot file format
+func encodeSnapshot(dst io.Writer, data *snapshotData) error {
+	hasher := crc32.New(snapshotCRCTable)
+	w := bufio.NewWriter(io.MultiWriter(dst, hasher))
+
+	w.WriteString(snapshotMagic)
+	binary.Write(w, binary.LittleEndian, data.index)
+	writeString(w, data.engine)
+	writeUvarint(w, uint64(len(data.tenants)))
+
+	// Sort tenants and keys so identical states produce identical files
+	tenantIDs := sortedKeys(data.tenants)
+	for _, tenantID := range tenantIDs {
+		entries := data.tenants[tenantID]
+		writeString(w, tenantID)
+		if entries == nil {
+			w.WriteByte(0)
+			continue
+		}
+		w.WriteByte(1)
+		writeUvarint(w, uint64(len(entries)))
+		for _, key := range sortedKeys(entries) {
+			writeString(w, key)
+			writeUvarint(w, uint64(len(entries[key])))
+			w.Write(entries[key])
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := binary.Write(dst, binary.LittleEndian, hasher.Sum32()); err != nil {
+		return fmt.Errorf("failed to write snapshot checksum: %w", err)
+	}
+	return nil
+}
+
+// readSnapshot loads and verifies a snapshot file
+func readSnapshot(path string) (*snapshotData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	return decodeSnapshot(f)
+}
+
+// decodeSnapshot reads and verifies a snapshot written by encodeSnapshot
+func decodeSnapshot(src io.Reader) (*snapshotData, error) {
+	r := &checksumReader{r: bufio.NewReader(src), hash: crc32.New(snapshotCRCTable)}
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, errSnapshotCorrupt
+	}
+	version1 := string(magic) == snapshotMagicV1
+	bareValues := version1 || string(magic) == snapshotMagicV2
+	if !bareValues && string(magic) != snapshotMagic {
+		return nil, errSnapshotCorrupt
+	}
+
+	data := &snapshotData{engine: EngineMemory, bareValues: bareValues}
+	if err := binary.Read(r, binary.LittleEndian, &data.index); err != nil {
+		return nil, errSnapshotCorrupt
+	}
+	if !version1 {
+		engineName, err := readSnapshotBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		data.engine = string(engineName)
+	}
+
+	tenantCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errSnapshotCorrupt
+	}
+
+	data.tenants = make(map[string]map[string][]byte)
+	for i := uint64(0); i < tenantCount; i++ {
+		tenantID, err := readSnapshotBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		if !version1 {
+			inline, err := r.ReadByte()
+			if err != nil || inline > 1 {
+				return nil, errSnapshotCorrupt
+			}
+			if inline == 0 {
+				data.tenants[string(tenantID)] = nil
+				continue
+			}
+		}
+		keyCount, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errSnapshotCorrupt
+		}
+
+		entries := make(map[string][]byte)
+		for j := uint64(0); j < keyCount; j++ {
+			key, err := readSnapshotBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readSnapshotBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			if bareValues {
+				value = encodeEntry(entry{value: value})
+			}
+			entries[string(key)] = value
+		}
+		data.tenants[string(tenantID)] = entries
+	}
+
+	expected := r.hash.Sum32()
+	var checksum uint32
+	if err := binary.Read(r.r, binary.LittleEndian, &checksum); err != nil || checksum != expected {
+		return nil, errSnapshotCorrupt
+	}
+
+	return data, nil
+}
+
+// checksumReader feeds everything read through it into a hash
+type checksumReader struct {
+	r    *bufio.Reader
+	hash hash.Hash32
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.hash.Write([]byte{b})
+	}
+	return b, err
+}
+
+func readSnapshotBytes(r *checksumReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errSnapshotCorrupt
+	}
+	if n > maxSnapshotField {
+		return nil, errSnapshotCorrupt
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errSnapshotCorrupt
+	}
+	return b, nil
+}
+
+// maxSnapshotField bounds a single key or value read from a snapshot
+const maxSnapshotField = 1 << 30
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// syncDir fsyncs a directory so that renames and new files survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory for sync: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
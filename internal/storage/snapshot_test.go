@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func openTestStore(t *testing.T, dir string) *Store {
+	t.Helper()
+
+	opts := DefaultOptions(dir)
+	opts.SnapshotInterval = 0
+	opts.WAL.SegmentSize = 256
+
+	store, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return store
+}
+
+func TestStore_SnapshotAndRecover(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	for i := 0; i < 20; i++ {
+		store.Set("tenant1", fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	info, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if info.Index != 20 || info.Keys != 20 || info.Tenants != 1 {
+		t.Fatalf("Unexpected snapshot info: %+v", info)
+	}
+
+	// Writes after the snapshot must come back from the WAL suffix
+	store.Set("tenant1", "key-0", []byte("after-snapshot"))
+	store.Delete("tenant1", "key-1")
+	store.Set("tenant2", "key", []byte("value"))
+	store.Close()
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+
+	value, _ := store.Get("tenant1", "key-0")
+	if string(value) != "after-snapshot" {
+		t.Fatalf("Expected 'after-snapshot', got %q", value)
+	}
+	if store.Exists("tenant1", "key-1") {
+		t.Fatal("key-1 was deleted after the snapshot and should stay deleted")
+	}
+	value, _ = store.Get("tenant1", "key-19")
+	if string(value) != "value-19" {
+		t.Fatalf("Expected 'value-19', got %q", value)
+	}
+	if !store.Exists("tenant2", "key") {
+		t.Fatal("tenant2 key should be recovered from the wal")
+	}
+}
+
+func TestStore_SnapshotTruncatesWAL(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer store.Close()
+
+	for i := 0; i < 50; i++ {
+		store.Set("tenant", fmt.Sprintf("key-%d", i), []byte("value"))
+	}
+	if store.wal.FirstIndex() != 1 {
+		t.Fatalf("Expected wal to start at 1, got %d", store.wal.FirstIndex())
+	}
+
+	// With a retention of 2, the log is released up to the older snapshot
+	store.Snapshot()
+	store.Set("tenant", "more", []byte("value"))
+	info, _ := store.Snapshot()
+
+	if store.wal.FirstIndex() <= 1 {
+		t.Fatal("Expected wal segments to be truncated")
+	}
+	if store.wal.FirstIndex() > info.Index+1 {
+		t.Fatalf("Wal truncated past the newest snapshot: first=%d snapshot=%d", store.wal.FirstIndex(), info.Index)
+	}
+}
+
+func TestStore_SnapshotRetention(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer store.Close()
+
+	for i := 0; i < 4; i++ {
+		store.Set("tenant", fmt.Sprintf("key-%d", i), []byte("value"))
+		if _, err := store.Snapshot(); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+	}
+
+	snapshots, _ := listSnapshots(store.snapshotDir())
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 retained snapshots, got %d", len(snapshots))
+	}
+	if snapshots[1].index != 4 {
+		t.Fatalf("Expected newest snapshot at index 4, got %d", snapshots[1].index)
+	}
+}
+
+func TestStore_CorruptSnapshotFallsBack(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	store.Set("tenant", "first", []byte("1"))
+	store.Snapshot()
+	store.Set("tenant", "second", []byte("2"))
+	info, _ := store.Snapshot()
+	store.Set("tenant", "third", []byte("3"))
+	store.Close()
+
+	// Damage the newest snapshot
+	data, _ := os.ReadFile(info.Path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(info.Path, data, 0o644)
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+
+	for _, key := range []string{"first", "second", "third"} {
+		if !store.Exists("tenant", key) {
+			t.Fatalf("Expected %s to be recovered via the older snapshot", key)
+		}
+	}
+}
+
+func TestStore_SnapshotRequiresDurableStore(t *testing.T) {
+	if _, err := NewStore().Snapshot(); err == nil {
+		t.Fatal("Expected snapshot of an in-memory store to fail")
+	}
+}
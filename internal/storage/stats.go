@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/wal"
+)
+
+// Stats describe a store at one point in time
+type Stats struct {
+	Revision  uint64
+	Tenants   map[string]TenantStats
+	WAL       wal.Stats // Zero for an in-memory store
+	Snapshots SnapshotStats
+}
+
+// TenantStats describe the data of one tenant
+type TenantStats struct {
+	Keys        int    // Expired keys count until they are reclaimed
+	Bytes       int64  // Space used by the tenant's engine, see engine.Stats
+	Compactions uint64 // Engine compactions since the tenant was opened
+}
+
+// SnapshotStats describe the snapshots taken since the store was opened
+type SnapshotStats struct {
+	Taken        uint64
+	Failed       uint64
+	Last         SnapshotInfo // Zero until a snapshot is taken
+	LastDuration time.Duration
+}
+
+// Stats returns the key counts and sizes of every tenant along with the
+// counters of the log and snapshots. It does not scan any tenant's data.
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	tenants := make(map[string]*TenantStore, len(s.tenants))
+	for tenantID, ts := range s.tenants {
+		tenants[tenantID] = ts
+	}
+	s.mu.RUnlock()
+
+	stats := Stats{
+		Revision: s.Revision(),
+		Tenants:  make(map[string]TenantStats, len(tenants)),
+	}
+	for tenantID, ts := range tenants {
+		stats.Tenants[tenantID] = ts.Stats()
+	}
+	if s.wal != nil {
+		stats.WAL = s.wal.Stats()
+	}
+
+	s.statsMu.Lock()
+	stats.Snapshots = s.snapshotStats
+	s.statsMu.Unlock()
+	return stats
+}
+
+// Stats returns the number of keys in the tenant store and the space its
+// engine uses
+func (ts *TenantStore) Stats() TenantStats {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	engineStats := ts.engine.Stats()
+	return TenantStats{
+		Keys:        ts.engine.Len(),
+		Bytes:       engineStats.Bytes,
+		Compactions: engineStats.Compactions,
+	}
+}
+
+// recordSnapshot counts a snapshot attempt
+func (s *Store) recordSnapshot(info SnapshotInfo, took time.Duration, err error) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if err != nil {
+		s.snapshotStats.Failed++
+		return
+	}
+	s.snapshotStats.Taken++
+	s.snapshotStats.Last = info
+	s.snapshotStats.LastDuration = took
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStore_Stats(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	for i := 0; i < 10; i++ {
+		store.Set("tenant1", fmt.Sprintf("key-%d", i), []byte("value"))
+	}
+	store.Set("tenant2", "key", make([]byte, 1000))
+
+	stats := store.Stats()
+	if stats.Revision != 11 || len(stats.Tenants) != 2 {
+		t.Fatalf("Expected revision 11 and 2 tenants, got %+v", stats)
+	}
+	if tenant := stats.Tenants["tenant1"]; tenant.Keys != 10 || tenant.Bytes == 0 {
+		t.Fatalf("Expected 10 keys with their bytes in tenant1, got %+v", tenant)
+	}
+	if tenant := stats.Tenants["tenant2"]; tenant.Keys != 1 || tenant.Bytes < 1000 {
+		t.Fatalf("Expected 1 key of at least 1000 bytes in tenant2, got %+v", tenant)
+	}
+	if stats.WAL.Appends != 11 || stats.Snapshots.Taken != 0 {
+		t.Fatalf("Expected 11 logged writes and no snapshots, got %+v", stats)
+	}
+
+	if _, err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	snapshots := store.Stats().Snapshots
+	if snapshots.Taken != 1 || snapshots.Last.Index != 11 || snapshots.LastDuration <= 0 {
+		t.Fatalf("Expected the snapshot to be counted, got %+v", snapshots)
+	}
+
+	// In-memory stores have no log
+	memStore := NewStore()
+	memStore.Set("tenant", "key", []byte("value"))
+	if stats := memStore.Stats(); stats.WAL.Appends != 0 || stats.Tenants["tenant"].Keys != 1 {
+		t.Fatalf("Unexpected in-memory store stats: %+v", stats)
+	}
+}
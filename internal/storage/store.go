@@ -1,119 +1,444 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/wal"
 )
 
-// TenantStore represents a key-value store for a single tenant
-type TenantStore struct {
-	data map[string][]byte
-	mu   sync.RWMutex
+// Options configures a durable Store
+type Options struct {
+	DataDir string      // Directory holding the write-ahead log, snapshots and engine files
+	Engine  string      // Storage engine for tenant data, see Engines
+	WAL     wal.Options // Fsync policy and segment sizing
+
+	SnapshotInterval time.Duration // Time between periodic snapshots, 0 disables them
+	SnapshotRetain   int           // Number of snapshots kept on disk
+
+	SweepInterval time.Duration // Time between expired key sweeps, 0 disables them
+}
+
+// Log is the write-ahead log of a durable store. *wal.Log is the local one;
+// a replicated log returns from AppendContext only once the record is
+// committed, and hands the records committed elsewhere to ApplyCommitted.
+// Record indexes are the store's revisions.
+//
+// WaitReady returns once the log accepts appends without first handing
+// records to ApplyCommitted, as a new leader does. Mutations wait for it
+// before taking the locks ApplyCommitted needs.
+type Log interface {
+	AppendContext(ctx context.Context, data []byte) (uint64, error)
+	WaitReady(ctx context.Context) error
+	Replay(from uint64, fn func(index uint64, data []byte) error) error
+	FirstIndex() uint64
+	LastIndex() uint64 // Newest record the store has applied
+	Rotate() error
+	TruncateFront(upTo uint64) error
+	Reset(next uint64) error
+	Stats() wal.Stats
+	Close() error
 }
 
-// NewTenantStore creates a new tenant store
-func NewTenantStore() *TenantStore {
-	return &TenantStore{
-		data: make(map[string][]byte),
+// DefaultOptions returns options for a store rooted at dataDir
+func DefaultOptions(dataDir string) Options {
+	return Options{
+		DataDir:          dataDir,
+		Engine:           EngineMemory,
+		WAL:              wal.DefaultOptions(),
+		SnapshotInterval: 5 * time.Minute,
+		SnapshotRetain:   2,
+		SweepInterval:    time.Second,
 	}
 }
 
-// Set stores a key-value pair in the tenant store
-func (ts *TenantStore) Set(key string, value []byte) error {
-	if key == "" {
-		return fmt.Errorf("key cannot be empty")
+// Store represents the multi-tenant key-value store. The copies returned by
+// WithContext share all of their state with the original.
+type Store struct {
+	*storeState
+	ctx context.Context // Parent of the store's spans, nil for none
+}
+
+// storeState is the state of a Store
+type storeState struct {
+	tenants map[string]*TenantStore
+	mu      sync.RWMutex
+
+	// commitMu orders mutations against whole-store operations. Set and
+	// Delete hold it shared while they log and apply, DeleteTenant holds it
+	// exclusively so no write can land in a tenant that is being dropped.
+	commitMu sync.RWMutex
+
+	// wal is nil for a purely in-memory store. revision is the newest
+	// record logged, or applied from a replicated log.
+	wal      Log
+	revision atomic.Uint64
+	opts     Options
+
+	// replicated is set when wal is not the local write-ahead log. Its
+	// snapshots then hold every tenant inline, to be sent to followers.
+	replicated bool
+
+	// watch delivers committed changes to watchers
+	watch *watchHub
+
+	// feed publishes committed changes in revision order, nil when no
+	// change handler is installed. It only changes under commitMu held
+	// exclusively.
+	feed *changeFeed
+
+	// snapshotMu serializes snapshot writers
+	snapshotMu   sync.Mutex
+	snapshotStop chan struct{}
+	snapshotDone chan struct{}
+
+	// statsMu guards snapshotStats, which is read without waiting for a
+	// snapshot in progress
+	statsMu       sync.Mutex
+	snapshotStats SnapshotStats
+
+	sweepStop chan struct{}
+	sweepDone chan struct{}
+}
+
+// NewStore creates a new in-memory multi-tenant store
+func NewStore() *Store {
+	return &Store{storeState: &storeState{
+		tenants: make(map[string]*TenantStore),
+		watch:   newWatchHub(1),
+	}}
+}
+
+// Open opens a durable store in opts.DataDir. Every mutation is appended to
+// the write-ahead log before it is applied. On open the newest valid snapshot
+// is loaded and only the log records after it are replayed.
+func Open(opts Options) (*Store, error) {
+	if opts.DataDir == "" {
+		return nil, fmt.Errorf("data directory cannot be empty")
+	}
+
+	walLog, err := wal.Open(filepath.Join(opts.DataDir, "wal"), opts.WAL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+	return OpenWithLog(opts, walLog)
+}
+
+// OpenWithLog is Open with walLog in place of the local write-ahead log. The
+// store takes ownership of walLog and closes it, also when opening fails.
+// opts.WAL is not used.
+func OpenWithLog(opts Options, walLog Log) (*Store, error) {
+	if opts.DataDir == "" {
+		walLog.Close()
+		return nil, fmt.Errorf("data directory cannot be empty")
+	}
+	if opts.Engine == "" {
+		opts.Engine = EngineMemory
+	}
+	if err := ValidateEngine(opts.Engine); err != nil {
+		walLog.Close()
+		return nil, err
+	}
+
+	s := NewStore()
+	s.opts = opts
+	_, local := walLog.(*wal.Log)
+	s.replicated = !local
+
+	if err := s.recover(walLog); err != nil {
+		walLog.Close()
+		s.closeTenants()
+		return nil, err
+	}
+
+	s.wal = walLog
+	s.advanceRevision(walLog.LastIndex())
+	s.watch.since = s.revision.Load() + 1
+
+	if opts.SnapshotInterval > 0 {
+		s.snapshotStop = make(chan struct{})
+		s.snapshotDone = make(chan struct{})
+		go s.snapshotLoop(opts.SnapshotInterval, s.snapshotStop, s.snapshotDone)
+	}
+
+	if opts.SweepInterval > 0 {
+		s.sweepStop = make(chan struct{})
+		s.sweepDone = make(chan struct{})
+		go s.sweepLoop(opts.SweepInterval, s.sweepStop, s.sweepDone)
+	}
+
+	return s, nil
+}
+
+// recover loads the newest valid snapshot and replays the WAL suffix after it
+func (s *Store) recover(walLog Log) error {
+	snapshots, err := listSnapshots(s.snapshotDir())
+	if err != nil {
+		return err
+	}
+
+	var index uint64
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		data, err := readSnapshot(snapshots[i].path)
+		if err != nil {
+			slog.Warn("Skipping unreadable snapshot", "snapshot", filepath.Base(snapshots[i].path), "err", err)
+			continue
+		}
+		if err := s.restoreSnapshot(data); err != nil {
+			return fmt.Errorf("failed to restore snapshot %s: %w", filepath.Base(snapshots[i].path), err)
+		}
+		index = data.index
+		break
 	}
 
-	ts.mu.Lock()
-	defer ts.mu.Unlock()
+	// The log must continue right where the snapshot ends. A crash while
+	// restoring a snapshot can leave the log behind it, see Restore.
+	if first := walLog.FirstIndex(); first > index+1 {
+		return fmt.Errorf("wal starts at record %d but the newest usable snapshot ends at %d", first, index)
+	}
+	if walLog.LastIndex() < index {
+		if err := walLog.Reset(index + 1); err != nil {
+			return fmt.Errorf("failed to reset wal after snapshot: %w", err)
+		}
+	}
 
-	// Create a copy of the value to avoid external modifications
-	valueCopy := make([]byte, len(value))
-	copy(valueCopy, value)
-	ts.data[key] = valueCopy
+	err = walLog.Replay(index+1, func(i uint64, data []byte) error {
+		rec, err := decodeWALRecord(data)
+		if err != nil {
+			return fmt.Errorf("wal record %d: %w", i, err)
+		}
+		return s.apply(&rec, i)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replay wal: %w", err)
+	}
 
+	s.revision.Store(index)
 	return nil
 }
 
-// Get retrieves a value for a key from the tenant store
-func (ts *TenantStore) Get(key string) ([]byte, bool) {
-	ts.mu.RLock()
-	defer ts.mu.RUnlock()
+// restoreSnapshot rebuilds the tenant map from a snapshot. Tenants whose
+// data lives in a persistent engine are reopened in place; inline tenant
+// data is loaded into freshly created engines.
+func (s *Store) restoreSnapshot(data *snapshotData) error {
+	for tenantID, entries := range data.tenants {
+		if entries == nil {
+			if data.engine != s.engineName() {
+				return fmt.Errorf("snapshot data lives in the %s engine but the store is configured for %s", data.engine, s.engineName())
+			}
+			if data.bareValues {
+				return fmt.Errorf("engine files for tenant %q predate per-key metadata and cannot be read", tenantID)
+			}
+			if _, err := s.getTenantStore(tenantID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Discard on-disk data the snapshot supersedes
+		if err := s.destroyEngine(tenantID); err != nil {
+			return err
+		}
+		ts, err := s.getTenantStore(tenantID)
+		if err != nil {
+			return err
+		}
+		// Readers can see the tenant already when a live store is restored
+		ts.mu.Lock()
+		for key, value := range entries {
+			if err := ts.engine.Set(key, value); err != nil {
+				ts.mu.Unlock()
+				return err
+			}
+		}
+		ts.mu.Unlock()
+	}
+	return nil
+}
 
-	value, exists := ts.data[key]
-	if !exists {
-		return nil, false
+// Close stops background snapshots and closes the write-ahead log
+func (s *Store) Close() error {
+	if s.snapshotStop != nil {
+		close(s.snapshotStop)
+		<-s.snapshotDone
+		s.snapshotStop = nil
+	}
+	if s.sweepStop != nil {
+		close(s.sweepStop)
+		<-s.sweepDone
+		s.sweepStop = nil
 	}
 
-	// Return a copy to prevent external modifications
-	valueCopy := make([]byte, len(value))
-	copy(valueCopy, value)
-	return valueCopy, true
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	var err error
+	if s.wal != nil {
+		err = s.wal.Close()
+		s.wal = nil
+	}
+	if closeErr := s.closeTenants(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-// Delete removes a key from the tenant store
-func (ts *TenantStore) Delete(key string) bool {
-	ts.mu.Lock()
-	defer ts.mu.Unlock()
+// closeTenants closes every tenant's engine
+func (s *Store) closeTenants() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, exists := ts.data[key]
-	if exists {
-		delete(ts.data, key)
+	var firstErr error
+	for _, ts := range s.tenants {
+		if err := ts.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return exists
+	return firstErr
 }
 
-// Exists checks if a key exists in the tenant store
-func (ts *TenantStore) Exists(key string) bool {
-	ts.mu.RLock()
-	defer ts.mu.RUnlock()
+// waitReady waits until the WAL accepts appends. Mutations call it before
+// taking commitMu or a tenant's lock, which applying the records a new
+// leader inherits needs.
+func (s *Store) waitReady() error {
+	s.rlockCommit()
+	walLog := s.wal
+	s.commitMu.RUnlock()
 
-	_, exists := ts.data[key]
-	return exists
+	if walLog == nil {
+		return nil
+	}
+	return walLog.WaitReady(s.context())
 }
 
-// Keys returns all keys in the tenant store
-func (ts *TenantStore) Keys() []string {
-	ts.mu.RLock()
-	defer ts.mu.RUnlock()
+// logRecord appends a mutation to the WAL, if the store is durable, and
+// returns the store revision assigned to it. A durable store uses the WAL
+// index as the revision so that replay assigns exactly the same revisions.
+func (s *Store) logRecord(rec *walRecord) (uint64, error) {
+	if s.wal == nil {
+		return s.revision.Add(1), nil
+	}
+	revision, err := s.wal.AppendContext(s.context(), rec.encode())
+	if err != nil {
+		return 0, err
+	}
+	s.advanceRevision(revision)
+	return revision, nil
+}
 
-	keys := make([]string, 0, len(ts.data))
-	for key := range ts.data {
-		keys = append(keys, key)
+// advanceRevision moves the revision up to revision. Writers to different
+// tenants log concurrently, so they may get here out of order.
+func (s *Store) advanceRevision(revision uint64) {
+	for {
+		current := s.revision.Load()
+		if revision <= current || s.revision.CompareAndSwap(current, revision) {
+			return
+		}
 	}
-	return keys
 }
 
-// Size returns the number of keys in the tenant store
-func (ts *TenantStore) Size() int {
-	ts.mu.RLock()
-	defer ts.mu.RUnlock()
+// commitLocked logs rec, applies it and publishes its changes to watchers
+// and the change feed. what names the mutation in errors. Caller must hold
+// commitMu, and for mutations of a single tenant ts.mu exclusively.
+//
+// Once logged a mutation is committed: its changes are published even if
+// apply fails, since replaying the WAL will apply them.
+func (s *Store) commitLocked(ts *TenantStore, rec *walRecord, what string, apply func(revision uint64) error) (uint64, error) {
+	changes := s.changesLocked(ts, rec)
+
+	revision, err := s.logRecord(rec)
+	if err != nil {
+		return 0, fmt.Errorf("failed to log %s: %w", what, err)
+	}
 
-	return len(ts.data)
+	err = apply(revision)
+	s.publishLocked(rec, revision, changes)
+	return revision, err
 }
 
-// Store represents the multi-tenant key-value store
-type Store struct {
-	tenants map[string]*TenantStore
-	mu      sync.RWMutex
+// changesLocked returns the changes rec is about to make, or nil if no
+// change feed wants them. Caller must hold commitMu, and ts.mu if ts is not
+// nil.
+func (s *Store) changesLocked(ts *TenantStore, rec *walRecord) []Change {
+	if s.feed == nil {
+		return nil
+	}
+	var old func(string) []byte
+	if ts != nil {
+		old = ts.oldValueLocked
+	}
+	return rec.changes(old)
 }
 
-// NewStore creates a new multi-tenant store
-func NewStore() *Store {
-	return &Store{
-		tenants: make(map[string]*TenantStore),
+// publishLocked hands the changes of a record applied at revision to
+// watchers and the change feed, caller must hold the locks of commitLocked
+func (s *Store) publishLocked(rec *walRecord, revision uint64, changes []Change) {
+	s.watch.publish(rec.tenant, rec.events(revision))
+	if s.feed != nil {
+		for i := range changes {
+			changes[i].Revision = revision
+		}
+		s.feed.deliver(revision, changes)
+	}
+}
+
+// Revision returns the revision of the most recent mutation
+func (s *Store) Revision() uint64 {
+	s.commitMu.RLock()
+	defer s.commitMu.RUnlock()
+
+	return s.revisionLocked()
+}
+
+// revisionLocked returns the current revision, caller must hold commitMu
+func (s *Store) revisionLocked() uint64 {
+	return s.revision.Load()
+}
+
+// apply replays a logged mutation without logging it again. Replaying a
+// record that a persistent engine already holds is harmless.
+func (s *Store) apply(rec *walRecord, revision uint64) error {
+	switch rec.op {
+	case opSet, opDelete, opExpire:
+		ts, err := s.getTenantStore(rec.tenant)
+		if err != nil {
+			return err
+		}
+		switch rec.op {
+		case opSet:
+			return ts.setLocked(rec.key, entry{value: rec.value, expireAt: rec.expireAt, version: revision})
+		case opExpire:
+			_, err = ts.expireLocked(rec.key, rec.expireAt)
+		default:
+			_, err = ts.deleteLocked(rec.key)
+		}
+		return err
+	case opBatch:
+		ts, err := s.getTenantStore(rec.tenant)
+		if err != nil {
+			return err
+		}
+		return ts.applyBatchLocked(rec.ops, revision)
+	case opDeleteTenant:
+		return s.dropTenant(rec.tenant)
 	}
+	return nil
 }
 
 // getTenantStore retrieves or creates a tenant store
-func (s *Store) getTenantStore(tenantID string) *TenantStore {
+func (s *Store) getTenantStore(tenantID string) (*TenantStore, error) {
 	// First try with read lock for performance
 	s.mu.RLock()
 	tenantStore, exists := s.tenants[tenantID]
 	s.mu.RUnlock()
 
 	if exists {
-		return tenantStore
+		return tenantStore, nil
 	}
 
 	// Need to create, acquire write lock
@@ -123,29 +448,110 @@ func (s *Store) getTenantStore(tenantID string) *TenantStore {
 	// Double-check after acquiring write lock (another goroutine might have created it)
 	tenantStore, exists = s.tenants[tenantID]
 	if exists {
-		return tenantStore
+		return tenantStore, nil
 	}
 
-	// Create new tenant store
-	tenantStore = NewTenantStore()
+	// Create new tenant store on top of the configured engine
+	e, err := s.openEngine(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open engine for tenant %q: %w", tenantID, err)
+	}
+	tenantStore = newTenantStore(e)
 	s.tenants[tenantID] = tenantStore
-	return tenantStore
+	return tenantStore, nil
+}
+
+// dropTenant closes and removes a tenant, caller must hold commitMu exclusively
+func (s *Store) dropTenant(tenantID string) error {
+	s.mu.Lock()
+	tenantStore, exists := s.tenants[tenantID]
+	delete(s.tenants, tenantID)
+	s.mu.Unlock()
+
+	if exists {
+		if err := tenantStore.close(); err != nil {
+			slog.Error("Failed to close tenant engine", "tenant", tenantID, "err", err)
+		}
+	}
+	return s.destroyEngine(tenantID)
+}
+
+// SetOptions controls a write made with SetWithOptions
+type SetOptions struct {
+	TTL          time.Duration // Expire the key after this long, 0 keeps it until deleted
+	Precondition Precondition  // Checked against the key before writing
 }
 
 // Set stores a key-value pair for a specific tenant
 func (s *Store) Set(tenantID, key string, value []byte) error {
+	_, err := s.SetWithOptions(tenantID, key, value, SetOptions{})
+	return err
+}
+
+// SetWithTTL stores a key-value pair that expires after ttl. A ttl of 0
+// stores the key without expiry; either way any previous expiry is replaced.
+func (s *Store) SetWithTTL(tenantID, key string, value []byte, ttl time.Duration) error {
+	_, err := s.SetWithOptions(tenantID, key, value, SetOptions{TTL: ttl})
+	return err
+}
+
+// SetWithOptions stores a key-value pair and returns the key's new version.
+// If the precondition does not hold nothing is written and the error wraps
+// ErrPreconditionFailed.
+func (s *Store) SetWithOptions(tenantID, key string, value []byte, opts SetOptions) (uint64, error) {
+	s, span := s.startOp("Set", tenantID)
+	defer span.End()
+
 	if tenantID == "" {
-		return fmt.Errorf("tenant ID cannot be empty")
+		return 0, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if key == "" {
+		return 0, errorf(ErrInvalidArgument, "key cannot be empty")
+	}
+	if opts.TTL < 0 {
+		return 0, errorf(ErrInvalidArgument, "ttl cannot be negative")
+	}
+
+	if err := s.waitReady(); err != nil {
+		return 0, err
+	}
+	s.rlockCommit()
+	defer s.commitMu.RUnlock()
+
+	tenantStore, err := s.getTenantStore(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	tenantStore.lock(s.context())
+	defer tenantStore.mu.Unlock()
+
+	if opts.Precondition.Kind != PreconditionNone {
+		current, exists := tenantStore.getLocked(key)
+		if err := opts.Precondition.check(current, exists); err != nil {
+			return 0, err
+		}
 	}
 
-	tenantStore := s.getTenantStore(tenantID)
-	return tenantStore.Set(key, value)
+	rec := &walRecord{op: opSet, tenant: tenantID, key: key, value: value, expireAt: expireAtFor(opts.TTL)}
+	return s.commitLocked(tenantStore, rec, "set", func(revision uint64) error {
+		return tenantStore.setLocked(key, entry{value: value, expireAt: rec.expireAt, version: revision})
+	})
 }
 
 // Get retrieves a value for a key from a specific tenant
 func (s *Store) Get(tenantID, key string) ([]byte, bool) {
+	value, _, found := s.GetWithVersion(tenantID, key)
+	return value, found
+}
+
+// GetWithVersion retrieves a value along with the key's version, the store
+// revision at which the key was last written
+func (s *Store) GetWithVersion(tenantID, key string) ([]byte, uint64, bool) {
+	s, span := s.startOp("Get", tenantID)
+	defer span.End()
+
 	if tenantID == "" {
-		return nil, false
+		return nil, 0, false
 	}
 
 	s.mu.RLock()
@@ -153,31 +559,75 @@ func (s *Store) Get(tenantID, key string) ([]byte, bool) {
 	s.mu.RUnlock()
 
 	if !exists {
-		return nil, false
+		return nil, 0, false
+	}
+
+	e, found := tenantStore.lookup(s.context(), key)
+	if !found {
+		return nil, 0, false
 	}
 
-	return tenantStore.Get(key)
+	// Return a copy to prevent external modifications
+	valueCopy := make([]byte, len(e.value))
+	copy(valueCopy, e.value)
+	return valueCopy, e.version, true
+}
+
+// Delete removes a key from a specific tenant. It reports whether the key
+// existed; an error means the deletion could not be made durable.
+func (s *Store) Delete(tenantID, key string) (bool, error) {
+	return s.DeleteWithPrecondition(tenantID, key, Precondition{})
 }
 
-// Delete removes a key from a specific tenant
-func (s *Store) Delete(tenantID, key string) bool {
+// DeleteWithPrecondition removes a key if the precondition holds. A failed
+// precondition is reported as an error wrapping ErrPreconditionFailed.
+func (s *Store) DeleteWithPrecondition(tenantID, key string, pre Precondition) (bool, error) {
+	s, span := s.startOp("Delete", tenantID)
+	defer span.End()
+
 	if tenantID == "" {
-		return false
+		return false, nil
 	}
 
+	if err := s.waitReady(); err != nil {
+		return false, err
+	}
+	s.rlockCommit()
+	defer s.commitMu.RUnlock()
+
 	s.mu.RLock()
 	tenantStore, exists := s.tenants[tenantID]
 	s.mu.RUnlock()
 
 	if !exists {
-		return false
+		return false, pre.check(entry{}, false)
+	}
+
+	tenantStore.lock(s.context())
+	defer tenantStore.mu.Unlock()
+
+	current, exists := tenantStore.getLocked(key)
+	if err := pre.check(current, exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
 	}
 
-	return tenantStore.Delete(key)
+	var deleted bool
+	_, err := s.commitLocked(tenantStore, &walRecord{op: opDelete, tenant: tenantID, key: key}, "delete", func(uint64) error {
+		var err error
+		deleted, err = tenantStore.deleteLocked(key)
+		return err
+	})
+	return deleted, err
 }
 
 // Exists checks if a key exists for a specific tenant
 func (s *Store) Exists(tenantID, key string) bool {
+	s, span := s.startOp("Exists", tenantID)
+	defer span.End()
+
 	if tenantID == "" {
 		return false
 	}
@@ -190,11 +640,15 @@ func (s *Store) Exists(tenantID, key string) bool {
 		return false
 	}
 
-	return tenantStore.Exists(key)
+	_, found := tenantStore.lookup(s.context(), key)
+	return found
 }
 
 // Keys returns all keys for a specific tenant
 func (s *Store) Keys(tenantID string) []string {
+	s, span := s.startOp("Keys", tenantID)
+	defer span.End()
+
 	if tenantID == "" {
 		return []string{}
 	}
@@ -219,13 +673,27 @@ func (s *Store) TenantCount() int {
 }
 
 // DeleteTenant removes an entire tenant and all its data
-func (s *Store) DeleteTenant(tenantID string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *Store) DeleteTenant(tenantID string) (bool, error) {
+	s, span := s.startOp("DeleteTenant", tenantID)
+	defer span.End()
 
+	if err := s.waitReady(); err != nil {
+		return false, err
+	}
+	s.lockCommit()
+	defer s.commitMu.Unlock()
+
+	s.mu.RLock()
 	_, exists := s.tenants[tenantID]
-	if exists {
-		delete(s.tenants, tenantID)
+	s.mu.RUnlock()
+
+	if !exists {
+		return false, nil
 	}
-	return exists
+
+	// Once logged, the tenant is deleted even if dropping its data fails
+	revision, err := s.commitLocked(nil, &walRecord{op: opDeleteTenant, tenant: tenantID}, "tenant deletion", func(uint64) error {
+		return s.dropTenant(tenantID)
+	})
+	return revision != 0, err
 }
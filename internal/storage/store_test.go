@@ -233,7 +233,7 @@ func TestStore_EmptyTenantID(t *testing.T) {
 	}
 
 	// Test Delete with empty tenant ID
-	deleted := store.Delete("", "key")
+	deleted, _ := store.Delete("", "key")
 	if deleted {
 		t.Fatal("Should not delete with empty tenant ID")
 	}
@@ -287,7 +287,10 @@ func TestStore_DeleteTenant(t *testing.T) {
 	}
 
 	// Delete tenant
-	deleted := store.DeleteTenant(tenant)
+	deleted, err := store.DeleteTenant(tenant)
+	if err != nil {
+		t.Fatalf("DeleteTenant failed: %v", err)
+	}
 	if !deleted {
 		t.Fatal("DeleteTenant should return true")
 	}
@@ -298,7 +301,7 @@ func TestStore_DeleteTenant(t *testing.T) {
 	}
 
 	// Try deleting again
-	deleted = store.DeleteTenant(tenant)
+	deleted, _ = store.DeleteTenant(tenant)
 	if deleted {
 		t.Fatal("DeleteTenant should return false for nonexistent tenant")
 	}
@@ -364,6 +367,74 @@ func TestStore_ValueIsolation(t *testing.T) {
 	}
 }
 
+func TestStore_RecoverFromWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	store.Set("tenant1", "key1", []byte("value1"))
+	store.Set("tenant1", "key2", []byte("value2"))
+	store.Set("tenant1", "key1", []byte("updated"))
+	store.Delete("tenant1", "key2")
+	store.Set("tenant2", "key", []byte("value"))
+	store.Set("tenant3", "key", []byte("value"))
+	store.DeleteTenant("tenant3")
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Reopen and verify the log was replayed
+	store, err = Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer store.Close()
+
+	value, exists := store.Get("tenant1", "key1")
+	if !exists || string(value) != "updated" {
+		t.Fatalf("Expected 'updated' for key1, got %q", value)
+	}
+
+	if store.Exists("tenant1", "key2") {
+		t.Fatal("Deleted key should not be recovered")
+	}
+
+	if !store.Exists("tenant2", "key") {
+		t.Fatal("tenant2 key should be recovered")
+	}
+
+	if store.TenantCount() != 2 {
+		t.Fatalf("Expected 2 tenants after recovery, got %d", store.TenantCount())
+	}
+}
+
+func TestStore_DeleteMissingKeyIsNotLogged(t *testing.T) {
+	dir := t.TempDir()
+
+	store, _ := Open(DefaultOptions(dir))
+	defer store.Close()
+
+	store.Set("tenant", "key", []byte("value"))
+	deleted, err := store.Delete("tenant", "missing")
+	if err != nil || deleted {
+		t.Fatalf("Expected (false, nil), got (%v, %v)", deleted, err)
+	}
+
+	if store.wal.LastIndex() != 1 {
+		t.Fatalf("Expected only the set to be logged, last index is %d", store.wal.LastIndex())
+	}
+}
+
+func TestStore_OpenEmptyDataDir(t *testing.T) {
+	if _, err := Open(Options{}); err == nil {
+		t.Fatal("Expected error for empty data directory")
+	}
+}
+
 func BenchmarkTenantStore_Set(b *testing.B) {
 	ts := NewTenantStore()
 	value := []byte("benchmark-value")
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/ayushgala/tinkerdb/internal/engine"
+	"github.com/ayushgala/tinkerdb/internal/engine/memory"
+	"github.com/ayushgala/tinkerdb/internal/logging"
+)
+
+// SystemTenantPrefix starts the IDs of the tenants that hold the server's own
+// state, such as authentication tokens. Their changes are not published for
+// change data capture, and the server does not let clients address them.
+const SystemTenantPrefix = "__"
+
+// IsSystemTenant reports whether tenantID is reserved for the server's state
+func IsSystemTenant(tenantID string) bool {
+	return strings.HasPrefix(tenantID, SystemTenantPrefix)
+}
+
+// TenantStore represents a key-value store for a single tenant. The data
+// itself lives in a pluggable engine; TenantStore adds locking, per-key
+// metadata such as expiry, and keeps callers from sharing byte slices with
+// the engine.
+type TenantStore struct {
+	engine engine.Engine
+	mu     sync.RWMutex
+
+	sweepCursor string // Where the expiry sweeper resumes, only used by the sweeper
+
+	views map[*tenantView]struct{} // Open point-in-time views, see Scanner
+}
+
+// NewTenantStore creates a new tenant store backed by an in-memory engine
+func NewTenantStore() *TenantStore {
+	return newTenantStore(memory.New())
+}
+
+func newTenantStore(e engine.Engine) *TenantStore {
+	return &TenantStore{
+		engine: e,
+		views:  make(map[*tenantView]struct{}),
+	}
+}
+
+// Set stores a key-value pair in the tenant store
+func (ts *TenantStore) Set(key string, value []byte) error {
+	if key == "" {
+		return errorf(ErrInvalidArgument, "key cannot be empty")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.setLocked(key, entry{value: value})
+}
+
+// setLocked stores an entry, caller must hold ts.mu. Encoding the entry
+// copies its value, so later changes by the caller are not seen.
+func (ts *TenantStore) setLocked(key string, e entry) error {
+	if len(ts.views) > 0 {
+		ts.preserveLocked(key)
+	}
+	return ts.engine.Set(key, encodeEntry(e))
+}
+
+// readLocked decodes the stored entry for key whether or not it has
+// expired, caller must hold ts.mu
+func (ts *TenantStore) readLocked(key string) (entry, bool) {
+	data, exists, err := ts.engine.Get(key)
+	if err != nil {
+		slog.Error("Engine get failed", logging.Key("key", key), "err", err)
+		return entry{}, false
+	}
+	if !exists {
+		return entry{}, false
+	}
+
+	e, err := decodeEntry(data)
+	if err != nil {
+		slog.Error("Failed to decode entry", logging.Key("key", key), "err", err)
+		return entry{}, false
+	}
+	return e, true
+}
+
+// getLocked returns the live entry for key, caller must hold ts.mu. Expired
+// entries are reported as missing.
+func (ts *TenantStore) getLocked(key string) (entry, bool) {
+	e, exists := ts.readLocked(key)
+	if !exists || e.expired(timeNow().UnixNano()) {
+		return entry{}, false
+	}
+	return e, true
+}
+
+// Get retrieves a value for a key from the tenant store
+func (ts *TenantStore) Get(key string) ([]byte, bool) {
+	e, exists := ts.lookup(context.Background(), key)
+	if !exists {
+		return nil, false
+	}
+
+	// Return a copy to prevent external modifications
+	valueCopy := make([]byte, len(e.value))
+	copy(valueCopy, e.value)
+	return valueCopy, true
+}
+
+// lookup reads the live entry for key, reclaiming it if it has expired. A
+// wait for the tenant lock is traced as a span of ctx.
+func (ts *TenantStore) lookup(ctx context.Context, key string) (entry, bool) {
+	ts.rlock(ctx)
+	e, exists := ts.readLocked(key)
+	ts.mu.RUnlock()
+
+	if exists && e.expired(timeNow().UnixNano()) {
+		ts.reclaim([]string{key})
+		return entry{}, false
+	}
+	return e, exists
+}
+
+// reclaim removes the keys that have expired. Expiry is decided by the
+// timestamp stored with each key, so removing an expired key changes nothing
+// visible and needs no WAL record.
+func (ts *TenantStore) reclaim(keys []string) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := timeNow().UnixNano()
+	reclaimed := 0
+	for _, key := range keys {
+		if e, exists := ts.readLocked(key); !exists || !e.expired(now) {
+			continue
+		}
+		if _, err := ts.deleteLocked(key); err != nil {
+			slog.Error("Failed to reclaim expired key", logging.Key("key", key), "err", err)
+			continue
+		}
+		reclaimed++
+	}
+	return reclaimed
+}
+
+// Delete removes a key from the tenant store
+func (ts *TenantStore) Delete(key string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.existsLocked(key) {
+		return false
+	}
+	deleted, err := ts.deleteLocked(key)
+	if err != nil {
+		slog.Error("Engine delete failed", logging.Key("key", key), "err", err)
+	}
+	return deleted
+}
+
+// deleteLocked removes key if present, caller must hold ts.mu
+func (ts *TenantStore) deleteLocked(key string) (bool, error) {
+	if len(ts.views) > 0 {
+		ts.preserveLocked(key)
+	}
+	return ts.engine.Delete(key)
+}
+
+// Exists checks if a key exists in the tenant store
+func (ts *TenantStore) Exists(key string) bool {
+	_, exists := ts.lookup(context.Background(), key)
+	return exists
+}
+
+// existsLocked reports whether a live key is present, caller must hold ts.mu
+func (ts *TenantStore) existsLocked(key string) bool {
+	_, exists := ts.getLocked(key)
+	return exists
+}
+
+// Keys returns all live keys in the tenant store in ascending order
+func (ts *TenantStore) Keys() []string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	now := timeNow().UnixNano()
+	keys := make([]string, 0, ts.engine.Len())
+	err := ts.engine.Iterate("", "", func(key string, data []byte) bool {
+		if e, err := decodeEntry(data); err == nil && !e.expired(now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		slog.Error("Engine iteration failed", "err", err)
+	}
+	return keys
+}
+
+// Size returns the number of keys in the tenant store. Expired keys count
+// until they are reclaimed.
+func (ts *TenantStore) Size() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	return ts.engine.Len()
+}
+
+// close releases the engine once in-flight readers are done
+func (ts *TenantStore) close() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.engine.Close()
+}
+
+// freeze prepares the tenant for a snapshot. Persistent engines are synced
+// and nil is returned, unless inline is set; other engines have their
+// contents copied. It also returns the number of keys captured.
+func (ts *TenantStore) freeze(inline bool) (map[string][]byte, int, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	if p, ok := ts.engine.(engine.Persistent); ok && !inline {
+		return nil, ts.engine.Len(), p.Sync()
+	}
+
+	entries := make(map[string][]byte, ts.engine.Len())
+	err := ts.engine.Iterate("", "", func(key string, value []byte) bool {
+		entries[key] = value
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, len(entries), nil
+}
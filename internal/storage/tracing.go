@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/ayushgala/tinkerdb/internal/storage")
+
+// WithContext returns a copy of the store whose operations are traced as
+// children of the span in ctx: each operation, the waits for contended locks
+// and the write-ahead log fsyncs. The copy shares all state with s.
+func (s *Store) WithContext(ctx context.Context) *Store {
+	return &Store{storeState: s.storeState, ctx: ctx}
+}
+
+// context returns the parent of the store's spans
+func (s *Store) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// startOp starts the span of a store operation on a tenant, or on the
+// whole store if tenantID is empty. It returns a copy of s whose spans are
+// children of the operation's.
+func (s *Store) startOp(name, tenantID string) (*Store, trace.Span) {
+	ctx, span := tracer.Start(s.context(), "storage."+name)
+	if tenantID != "" {
+		span.SetAttributes(attribute.String("tinkerdb.tenant", tenantID))
+	}
+	return s.WithContext(ctx), span
+}
+
+// rlockCommit takes commitMu shared, as mutations do
+func (s *Store) rlockCommit() {
+	if !s.commitMu.TryRLock() {
+		waitLock(s.context(), "commit", s.commitMu.RLock)
+	}
+}
+
+// lockCommit takes commitMu exclusively, pausing every mutation
+func (s *Store) lockCommit() {
+	if !s.commitMu.TryLock() {
+		waitLock(s.context(), "commit", s.commitMu.Lock)
+	}
+}
+
+// lock takes the tenant's lock exclusively, as mutations do
+func (ts *TenantStore) lock(ctx context.Context) {
+	if !ts.mu.TryLock() {
+		waitLock(ctx, "tenant", ts.mu.Lock)
+	}
+}
+
+// rlock takes the tenant's lock shared, as reads do
+func (ts *TenantStore) rlock(ctx context.Context) {
+	if !ts.mu.TryRLock() {
+		waitLock(ctx, "tenant", ts.mu.RLock)
+	}
+}
+
+// waitLock blocks in lock, tracing the wait as a span of ctx. Callers try
+// the lock first so that an uncontended one costs no span.
+func waitLock(ctx context.Context, name string, lock func()) {
+	_, span := tracer.Start(ctx, "storage.lock_wait", trace.WithAttributes(attribute.String("tinkerdb.lock", name)))
+	lock()
+	span.End()
+}
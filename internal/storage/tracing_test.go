@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.InMemoryExporter
+)
+
+// recordSpans records the spans of the store from here on. The global
+// provider can only be set once, so it is shared by the tests.
+func recordSpans() *tracetest.InMemoryExporter {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder)))
+	})
+	recorder.Reset()
+	return recorder
+}
+
+func TestStore_Tracing(t *testing.T) {
+	spans := recordSpans()
+
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "request")
+	traced := store.WithContext(ctx)
+
+	// The write waits for a snapshot holding the commit lock
+	store.commitMu.Lock()
+	done := make(chan error)
+	go func() {
+		done <- traced.Set("tenant1", "key", []byte("value"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	store.commitMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, _ := traced.Get("tenant1", "key"); string(value) != "value" {
+		t.Fatalf("Expected value, got %q", value)
+	}
+	root.End()
+
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans.GetSpans() {
+		byName[span.Name] = span
+	}
+	set, get := byName["storage.Set"], byName["storage.Get"]
+	if set.Parent.SpanID() != root.SpanContext().SpanID() || get.Parent.SpanID() != root.SpanContext().SpanID() {
+		t.Fatalf("Expected the operations to be children of the request, got %v", spans.GetSpans())
+	}
+	for _, name := range []string{"storage.lock_wait", "wal.fsync"} {
+		if byName[name].Parent.SpanID() != set.SpanContext.SpanID() {
+			t.Fatalf("Expected a %s span under storage.Set, got %v", name, byName[name])
+		}
+	}
+	if wait := byName["storage.lock_wait"]; wait.EndTime.Sub(wait.StartTime) < 20*time.Millisecond {
+		t.Fatalf("Expected the lock wait to last until the lock was released, got %v", wait.EndTime.Sub(wait.StartTime))
+	}
+	if len(byName) != 5 {
+		t.Fatalf("Expected no spans but the request's, got %d", len(byName))
+	}
+
+	// Without a context operations are traced as roots
+	spans.Reset()
+	store.Delete("tenant1", "key")
+	if ended := spans.GetSpans(); len(ended) != 2 || ended[1].Parent.IsValid() {
+		t.Fatalf("Expected a root delete span, got %v", ended)
+	}
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+)
+
+// MaxTxnOps bounds the compares and the operations of either branch of a
+// transaction, keeping the time the tenant lock is held predictable
+const MaxTxnOps = 128
+
+// CompareTarget selects what a Compare inspects
+type CompareTarget int
+
+const (
+	CompareValue   CompareTarget = iota // The key's value, compared bytewise
+	CompareVersion                      // The key's version, 0 if the key does not exist
+	CompareExists                       // Whether the key exists
+)
+
+// CompareOp is the relation a Compare requires between the key and its operand
+type CompareOp int
+
+const (
+	CompareEqual CompareOp = iota
+	CompareNotEqual
+	CompareGreater
+	CompareLess
+)
+
+// Compare is a guard clause of a transaction. A value compare against a
+// missing key never holds; a missing key has version 0.
+type Compare struct {
+	Key    string
+	Target CompareTarget
+	Op     CompareOp
+
+	Value   []byte // Operand for CompareValue
+	Version uint64 // Operand for CompareVersion
+	Exists  bool   // Operand for CompareExists, only Equal and NotEqual apply
+}
+
+// TxnOpType identifies a transaction operation
+type TxnOpType int
+
+const (
+	TxnSet TxnOpType = iota
+	TxnGet
+	TxnDelete
+)
+
+// TxnOp is a single operation in a branch of a transaction
+type TxnOp struct {
+	Type  TxnOpType
+	Key   string
+	Value []byte        // Value for TxnSet
+	TTL   time.Duration // Expiry for TxnSet, 0 keeps the key until deleted
+}
+
+// Txn is an atomic compare-then-act transaction on one tenant. If every
+// compare holds the Success operations run, otherwise the Failure ones do.
+type Txn struct {
+	Compares []Compare
+	Success  []TxnOp
+	Failure  []TxnOp
+}
+
+// TxnOpResult is the outcome of one operation of the executed branch
+type TxnOpResult struct {
+	Type    TxnOpType
+	Key     string
+	Found   bool   // For gets and deletes, whether the key existed
+	Value   []byte // For gets, the value read
+	Version uint64 // For gets and sets, the key's version afterwards
+}
+
+// TxnResult is the outcome of a transaction
+type TxnResult struct {
+	Succeeded bool          // Every compare held and the Success branch ran
+	Revision  uint64        // Store revision the transaction observed or wrote at
+	Results   []TxnOpResult // One per operation of the branch that ran
+}
+
+// Txn executes a transaction atomically within a tenant. Operations see the
+// effects of earlier operations in the same branch, and all the writes of a
+// branch are logged as a single WAL record, so they share one revision and
+// recover together or not at all.
+func (s *Store) Txn(tenantID string, txn Txn) (*TxnResult, error) {
+	s, span := s.startOp("Txn", tenantID)
+	defer span.End()
+
+	if tenantID == "" {
+		return nil, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if err := txn.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.waitReady(); err != nil {
+		return nil, err
+	}
+	s.rlockCommit()
+	defer s.commitMu.RUnlock()
+
+	tenantStore, err := s.getTenantStore(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	tenantStore.lock(s.context())
+	defer tenantStore.mu.Unlock()
+
+	result := &TxnResult{Succeeded: true}
+	for _, cmp := range txn.Compares {
+		current, exists := tenantStore.getLocked(cmp.Key)
+		if !cmp.holds(current, exists) {
+			result.Succeeded = false
+			break
+		}
+	}
+
+	ops := txn.Success
+	if !result.Succeeded {
+		ops = txn.Failure
+	}
+
+	// Run the branch against an overlay of its own writes so nothing reaches
+	// the engine until the whole batch is logged
+	batch := &txnBatch{ts: tenantStore, pending: make(map[string]*entry)}
+	result.Results = make([]TxnOpResult, len(ops))
+	for i, op := range ops {
+		result.Results[i] = batch.run(i, op)
+	}
+
+	if len(batch.ops) == 0 {
+		result.Revision = s.revisionLocked()
+		return result, nil
+	}
+
+	rec := &walRecord{op: opBatch, tenant: tenantID, ops: batch.ops}
+	revision, err := s.commitLocked(tenantStore, rec, "transaction", func(revision uint64) error {
+		return tenantStore.applyBatchLocked(batch.ops, revision)
+	})
+	if revision == 0 {
+		return nil, err
+	}
+	result.Revision = revision
+	for _, i := range batch.written {
+		result.Results[i].Version = revision
+	}
+	return result, err
+}
+
+// validate rejects malformed transactions before any lock is taken
+func (txn *Txn) validate() error {
+	if len(txn.Compares) > MaxTxnOps || len(txn.Success) > MaxTxnOps || len(txn.Failure) > MaxTxnOps {
+		return errorf(ErrLimitExceeded, "transaction exceeds %d compares or operations per branch", MaxTxnOps)
+	}
+	for _, cmp := range txn.Compares {
+		if cmp.Key == "" {
+			return errorf(ErrInvalidArgument, "compare key cannot be empty")
+		}
+		if cmp.Target < CompareValue || cmp.Target > CompareExists || cmp.Op < CompareEqual || cmp.Op > CompareLess {
+			return errorf(ErrInvalidArgument, "invalid compare on %q", cmp.Key)
+		}
+		if cmp.Target == CompareExists && cmp.Op != CompareEqual && cmp.Op != CompareNotEqual {
+			return errorf(ErrInvalidArgument, "existence compare on %q must be equal or not equal", cmp.Key)
+		}
+	}
+	for _, ops := range [][]TxnOp{txn.Success, txn.Failure} {
+		for _, op := range ops {
+			if op.Key == "" {
+				return errorf(ErrInvalidArgument, "operation key cannot be empty")
+			}
+			if op.Type < TxnSet || op.Type > TxnDelete {
+				return errorf(ErrInvalidArgument, "invalid operation on %q", op.Key)
+			}
+			if op.TTL < 0 {
+				return errorf(ErrInvalidArgument, "ttl cannot be negative")
+			}
+		}
+	}
+	return nil
+}
+
+// holds evaluates the compare against the key's current live entry
+func (cmp Compare) holds(current entry, exists bool) bool {
+	var order int
+	switch cmp.Target {
+	case CompareValue:
+		if !exists {
+			return false
+		}
+		order = bytes.Compare(current.value, cmp.Value)
+	case CompareVersion:
+		switch {
+		case current.version < cmp.Version:
+			order = -1
+		case current.version > cmp.Version:
+			order = 1
+		}
+	case CompareExists:
+		if exists != cmp.Exists {
+			order = 1
+		}
+	default:
+		return false
+	}
+
+	switch cmp.Op {
+	case CompareEqual:
+		return order == 0
+	case CompareNotEqual:
+		return order != 0
+	case CompareGreater:
+		return order > 0
+	case CompareLess:
+		return order < 0
+	}
+	return false
+}
+
+// txnBatch collects the writes of a transaction branch. pending holds the
+// branch's own writes, a nil entry marking a key it deleted.
+type txnBatch struct {
+	ts      *TenantStore
+	pending map[string]*entry
+	ops     []walRecord
+	written []int // Results whose version is the revision being written
+}
+
+// get reads a key as the branch currently sees it
+func (b *txnBatch) get(key string) (entry, bool) {
+	if e, ok := b.pending[key]; ok {
+		if e == nil {
+			return entry{}, false
+		}
+		return *e, true
+	}
+	return b.ts.getLocked(key)
+}
+
+// run executes the i-th operation of the branch and returns its result
+func (b *txnBatch) run(i int, op TxnOp) TxnOpResult {
+	result := TxnOpResult{Type: op.Type, Key: op.Key}
+	switch op.Type {
+	case TxnGet:
+		if e, exists := b.get(op.Key); exists {
+			result.Found = true
+			result.Value = append([]byte(nil), e.value...)
+			result.Version = e.version
+			if _, own := b.pending[op.Key]; own {
+				b.written = append(b.written, i)
+			}
+		}
+	case TxnSet:
+		e := &entry{value: op.Value, expireAt: expireAtFor(op.TTL)}
+		b.pending[op.Key] = e
+		b.ops = append(b.ops, walRecord{op: opSet, key: op.Key, value: op.Value, expireAt: e.expireAt})
+		b.written = append(b.written, i)
+	case TxnDelete:
+		if _, exists := b.get(op.Key); exists {
+			result.Found = true
+			b.pending[op.Key] = nil
+			b.ops = append(b.ops, walRecord{op: opDelete, key: op.Key})
+		}
+	}
+	return result
+}
+
+// applyBatchLocked applies the operations of a batch record, every write
+// taking the batch's revision, caller must hold ts.mu
+func (ts *TenantStore) applyBatchLocked(ops []walRecord, revision uint64) error {
+	for _, op := range ops {
+		var err error
+		switch op.op {
+		case opSet:
+			err = ts.setLocked(op.key, entry{value: op.value, expireAt: op.expireAt, version: revision})
+		case opDelete:
+			_, err = ts.deleteLocked(op.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
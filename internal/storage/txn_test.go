@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// transfer moves amount from one counter to another if the source has not
+// changed since it was read
+func transfer(store *Store, from, to string, amount int) (bool, error) {
+	fromValue, fromVersion, _ := store.GetWithVersion("bank", from)
+	toValue, toVersion, _ := store.GetWithVersion("bank", to)
+	fromBalance, _ := strconv.Atoi(string(fromValue))
+	toBalance, _ := strconv.Atoi(string(toValue))
+
+	result, err := store.Txn("bank", Txn{
+		Compares: []Compare{
+			{Key: from, Target: CompareVersion, Op: CompareEqual, Version: fromVersion},
+			{Key: to, Target: CompareVersion, Op: CompareEqual, Version: toVersion},
+		},
+		Success: []TxnOp{
+			{Type: TxnSet, Key: from, Value: []byte(strconv.Itoa(fromBalance - amount))},
+			{Type: TxnSet, Key: to, Value: []byte(strconv.Itoa(toBalance + amount))},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.Succeeded, nil
+}
+
+func TestStore_TxnBranches(t *testing.T) {
+	store := NewStore()
+	store.Set("tenant", "a", []byte("1"))
+
+	result, err := store.Txn("tenant", Txn{
+		Compares: []Compare{{Key: "a", Target: CompareValue, Op: CompareEqual, Value: []byte("1")}},
+		Success: []TxnOp{
+			{Type: TxnSet, Key: "b", Value: []byte("2")},
+			{Type: TxnGet, Key: "b"},
+			{Type: TxnDelete, Key: "a"},
+			{Type: TxnGet, Key: "a"},
+		},
+		Failure: []TxnOp{{Type: TxnSet, Key: "failed", Value: []byte("x")}},
+	})
+	if err != nil {
+		t.Fatalf("Txn failed: %v", err)
+	}
+	if !result.Succeeded {
+		t.Fatal("Expected the compare to hold")
+	}
+	if len(result.Results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(result.Results))
+	}
+
+	// Later operations see earlier writes of the same branch
+	get := result.Results[1]
+	if !get.Found || string(get.Value) != "2" || get.Version != result.Revision {
+		t.Fatalf("Expected to read own write at revision %d, got %+v", result.Revision, get)
+	}
+	if !result.Results[2].Found || result.Results[3].Found {
+		t.Fatalf("Expected a deleted then missing key, got %+v", result.Results[2:])
+	}
+	if store.Exists("tenant", "a") || store.Exists("tenant", "failed") {
+		t.Fatal("Expected only the success branch to apply")
+	}
+	if _, version, _ := store.GetWithVersion("tenant", "b"); version != result.Revision {
+		t.Fatalf("Expected version %d, got %d", result.Revision, version)
+	}
+
+	// The value changed, so the failure branch runs now
+	result, err = store.Txn("tenant", Txn{
+		Compares: []Compare{{Key: "b", Target: CompareValue, Op: CompareEqual, Value: []byte("1")}},
+		Success:  []TxnOp{{Type: TxnSet, Key: "succeeded", Value: []byte("x")}},
+		Failure:  []TxnOp{{Type: TxnGet, Key: "b"}},
+	})
+	if err != nil {
+		t.Fatalf("Txn failed: %v", err)
+	}
+	if result.Succeeded || !result.Results[0].Found || store.Exists("tenant", "succeeded") {
+		t.Fatalf("Expected the failure branch to run, got %+v", result)
+	}
+}
+
+func TestStore_TxnCompares(t *testing.T) {
+	store := NewStore()
+	version, _ := store.SetWithOptions("tenant", "key", []byte("m"), SetOptions{})
+
+	tests := []struct {
+		name string
+		cmp  Compare
+		want bool
+	}{
+		{"value equal", Compare{Key: "key", Target: CompareValue, Op: CompareEqual, Value: []byte("m")}, true},
+		{"value greater", Compare{Key: "key", Target: CompareValue, Op: CompareGreater, Value: []byte("a")}, true},
+		{"value less", Compare{Key: "key", Target: CompareValue, Op: CompareLess, Value: []byte("a")}, false},
+		{"value of missing key", Compare{Key: "missing", Target: CompareValue, Op: CompareNotEqual, Value: []byte("m")}, false},
+		{"version equal", Compare{Key: "key", Target: CompareVersion, Op: CompareEqual, Version: version}, true},
+		{"version not equal", Compare{Key: "key", Target: CompareVersion, Op: CompareNotEqual, Version: version}, false},
+		{"missing key version", Compare{Key: "missing", Target: CompareVersion, Op: CompareEqual, Version: 0}, true},
+		{"exists", Compare{Key: "key", Target: CompareExists, Op: CompareEqual, Exists: true}, true},
+		{"not exists", Compare{Key: "missing", Target: CompareExists, Op: CompareNotEqual, Exists: true}, true},
+	}
+
+	for _, tt := range tests {
+		result, err := store.Txn("tenant", Txn{Compares: []Compare{tt.cmp}})
+		if err != nil {
+			t.Fatalf("%s: Txn failed: %v", tt.name, err)
+		}
+		if result.Succeeded != tt.want {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, result.Succeeded)
+		}
+	}
+}
+
+func TestStore_TxnValidation(t *testing.T) {
+	store := NewStore()
+
+	invalid := []Txn{
+		{Compares: []Compare{{Key: ""}}},
+		{Compares: []Compare{{Key: "k", Target: CompareExists, Op: CompareGreater}}},
+		{Success: []TxnOp{{Type: TxnSet, Key: ""}}},
+		{Failure: []TxnOp{{Type: TxnOpType(42), Key: "k"}}},
+		{Success: make([]TxnOp, MaxTxnOps+1)},
+	}
+	for i, txn := range invalid {
+		if _, err := store.Txn("tenant", txn); err == nil {
+			t.Fatalf("Expected transaction %d to be rejected", i)
+		}
+	}
+}
+
+func TestStore_TxnIsAtomic(t *testing.T) {
+	store := NewStore()
+	store.Set("bank", "alice", []byte("1000"))
+	store.Set("bank", "bob", []byte("1000"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			from, to := "alice", "bob"
+			if i%2 == 0 {
+				from, to = to, from
+			}
+			for moved := 0; moved < 20; {
+				ok, err := transfer(store, from, to, 7)
+				if err != nil {
+					t.Errorf("Transfer failed: %v", err)
+					return
+				}
+				if ok {
+					moved++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	alice, _ := store.Get("bank", "alice")
+	bob, _ := store.Get("bank", "bob")
+	a, _ := strconv.Atoi(string(alice))
+	b, _ := strconv.Atoi(string(bob))
+	if a+b != 2000 {
+		t.Fatalf("Expected the total to stay 2000, got %d + %d", a, b)
+	}
+}
+
+func TestStore_TxnRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	store.Set("bank", "alice", []byte("100"))
+	if ok, err := transfer(store, "alice", "bob", 30); err != nil || !ok {
+		t.Fatalf("Transfer = (%v, %v), expected (true, nil)", ok, err)
+	}
+	_, version, _ := store.GetWithVersion("bank", "bob")
+	store.Close()
+
+	store = openTestStore(t, dir)
+	defer store.Close()
+
+	alice, _ := store.Get("bank", "alice")
+	bob, bobVersion, _ := store.GetWithVersion("bank", "bob")
+	if string(alice) != "70" || string(bob) != "30" {
+		t.Fatalf("Expected 70 and 30 after recovery, got %s and %s", alice, bob)
+	}
+	if bobVersion != version {
+		t.Fatalf("Expected version %d after recovery, got %d", version, bobVersion)
+	}
+}
+
+func TestWALRecord_BatchRoundTrip(t *testing.T) {
+	rec := walRecord{op: opBatch, tenant: "tenant", ops: []walRecord{
+		{op: opSet, key: "a", value: []byte("1"), expireAt: 42},
+		{op: opDelete, key: "b"},
+	}}
+
+	decoded, err := decodeWALRecord(rec.encode())
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.tenant != "tenant" || len(decoded.ops) != 2 {
+		t.Fatalf("Unexpected batch: %+v", decoded)
+	}
+	set, del := decoded.ops[0], decoded.ops[1]
+	if set.op != opSet || set.key != "a" || string(set.value) != "1" || set.expireAt != 42 {
+		t.Fatalf("Unexpected set: %+v", set)
+	}
+	if del.op != opDelete || del.key != "b" {
+		t.Fatalf("Unexpected delete: %+v", del)
+	}
+}
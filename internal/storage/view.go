@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+
+	"github.com/ayushgala/tinkerdb/internal/engine/skiplist"
+	"github.com/ayushgala/tinkerdb/internal/logging"
+)
+
+// tenantView is a point-in-time view of a range of a tenant, used to stream
+// long scans without holding the tenant lock for their whole duration.
+//
+// The view reads the live engine in short chunks. Before a write changes a
+// key the view still has to visit, the key's value at the time the view was
+// opened is saved as a preimage, so the view sees the tenant exactly as it
+// was. Keys the view has already passed are not saved, which keeps the
+// preimages of a forward-moving scan small.
+type tenantView struct {
+	start, end string
+	reverse    bool
+	now        int64 // Expiry is judged at the time the view was opened
+
+	// pos is the boundary of what has been visited: keys below it for a
+	// forward view, keys at or above it for a reverse one. done marks an
+	// exhausted view.
+	pos  string
+	done bool
+
+	preimages map[string][]byte // Saved engine data, nil if the key did not exist
+	index     *skiplist.List    // Ordered keys of preimages
+}
+
+// needs reports whether the view will still visit key
+func (v *tenantView) needs(key string) bool {
+	if v.done || key < v.start || (v.end != "" && key >= v.end) {
+		return false
+	}
+	if v.reverse {
+		return v.pos == "" || key < v.pos
+	}
+	return key >= v.pos
+}
+
+// preserveLocked saves the current data of key for every open view that
+// still needs it, caller must hold ts.mu exclusively and call it before
+// changing the key in the engine
+func (ts *TenantStore) preserveLocked(key string) {
+	var data []byte
+	read := false
+	for v := range ts.views {
+		if !v.needs(key) {
+			continue
+		}
+		if _, saved := v.preimages[key]; saved {
+			continue
+		}
+		if !read {
+			current, exists, err := ts.engine.Get(key)
+			if err != nil {
+				slog.Error("Engine get failed", logging.Key("key", key), "err", err)
+			}
+			if exists {
+				data = append([]byte{}, current...)
+			}
+			read = true
+		}
+		v.preimages[key] = data
+		v.index.Insert(key)
+	}
+}
+
+// Scanner streams a range of keys from a consistent point-in-time view of a
+// tenant. It must be closed to release the view.
+type Scanner struct {
+	ts       *TenantStore
+	view     *tenantView
+	revision uint64
+	keysOnly bool
+	limit    int
+	emitted  int
+}
+
+// NewScanner opens a scanner over the keys selected by opts as they are at
+// the moment of the call. Limit caps the total number of keys returned, 0
+// returns the whole range. A Cursor resumes after the key it names.
+func (s *Store) NewScanner(tenantID string, opts ScanOptions) (*Scanner, error) {
+	s, span := s.startOp("NewScanner", tenantID)
+	defer span.End()
+
+	if tenantID == "" {
+		return nil, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if opts.Limit < 0 {
+		return nil, errorf(ErrInvalidArgument, "limit cannot be negative")
+	}
+
+	s.rlockCommit()
+	defer s.commitMu.RUnlock()
+
+	s.mu.RLock()
+	ts, exists := s.tenants[tenantID]
+	s.mu.RUnlock()
+
+	start, end := opts.scanBounds()
+	view := &tenantView{
+		start:     start,
+		end:       end,
+		reverse:   opts.Reverse,
+		now:       timeNow().UnixNano(),
+		preimages: make(map[string][]byte),
+		index:     skiplist.New(),
+	}
+	view.pos = start
+	if opts.Reverse {
+		view.pos = end
+	}
+	sc := &Scanner{ts: ts, view: view, keysOnly: opts.KeysOnly, limit: opts.Limit}
+
+	if !exists || (end != "" && start >= end) {
+		view.done = true
+		sc.ts = nil
+		sc.revision = s.revisionLocked()
+		return sc, nil
+	}
+
+	// No write to the tenant is in flight while its lock is held, so the
+	// view starts exactly at the current revision
+	ts.lock(s.context())
+	ts.views[view] = struct{}{}
+	sc.revision = s.revisionLocked()
+	ts.mu.Unlock()
+	return sc, nil
+}
+
+// Revision returns the store revision the scanner's view was taken at
+func (sc *Scanner) Revision() uint64 {
+	return sc.revision
+}
+
+// Next returns up to about n further keys in order, or io.EOF once the range
+// is exhausted. The tenant lock is only held while a single batch is read.
+func (sc *Scanner) Next(n int) ([]KeyValue, error) {
+	if n <= 0 {
+		return nil, errorf(ErrInvalidArgument, "batch size must be positive")
+	}
+	if sc.ts == nil || sc.view.done {
+		return nil, io.EOF
+	}
+	if sc.limit > 0 {
+		n = min(n, sc.limit-sc.emitted)
+	}
+
+	sc.ts.mu.RLock()
+	defer sc.ts.mu.RUnlock()
+
+	v := sc.view
+	batch := make(map[string][]byte, n)
+	var keys []string
+	var last string
+
+	visit := func(key string, data []byte) bool {
+		batch[key] = data
+		keys = append(keys, key)
+		last = key
+		return len(keys) < n
+	}
+	var err error
+	if v.reverse {
+		err = sc.ts.engine.IterateReverse(v.start, v.pos, visit)
+	} else {
+		err = sc.ts.engine.Iterate(v.pos, v.end, visit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan: %w", err)
+	}
+	exhausted := len(keys) < n
+
+	// Swap in the preimages of keys changed since the view was opened, and
+	// bring back keys deleted since then, up to the end of this batch
+	overlay := func(key string) bool {
+		if !exhausted && ((!v.reverse && key > last) || (v.reverse && key < last)) {
+			return false
+		}
+		if _, seen := batch[key]; !seen {
+			keys = append(keys, key)
+		}
+		batch[key] = v.preimages[key]
+		return true
+	}
+	if v.reverse {
+		node := v.index.Last()
+		if v.pos != "" {
+			node = v.index.SeekBefore(v.pos)
+		}
+		for ; node != nil && node.Key() >= v.start && overlay(node.Key()); node = node.Prev() {
+		}
+	} else {
+		for node := v.index.Seek(v.pos); node != nil && (v.end == "" || node.Key() < v.end) && overlay(node.Key()); node = node.Next() {
+		}
+	}
+
+	if v.reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+
+	items := make([]KeyValue, 0, len(keys))
+	for _, key := range keys {
+		data := batch[key]
+		if data == nil {
+			continue
+		}
+		e, err := decodeEntry(data)
+		if err != nil {
+			slog.Error("Failed to decode entry", logging.Key("key", key), "err", err)
+			continue
+		}
+		if e.expired(v.now) {
+			continue
+		}
+		item := KeyValue{Key: key, Version: e.version}
+		if !sc.keysOnly {
+			item.Value = append([]byte(nil), e.value...)
+		}
+		items = append(items, item)
+	}
+	if sc.limit > 0 && len(items) > sc.limit-sc.emitted {
+		items = items[:sc.limit-sc.emitted]
+	}
+	sc.emitted += len(items)
+
+	// Move past the batch. The read lock keeps writers, the only other users
+	// of the view, away.
+	switch {
+	case exhausted || (sc.limit > 0 && sc.emitted == sc.limit):
+		v.done = true
+	case v.reverse:
+		v.pos = last
+	default:
+		v.pos = last + "\x00"
+	}
+
+	if len(items) == 0 && v.done {
+		return nil, io.EOF
+	}
+	return items, nil
+}
+
+// Close releases the scanner's view
+func (sc *Scanner) Close() {
+	if sc.ts == nil {
+		return
+	}
+	sc.ts.mu.Lock()
+	delete(sc.ts.views, sc.view)
+	sc.ts.mu.Unlock()
+	sc.ts = nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain reads a scanner to the end in batches of n
+func drain(t *testing.T, sc *Scanner, n int, between func()) []KeyValue {
+	t.Helper()
+
+	var items []KeyValue
+	for {
+		batch, err := sc.Next(n)
+		if errors.Is(err, io.EOF) {
+			return items
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		items = append(items, batch...)
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func TestScanner_ConsistentDuringWrites(t *testing.T) {
+	for _, reverse := range []bool{false, true} {
+		store := NewStore()
+		expected := make(map[string]string)
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key-%03d", i)
+			store.Set("tenant", key, []byte("v1"))
+			expected[key] = "v1"
+		}
+
+		sc, err := store.NewScanner("tenant", ScanOptions{Reverse: reverse})
+		if err != nil {
+			t.Fatalf("NewScanner failed: %v", err)
+		}
+
+		// Between batches, rewrite, delete and insert keys all over the range
+		round := 0
+		items := drain(t, sc, 50, func() {
+			round++
+			for i := 0; i < 500; i += 7 {
+				key := fmt.Sprintf("key-%03d", (i+round*13)%500)
+				if round%2 == 0 {
+					store.Delete("tenant", key)
+				} else {
+					store.Set("tenant", key, []byte(fmt.Sprintf("v%d", round+1)))
+				}
+			}
+			store.Set("tenant", fmt.Sprintf("key-%03d-new-%d", round*37%500, round), []byte("new"))
+			store.Txn("tenant", Txn{Success: []TxnOp{{Type: TxnDelete, Key: fmt.Sprintf("key-%03d", round*41%500)}}})
+		})
+		sc.Close()
+
+		if len(items) != len(expected) {
+			t.Fatalf("reverse=%v: expected %d keys, got %d", reverse, len(expected), len(items))
+		}
+		for i, item := range items {
+			if expected[item.Key] != string(item.Value) {
+				t.Fatalf("reverse=%v: key %q has value %q, expected %q", reverse, item.Key, item.Value, expected[item.Key])
+			}
+			if i > 0 && (items[i-1].Key < item.Key) == reverse {
+				t.Fatalf("reverse=%v: keys out of order at %d", reverse, i)
+			}
+		}
+
+		ts := store.tenants["tenant"]
+		if len(ts.views) != 0 {
+			t.Fatalf("Expected Close to release the view, %d remain", len(ts.views))
+		}
+	}
+}
+
+func TestScanner_ConcurrentWriters(t *testing.T) {
+	store := NewStore()
+	for i := 0; i < 200; i++ {
+		store.Set("tenant", fmt.Sprintf("key-%03d", i), []byte("stable"))
+	}
+
+	sc, _ := store.NewScanner("tenant", ScanOptions{})
+	defer sc.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key-%03d", (i*17+w)%200)
+				if i%3 == 0 {
+					store.Delete("tenant", key)
+				} else {
+					store.Set("tenant", key, []byte("changed"))
+				}
+			}
+		}(w)
+	}
+
+	items := drain(t, sc, 10, nil)
+	close(stop)
+	wg.Wait()
+
+	if len(items) != 200 {
+		t.Fatalf("Expected 200 keys, got %d", len(items))
+	}
+	for _, item := range items {
+		if string(item.Value) != "stable" {
+			t.Fatalf("Key %q shows a write made after the scan began", item.Key)
+		}
+	}
+}
+
+func TestScanner_Options(t *testing.T) {
+	clock := useFakeClock(t)
+	store := NewStore()
+	for i := 0; i < 10; i++ {
+		store.Set("tenant", fmt.Sprintf("a:%d", i), []byte("v"))
+	}
+	store.Set("tenant", "b:0", []byte("v"))
+
+	sc, _ := store.NewScanner("tenant", ScanOptions{Prefix: "a:", Limit: 4, KeysOnly: true})
+	items := drain(t, sc, 3, nil)
+	sc.Close()
+	if len(items) != 4 || items[0].Key != "a:0" || items[3].Key != "a:3" || items[0].Value != nil {
+		t.Fatalf("Expected keys a:0 to a:3 without values, got %+v", items)
+	}
+
+	// A key that was live when the scanner opened stays in its view
+	store.SetWithTTL("tenant", "a:expiring", []byte("v"), time.Second)
+	sc, _ = store.NewScanner("tenant", ScanOptions{Start: "a:8", End: "b"})
+	clock.advance(2 * time.Second)
+	store.Get("tenant", "a:expiring")
+	items = drain(t, sc, 1, nil)
+	sc.Close()
+	if len(items) != 3 || items[2].Key != "a:expiring" {
+		t.Fatalf("Expected a:8, a:9 and a:expiring, got %+v", items)
+	}
+
+	sc, _ = store.NewScanner("tenant", ScanOptions{Prefix: "a:", Cursor: "a:7"})
+	items = drain(t, sc, 100, nil)
+	sc.Close()
+	if len(items) != 2 || items[0].Key != "a:8" {
+		t.Fatalf("Expected the cursor to resume after a:7, got %+v", items)
+	}
+
+	sc, _ = store.NewScanner("unknown", ScanOptions{})
+	if _, err := sc.Next(10); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected EOF for an unknown tenant, got %v", err)
+	}
+	sc.Close()
+}
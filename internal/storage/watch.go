@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// watchHistory is the minimum number of recent events kept in memory for
+	// watchers resuming from an earlier revision. Older events are read back
+	// from the WAL while it still holds them.
+	watchHistory = 4096
+
+	// watchQueueLimit bounds the events waiting to be read by one watcher. A
+	// watcher that falls further behind is cancelled with ErrWatcherTooSlow
+	// so that writers never wait for it.
+	watchQueueLimit = 10000
+)
+
+var (
+	// ErrCompacted is returned when a watch asks to resume from a revision
+	// whose events are no longer retained. The caller should re-read the
+	// current state and watch from there.
+	ErrCompacted = errors.New("requested revision has been compacted")
+
+	// ErrWatcherTooSlow ends a watcher that stopped keeping up with writes
+	ErrWatcherTooSlow = errors.New("watcher fell too far behind")
+
+	// ErrWatcherClosed is returned by Next once the watcher has been closed
+	ErrWatcherClosed = errors.New("watcher closed")
+
+	errStopReplay = errors.New("stop replay")
+)
+
+// EventType identifies the change an Event reports
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+// Event is a change to a key. Keys removed because their TTL passed and
+// changes to a key's expiry alone are not reported.
+type Event struct {
+	Type     EventType
+	Key      string
+	Value    []byte // New value for EventPut
+	Revision uint64 // Store revision of the change, shared by the writes of one batch
+}
+
+// WatchOptions selects the keys a watcher reports
+type WatchOptions struct {
+	Key    string
+	Prefix bool // Watch every key starting with Key, an empty Key watches the whole tenant
+
+	// FromRevision replays the events at or after this revision before
+	// reporting new ones, 0 only reports changes made after the watch starts
+	FromRevision uint64
+}
+
+// tenantEvent is an event in the history shared by all tenants
+type tenantEvent struct {
+	tenant string
+	Event
+}
+
+// watchHub fans events out to watchers and keeps the recent history
+type watchHub struct {
+	mu       sync.Mutex
+	history  []tenantEvent
+	watchers map[string]map[*Watcher]struct{} // By tenant
+
+	// since is the lowest revision whose events are all in history once
+	// published. Events are published under their tenant's lock, so within
+	// a tenant history is in revision order.
+	since uint64
+}
+
+func newWatchHub(since uint64) *watchHub {
+	return &watchHub{
+		watchers: make(map[string]map[*Watcher]struct{}),
+		since:    since,
+	}
+}
+
+// events converts a logged mutation into the events it causes
+func (r *walRecord) events(revision uint64) []Event {
+	switch r.op {
+	case opSet:
+		return []Event{{Type: EventPut, Key: r.key, Value: append([]byte{}, r.value...), Revision: revision}}
+	case opDelete:
+		return []Event{{Type: EventDelete, Key: r.key, Revision: revision}}
+	case opBatch:
+		var events []Event
+		for i := range r.ops {
+			events = append(events, r.ops[i].events(revision)...)
+		}
+		return events
+	}
+	return nil
+}
+
+// publish records the events of a mutation and hands them to the tenant's
+// watchers, caller must hold the tenant's lock so events stay in order
+func (h *watchHub) publish(tenantID string, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, ev := range events {
+		h.history = append(h.history, tenantEvent{tenant: tenantID, Event: ev})
+	}
+	// Trim in bulk once the history reaches twice its size, so that the
+	// copy is amortized over many writes
+	if len(h.history) >= 2*watchHistory {
+		excess := len(h.history) - watchHistory
+		for _, ev := range h.history[:excess] {
+			h.since = max(h.since, ev.Revision+1)
+		}
+		h.history = append([]tenantEvent(nil), h.history[excess:]...)
+	}
+
+	for w := range h.watchers[tenantID] {
+		if !w.deliver(events) {
+			h.removeLocked(w)
+		}
+	}
+}
+
+// reset ends every watcher with ErrCompacted and forgets the history, for a
+// store whose contents were replaced up to revision since-1
+func (h *watchHub) reset(since uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, watchers := range h.watchers {
+		for w := range watchers {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = fmt.Errorf("%w: the store was restored from a snapshot at revision %d", ErrCompacted, since-1)
+			}
+			w.queue = nil
+			w.mu.Unlock()
+			w.signal()
+		}
+	}
+	h.watchers = make(map[string]map[*Watcher]struct{})
+	h.history = nil
+	h.since = since
+}
+
+func (h *watchHub) removeLocked(w *Watcher) {
+	watchers := h.watchers[w.tenant]
+	delete(watchers, w)
+	if len(watchers) == 0 {
+		delete(h.watchers, w.tenant)
+	}
+}
+
+// Watcher reports changes to the keys of a tenant. It must be closed to
+// release it.
+type Watcher struct {
+	hub    *watchHub
+	tenant string
+	opts   WatchOptions
+
+	mu     sync.Mutex
+	queue  []Event
+	notify chan struct{} // Signalled when events are queued or the watcher ends
+	err    error
+}
+
+// Watch starts reporting changes to the keys selected by opts. With a
+// FromRevision the retained events from that revision on are reported
+// first, or ErrCompacted is returned if some of them are gone.
+func (s *Store) Watch(tenantID string, opts WatchOptions) (*Watcher, error) {
+	if tenantID == "" {
+		return nil, errorf(ErrInvalidArgument, "tenant ID cannot be empty")
+	}
+	if opts.Key == "" && !opts.Prefix {
+		return nil, errorf(ErrInvalidArgument, "key cannot be empty")
+	}
+
+	h := s.watch
+	w := &Watcher{
+		hub:    h,
+		tenant: tenantID,
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+	}
+
+	// Holding commitMu keeps the WAL from being truncated during replay
+	s.commitMu.RLock()
+	defer s.commitMu.RUnlock()
+
+	// Registering and copying the history under the hub lock means every
+	// event is either in the copy or delivered to the watcher, never both.
+	// Every event up to the current revision that has not been published
+	// yet is still in flight, so a watch from now starts right after it.
+	h.mu.Lock()
+	var backlog []Event
+	if opts.FromRevision == 0 {
+		w.opts.FromRevision = s.revisionLocked() + 1
+	} else {
+		for _, ev := range h.history {
+			// Events before since are read from the WAL below
+			if ev.tenant == tenantID && ev.Revision >= h.since && w.matches(ev.Event) {
+				backlog = append(backlog, ev.Event)
+			}
+		}
+	}
+	since := h.since
+	if h.watchers[tenantID] == nil {
+		h.watchers[tenantID] = make(map[*Watcher]struct{})
+	}
+	h.watchers[tenantID][w] = struct{}{}
+	h.mu.Unlock()
+
+	if w.opts.FromRevision < since {
+		older, err := s.replayEvents(w, since)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		backlog = append(older, backlog...)
+	}
+
+	if len(backlog) > 0 {
+		w.mu.Lock()
+		w.queue = append(backlog, w.queue...)
+		if len(w.queue) > watchQueueLimit && w.err == nil {
+			w.err = fmt.Errorf("%w: %d events to replay", ErrCompacted, len(w.queue))
+		}
+		err := w.err
+		w.mu.Unlock()
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.signal()
+	}
+	return w, nil
+}
+
+// Revision returns the revision the watcher started after: it reports every
+// matching event with a higher revision
+func (w *Watcher) Revision() uint64 {
+	return w.opts.FromRevision - 1
+}
+
+// replayEvents reads the watcher's events before the start of the history
+// back from the WAL
+func (s *Store) replayEvents(w *Watcher, since uint64) ([]Event, error) {
+	from := w.opts.FromRevision
+	if s.wal == nil || s.wal.FirstIndex() > from {
+		return nil, fmt.Errorf("%w: events before revision %d are not retained", ErrCompacted, since)
+	}
+
+	var events []Event
+	err := s.wal.Replay(from, func(index uint64, data []byte) error {
+		// A replicated log skips the indexes that carry no mutation
+		if index >= since {
+			return errStopReplay
+		}
+		rec, err := decodeWALRecord(data)
+		if err != nil {
+			return fmt.Errorf("wal record %d: %w", index, err)
+		}
+		if rec.tenant == w.tenant {
+			for _, ev := range rec.events(index) {
+				if w.matches(ev) {
+					events = append(events, ev)
+				}
+			}
+		}
+		if len(events) > watchQueueLimit {
+			return fmt.Errorf("%w: more than %d events to replay", ErrCompacted, watchQueueLimit)
+		}
+		// Stop before reaching records that may still be being written
+		if index+1 >= since {
+			return errStopReplay
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopReplay) {
+		if errors.Is(err, ErrCompacted) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to replay events: %w", err)
+	}
+	return events, nil
+}
+
+// matches reports whether the watcher selects the event
+func (w *Watcher) matches(ev Event) bool {
+	if ev.Revision < w.opts.FromRevision {
+		return false
+	}
+	if w.opts.Prefix {
+		return strings.HasPrefix(ev.Key, w.opts.Key)
+	}
+	return ev.Key == w.opts.Key
+}
+
+// deliver queues the matching events and reports whether the watcher is
+// still alive, caller must hold the hub lock
+func (w *Watcher) deliver(events []Event) bool {
+	w.mu.Lock()
+	if w.err != nil {
+		w.mu.Unlock()
+		return false
+	}
+	queued := false
+	for _, ev := range events {
+		if w.matches(ev) {
+			w.queue = append(w.queue, ev)
+			queued = true
+		}
+	}
+	alive := true
+	if len(w.queue) > watchQueueLimit {
+		w.queue = nil
+		w.err = ErrWatcherTooSlow
+		alive = false
+	}
+	w.mu.Unlock()
+
+	if queued || !alive {
+		w.signal()
+	}
+	return alive
+}
+
+func (w *Watcher) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Next waits for events and returns all those queued, in revision order.
+// The events of one revision are always returned together. It returns an
+// error once the watcher has ended or ctx is done.
+func (w *Watcher) Next(ctx context.Context) ([]Event, error) {
+	for {
+		w.mu.Lock()
+		events, err := w.queue, w.err
+		w.queue = nil
+		w.mu.Unlock()
+
+		if len(events) > 0 {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close stops the watcher
+func (w *Watcher) Close() {
+	w.hub.mu.Lock()
+	w.hub.removeLocked(w)
+	w.hub.mu.Unlock()
+
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = ErrWatcherClosed
+	}
+	w.queue = nil
+	w.mu.Unlock()
+	w.signal()
+}
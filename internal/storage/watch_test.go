@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// nextEvents reads one batch of events, failing the test if none arrive
+func nextEvents(t *testing.T, w *Watcher) []Event {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	events, err := w.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	return events
+}
+
+func TestStore_WatchKeyAndPrefix(t *testing.T) {
+	store := NewStore()
+
+	key, _ := store.Watch("tenant", WatchOptions{Key: "config"})
+	defer key.Close()
+	prefix, _ := store.Watch("tenant", WatchOptions{Key: "user:", Prefix: true})
+	defer prefix.Close()
+
+	store.Set("tenant", "config", []byte("v1"))
+	store.Set("tenant", "user:1", []byte("alice"))
+	store.Set("other", "config", []byte("other tenant"))
+	store.Delete("tenant", "config")
+
+	events := nextEvents(t, key)
+	if len(events) != 2 || events[0].Type != EventPut || string(events[0].Value) != "v1" || events[1].Type != EventDelete {
+		t.Fatalf("Expected a put and a delete of config, got %+v", events)
+	}
+	if events[1].Revision != store.Revision() {
+		t.Fatalf("Expected the delete at revision %d, got %d", store.Revision(), events[1].Revision)
+	}
+
+	events = nextEvents(t, prefix)
+	if len(events) != 1 || events[0].Key != "user:1" {
+		t.Fatalf("Expected only user:1, got %+v", events)
+	}
+
+	// The writes of one transaction share a revision and arrive together
+	store.Txn("tenant", Txn{Success: []TxnOp{
+		{Type: TxnSet, Key: "user:2", Value: []byte("bob")},
+		{Type: TxnDelete, Key: "user:1"},
+	}})
+	events = nextEvents(t, prefix)
+	if len(events) != 2 || events[0].Revision != events[1].Revision {
+		t.Fatalf("Expected two events at one revision, got %+v", events)
+	}
+
+	if _, err := store.Watch("tenant", WatchOptions{}); err == nil {
+		t.Fatal("Expected an empty key without prefix to be rejected")
+	}
+}
+
+func TestStore_WatchResume(t *testing.T) {
+	store := NewStore()
+	for i := 0; i < 10; i++ {
+		store.Set("tenant", "counter", []byte(fmt.Sprint(i)))
+	}
+
+	w, err := store.Watch("tenant", WatchOptions{Key: "counter", FromRevision: 8})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	store.Set("tenant", "counter", []byte("10"))
+	events := nextEvents(t, w)
+	if len(events) < 2 || events[0].Revision != 8 || string(events[0].Value) != "7" {
+		t.Fatalf("Expected to resume at revision 8, got %+v", events)
+	}
+	for len(events) < 4 {
+		events = append(events, nextEvents(t, w)...)
+	}
+	if string(events[3].Value) != "10" {
+		t.Fatalf("Expected the live event after the replayed ones, got %+v", events)
+	}
+	w.Close()
+
+	// Push revision 1 out of the in-memory history
+	for i := 0; i < 2*watchHistory; i++ {
+		store.Set("tenant", "filler", []byte("x"))
+	}
+	if _, err := store.Watch("tenant", WatchOptions{Key: "counter", FromRevision: 1}); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("Expected ErrCompacted, got %v", err)
+	}
+}
+
+func TestStore_WatchResumeFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	store.Set("tenant", "a", []byte("1"))
+	store.MSet("tenant", []SetItem{{Key: "a", Value: []byte("2")}, {Key: "b", Value: []byte("3")}}, true)
+	store.Delete("tenant", "a")
+	store.Close()
+
+	// The history is empty after a restart, so the events come from the WAL
+	store = openTestStore(t, dir)
+	defer store.Close()
+
+	w, err := store.Watch("tenant", WatchOptions{Prefix: true, FromRevision: 2})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Close()
+	store.Set("tenant", "c", []byte("4"))
+
+	var events []Event
+	for len(events) < 4 {
+		events = append(events, nextEvents(t, w)...)
+	}
+	expected := []string{"put a 2", "put b 2", "delete a 3", "put c 4"}
+	for i, ev := range events {
+		kind := "put"
+		if ev.Type == EventDelete {
+			kind = "delete"
+		}
+		if got := fmt.Sprintf("%s %s %d", kind, ev.Key, ev.Revision); got != expected[i] {
+			t.Fatalf("Event %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+}
+
+func TestStore_WatchSlowAndClosed(t *testing.T) {
+	store := NewStore()
+
+	slow, _ := store.Watch("tenant", WatchOptions{Key: "k"})
+	for i := 0; i <= watchQueueLimit; i++ {
+		store.Set("tenant", "k", []byte("v"))
+	}
+	if _, err := slow.Next(context.Background()); !errors.Is(err, ErrWatcherTooSlow) {
+		t.Fatalf("Expected ErrWatcherTooSlow, got %v", err)
+	}
+	slow.Close()
+
+	w, _ := store.Watch("tenant", WatchOptions{Key: "k"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := w.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the context error, got %v", err)
+	}
+
+	w.Close()
+	if _, err := w.Next(context.Background()); !errors.Is(err, ErrWatcherClosed) {
+		t.Fatalf("Expected ErrWatcherClosed, got %v", err)
+	}
+	if len(store.watch.watchers) != 0 {
+		t.Fatalf("Expected no registered watchers, got %d tenants", len(store.watch.watchers))
+	}
+}
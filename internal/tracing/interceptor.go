@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var tracer = otel.Tracer("github.com/ayushgala/tinkerdb/internal/tracing")
+
+// metadataCarrier reads and writes trace context in gRPC metadata
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// UnaryServerInterceptor traces unary calls, continuing the trace of the
+// caller if its context was propagated
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, span := startServer(ctx, info.FullMethod)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		finish(span, err, serverError)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor traces streaming calls, see UnaryServerInterceptor
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServer(ss.Context(), info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx})
+		finish(span, err, serverError)
+		return err
+	}
+}
+
+// tracedServerStream hands the call's span to the handler
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func startServer(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	return tracer.Start(ctx, spanName(method), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(rpcAttributes(method)...))
+}
+
+// UnaryClientInterceptor traces unary calls and propagates their trace
+// context to the server
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := startClient(ctx, method)
+		defer span.End()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		finish(span, err, clientError)
+		return err
+	}
+}
+
+// StreamClientInterceptor traces streaming calls, see
+// UnaryClientInterceptor. The span ends when the stream does.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startClient(ctx, method)
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finish(span, err, clientError)
+			span.End()
+			return nil, err
+		}
+		return &tracedClientStream{ClientStream: cs, span: span}, nil
+	}
+}
+
+// tracedClientStream ends the call's span with the first receive error,
+// io.EOF for a stream that completed
+type tracedClientStream struct {
+	grpc.ClientStream
+	span trace.Span
+	once sync.Once
+}
+
+func (s *tracedClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			finish(s.span, err, clientError)
+			s.span.End()
+		})
+	}
+	return err
+}
+
+func startClient(ctx context.Context, method string) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, spanName(method), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(rpcAttributes(method)...))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// spanName names a call's span after its method, package.Service/Method
+func spanName(method string) string {
+	return strings.TrimPrefix(method, "/")
+}
+
+func rpcAttributes(method string) []attribute.KeyValue {
+	service, name, _ := strings.Cut(spanName(method), "/")
+	return []attribute.KeyValue{
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.service", service),
+		attribute.String("rpc.method", name),
+	}
+}
+
+// finish records the outcome of a call on its span. isError tells which
+// status codes mark the span as failed.
+func finish(span trace.Span, err error, isError func(codes.Code) bool) {
+	code := status.Code(err)
+	if code == codes.Unknown {
+		code = status.FromContextError(err).Code()
+	}
+	span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(code)))
+	if err != nil && isError(code) {
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+}
+
+// serverError reports the codes that are the server's fault rather than the
+// client's
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// clientError reports every failed call as an error, as seen by the caller
+func clientError(code codes.Code) bool {
+	return code != codes.OK
+}
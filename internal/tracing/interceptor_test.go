@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.InMemoryExporter
+)
+
+// recordSpans records the spans of the package's tracer from here on. The
+// global provider can only be set once, so it is shared by the tests.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder)))
+	})
+	recorder.Reset()
+	return recorder
+}
+
+// call runs a unary call through the client and server interceptors, moving
+// the outgoing metadata to the server as gRPC would
+func call(ctx context.Context, method string, handler grpc.UnaryHandler) error {
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		serverCtx := metadata.NewIncomingContext(context.Background(), md)
+		_, err := UnaryServerInterceptor()(serverCtx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+	return UnaryClientInterceptor()(ctx, method, nil, nil, nil, invoker)
+}
+
+func TestInterceptors_PropagateContext(t *testing.T) {
+	spans := recordSpans(t)
+
+	var handlerSpan trace.SpanContext
+	err := call(context.Background(), "/kvstore.KVStore/Get", func(ctx context.Context, req any) (any, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+
+	ended := spans.GetSpans()
+	if len(ended) != 2 {
+		t.Fatalf("Expected a server and a client span, got %d spans", len(ended))
+	}
+	server, client := ended[0], ended[1]
+	if server.SpanKind != trace.SpanKindServer || client.SpanKind != trace.SpanKindClient {
+		t.Fatalf("Expected server then client spans, got %s and %s", server.SpanKind, client.SpanKind)
+	}
+	if server.Name != "kvstore.KVStore/Get" {
+		t.Fatalf("Expected the span to be named after the method, got %q", server.Name)
+	}
+	if server.Parent.SpanID() != client.SpanContext.SpanID() || server.SpanContext.TraceID() != client.SpanContext.TraceID() {
+		t.Fatal("Expected the server span to continue the client's trace")
+	}
+	if handlerSpan.SpanID() != server.SpanContext.SpanID() {
+		t.Fatal("Expected the handler to run in the server span")
+	}
+}
+
+func TestInterceptors_RecordStatus(t *testing.T) {
+	spans := recordSpans(t)
+
+	// A client error fails the client's span but not the server's
+	call(context.Background(), "/kvstore.KVStore/Set", func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.InvalidArgument, "key cannot be empty")
+	})
+	ended := spans.GetSpans()
+	if ended[0].Status.Code != otelcodes.Unset || ended[1].Status.Code != otelcodes.Error {
+		t.Fatalf("Expected only the client span to fail, got %v and %v", ended[0].Status, ended[1].Status)
+	}
+
+	spans.Reset()
+	call(context.Background(), "/kvstore.KVStore/Set", func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.Internal, "disk on fire")
+	})
+	ended = spans.GetSpans()
+	if ended[0].Status.Code != otelcodes.Error || ended[0].Status.Description != "rpc error: code = Internal desc = disk on fire" {
+		t.Fatalf("Expected the server span to fail, got %v", ended[0].Status)
+	}
+	for _, attr := range ended[0].Attributes {
+		if attr.Key == "rpc.grpc.status_code" && attr.Value.AsInt64() != int64(codes.Internal) {
+			t.Fatalf("Expected status code %d, got %d", codes.Internal, attr.Value.AsInt64())
+		}
+	}
+}
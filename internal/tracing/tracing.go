@@ -0,0 +1,114 @@
+// Package tracing exports OpenTelemetry traces of the server and propagates
+// trace context over gRPC metadata.
+//
+// Spans are created through the global tracer provider, so instrumented
+// code costs next to nothing until Setup installs an exporter.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// Exporters
+const (
+	ExporterNone   = "none"   // Spans are not recorded, trace context is still propagated
+	ExporterOTLP   = "otlp"   // Spans are sent to an OTLP collector over gRPC
+	ExporterStdout = "stdout" // Spans are written to standard output as JSON
+	ExporterFile   = "file"   // Spans are appended to a file as JSON
+)
+
+// DefaultEndpoint is the address of a local OTLP collector
+const DefaultEndpoint = "localhost:4317"
+
+// Options configures tracing
+type Options struct {
+	Exporter    string
+	Endpoint    string  // Collector address of the OTLP exporter
+	Insecure    bool    // Connect to the collector without TLS
+	File        string  // File of the file exporter
+	SampleRatio float64 // Fraction of traces started here that are recorded
+	ServiceName string
+}
+
+func init() {
+	// Trace context is propagated even when spans are not recorded, so a
+	// traced client's trace continues through the server to its callees
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Setup installs the exporter described by opts as the global tracer
+// provider. The returned function flushes the spans still buffered and
+// stops exporting.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Exporter == ExporterNone || opts.Exporter == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closer, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// newExporter creates the exporter of opts and whatever it writes to that
+// must be closed after it
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, io.Closer, error) {
+	switch opts.Exporter {
+	case ExporterOTLP:
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exporter, nil, err
+	case ExporterFile:
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	}
+	return nil, nil, fmt.Errorf("unknown trace exporter %q, expected none, otlp, stdout or file", opts.Exporter)
+}
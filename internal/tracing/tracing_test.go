@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestNewExporter_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	exporter, closer, err := newExporter(context.Background(), Options{Exporter: ExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("newExporter failed: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := provider.Tracer("test").Start(context.Background(), "storage.Set")
+	span.End()
+	provider.Shutdown(context.Background())
+	closer.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"Name":"storage.Set"`) {
+		t.Fatalf("Expected the span in the trace file, got %s", data)
+	}
+
+	if _, _, err := newExporter(context.Background(), Options{Exporter: "jaeger"}); err == nil {
+		t.Fatal("Expected an unknown exporter to be rejected")
+	}
+}
+
+func TestSetup_None(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterNone})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+}
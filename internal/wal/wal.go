@@ -0,0 +1,713 @@
+// Package wal implements an append-only, checksummed write-ahead log.
+//
+// The log is split into segment files named after the index of their first
+// record. Every record is framed as:
+//
+//	+----------------+----------------+-----------------+
+//	| length (4B LE) | crc32c (4B LE) | payload (length) |
+//	+----------------+----------------+-----------------+
+//
+// Records are numbered with consecutive indexes starting at 1. A torn record
+// at the tail of the newest segment (e.g. after a crash mid-write) is trimmed
+// when the log is opened; a bad record anywhere else is reported as corruption.
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	segmentExt = ".wal"
+	headerSize = 8
+
+	// maxRecordSize guards against allocating absurd buffers when a length
+	// prefix is garbage
+	maxRecordSize = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var tracer = otel.Tracer("github.com/ayushgala/tinkerdb/internal/wal")
+
+var (
+	// ErrCorrupt is returned when a record fails its checksum outside of the
+	// repairable tail of the log
+	ErrCorrupt = errors.New("wal: corrupt record")
+
+	// ErrClosed is returned when appending to a closed log
+	ErrClosed = errors.New("wal: log is closed")
+)
+
+// SyncPolicy controls when appended records are flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every append, so a record is durable once Append returns
+	SyncAlways SyncPolicy = iota
+	// SyncBatch fsyncs in the background every SyncInterval
+	SyncBatch
+	// SyncNone hands writes to the operating system and never fsyncs explicitly
+	SyncNone
+)
+
+// String returns the configuration name of the policy
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncBatch:
+		return "batch"
+	case SyncNone:
+		return "none"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
+// ParseSyncPolicy parses "always", "batch" or "none"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "always", "":
+		return SyncAlways, nil
+	case "batch":
+		return SyncBatch, nil
+	case "none", "os":
+		return SyncNone, nil
+	default:
+		return 0, fmt.Errorf("unknown wal sync policy %q (want always, batch or none)", s)
+	}
+}
+
+// Options configures a Log
+type Options struct {
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration // Used by SyncBatch
+	SegmentSize  int64         // Size after which a new segment is started
+}
+
+// DefaultOptions returns options that fsync every append
+func DefaultOptions() Options {
+	return Options{
+		SyncPolicy:   SyncAlways,
+		SyncInterval: 10 * time.Millisecond,
+		SegmentSize:  64 * 1024 * 1024,
+	}
+}
+
+// Stats are counters of a Log since it was opened
+type Stats struct {
+	Appends     uint64        // Records appended
+	AppendBytes uint64        // Bytes appended, framing included
+	Syncs       uint64        // Fsyncs of the active segment
+	SyncTime    time.Duration // Total time spent in fsync
+	Segments    int           // Segment files currently in the log
+}
+
+type segment struct {
+	firstIndex uint64
+	path       string
+}
+
+// Log is a segmented write-ahead log. It is safe for concurrent use.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu        sync.Mutex
+	segments  []segment // Sorted by firstIndex, last one is active
+	file      *os.File  // Active segment opened for appending
+	fileSize  int64
+	lastIndex uint64
+	dirty     bool  // Unsynced writes pending (SyncBatch)
+	err       error // Sticky write error, the log refuses appends after one
+	closed    bool
+	stats     Stats
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// Open opens or creates the log stored in dir
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultOptions().SegmentSize
+	}
+	if opts.SyncPolicy == SyncBatch && opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultOptions().SyncInterval
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:  dir,
+		opts: opts,
+	}
+
+	if len(segments) == 0 {
+		if err := l.createSegment(1); err != nil {
+			return nil, err
+		}
+	} else {
+		l.segments = segments
+		if err := l.openTail(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.SyncPolicy == SyncBatch {
+		l.stopCh = make(chan struct{})
+		l.doneCh = make(chan struct{})
+		go l.syncLoop()
+	}
+
+	return l, nil
+}
+
+// openTail scans the newest segment, trims a torn tail and opens it for appending
+func (l *Log) openTail() error {
+	tail := l.segments[len(l.segments)-1]
+
+	validSize, count, scanErr := scanSegment(tail.path, func([]byte) error { return nil })
+	if scanErr != nil && !errors.Is(scanErr, errTorn) {
+		return scanErr
+	}
+
+	f, err := os.OpenFile(tail.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment: %w", err)
+	}
+
+	if errors.Is(scanErr, errTorn) || validSize != fileSizeOf(f) {
+		if err := f.Truncate(validSize); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to trim torn wal tail: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to sync trimmed wal segment: %w", err)
+		}
+	}
+
+	if _, err := f.Seek(validSize, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to seek wal segment: %w", err)
+	}
+
+	l.file = f
+	l.fileSize = validSize
+	l.lastIndex = tail.firstIndex + count - 1
+	return nil
+}
+
+// createSegment starts a new active segment whose first record will have firstIndex
+func (l *Log) createSegment(firstIndex uint64) error {
+	path := filepath.Join(l.dir, segmentName(firstIndex))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create wal segment: %w", err)
+	}
+	if err := syncDir(l.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.segments = append(l.segments, segment{firstIndex: firstIndex, path: path})
+	l.file = f
+	l.fileSize = 0
+	l.lastIndex = firstIndex - 1
+	return nil
+}
+
+// Append writes a record to the log and returns its index. Under SyncAlways
+// the record is on stable storage when Append returns.
+func (l *Log) Append(data []byte) (uint64, error) {
+	return l.AppendContext(context.Background(), data)
+}
+
+// AppendContext is Append, tracing the fsync under SyncAlways as a span of
+// ctx
+func (l *Log) AppendContext(ctx context.Context, data []byte) (uint64, error) {
+	if len(data) > maxRecordSize {
+		return 0, fmt.Errorf("wal: record of %d bytes exceeds limit", len(data))
+	}
+
+	frame := make([]byte, headerSize+len(data))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(data, crcTable))
+	copy(frame[headerSize:], data)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	if l.fileSize > 0 && l.fileSize+int64(len(frame)) > l.opts.SegmentSize {
+		if err := l.rotate(); err != nil {
+			l.err = err
+			return 0, err
+		}
+	}
+
+	if _, err := l.file.Write(frame); err != nil {
+		l.err = fmt.Errorf("wal write failed: %w", err)
+		return 0, l.err
+	}
+	l.fileSize += int64(len(frame))
+	l.lastIndex++
+	l.stats.Appends++
+	l.stats.AppendBytes += uint64(len(frame))
+
+	switch l.opts.SyncPolicy {
+	case SyncAlways:
+		_, span := tracer.Start(ctx, "wal.fsync")
+		err := l.fsync()
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err != nil {
+			l.err = fmt.Errorf("wal fsync failed: %w", err)
+			return 0, l.err
+		}
+	case SyncBatch:
+		l.dirty = true
+	}
+
+	return l.lastIndex, nil
+}
+
+// WaitReady returns at once, a local log accepts appends whenever it is open
+func (l *Log) WaitReady(ctx context.Context) error {
+	return nil
+}
+
+// rotate seals the active segment and starts a new one
+func (l *Log) rotate() error {
+	if err := l.fsync(); err != nil {
+		return fmt.Errorf("wal fsync failed: %w", err)
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close wal segment: %w", err)
+	}
+	l.dirty = false
+	return l.createSegment(l.lastIndex + 1)
+}
+
+// Replay calls fn for every record with an index >= from, in order
+func (l *Log) Replay(from uint64, fn func(index uint64, data []byte) error) error {
+	l.mu.Lock()
+	segments := append([]segment(nil), l.segments...)
+	last := l.lastIndex
+	l.mu.Unlock()
+
+	if from == 0 {
+		from = 1
+	}
+
+	for i, seg := range segments {
+		// Skip segments that end before the requested index
+		if i+1 < len(segments) && segments[i+1].firstIndex <= from {
+			continue
+		}
+
+		index := seg.firstIndex
+		_, _, err := scanSegment(seg.path, func(data []byte) error {
+			defer func() { index++ }()
+			if index < from || index > last {
+				return nil
+			}
+			return fn(index, data)
+		})
+		if errors.Is(err, errTorn) {
+			return fmt.Errorf("%w in segment %s", ErrCorrupt, filepath.Base(seg.path))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rotate seals the active segment so that everything written so far can be
+// released by TruncateFront. It is a no-op when the active segment is empty.
+func (l *Log) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	if l.err != nil {
+		return l.err
+	}
+	if l.fileSize == 0 {
+		return nil
+	}
+	if err := l.rotate(); err != nil {
+		l.err = err
+		return err
+	}
+	return nil
+}
+
+// TruncateFront deletes sealed segments whose records all have an index <=
+// upTo. The active segment is never removed, so records may survive past upTo.
+func (l *Log) TruncateFront(upTo uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	removed := 0
+	for removed+1 < len(l.segments) && l.segments[removed+1].firstIndex <= upTo+1 {
+		if err := os.Remove(l.segments[removed].path); err != nil && !os.IsNotExist(err) {
+			l.segments = l.segments[removed:]
+			return fmt.Errorf("failed to remove wal segment: %w", err)
+		}
+		removed++
+	}
+	if removed == 0 {
+		return nil
+	}
+
+	l.segments = l.segments[removed:]
+	return syncDir(l.dir)
+}
+
+// TruncateBack deletes the records with an index >= from, so that the next
+// record appended gets index from. from must not precede FirstIndex.
+func (l *Log) TruncateBack(from uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	if l.err != nil {
+		return l.err
+	}
+	if from > l.lastIndex {
+		return nil
+	}
+	if from < l.segments[0].firstIndex {
+		return fmt.Errorf("wal: cannot truncate to %d before the first record %d", from, l.segments[0].firstIndex)
+	}
+
+	keep := len(l.segments) - 1
+	for l.segments[keep].firstIndex > from {
+		keep--
+	}
+	if err := l.file.Close(); err != nil {
+		l.err = fmt.Errorf("failed to close wal segment: %w", err)
+		return l.err
+	}
+	for i := len(l.segments) - 1; i > keep; i-- {
+		if err := os.Remove(l.segments[i].path); err != nil && !os.IsNotExist(err) {
+			l.err = fmt.Errorf("failed to remove wal segment: %w", err)
+			return l.err
+		}
+	}
+	l.segments = l.segments[:keep+1]
+
+	// Cut the kept segment right before the record with index from
+	tail := l.segments[keep]
+	n := from - tail.firstIndex
+	size, _, err := scanSegment(tail.path, func([]byte) error {
+		if n == 0 {
+			return errStop
+		}
+		n--
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		l.err = err
+		return err
+	}
+	f, err := os.OpenFile(tail.path, os.O_RDWR, 0o644)
+	if err != nil {
+		l.err = fmt.Errorf("failed to open wal segment: %w", err)
+		return l.err
+	}
+	l.file = f
+	if err := f.Truncate(size); err != nil {
+		l.err = fmt.Errorf("failed to truncate wal segment: %w", err)
+		return l.err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		l.err = fmt.Errorf("failed to seek wal segment: %w", err)
+		return l.err
+	}
+	if err := l.fsync(); err != nil {
+		l.err = fmt.Errorf("wal fsync failed: %w", err)
+		return l.err
+	}
+	l.fileSize = size
+	l.lastIndex = from - 1
+	l.dirty = false
+	return syncDir(l.dir)
+}
+
+// Reset deletes every record, so that the next record appended gets index
+// next
+func (l *Log) Reset(next uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	if l.err != nil {
+		return l.err
+	}
+
+	if err := l.file.Close(); err != nil {
+		l.err = fmt.Errorf("failed to close wal segment: %w", err)
+		return l.err
+	}
+	for _, seg := range l.segments {
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			l.err = fmt.Errorf("failed to remove wal segment: %w", err)
+			return l.err
+		}
+	}
+	l.segments = nil
+	l.dirty = false
+	if err := l.createSegment(next); err != nil {
+		l.err = err
+		return err
+	}
+	return nil
+}
+
+// FirstIndex returns the index of the oldest record still in the log
+func (l *Log) FirstIndex() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.segments[0].firstIndex
+}
+
+// LastIndex returns the index of the newest record, or FirstIndex()-1 if the log is empty
+func (l *Log) LastIndex() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastIndex
+}
+
+// Sync flushes all appended records to stable storage
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.syncLocked()
+}
+
+func (l *Log) syncLocked() error {
+	if l.closed || l.err != nil {
+		return l.err
+	}
+	if err := l.fsync(); err != nil {
+		l.err = fmt.Errorf("wal fsync failed: %w", err)
+		return l.err
+	}
+	l.dirty = false
+	return nil
+}
+
+// fsync flushes the active segment and counts the time spent, caller must
+// hold l.mu
+func (l *Log) fsync() error {
+	start := time.Now()
+	err := l.file.Sync()
+	l.stats.Syncs++
+	l.stats.SyncTime += time.Since(start)
+	return err
+}
+
+// Stats returns the counters of the log
+func (l *Log) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.stats
+	stats.Segments = len(l.segments)
+	return stats
+}
+
+// syncLoop periodically flushes batched writes
+func (l *Log) syncLoop() {
+	defer close(l.doneCh)
+
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.dirty {
+				l.syncLocked()
+			}
+			l.mu.Unlock()
+		case <-l.stopCh:
+			return
+		}
+	}
+}
+
+// Close syncs and closes the log
+func (l *Log) Close() error {
+	if l.stopCh != nil {
+		close(l.stopCh)
+		<-l.doneCh
+		l.stopCh = nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+
+	syncErr := l.syncLocked()
+	l.closed = true
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close wal segment: %w", err)
+	}
+	return syncErr
+}
+
+// errTorn marks a short or mismatching record, which is only repairable at the tail
+var errTorn = errors.New("wal: torn record")
+
+// errStop ends a segment scan early
+var errStop = errors.New("wal: stop scan")
+
+// scanSegment reads records from a segment file, calling fn for each. It
+// returns the size of the valid prefix and the number of records in it.
+func scanSegment(path string, fn func(data []byte) error) (int64, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open wal segment: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		offset int64
+		count  uint64
+		header [headerSize]byte
+	)
+
+	for {
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			if err == io.EOF {
+				return offset, count, nil
+			}
+			return offset, count, errTorn
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		if length > maxRecordSize {
+			return offset, count, errTorn
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return offset, count, errTorn
+		}
+		if crc32.Checksum(data, crcTable) != checksum {
+			return offset, count, errTorn
+		}
+
+		if err := fn(data); err != nil {
+			return offset, count, err
+		}
+
+		offset += headerSize + int64(length)
+		count++
+	}
+}
+
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wal directory: %w", err)
+	}
+
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		firstIndex, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{firstIndex: firstIndex, path: filepath.Join(dir, name)})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].firstIndex < segments[j].firstIndex
+	})
+	return segments, nil
+}
+
+func segmentName(firstIndex uint64) string {
+	return fmt.Sprintf("%016x%s", firstIndex, segmentExt)
+}
+
+func fileSizeOf(f *os.File) int64 {
+	info, err := f.Stat()
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// syncDir fsyncs a directory so that newly created files survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory for sync: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
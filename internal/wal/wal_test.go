@@ -0,0 +1,361 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, l *Log, from uint64) []string {
+	t.Helper()
+
+	var records []string
+	err := l.Replay(from, func(index uint64, data []byte) error {
+		records = append(records, fmt.Sprintf("%d:%s", index, data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	return records
+}
+
+func TestLog_AppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		index, err := l.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		if index != uint64(i) {
+			t.Fatalf("Expected index %d, got %d", i, index)
+		}
+	}
+
+	records := readAll(t, l, 1)
+	if len(records) != 3 || records[0] != "1:record-1" || records[2] != "3:record-3" {
+		t.Fatalf("Unexpected records: %v", records)
+	}
+
+	records = readAll(t, l, 2)
+	if len(records) != 2 || records[0] != "2:record-2" {
+		t.Fatalf("Unexpected records from index 2: %v", records)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestLog_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _ := Open(dir, DefaultOptions())
+	l.Append([]byte("a"))
+	l.Append([]byte("b"))
+	l.Close()
+
+	l, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer l.Close()
+
+	if l.LastIndex() != 2 {
+		t.Fatalf("Expected last index 2, got %d", l.LastIndex())
+	}
+
+	index, _ := l.Append([]byte("c"))
+	if index != 3 {
+		t.Fatalf("Expected index 3 after reopen, got %d", index)
+	}
+
+	records := readAll(t, l, 1)
+	if len(records) != 3 || records[2] != "3:c" {
+		t.Fatalf("Unexpected records: %v", records)
+	}
+}
+
+func TestLog_TornTailIsTrimmed(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _ := Open(dir, DefaultOptions())
+	l.Append([]byte("complete"))
+	l.Close()
+
+	// Simulate a crash halfway through writing a second record
+	path := filepath.Join(dir, segmentName(1))
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.Write([]byte{0x10, 0x00, 0x00, 0x00, 0xde, 0xad})
+	f.Close()
+
+	l, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Open after torn write failed: %v", err)
+	}
+	defer l.Close()
+
+	records := readAll(t, l, 1)
+	if len(records) != 1 || records[0] != "1:complete" {
+		t.Fatalf("Expected only the complete record, got %v", records)
+	}
+
+	index, _ := l.Append([]byte("next"))
+	if index != 2 {
+		t.Fatalf("Expected index 2, got %d", index)
+	}
+	if records := readAll(t, l, 1); len(records) != 2 {
+		t.Fatalf("Expected 2 records after append, got %v", records)
+	}
+}
+
+func TestLog_ChecksumMismatchInSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.SegmentSize = 32
+	l, _ := Open(dir, opts)
+	for i := 0; i < 4; i++ {
+		l.Append([]byte("0123456789abcdef"))
+	}
+	l.Close()
+
+	// Flip a payload byte in the first (sealed) segment
+	path := filepath.Join(dir, segmentName(1))
+	data, _ := os.ReadFile(path)
+	data[headerSize] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer l.Close()
+
+	err = l.Replay(1, func(uint64, []byte) error { return nil })
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestLog_SegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.SegmentSize = 64
+	l, _ := Open(dir, opts)
+
+	for i := 0; i < 10; i++ {
+		l.Append([]byte(fmt.Sprintf("record-%02d-padding", i)))
+	}
+	l.Close()
+
+	segments, _ := listSegments(dir)
+	if len(segments) < 2 {
+		t.Fatalf("Expected multiple segments, got %d", len(segments))
+	}
+
+	l, _ = Open(dir, opts)
+	defer l.Close()
+
+	records := readAll(t, l, 7)
+	if len(records) != 4 || records[0] != "7:record-06-padding" {
+		t.Fatalf("Unexpected records from index 7: %v", records)
+	}
+}
+
+func TestLog_BatchSync(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.SyncPolicy = SyncBatch
+	opts.SyncInterval = time.Millisecond
+	l, _ := Open(dir, opts)
+
+	l.Append([]byte("batched"))
+	time.Sleep(10 * time.Millisecond)
+
+	l.mu.Lock()
+	dirty := l.dirty
+	l.mu.Unlock()
+	if dirty {
+		t.Fatal("Expected background sync to clear dirty flag")
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := l.Append([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	cases := map[string]SyncPolicy{
+		"always": SyncAlways,
+		"":       SyncAlways,
+		"batch":  SyncBatch,
+		"none":   SyncNone,
+	}
+	for input, expected := range cases {
+		policy, err := ParseSyncPolicy(input)
+		if err != nil || policy != expected {
+			t.Fatalf("ParseSyncPolicy(%q) = %v, %v", input, policy, err)
+		}
+	}
+
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Fatal("Expected error for unknown policy")
+	}
+}
+
+func TestLog_RotateAndTruncateFront(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _ := Open(dir, DefaultOptions())
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		l.Append([]byte("record"))
+	}
+	if err := l.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	l.Append([]byte("after-rotate"))
+
+	// Nothing is released while records <= upTo remain in the segment
+	l.TruncateFront(3)
+	if l.FirstIndex() != 1 {
+		t.Fatalf("Expected first index 1, got %d", l.FirstIndex())
+	}
+
+	l.TruncateFront(5)
+	if l.FirstIndex() != 6 {
+		t.Fatalf("Expected first index 6, got %d", l.FirstIndex())
+	}
+
+	records := readAll(t, l, 1)
+	if len(records) != 1 || records[0] != "6:after-rotate" {
+		t.Fatalf("Unexpected records after truncation: %v", records)
+	}
+	l.Close()
+
+	// The truncated log reopens at its new first index
+	l, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if l.FirstIndex() != 6 || l.LastIndex() != 6 {
+		t.Fatalf("Expected [6, 6], got [%d, %d]", l.FirstIndex(), l.LastIndex())
+	}
+	l.Close()
+}
+
+func TestLog_TruncateBack(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _ := Open(dir, DefaultOptions())
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		l.Append([]byte("record"))
+	}
+	l.Rotate()
+	for i := 0; i < 3; i++ {
+		l.Append([]byte("record"))
+	}
+
+	// Truncating past the last record changes nothing
+	if err := l.TruncateBack(7); err != nil {
+		t.Fatalf("TruncateBack failed: %v", err)
+	}
+	if l.LastIndex() != 6 {
+		t.Fatalf("Expected last index 6, got %d", l.LastIndex())
+	}
+
+	// Cutting into the sealed segment drops the newer one
+	if err := l.TruncateBack(3); err != nil {
+		t.Fatalf("TruncateBack failed: %v", err)
+	}
+	if l.LastIndex() != 2 || l.Stats().Segments != 1 {
+		t.Fatalf("Expected last index 2 in 1 segment, got %d in %d", l.LastIndex(), l.Stats().Segments)
+	}
+	if index, _ := l.Append([]byte("replaced")); index != 3 {
+		t.Fatalf("Expected index 3, got %d", index)
+	}
+	l.Close()
+
+	l, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer l.Close()
+	records := readAll(t, l, 1)
+	if len(records) != 3 || records[2] != "3:replaced" {
+		t.Fatalf("Unexpected records after truncation: %v", records)
+	}
+
+	if err := l.TruncateBack(0); err == nil {
+		t.Fatal("Expected truncating before the first record to fail")
+	}
+}
+
+func TestLog_Reset(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _ := Open(dir, DefaultOptions())
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		l.Append([]byte("record"))
+	}
+	if err := l.Reset(10); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if l.FirstIndex() != 10 || l.LastIndex() != 9 {
+		t.Fatalf("Expected [10, 9], got [%d, %d]", l.FirstIndex(), l.LastIndex())
+	}
+	if index, _ := l.Append([]byte("after-reset")); index != 10 {
+		t.Fatalf("Expected index 10, got %d", index)
+	}
+	l.Close()
+
+	l, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer l.Close()
+	if records := readAll(t, l, 1); len(records) != 1 || records[0] != "10:after-reset" {
+		t.Fatalf("Unexpected records after reset: %v", records)
+	}
+}
+
+func TestLog_Stats(t *testing.T) {
+	l, err := Open(t.TempDir(), DefaultOptions())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer l.Close()
+
+	l.Append([]byte("first"))
+	l.Append([]byte("second"))
+	l.Rotate()
+
+	stats := l.Stats()
+	if stats.Appends != 2 || stats.AppendBytes != uint64(2*headerSize+len("first")+len("second")) {
+		t.Fatalf("Expected 2 appends of %d bytes, got %+v", 2*headerSize+11, stats)
+	}
+	// SyncAlways fsyncs every append, and rotating syncs the sealed segment
+	if stats.Syncs != 3 || stats.Segments != 2 {
+		t.Fatalf("Expected 3 syncs and 2 segments, got %+v", stats)
+	}
+}
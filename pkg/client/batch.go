@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+// GetResult is one key read by MGet
+type GetResult struct {
+	Key     string
+	Value   []byte
+	Version uint64
+	Found   bool
+}
+
+// SetItem is one key-value pair written by MSet
+type SetItem struct {
+	Key   string
+	Value []byte
+	TTL   time.Duration // Expire the key after this long, 0 keeps it until deleted
+}
+
+// SetResult is the outcome of writing one item of MSet
+type SetResult struct {
+	Key     string
+	Version uint64 // Version the key was written at
+	Err     error  // Why the key was not written, nil on success
+}
+
+// MGet retrieves several keys in one round trip, one per shard group when
+// sharded. Results are returned in the order of keys and are consistent
+// with each other within a group; missing keys have Found unset.
+func (c *Client) MGet(ctx context.Context, keys []string) ([]GetResult, error) {
+	results := make([]GetResult, len(keys))
+	for _, batch := range c.shardBatches(ctx, keys) {
+		resp, err := c.client.MGet(ctx, &pb.MGetRequest{
+			TenantId: c.tenantID,
+			Keys:     pick(keys, batch),
+		})
+		if err != nil {
+			return nil, wrapError("mget", err)
+		}
+
+		for j, r := range resp.Results {
+			results[batch[j]] = GetResult{
+				Key:     r.Key,
+				Value:   r.Value,
+				Version: r.Version,
+				Found:   r.Found,
+			}
+		}
+	}
+	return results, nil
+}
+
+// MSet stores several key-value pairs in one round trip. Each pair is
+// written independently; check the Err of each result for the ones that
+// were rejected.
+func (c *Client) MSet(ctx context.Context, items []SetItem) ([]SetResult, error) {
+	return c.mset(ctx, items, false)
+}
+
+// MSetAtomic stores several key-value pairs all or nothing: either every
+// pair is written, at one shared version, or an error is returned and none
+// is. When sharded the keys must belong to one shard group.
+func (c *Client) MSetAtomic(ctx context.Context, items []SetItem) ([]SetResult, error) {
+	return c.mset(ctx, items, true)
+}
+
+func (c *Client) mset(ctx context.Context, items []SetItem, atomic bool) ([]SetResult, error) {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	batches := [][]int{allIndexes(len(items))}
+	if !atomic {
+		batches = c.shardBatches(ctx, keys)
+	}
+
+	results := make([]SetResult, len(items))
+	for _, batch := range batches {
+		req := &pb.MSetRequest{
+			TenantId: c.tenantID,
+			Items:    make([]*pb.MSetItem, len(batch)),
+			Atomic:   atomic,
+		}
+		for j, i := range batch {
+			req.Items[j] = &pb.MSetItem{
+				Key:   items[i].Key,
+				Value: items[i].Value,
+				TtlMs: millis(items[i].TTL),
+			}
+		}
+
+		resp, err := c.client.MSet(ctx, req)
+		if err != nil {
+			return nil, wrapError("mset", err)
+		}
+
+		for j, r := range resp.Results {
+			results[batch[j]] = SetResult{Key: r.Key, Version: r.Version}
+			if !r.Success {
+				results[batch[j]].Err = errors.New(r.Message)
+			}
+		}
+	}
+	return results, nil
+}
+
+// MDelete removes several keys in one round trip, one per shard group when
+// sharded, and reports, in the order of keys, which of them existed
+func (c *Client) MDelete(ctx context.Context, keys []string) ([]bool, error) {
+	deleted := make([]bool, len(keys))
+	for _, batch := range c.shardBatches(ctx, keys) {
+		resp, err := c.client.MDelete(ctx, &pb.MDeleteRequest{
+			TenantId: c.tenantID,
+			Keys:     pick(keys, batch),
+		})
+		if err != nil {
+			return nil, wrapError("mdelete", err)
+		}
+
+		for j, r := range resp.Results {
+			deleted[batch[j]] = r.Found
+		}
+	}
+	return deleted, nil
+}
+
+// shardBatches splits the indexes of keys by the shard group owning them
+// when the client is sharded, the batches of MGet, MSet and MDelete
+func (c *Client) shardBatches(ctx context.Context, keys []string) [][]int {
+	if c.router == nil {
+		return [][]int{allIndexes(len(keys))}
+	}
+	return c.router.batches(ctx, c.tenantID, keys)
+}
+
+// pick returns the items at indexes
+func pick[T any](items []T, indexes []int) []T {
+	picked := make([]T, len(indexes))
+	for j, i := range indexes {
+		picked[j] = items[i]
+	}
+	return picked
+}
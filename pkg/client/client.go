@@ -2,26 +2,172 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"slices"
 	"time"
 
+	"github.com/ayushgala/tinkerdb/internal/certs"
+	"github.com/ayushgala/tinkerdb/internal/tracing"
 	pb "github.com/ayushgala/tinkerdb/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// NoExpiry is returned by TTL for keys that never expire
+const NoExpiry time.Duration = -1
+
+// WriteOption configures a Put or DeleteIf call
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	ttl          time.Duration
+	precondition *pb.Precondition
+}
+
+// WithTTL makes the written key expire after ttl
+func WithTTL(ttl time.Duration) WriteOption {
+	return func(o *writeOptions) { o.ttl = ttl }
+}
+
+// IfVersion applies the write only if the key exists at exactly version
+func IfVersion(version uint64) WriteOption {
+	return func(o *writeOptions) {
+		o.precondition = &pb.Precondition{Condition: &pb.Precondition_IfVersionEquals{IfVersionEquals: version}}
+	}
+}
+
+// IfAbsent applies the write only if the key does not exist
+func IfAbsent() WriteOption {
+	return func(o *writeOptions) {
+		o.precondition = &pb.Precondition{Condition: &pb.Precondition_IfAbsent{IfAbsent: true}}
+	}
+}
+
+// IfPresent applies the write only if the key exists
+func IfPresent() WriteOption {
+	return func(o *writeOptions) {
+		o.precondition = &pb.Precondition{Condition: &pb.Precondition_IfPresent{IfPresent: true}}
+	}
+}
+
+func applyWriteOptions(opts []WriteOption) writeOptions {
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ReadOption selects how up to date a Get or Scan must be when the server
+// runs in cluster mode. A standalone server always reads its latest state.
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	consistency pb.ReadConsistency
+	maxLagMs    int64
+}
+
+// Linearizable makes the read see every write that completed before it
+// began. It is the default; a follower serves it once the leader confirmed
+// its commit index with a quorum.
+func Linearizable() ReadOption {
+	return func(o *readOptions) { o.consistency = pb.ReadConsistency_LINEARIZABLE }
+}
+
+// Lease serves the read from the leader alone while its lease holds, saving
+// the round trip to the quorum
+func Lease() ReadOption {
+	return func(o *readOptions) { o.consistency = pb.ReadConsistency_LEASE }
+}
+
+// Stale serves the read from the local state of the node the client is
+// configured with, as long as it heard from the leader within maxLag; 0
+// accepts any lag. A node further behind sends the read to the leader.
+func Stale(maxLag time.Duration) ReadOption {
+	return func(o *readOptions) {
+		o.consistency = pb.ReadConsistency_STALE
+		o.maxLagMs = millis(maxLag)
+	}
+}
+
+func applyReadOptions(opts []ReadOption) readOptions {
+	var o readOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // Client represents a TinkerDB client
 type Client struct {
 	conn     *grpc.ClientConn
 	client   pb.KVStoreClient
 	tenantID string
+	pool     *connPool
+	router   *router // nil unless sharded
 }
 
 // Config holds client configuration
 type Config struct {
 	Address  string
-	TenantID string
+	TenantID string // May be empty with a token bound to a single tenant
 	Timeout  time.Duration
+
+	// Token authenticates every call, as issued by the Admin service's
+	// IssueToken. The server only serves the tenants the token is bound to.
+	Token string
+
+	// TLS encrypts the connection. It is implied by any of the options below;
+	// without CAFile the server is verified against the system roots.
+	TLS bool
+
+	// CAFile is a PEM bundle of the CAs the server certificate is verified
+	// against
+	CAFile string
+
+	// CertFile and KeyFile are a client certificate and its key, presented to
+	// servers that require mutual TLS
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the name verified in the server certificate, by
+	// default the host of Address
+	ServerName string
+
+	// Sharded sends every call with a key to the shard group owning it, by
+	// the shard map fetched from Address and cached, and splits MGet, MSet
+	// and MDelete by group. Scans, key listings and prefix watches are made
+	// on every group and merged. Without it the server at Address redirects
+	// the calls with a key to the owner, and rejects the others with
+	// FAILED_PRECONDITION.
+	Sharded bool
+}
+
+// TransportCredentials returns the credentials the configuration connects
+// with: TLS if any TLS option is set, plaintext otherwise
+func (cfg *Config) TransportCredentials() (credentials.TransportCredentials, error) {
+	if !cfg.TLS && cfg.CAFile == "" && cfg.CertFile == "" && cfg.KeyFile == "" && cfg.ServerName == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.ServerName}
+	if cfg.CAFile != "" {
+		pool, err := certs.LoadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
 }
 
 // DefaultConfig returns a default configuration
@@ -40,7 +186,30 @@ func NewClient(cfg *Config) (*Client, error) {
 	}
 
 	// Create gRPC connection
-	conn, err := grpc.NewClient(cfg.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := cfg.TransportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	baseOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if cfg.Token != "" {
+		baseOpts = append(baseOpts, grpc.WithPerRPCCredentials(tokenCredentials(cfg.Token)))
+	}
+	// Calls join the caller's trace, if the application set up tracing. With
+	// sharding they are routed to the group owning their key first, then
+	// calls rejected by a cluster follower are retried on the leader.
+	pool := newConnPool(baseOpts)
+	interceptors := []grpc.UnaryClientInterceptor{tracing.UnaryClientInterceptor()}
+	var route *router
+	if cfg.Sharded {
+		route = newRouter(cfg.Address, pool)
+		interceptors = append(interceptors, route.intercept)
+	}
+	interceptors = append(interceptors, newRedirector(pool).intercept)
+	dialOpts := append(baseOpts,
+		grpc.WithChainUnaryInterceptor(interceptors...),
+		grpc.WithStreamInterceptor(tracing.StreamClientInterceptor()),
+	)
+	conn, err := grpc.NewClient(cfg.Address, dialOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to server: %w", err)
 	}
@@ -51,33 +220,65 @@ func NewClient(cfg *Config) (*Client, error) {
 		conn:     conn,
 		client:   client,
 		tenantID: cfg.TenantID,
+		pool:     pool,
+		router:   route,
 	}, nil
 }
 
+// tokenCredentials sends a bearer token with every call. It is allowed over
+// plaintext connections too.
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
 // Close closes the client connection
 func (c *Client) Close() error {
+	var err error
+	if c.pool != nil {
+		err = c.pool.close()
+	}
 	if c.conn != nil {
-		return c.conn.Close()
+		if closeErr := c.conn.Close(); err == nil {
+			err = closeErr
+		}
 	}
-	return nil
+	return err
 }
 
 // Set stores a key-value pair
 func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL stores a key-value pair that expires after ttl, a ttl of 0
+// keeps the key until it is deleted. The TTL has millisecond precision.
+func (c *Client) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.Put(ctx, key, value, WithTTL(ttl))
+	return err
+}
+
+// Put stores a key-value pair and returns the version it was written at.
+// With a precondition option a rejected write returns ErrPreconditionFailed.
+func (c *Client) Put(ctx context.Context, key string, value []byte, opts ...WriteOption) (uint64, error) {
+	o := applyWriteOptions(opts)
 	resp, err := c.client.Set(ctx, &pb.SetRequest{
-		TenantId: c.tenantID,
-		Key:      key,
-		Value:    value,
+		TenantId:     c.tenantID,
+		Key:          key,
+		Value:        value,
+		TtlMs:        millis(o.ttl),
+		Precondition: o.precondition,
 	})
 	if err != nil {
-		return fmt.Errorf("set failed: %w", err)
+		return 0, wrapError("set", err)
 	}
 
-	if !resp.Success {
-		return fmt.Errorf("set failed: %s", resp.Message)
-	}
-
-	return nil
+	return resp.Version, nil
 }
 
 // SetString stores a key with a string value
@@ -85,44 +286,54 @@ func (c *Client) SetString(ctx context.Context, key, value string) error {
 	return c.Set(ctx, key, []byte(value))
 }
 
-// Get retrieves a value for a key
-func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+// Get retrieves a value for a key, or returns ErrNotFound if it does not exist
+func (c *Client) Get(ctx context.Context, key string, opts ...ReadOption) ([]byte, error) {
+	value, _, err := c.GetWithVersion(ctx, key, opts...)
+	return value, err
+}
+
+// GetWithVersion retrieves a value for a key along with its version, which
+// can be passed to IfVersion for a compare-and-swap
+func (c *Client) GetWithVersion(ctx context.Context, key string, opts ...ReadOption) ([]byte, uint64, error) {
+	o := applyReadOptions(opts)
 	resp, err := c.client.Get(ctx, &pb.GetRequest{
-		TenantId: c.tenantID,
-		Key:      key,
+		TenantId:    c.tenantID,
+		Key:         key,
+		Consistency: o.consistency,
+		MaxLagMs:    o.maxLagMs,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("get failed: %w", err)
+		return nil, 0, wrapError("get", err)
 	}
 
-	if !resp.Found {
-		return nil, fmt.Errorf("key not found: %s", key)
-	}
-
-	return resp.Value, nil
+	return resp.Value, resp.Version, nil
 }
 
 // GetString retrieves a string value for a key
-func (c *Client) GetString(ctx context.Context, key string) (string, error) {
-	value, err := c.Get(ctx, key)
+func (c *Client) GetString(ctx context.Context, key string, opts ...ReadOption) (string, error) {
+	value, err := c.Get(ctx, key, opts...)
 	if err != nil {
 		return "", err
 	}
 	return string(value), nil
 }
 
-// Delete removes a key
+// Delete removes a key, or returns ErrNotFound if it does not exist
 func (c *Client) Delete(ctx context.Context, key string) error {
-	resp, err := c.client.Delete(ctx, &pb.DeleteRequest{
-		TenantId: c.tenantID,
-		Key:      key,
+	return c.DeleteIf(ctx, key)
+}
+
+// DeleteIf removes a key if it meets the precondition option, a rejected
+// delete returns ErrPreconditionFailed. WithTTL has no effect on deletes.
+func (c *Client) DeleteIf(ctx context.Context, key string, opts ...WriteOption) error {
+	o := applyWriteOptions(opts)
+	_, err := c.client.Delete(ctx, &pb.DeleteRequest{
+		TenantId:     c.tenantID,
+		Key:          key,
+		Precondition: o.precondition,
 	})
 	if err != nil {
-		return fmt.Errorf("delete failed: %w", err)
-	}
-
-	if !resp.Success {
-		return fmt.Errorf("delete failed: %s", resp.Message)
+		return wrapError("delete", err)
 	}
 
 	return nil
@@ -135,22 +346,91 @@ func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
 		Key:      key,
 	})
 	if err != nil {
-		return false, fmt.Errorf("exists check failed: %w", err)
+		return false, wrapError("exists check", err)
 	}
 
 	return resp.Exists, nil
 }
 
-// Keys retrieves all keys in the tenant namespace
+// Keys retrieves all keys in the tenant namespace in a single response, one
+// per shard group when sharded.
+//
+// Deprecated: use Scan, which pages through large tenants.
 func (c *Client) Keys(ctx context.Context) ([]string, error) {
-	resp, err := c.client.Keys(ctx, &pb.KeysRequest{
+	resps, err := onShards(ctx, c, func(ctx context.Context) (*pb.KeysResponse, error) {
+		return c.client.Keys(ctx, &pb.KeysRequest{
+			TenantId: c.tenantID,
+		})
+	})
+	if err != nil {
+		return nil, wrapError("keys retrieval", err)
+	}
+
+	if len(resps) == 1 {
+		return resps[0].Keys, nil
+	}
+	var keys []string
+	for _, resp := range resps {
+		keys = append(keys, resp.Keys...)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// Expire sets a key to expire after ttl, or returns ErrNotFound if it does
+// not exist
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := c.client.Expire(ctx, &pb.ExpireRequest{
+		TenantId: c.tenantID,
+		Key:      key,
+		TtlMs:    millis(ttl),
+	})
+	if err != nil {
+		return wrapError("expire", err)
+	}
+
+	return nil
+}
+
+// Persist removes the expiry from a key, or returns ErrNotFound if it does
+// not exist
+func (c *Client) Persist(ctx context.Context, key string) error {
+	_, err := c.client.Persist(ctx, &pb.PersistRequest{
+		TenantId: c.tenantID,
+		Key:      key,
+	})
+	if err != nil {
+		return wrapError("persist", err)
+	}
+
+	return nil
+}
+
+// TTL returns the time left before a key expires, or NoExpiry if it never
+// does. It returns ErrNotFound if the key does not exist.
+func (c *Client) TTL(ctx context.Context, key string) (time.Duration, error) {
+	resp, err := c.client.TTL(ctx, &pb.TTLRequest{
 		TenantId: c.tenantID,
+		Key:      key,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("keys retrieval failed: %w", err)
+		return 0, wrapError("ttl", err)
+	}
+
+	if resp.TtlMs < 0 {
+		return NoExpiry, nil
 	}
+	return time.Duration(resp.TtlMs) * time.Millisecond, nil
+}
 
-	return resp.Keys, nil
+// millis converts a TTL or a lag for the wire, rounding sub-millisecond
+// durations up so they are not mistaken for "no expiry" or "no bound"
+func millis(d time.Duration) int64 {
+	ms := d.Milliseconds()
+	if ms == 0 && d > 0 {
+		return 1
+	}
+	return ms
 }
 
 // SetTenant changes the tenant ID for subsequent operations
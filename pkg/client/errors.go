@@ -0,0 +1,103 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reasonPreconditionFailed is the ErrorInfo reason of a conditional write
+// or delete rejected because the key was not in the expected state
+const reasonPreconditionFailed = "PRECONDITION_FAILED"
+
+// Sentinel errors matched, with errors.Is, by the errors of calls the
+// server rejected
+var (
+	// ErrNotFound is returned when the key does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidArgument is returned for malformed requests, such as an
+	// empty key or a negative TTL
+	ErrInvalidArgument = errors.New("invalid argument")
+
+	// ErrPreconditionFailed is returned when a conditional write is rejected
+	// because the key was not in the expected state
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrResourceExhausted is returned for requests over a server limit, such
+	// as a batch with too many keys
+	ErrResourceExhausted = errors.New("resource exhausted")
+
+	// ErrUnauthenticated is returned when the server requires a token and
+	// the client sent none, or one that is invalid or revoked
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	// ErrPermissionDenied is returned for a tenant the token is not bound to
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// sentinels maps status codes to the sentinel errors they match
+var sentinels = map[codes.Code]error{
+	codes.NotFound:           ErrNotFound,
+	codes.InvalidArgument:    ErrInvalidArgument,
+	codes.FailedPrecondition: ErrPreconditionFailed,
+	codes.ResourceExhausted:  ErrResourceExhausted,
+	codes.Unauthenticated:    ErrUnauthenticated,
+	codes.PermissionDenied:   ErrPermissionDenied,
+}
+
+// Error is a call that failed with a gRPC status. It matches the sentinel
+// error of its code with errors.Is, and status.Code and status.FromError
+// still see the original status.
+type Error struct {
+	Op       string // Client operation, such as "get"
+	Code     codes.Code
+	Message  string
+	Reason   string            // ErrorInfo reason, such as "KEY_NOT_FOUND", empty if the server sent none
+	Metadata map[string]string // ErrorInfo metadata, such as the tenant and key
+
+	status *status.Status
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Op, e.Message)
+}
+
+// Is reports whether target is the sentinel error of e's code. Only errors
+// with the PRECONDITION_FAILED reason, writes whose condition did not hold,
+// match ErrPreconditionFailed: the code also rejects calls the server is not
+// in a state to serve.
+func (e *Error) Is(target error) bool {
+	sentinel, ok := sentinels[e.Code]
+	if sentinel == ErrPreconditionFailed && e.Reason != reasonPreconditionFailed {
+		return false
+	}
+	return ok && target == sentinel
+}
+
+// GRPCStatus returns the status the call failed with
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// wrapError converts the error of a failed call. Errors without a status,
+// which grpc-go never returns, are only wrapped.
+func wrapError(op string, err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return fmt.Errorf("%s failed: %w", op, err)
+	}
+
+	e := &Error{Op: op, Code: st.Code(), Message: st.Message(), status: st}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			e.Reason = info.Reason
+			e.Metadata = info.Metadata
+			break
+		}
+	}
+	return e
+}
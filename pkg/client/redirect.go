@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxRedirects bounds the retries of a call rejected by a node that is
+	// not the leader
+	maxRedirects = 5
+
+	// redirectBackoff is the first wait for an election to settle when the
+	// rejecting node knows no leader, doubled on every retry
+	redirectBackoff = 50 * time.Millisecond
+)
+
+// redirector sends the calls a cluster follower rejects to the leader it
+// names. A rejected write was not made, so retrying it is safe. The leader
+// is remembered, so later calls go to it directly, except stale reads,
+// which any node serves. With sharding every shard group has its leader,
+// remembered by the connection to the group's node calls are routed to.
+type redirector struct {
+	pool *connPool
+
+	mu      sync.Mutex
+	leaders map[string]string // By the target of the connection the call was made on
+}
+
+func newRedirector(pool *connPool) *redirector {
+	return &redirector{pool: pool, leaders: make(map[string]string)}
+}
+
+// intercept is a unary client interceptor that follows NOT_LEADER and
+// REPLICA_STALE errors
+func (r *redirector) intercept(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	target := cc.Target()
+	leader := r.currentLeader(target)
+	if staleRead(req) {
+		leader = ""
+	}
+	err := r.invoke(ctx, leader, method, req, reply, cc, invoker, opts)
+
+	backoff := redirectBackoff
+	for range maxRedirects {
+		next, ok := leaderRedirect(err)
+		if !ok {
+			if leader != "" && status.Code(err) == codes.Unavailable {
+				// The leader went away, the next call asks the configured node
+				r.setLeader(target, leader, "")
+			}
+			return err
+		}
+		r.setLeader(target, leader, next)
+
+		if next == "" {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		leader = next
+		err = r.invoke(ctx, leader, method, req, reply, cc, invoker, opts)
+	}
+	return err
+}
+
+// invoke makes the call on the leader at addr, or on cc if addr is empty
+func (r *redirector) invoke(ctx context.Context, addr, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	if addr == "" {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	conn, err := r.pool.conn(addr)
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, method, req, reply, opts...)
+}
+
+func (r *redirector) currentLeader(target string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leaders[target]
+}
+
+// setLeader replaces the leader of the node at target, unless another call
+// already replaced old
+func (r *redirector) setLeader(target, old, leader string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.leaders[target] == old {
+		r.leaders[target] = leader
+	}
+}
+
+// connPool holds the connections the client makes besides the one to the
+// configured address, to leaders and shard groups. They are made without
+// the client's interceptors, which route and redirect calls themselves.
+type connPool struct {
+	opts []grpc.DialOption
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn // By address
+}
+
+func newConnPool(opts []grpc.DialOption) *connPool {
+	return &connPool{opts: opts, conns: make(map[string]*grpc.ClientConn)}
+}
+
+// conn returns the connection to addr, connecting on first use
+func (p *connPool) conn(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+// close closes the connections
+func (p *connPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var firstErr error
+	for addr, conn := range p.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(p.conns, addr)
+	}
+	return firstErr
+}
+
+// staleRead reports whether req is a read that any node may serve
+func staleRead(req any) bool {
+	read, ok := req.(interface{ GetConsistency() pb.ReadConsistency })
+	return ok && read.GetConsistency() == pb.ReadConsistency_STALE
+}
+
+// NotLeader reports whether err rejects a call made to a cluster node that
+// is not the leader, and returns the leader's address, empty if none is
+// known. Client follows these errors itself.
+func NotLeader(err error) (string, bool) {
+	return redirectReason(err, "NOT_LEADER")
+}
+
+// leaderRedirect reports whether err rejects a call that the leader serves,
+// a write or a read made to a follower, and returns the leader's address
+func leaderRedirect(err error) (string, bool) {
+	if leader, ok := NotLeader(err); ok {
+		return leader, true
+	}
+	return redirectReason(err, "REPLICA_STALE")
+}
+
+// redirectReason reports whether err is UNAVAILABLE with reason, and returns
+// the leader its ErrorInfo names
+func redirectReason(err error, reason string) (string, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return "", false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == reason {
+			return info.Metadata["leader"], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc"
+)
+
+// ScanOptions selects the keys a scan returns. Keys are matched in
+// [Start, End), further narrowed to Prefix when it is set.
+type ScanOptions struct {
+	Start    string // First key of the range, inclusive
+	End      string // Key past the range, exclusive; empty means no upper bound
+	Prefix   string // Only return keys with this prefix
+	Limit    int    // Maximum keys per page, 0 uses the server default
+	Reverse  bool   // Return keys in descending order
+	KeysOnly bool   // Omit values
+	Cursor   string // Cursor of the previous page, empty for the first page
+}
+
+// KeyValue is a key returned by a scan
+type KeyValue struct {
+	Key     string
+	Value   []byte
+	Version uint64
+}
+
+// ScanPage is one page of a scan
+type ScanPage struct {
+	Items  []KeyValue
+	Cursor string // Set as ScanOptions.Cursor to fetch the next page, empty when the scan is done
+}
+
+// Scan returns one page of keys in lexicographic order, or reverse order
+// when requested. To page through a range, repeat the call with Cursor set
+// to the previous page's cursor until it comes back empty. When sharded,
+// every group returns a page and the pages are merged, up to the last key
+// before which no group has keys left.
+func (c *Client) Scan(ctx context.Context, opts ScanOptions, readOpts ...ReadOption) (*ScanPage, error) {
+	o := applyReadOptions(readOpts)
+	resps, err := onShards(ctx, c, func(ctx context.Context) (*pb.ScanResponse, error) {
+		return c.client.Scan(ctx, &pb.ScanRequest{
+			TenantId:    c.tenantID,
+			Start:       opts.Start,
+			End:         opts.End,
+			Prefix:      opts.Prefix,
+			Limit:       int32(opts.Limit),
+			Reverse:     opts.Reverse,
+			KeysOnly:    opts.KeysOnly,
+			Cursor:      opts.Cursor,
+			Consistency: o.consistency,
+			MaxLagMs:    o.maxLagMs,
+		})
+	})
+	if err != nil {
+		return nil, wrapError("scan", err)
+	}
+
+	page := &ScanPage{}
+	for _, resp := range resps {
+		for _, item := range resp.Items {
+			page.Items = append(page.Items, KeyValue{
+				Key:     item.Key,
+				Value:   item.Value,
+				Version: item.Version,
+			})
+		}
+	}
+	if len(resps) == 1 {
+		page.Cursor = resps[0].Cursor
+		return page, nil
+	}
+
+	// A group's page may end before the limit, at the server's byte limit,
+	// and its keys past its cursor may come before those of other groups.
+	// So the merged page ends at the first cursor, and the limit.
+	slices.SortFunc(page.Items, func(a, b KeyValue) int { return scanCompare(opts.Reverse, a.Key, b.Key) })
+	n := len(page.Items)
+	for _, resp := range resps {
+		if resp.Cursor == "" {
+			continue
+		}
+		if i, _ := slices.BinarySearchFunc(page.Items, resp.Cursor, func(item KeyValue, cursor string) int {
+			return scanCompare(opts.Reverse, item.Key, cursor)
+		}); i+1 < n {
+			n = i + 1
+		}
+		page.Cursor = resp.Cursor
+	}
+	if opts.Limit > 0 && opts.Limit < n {
+		n = opts.Limit
+	}
+	if n < len(page.Items) || page.Cursor != "" {
+		page.Items = page.Items[:n]
+		page.Cursor = page.Items[n-1].Key
+	}
+	return page, nil
+}
+
+// scanCompare compares keys a and b in the order of a scan
+func scanCompare(reverse bool, a, b string) int {
+	if reverse {
+		return strings.Compare(b, a)
+	}
+	return strings.Compare(a, b)
+}
+
+// ScanStream streams every key selected by opts from a consistent snapshot
+// taken when the stream opens. Limit caps the total number of keys, 0
+// streams the whole range. Iteration stops at the first error, which is
+// yielded with an empty KeyValue; breaking out of the loop cancels the
+// stream on the server. When sharded, the streams of every group are merged
+// in key order, each from a snapshot of its group.
+//
+//	for kv, err := range c.ScanStream(ctx, client.ScanOptions{Prefix: "user:"}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(kv.Key)
+//	}
+func (c *Client) ScanStream(ctx context.Context, opts ScanOptions, readOpts ...ReadOption) iter.Seq2[KeyValue, error] {
+	o := applyReadOptions(readOpts)
+	return func(yield func(KeyValue, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		streams, err := onShards(ctx, c, func(ctx context.Context) (*scanStream, error) {
+			stream, err := c.streamClient(ctx).ScanStream(ctx, &pb.ScanRequest{
+				TenantId:    c.tenantID,
+				Start:       opts.Start,
+				End:         opts.End,
+				Prefix:      opts.Prefix,
+				Limit:       int32(opts.Limit),
+				Reverse:     opts.Reverse,
+				KeysOnly:    opts.KeysOnly,
+				Cursor:      opts.Cursor,
+				Consistency: o.consistency,
+				MaxLagMs:    o.maxLagMs,
+			})
+			if err != nil {
+				return nil, err
+			}
+			// The first batch tells whether the group serves the stream
+			s := &scanStream{stream: stream}
+			return s, s.fill()
+		})
+		if err != nil {
+			yield(KeyValue{}, wrapError("scan stream", err))
+			return
+		}
+
+		for n := 0; opts.Limit == 0 || n < opts.Limit; n++ {
+			var next *scanStream
+			for _, s := range streams {
+				if len(s.items) > 0 && (next == nil || scanCompare(opts.Reverse, s.items[0].Key, next.items[0].Key) < 0) {
+					next = s
+				}
+			}
+			if next == nil {
+				return
+			}
+
+			item := next.items[0]
+			next.items = next.items[1:]
+			if !yield(KeyValue{Key: item.Key, Value: item.Value, Version: item.Version}, nil) {
+				return
+			}
+			if err := next.fill(); err != nil {
+				yield(KeyValue{}, wrapError("scan stream", err))
+				return
+			}
+		}
+	}
+}
+
+// scanStream is the stream of one shard group in a scan, with the keys
+// received and not yielded yet
+type scanStream struct {
+	stream grpc.ServerStreamingClient[pb.ScanStreamResponse]
+	items  []*pb.KeyValue
+	done   bool
+}
+
+// fill receives the next batch of keys if none are left, until the stream
+// ends
+func (s *scanStream) fill() error {
+	for len(s.items) == 0 && !s.done {
+		resp, err := s.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			s.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.items = resp.Items
+	}
+	return nil
+}
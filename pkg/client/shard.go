@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// kvStorePrefix is the prefix of the KVStore methods, the calls routed by key
+const kvStorePrefix = "/kvstore.KVStore/"
+
+// router sends every call with a key to the shard group owning it, by the
+// shard map fetched from the configured address and cached. Calls go to the
+// first address of the group, and on to its leader through the redirector.
+// A call that reached the wrong group, because the map changed or was not
+// known yet, is retried where the server says and the map fetched again.
+// Range calls are made on every group by onShards, and sent to the group
+// their shard.RangeHeader metadata names.
+type router struct {
+	addr string // Address the map is fetched from
+	pool *connPool
+
+	mu        sync.Mutex
+	shards    *shard.Map // nil until fetched
+	unsharded bool       // The server at addr is not sharded
+}
+
+func newRouter(addr string, pool *connPool) *router {
+	return &router{addr: addr, pool: pool}
+}
+
+// intercept is a unary client interceptor that routes calls by key and
+// follows WRONG_SHARD errors
+func (r *router) intercept(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	target := cc
+	if conn, ok := r.rangeConn(ctx); ok {
+		// onShards makes the whole range call again if the map changed
+		if conn != nil {
+			target = conn
+		}
+		return invoker(ctx, method, req, reply, target, opts...)
+	}
+	if tenantID, key, ok := routeKey(method, req); ok {
+		if conn := r.conn(ctx, tenantID, key); conn != nil {
+			target = conn
+		}
+	}
+	err := invoker(ctx, method, req, reply, target, opts...)
+
+	for range maxRedirects {
+		addrs, version, ok := wrongShard(err)
+		if !ok || addrs[0] == "" {
+			return err
+		}
+		r.invalidate(version)
+		conn, connErr := r.pool.conn(addrs[0])
+		if connErr != nil {
+			return err
+		}
+		err = invoker(ctx, method, req, reply, conn, opts...)
+	}
+	return err
+}
+
+// conn returns the connection to the group owning key of tenantID, nil if
+// the map is not known
+func (r *router) conn(ctx context.Context, tenantID, key string) *grpc.ClientConn {
+	m := r.shardMap(ctx)
+	if m == nil || tenantID == "" {
+		return nil
+	}
+	conn, err := r.pool.conn(m.Locate(tenantID, key).Addresses[0])
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// rangeConn reports whether ctx is that of a range call made on one shard
+// group, and returns the connection to the group, nil if the map does not
+// have it
+func (r *router) rangeConn(ctx context.Context) (*grpc.ClientConn, bool) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	values := md.Get(shard.RangeHeader)
+	if len(values) == 0 {
+		return nil, false
+	}
+	id, _, _ := shard.ParseRangeValue(values[0])
+	m := r.shardMap(ctx)
+	if m == nil {
+		return nil, true
+	}
+	g, ok := m.Group(id)
+	if !ok {
+		return nil, true
+	}
+	conn, err := r.pool.conn(g.Addresses[0])
+	if err != nil {
+		return nil, true
+	}
+	return conn, true
+}
+
+// shardMap returns the cached map, fetching it if needed. It returns nil if
+// the map cannot be fetched, the call then goes to the configured address.
+func (r *router) shardMap(ctx context.Context) *shard.Map {
+	r.mu.Lock()
+	if r.shards != nil || r.unsharded {
+		defer r.mu.Unlock()
+		return r.shards
+	}
+	r.mu.Unlock()
+
+	conn, err := r.pool.conn(r.addr)
+	if err != nil {
+		return nil
+	}
+	resp, err := pb.NewShardClient(conn).GetShardMap(ctx, &pb.GetShardMapRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		r.mu.Lock()
+		r.unsharded = true
+		r.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	m, err := shard.FromProto(resp.Map)
+	if err != nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shards = m
+	return m
+}
+
+// invalidate drops the cached map unless it is at version
+func (r *router) invalidate(version uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shards != nil && r.shards.Version() != version {
+		r.shards = nil
+	}
+}
+
+// batches splits the indexes of keys by the shard group owning them. All
+// keys are one batch if the map is not known.
+func (r *router) batches(ctx context.Context, tenantID string, keys []string) [][]int {
+	m := r.shardMap(ctx)
+	if m == nil || tenantID == "" || len(keys) == 0 {
+		return [][]int{allIndexes(len(keys))}
+	}
+
+	var batches [][]int
+	byGroup := make(map[string]int)
+	for i, key := range keys {
+		id := m.Locate(tenantID, key).ID
+		b, ok := byGroup[id]
+		if !ok {
+			b = len(batches)
+			byGroup[id] = b
+			batches = append(batches, nil)
+		}
+		batches[b] = append(batches[b], i)
+	}
+	return batches
+}
+
+// onShards makes a range call on every shard group when the client is
+// sharded, with ctx naming the group in its shard.RangeHeader metadata, and
+// returns the results in the order of the groups. Otherwise, or if the map
+// cannot be fetched, the call is made once on the configured address. If a
+// group answers WRONG_SHARD the map changed, and the call is made again on
+// the groups of the new map, once.
+func onShards[T any](ctx context.Context, c *Client, call func(ctx context.Context) (T, error)) ([]T, error) {
+	for retried := false; ; retried = true {
+		var m *shard.Map
+		if c.router != nil {
+			m = c.router.shardMap(ctx)
+		}
+		if m == nil {
+			result, err := call(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return []T{result}, nil
+		}
+
+		var results []T
+		var err error
+		for _, g := range m.Groups() {
+			var result T
+			result, err = call(metadata.AppendToOutgoingContext(ctx, shard.RangeHeader, shard.RangeValue(g.ID, m.Version())))
+			if err != nil {
+				break
+			}
+			results = append(results, result)
+		}
+		_, version, wrong := wrongShard(err)
+		if !wrong || retried {
+			if err != nil {
+				return nil, err
+			}
+			return results, nil
+		}
+		c.router.invalidate(version)
+	}
+}
+
+// streamClient returns the client to open a stream with ctx on. Streams are
+// not intercepted, so those of range calls are routed here.
+func (c *Client) streamClient(ctx context.Context) pb.KVStoreClient {
+	if c.router != nil {
+		if conn, _ := c.router.rangeConn(ctx); conn != nil {
+			return pb.NewKVStoreClient(conn)
+		}
+	}
+	return c.client
+}
+
+// routeKey returns the tenant and the key that place a KVStore call, the
+// first key of a batch or transaction, which must all be in one group. It
+// reports false for calls without a key and requests without a tenant, left
+// to the server to redirect.
+func routeKey(method string, req any) (string, string, bool) {
+	if !strings.HasPrefix(method, kvStorePrefix) {
+		return "", "", false
+	}
+	tenant, ok := req.(interface{ GetTenantId() string })
+	if !ok || tenant.GetTenantId() == "" {
+		return "", "", false
+	}
+
+	var key string
+	switch r := req.(type) {
+	case interface{ GetKey() string }:
+		key = r.GetKey()
+	case interface{ GetKeys() []string }:
+		if keys := r.GetKeys(); len(keys) > 0 {
+			key = keys[0]
+		}
+	case *pb.MSetRequest:
+		if len(r.Items) > 0 {
+			key = r.Items[0].Key
+		}
+	case *pb.TxnRequest:
+		if len(r.Compare) > 0 {
+			key = r.Compare[0].Key
+		} else if ops := slices.Concat(r.Success, r.Failure); len(ops) > 0 {
+			key = ops[0].Key
+		}
+	}
+	return tenant.GetTenantId(), key, key != ""
+}
+
+// wrongShard reports whether err rejects a call made to the wrong shard
+// group, and returns the addresses of the owning group and the version of
+// the server's map
+func wrongShard(err error) ([]string, uint64, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.OutOfRange {
+		return nil, 0, false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == "WRONG_SHARD" {
+			version, _ := strconv.ParseUint(info.Metadata["map_version"], 10, 64)
+			return strings.Split(info.Metadata["addresses"], ","), version, true
+		}
+	}
+	return nil, 0, false
+}
+
+// allIndexes returns 0 to n-1
+func allIndexes(n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
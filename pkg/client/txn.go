@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/ayushgala/tinkerdb/proto"
+)
+
+// Txn builds an atomic transaction on the client's tenant:
+//
+//	resp, err := c.Txn(ctx).
+//		If(client.Version("from").Equal(v)).
+//		Then(client.OpSet("from", debited), client.OpSet("to", credited)).
+//		Else(client.OpGet("from")).
+//		Commit()
+//
+// If every compare holds the Then operations run, otherwise the Else ones
+// do. Operations see the writes of earlier operations in the same branch.
+// When sharded every key of a transaction must belong to one shard group.
+type Txn struct {
+	c       *Client
+	ctx     context.Context
+	compare []*pb.Compare
+	success []*pb.TxnOp
+	failure []*pb.TxnOp
+}
+
+// Cmp is a compare clause built from Value, Version or Exists
+type Cmp struct {
+	pb *pb.Compare
+}
+
+// ValueCmp compares a key's value bytewise. It never holds for a missing key.
+type ValueCmp struct {
+	key string
+}
+
+// VersionCmp compares a key's version, a missing key has version 0
+type VersionCmp struct {
+	key string
+}
+
+// Op is a transaction operation built from OpSet, OpGet or OpDelete
+type Op struct {
+	pb *pb.TxnOp
+}
+
+// OpResult is the outcome of one operation of the branch that ran
+type OpResult struct {
+	Key     string
+	Found   bool   // For gets and deletes, whether the key existed
+	Value   []byte // For gets, the value read
+	Version uint64 // For gets and sets, the key's version afterwards
+}
+
+// TxnResponse is the outcome of a committed transaction
+type TxnResponse struct {
+	Succeeded bool       // Every compare held and the Then branch ran
+	Revision  uint64     // Store revision the transaction observed or wrote at
+	Results   []OpResult // One per operation of the branch that ran
+}
+
+// Txn starts building a transaction
+func (c *Client) Txn(ctx context.Context) *Txn {
+	return &Txn{c: c, ctx: ctx}
+}
+
+// If adds compares that must all hold for the Then branch to run
+func (t *Txn) If(cmps ...Cmp) *Txn {
+	for _, cmp := range cmps {
+		t.compare = append(t.compare, cmp.pb)
+	}
+	return t
+}
+
+// Then adds operations run when every compare holds
+func (t *Txn) Then(ops ...Op) *Txn {
+	for _, op := range ops {
+		t.success = append(t.success, op.pb)
+	}
+	return t
+}
+
+// Else adds operations run when any compare fails
+func (t *Txn) Else(ops ...Op) *Txn {
+	for _, op := range ops {
+		t.failure = append(t.failure, op.pb)
+	}
+	return t
+}
+
+// Commit sends the transaction to the server
+func (t *Txn) Commit() (*TxnResponse, error) {
+	resp, err := t.c.client.Txn(t.ctx, &pb.TxnRequest{
+		TenantId: t.c.tenantID,
+		Compare:  t.compare,
+		Success:  t.success,
+		Failure:  t.failure,
+	})
+	if err != nil {
+		return nil, wrapError("txn", err)
+	}
+
+	results := make([]OpResult, len(resp.Results))
+	for i, r := range resp.Results {
+		results[i] = OpResult{
+			Key:     r.Key,
+			Found:   r.Found,
+			Value:   r.Value,
+			Version: r.Version,
+		}
+	}
+	return &TxnResponse{
+		Succeeded: resp.Succeeded,
+		Revision:  resp.Revision,
+		Results:   results,
+	}, nil
+}
+
+// Value starts a compare on a key's value
+func Value(key string) ValueCmp {
+	return ValueCmp{key: key}
+}
+
+func (v ValueCmp) cmp(result pb.Compare_Result, value []byte) Cmp {
+	return Cmp{pb: &pb.Compare{Key: v.key, Target: pb.Compare_VALUE, Result: result, Value: value}}
+}
+
+// Equal holds if the key's value is value
+func (v ValueCmp) Equal(value []byte) Cmp { return v.cmp(pb.Compare_EQUAL, value) }
+
+// NotEqual holds if the key exists with a value other than value
+func (v ValueCmp) NotEqual(value []byte) Cmp { return v.cmp(pb.Compare_NOT_EQUAL, value) }
+
+// Greater holds if the key's value sorts after value
+func (v ValueCmp) Greater(value []byte) Cmp { return v.cmp(pb.Compare_GREATER, value) }
+
+// Less holds if the key's value sorts before value
+func (v ValueCmp) Less(value []byte) Cmp { return v.cmp(pb.Compare_LESS, value) }
+
+// Version starts a compare on a key's version
+func Version(key string) VersionCmp {
+	return VersionCmp{key: key}
+}
+
+func (v VersionCmp) cmp(result pb.Compare_Result, version uint64) Cmp {
+	return Cmp{pb: &pb.Compare{Key: v.key, Target: pb.Compare_VERSION, Result: result, Version: version}}
+}
+
+// Equal holds if the key is at version
+func (v VersionCmp) Equal(version uint64) Cmp { return v.cmp(pb.Compare_EQUAL, version) }
+
+// NotEqual holds if the key is not at version
+func (v VersionCmp) NotEqual(version uint64) Cmp { return v.cmp(pb.Compare_NOT_EQUAL, version) }
+
+// Greater holds if the key was written after version
+func (v VersionCmp) Greater(version uint64) Cmp { return v.cmp(pb.Compare_GREATER, version) }
+
+// Less holds if the key was written before version
+func (v VersionCmp) Less(version uint64) Cmp { return v.cmp(pb.Compare_LESS, version) }
+
+// Exists holds if the key exists
+func Exists(key string) Cmp {
+	return Cmp{pb: &pb.Compare{Key: key, Target: pb.Compare_EXISTS, Result: pb.Compare_EQUAL, Exists: true}}
+}
+
+// Missing holds if the key does not exist
+func Missing(key string) Cmp {
+	return Cmp{pb: &pb.Compare{Key: key, Target: pb.Compare_EXISTS, Result: pb.Compare_EQUAL, Exists: false}}
+}
+
+// OpSet stores a key-value pair
+func OpSet(key string, value []byte) Op {
+	return OpSetWithTTL(key, value, 0)
+}
+
+// OpSetWithTTL stores a key-value pair that expires after ttl
+func OpSetWithTTL(key string, value []byte, ttl time.Duration) Op {
+	return Op{pb: &pb.TxnOp{Type: pb.TxnOp_SET, Key: key, Value: value, TtlMs: millis(ttl)}}
+}
+
+// OpGet reads a key
+func OpGet(key string) Op {
+	return Op{pb: &pb.TxnOp{Type: pb.TxnOp_GET, Key: key}}
+}
+
+// OpDelete removes a key
+func OpDelete(key string) Op {
+	return Op{pb: &pb.TxnOp{Type: pb.TxnOp_DELETE, Key: key}}
+}
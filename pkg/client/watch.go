@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	pb "github.com/ayushgala/tinkerdb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// watchRetryMin and watchRetryMax bound the wait before a broken watch
+	// stream is re-established
+	watchRetryMin = 100 * time.Millisecond
+	watchRetryMax = 5 * time.Second
+)
+
+// EventType identifies the change an Event reports
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+// Event is a change to a watched key
+type Event struct {
+	Type     EventType
+	Key      string
+	Value    []byte // New value for EventPut
+	Revision uint64
+}
+
+// WatchOptions configures a Watch call
+type WatchOptions struct {
+	Prefix        bool   // Watch every key starting with the given key
+	StartRevision uint64 // Replay the changes from this revision on first, 0 only reports new changes
+}
+
+// WatchResponse is a batch of changes in revision order. The changes of
+// one revision always arrive in the same response.
+type WatchResponse struct {
+	Events []Event
+	Err    error // Set on the last response if the watch ended for a reason other than ctx
+}
+
+// Watch reports changes to key, or to every key with the prefix key, on the
+// returned channel until ctx is done. If the stream breaks it is
+// re-established from the revision after the last one received, so no
+// change is missed or repeated. The channel is closed when the watch ends;
+// a watch that cannot continue, for instance because the revision it needs
+// to resume from has been compacted, ends with a response carrying Err.
+// When sharded, a prefix is watched on every shard group. Revisions are
+// those of each group, so the responses of different groups are in no
+// particular order, and StartRevision cannot be set.
+func (c *Client) Watch(ctx context.Context, key string, opts WatchOptions) <-chan WatchResponse {
+	ch := make(chan WatchResponse)
+	go c.watchLoop(ctx, key, opts, ch)
+	return ch
+}
+
+func (c *Client) watchLoop(ctx context.Context, key string, opts WatchOptions, ch chan<- WatchResponse) {
+	defer close(ch)
+
+	var m *shard.Map
+	if c.router != nil && opts.Prefix {
+		m = c.router.shardMap(ctx)
+	}
+	if m == nil {
+		if err := c.watchGroup(ctx, key, opts, ch); err != nil {
+			select {
+			case ch <- WatchResponse{Err: wrapError("watch", err)}:
+			case <-ctx.Done():
+			}
+		}
+		return
+	}
+	if opts.StartRevision != 0 {
+		err := status.Error(codes.InvalidArgument, "start revision cannot be set to watch a prefix on several shard groups")
+		select {
+		case ch <- WatchResponse{Err: wrapError("watch", err)}:
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	// The watch ends with the first group whose watch cannot continue
+	groupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(m.Groups()))
+	for _, g := range m.Groups() {
+		go func() {
+			ctx := metadata.AppendToOutgoingContext(groupCtx, shard.RangeHeader, shard.RangeValue(g.ID, m.Version()))
+			errs <- c.watchGroup(ctx, key, opts, ch)
+		}()
+	}
+	var watchErr error
+	for range m.Groups() {
+		if err := <-errs; err != nil && watchErr == nil {
+			watchErr = err
+			cancel()
+		}
+	}
+	if watchErr == nil || ctx.Err() != nil {
+		return
+	}
+	if _, version, ok := wrongShard(watchErr); ok {
+		// The map changed, the next watch is made on the groups of the new one
+		c.router.invalidate(version)
+	}
+	select {
+	case ch <- WatchResponse{Err: wrapError("watch", watchErr)}:
+	case <-ctx.Done():
+	}
+}
+
+// watchGroup watches key on the shard group ctx names, or the group owning
+// key, until ctx is done or the watch cannot continue, and returns why
+func (c *Client) watchGroup(ctx context.Context, key string, opts WatchOptions, ch chan<- WatchResponse) error {
+	next := opts.StartRevision
+	retry := watchRetryMin
+	for {
+		err := c.watchOnce(ctx, key, opts.Prefix, &next, &retry, ch)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.OutOfRange, codes.Unimplemented, codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition:
+			return err
+		}
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return nil
+		}
+		retry = min(2*retry, watchRetryMax)
+	}
+}
+
+// watchOnce runs a single watch stream until it breaks, advancing next past
+// every revision delivered
+func (c *Client) watchOnce(ctx context.Context, key string, prefix bool, next *uint64, retry *time.Duration, ch chan<- WatchResponse) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Streams are not intercepted, a sharded client routes watches itself
+	kv := c.streamClient(ctx)
+	if c.router != nil && !prefix {
+		if conn := c.router.conn(ctx, c.tenantID, key); conn != nil {
+			kv = pb.NewKVStoreClient(conn)
+		}
+	}
+	stream, err := kv.Watch(ctx, &pb.WatchRequest{
+		TenantId:      c.tenantID,
+		Key:           key,
+		Prefix:        prefix,
+		StartRevision: *next,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		// The stream is established, so a later break is retried promptly
+		*retry = watchRetryMin
+		if len(resp.Events) > 0 {
+			events := make([]Event, len(resp.Events))
+			for i, ev := range resp.Events {
+				events[i] = Event{
+					Type:     EventType(ev.Type),
+					Key:      ev.Key,
+					Value:    ev.Value,
+					Revision: ev.Revision,
+				}
+			}
+			select {
+			case ch <- WatchResponse{Events: events}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		*next = resp.Revision + 1
+	}
+}
@@ -0,0 +1,30 @@
+#!/usr/bin/env bash
+# Starts a local 3-node cluster: node nN serves gRPC on port 5005N and
+# metrics on 909N, keeps its data in $CLUSTER_DIR/nN and logs to
+# $CLUSTER_DIR/nN.log. Ctrl-C stops every node.
+set -euo pipefail
+
+CLUSTER_DIR=${CLUSTER_DIR:-data/cluster}
+PEERS="n1=127.0.0.1:50051,n2=127.0.0.1:50052,n3=127.0.0.1:50053"
+SECRET=${TINKERDB_CLUSTER_SECRET:-local-cluster-secret}
+
+go build -o bin/tinkerdb-server ./cmd/server
+mkdir -p "$CLUSTER_DIR"
+
+pids=()
+trap 'kill "${pids[@]}" 2>/dev/null; wait' INT TERM EXIT
+
+for n in 1 2 3; do
+	TINKERDB_CLUSTER_SECRET=$SECRET bin/tinkerdb-server \
+		-listen "127.0.0.1:5005$n" \
+		-metrics.listen "127.0.0.1:909$n" \
+		-data-dir "$CLUSTER_DIR/n$n" \
+		-cluster.node-id "n$n" \
+		-cluster.peers "$PEERS" \
+		"$@" >"$CLUSTER_DIR/n$n.log" 2>&1 &
+	pids+=($!)
+	echo "Started node n$n on 127.0.0.1:5005$n (log: $CLUSTER_DIR/n$n.log)"
+done
+
+echo "Cluster running, press Ctrl-C to stop it"
+wait
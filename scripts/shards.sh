@@ -0,0 +1,61 @@
+#!/usr/bin/env bash
+# Starts a local sharded deployment of $SHARDS shard groups (3 by default)
+# of $REPLICAS nodes each (1 by default, more run each group as a cluster).
+# Node M of group sN serves gRPC on port 500NM and metrics on 91NM, keeps
+# its data in $SHARD_DIR/sNrM and logs to $SHARD_DIR/sNrM.log. Ctrl-C stops
+# every node.
+set -euo pipefail
+
+SHARD_DIR=${SHARD_DIR:-data/shards}
+SHARDS=${SHARDS:-3}
+REPLICAS=${REPLICAS:-1}
+SECRET=${TINKERDB_CLUSTER_SECRET:-local-cluster-secret}
+
+if ((SHARDS < 1 || SHARDS > 9 || REPLICAS < 1 || REPLICAS > 9)); then
+	echo "SHARDS and REPLICAS must be between 1 and 9" >&2
+	exit 2
+fi
+
+# Every node lists every group: s1=127.0.0.1:50011|127.0.0.1:50012,...
+groups=()
+for n in $(seq "$SHARDS"); do
+	addrs=()
+	for m in $(seq "$REPLICAS"); do
+		addrs+=("127.0.0.1:500$n$m")
+	done
+	groups+=("s$n=$(IFS='|'; echo "${addrs[*]}")")
+done
+GROUPS_LIST=$(IFS=','; echo "${groups[*]}")
+
+go build -o bin/tinkerdb-server ./cmd/server
+mkdir -p "$SHARD_DIR"
+
+pids=()
+trap 'kill ${pids[@]+"${pids[@]}"} 2>/dev/null; wait' INT TERM EXIT
+
+for n in $(seq "$SHARDS"); do
+	peers=()
+	for m in $(seq "$REPLICAS"); do
+		peers+=("s${n}r$m=127.0.0.1:500$n$m")
+	done
+	for m in $(seq "$REPLICAS"); do
+		node="s${n}r$m"
+		cluster=()
+		if ((REPLICAS > 1)); then
+			cluster=(-cluster.node-id "$node" -cluster.peers "$(IFS=','; echo "${peers[*]}")")
+		fi
+		TINKERDB_CLUSTER_SECRET=$SECRET bin/tinkerdb-server \
+			-listen "127.0.0.1:500$n$m" \
+			-metrics.listen "127.0.0.1:91$n$m" \
+			-data-dir "$SHARD_DIR/$node" \
+			-shard.id "s$n" \
+			-shard.groups "$GROUPS_LIST" \
+			${cluster[@]+"${cluster[@]}"} \
+			"$@" >"$SHARD_DIR/$node.log" 2>&1 &
+		pids+=($!)
+		echo "Started $node of shard s$n on 127.0.0.1:500$n$m (log: $SHARD_DIR/$node.log)"
+	done
+done
+
+echo "$SHARDS shard groups running, press Ctrl-C to stop them"
+wait
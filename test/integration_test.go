@@ -1,16 +1,38 @@
 package test
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ayushgala/tinkerdb/internal/auth"
+	"github.com/ayushgala/tinkerdb/internal/cdc"
+	"github.com/ayushgala/tinkerdb/internal/certs"
+	"github.com/ayushgala/tinkerdb/internal/certs/certstest"
+	"github.com/ayushgala/tinkerdb/internal/logging"
+	"github.com/ayushgala/tinkerdb/internal/raft"
 	"github.com/ayushgala/tinkerdb/internal/server"
+	"github.com/ayushgala/tinkerdb/internal/shard"
+	"github.com/ayushgala/tinkerdb/internal/storage"
 	"github.com/ayushgala/tinkerdb/pkg/client"
 	pb "github.com/ayushgala/tinkerdb/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
 
@@ -329,6 +351,455 @@ func TestIntegration_LargeValues(t *testing.T) {
 	}
 }
 
+func TestIntegration_ScanStream(t *testing.T) {
+	conn, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(bufDialer),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewKVStoreClient(conn)
+	ctx := context.Background()
+	tenantID := "stream-tenant"
+
+	// Enough data to need several messages
+	value := make([]byte, 1024)
+	for i := 0; i < 2000; i++ {
+		client.Set(ctx, &pb.SetRequest{TenantId: tenantID, Key: fmt.Sprintf("key-%04d", i), Value: value})
+	}
+
+	stream, err := client.ScanStream(ctx, &pb.ScanRequest{TenantId: tenantID, Prefix: "key-"})
+	if err != nil {
+		t.Fatalf("ScanStream failed: %v", err)
+	}
+
+	// Writes made while streaming are not seen
+	messages, count := 0, 0
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if messages == 0 {
+			client.Delete(ctx, &pb.DeleteRequest{TenantId: tenantID, Key: "key-1999"})
+			client.Set(ctx, &pb.SetRequest{TenantId: tenantID, Key: "key-1000", Value: []byte("changed")})
+		}
+		messages++
+		for _, item := range resp.Items {
+			if item.Key != fmt.Sprintf("key-%04d", count) || len(item.Value) != len(value) {
+				t.Fatalf("Unexpected item %q at position %d", item.Key, count)
+			}
+			count++
+		}
+	}
+	if count != 2000 {
+		t.Fatalf("Expected 2000 keys, got %d", count)
+	}
+	if messages < 2 {
+		t.Fatalf("Expected the scan to span several messages, got %d", messages)
+	}
+
+	// Cancelling the context ends the stream
+	cancelCtx, cancel := context.WithCancel(ctx)
+	stream, err = client.ScanStream(cancelCtx, &pb.ScanRequest{TenantId: tenantID})
+	if err != nil {
+		t.Fatalf("ScanStream failed: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	cancel()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("Expected Canceled after cancelling, got %v", err)
+	}
+}
+
+func TestIntegration_WatchResubscribe(t *testing.T) {
+	store := storage.NewStore()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := lis.Addr().String()
+	serve := func(lis net.Listener) *grpc.Server {
+		s := grpc.NewServer()
+		pb.RegisterKVStoreServer(s, server.NewKVStoreServerWithStore(store))
+		go s.Serve(lis)
+		return s
+	}
+	srv := serve(lis)
+
+	c, err := client.NewClient(&client.Config{Address: addr, TenantID: "watch-tenant"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Starting at revision 1 replays the first write, so no race with setup
+	store.Set("watch-tenant", "config", []byte("v1"))
+	ch := c.Watch(ctx, "config", client.WatchOptions{StartRevision: 1})
+	expect := func(value string) {
+		t.Helper()
+		select {
+		case resp, ok := <-ch:
+			if !ok || resp.Err != nil || len(resp.Events) != 1 || string(resp.Events[0].Value) != value {
+				t.Fatalf("Expected a single put of %q, got %+v (open %v)", value, resp, ok)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for %q", value)
+		}
+	}
+	expect("v1")
+
+	// A change made while the server is down is delivered after reconnecting
+	srv.Stop()
+	store.Set("watch-tenant", "config", []byte("v2"))
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen again: %v", err)
+	}
+	srv = serve(lis)
+	defer srv.Stop()
+
+	expect("v2")
+	store.Set("watch-tenant", "config", []byte("v3"))
+	expect("v3")
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestIntegration_ClientErrors(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterKVStoreServer(s, server.NewKVStoreServer())
+	go s.Serve(lis)
+	defer s.Stop()
+
+	c, err := client.NewClient(&client.Config{Address: lis.Addr().String(), TenantID: "errors-tenant"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	_, err = c.Get(ctx, "missing")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	var clientErr *client.Error
+	if !errors.As(err, &clientErr) || clientErr.Reason != "KEY_NOT_FOUND" || clientErr.Metadata["key"] != "missing" {
+		t.Fatalf("Expected the KEY_NOT_FOUND reason for key missing, got %+v", clientErr)
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected the status code to be preserved, got %v", status.Code(err))
+	}
+	if err := c.Delete(ctx, "missing"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting a missing key, got %v", err)
+	}
+
+	c.Set(ctx, "key", []byte("v"))
+	_, err = c.Put(ctx, "key", []byte("v2"), client.IfAbsent())
+	if !errors.Is(err, client.ErrPreconditionFailed) || errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Expected only ErrPreconditionFailed, got %v", err)
+	}
+	if err := c.Expire(ctx, "key", -time.Second); !errors.Is(err, client.ErrInvalidArgument) {
+		t.Fatalf("Expected ErrInvalidArgument for a negative ttl, got %v", err)
+	}
+	if _, err := c.MGet(ctx, make([]string, storage.MaxBatchKeys+1)); !errors.Is(err, client.ErrResourceExhausted) {
+		t.Fatalf("Expected ErrResourceExhausted for an oversized batch, got %v", err)
+	}
+}
+
+func TestIntegration_TokenAuth(t *testing.T) {
+	store := storage.NewStore()
+	tokens, err := auth.OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+	rbac, err := auth.OpenRBAC(store)
+	if err != nil {
+		t.Fatalf("OpenRBAC failed: %v", err)
+	}
+	defer rbac.Close()
+	authenticator := server.NewAuthenticator(tokens, rbac, server.AuthOptions{Required: true, AdminToken: "bootstrap"})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()),
+	)
+	pb.RegisterKVStoreServer(s, server.NewKVStoreServerWithStore(store))
+	pb.RegisterAdminServer(s, server.NewAdminServer(store, tokens, rbac))
+	go s.Serve(lis)
+	defer s.Stop()
+	ctx := context.Background()
+
+	// Issue a token through the Admin service with the bootstrap token
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	adminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer bootstrap")
+	issued, err := pb.NewAdminClient(conn).IssueToken(adminCtx, &pb.IssueTokenRequest{Name: "app", Tenants: []string{"app-tenant"}})
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+
+	// The token's tenant is used whatever the client asks for
+	c, err := client.NewClient(&client.Config{Address: lis.Addr().String(), Token: issued.Token})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, found := store.Get("app-tenant", "key"); !found {
+		t.Fatal("Expected the key to be written to the token's tenant")
+	}
+	for kv, err := range c.ScanStream(ctx, client.ScanOptions{}) {
+		if err != nil {
+			t.Fatalf("ScanStream failed: %v", err)
+		}
+		if kv.Key != "key" {
+			t.Fatalf("Unexpected key %q", kv.Key)
+		}
+	}
+
+	c.SetTenant("other-tenant")
+	if err := c.Set(ctx, "key", []byte("value")); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied for another tenant, got %v", err)
+	}
+	for _, err := range c.ScanStream(ctx, client.ScanOptions{}) {
+		if !errors.Is(err, client.ErrPermissionDenied) {
+			t.Fatalf("Expected ErrPermissionDenied streaming another tenant, got %v", err)
+		}
+	}
+
+	// Calls without a token, or with a revoked one, are rejected
+	anon, err := client.NewClient(&client.Config{Address: lis.Addr().String(), TenantID: "app-tenant"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer anon.Close()
+	if _, err := anon.Get(ctx, "key"); !errors.Is(err, client.ErrUnauthenticated) {
+		t.Fatalf("Expected ErrUnauthenticated without a token, got %v", err)
+	}
+
+	if _, err := pb.NewAdminClient(conn).RevokeToken(adminCtx, &pb.RevokeTokenRequest{TokenId: issued.Info.TokenId}); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	c.SetTenant("")
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, client.ErrUnauthenticated) {
+		t.Fatalf("Expected ErrUnauthenticated with a revoked token, got %v", err)
+	}
+}
+
+func TestIntegration_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, dir, "tinkerdb")
+	serverCert, serverKey := ca.Issue(t, dir, "server")
+	aliceCert, aliceKey := ca.Issue(t, dir, "alice")
+	opsCert, opsKey := ca.Issue(t, dir, "ops")
+
+	store := storage.NewStore()
+	tokens, err := auth.OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+	rbac, err := auth.OpenRBAC(store)
+	if err != nil {
+		t.Fatalf("OpenRBAC failed: %v", err)
+	}
+	defer rbac.Close()
+	authenticator := server.NewAuthenticator(tokens, rbac, server.AuthOptions{Required: true, AdminClients: []string{"ops"}})
+
+	reloader, err := certs.NewReloader(certs.Files{Cert: serverCert, Key: serverKey, ClientCA: ca.File}, 0)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	defer reloader.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(reloader.ServerConfig(tls.RequireAndVerifyClientCert))),
+		grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()),
+	)
+	pb.RegisterKVStoreServer(s, server.NewKVStoreServerWithStore(store))
+	pb.RegisterAdminServer(s, server.NewAdminServer(store, tokens, rbac))
+	go s.Serve(lis)
+	defer s.Stop()
+	ctx := context.Background()
+	addr := lis.Addr().String()
+
+	// The admin certificate sets up a read-only role for alice
+	opsCfg := &client.Config{Address: addr, CAFile: ca.File, CertFile: opsCert, KeyFile: opsKey}
+	creds, err := opsCfg.TransportCredentials()
+	if err != nil {
+		t.Fatalf("TransportCredentials failed: %v", err)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	admin := pb.NewAdminClient(conn)
+	role := &pb.Role{Name: "reader", Permissions: []*pb.Permission{{TenantId: "orders", Access: pb.Permission_READ}}}
+	if _, err := admin.PutRole(ctx, &pb.PutRoleRequest{Role: role}); err != nil {
+		t.Fatalf("PutRole failed: %v", err)
+	}
+	if _, err := admin.GrantRole(ctx, &pb.GrantRoleRequest{User: "alice", Role: "reader"}); err != nil {
+		t.Fatalf("GrantRole failed: %v", err)
+	}
+	store.Set("orders", "key", []byte("value"))
+
+	// alice's certificate acts with her roles, in her only tenant
+	alice, err := client.NewClient(&client.Config{Address: addr, CAFile: ca.File, CertFile: aliceCert, KeyFile: aliceKey})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer alice.Close()
+	if value, err := alice.Get(ctx, "key"); err != nil || string(value) != "value" {
+		t.Fatalf("Expected alice to read, got %q %v", value, err)
+	}
+	if err := alice.Set(ctx, "key", []byte("other")); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied for alice writing, got %v", err)
+	}
+
+	// Without a client certificate, or trusting another CA, the handshake fails
+	anon, err := client.NewClient(&client.Config{Address: addr, TenantID: "orders", CAFile: ca.File})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer anon.Close()
+	if _, err := anon.Get(ctx, "key"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable without a client certificate, got %v", err)
+	}
+
+	other := certstest.NewCA(t, t.TempDir(), "other")
+	untrusting, err := client.NewClient(&client.Config{Address: addr, TenantID: "orders", CAFile: other.File, CertFile: aliceCert, KeyFile: aliceKey})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer untrusting.Close()
+	if _, err := untrusting.Get(ctx, "key"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable for an untrusted server, got %v", err)
+	}
+}
+
+func TestIntegration_CDCSubscribeAndResume(t *testing.T) {
+	store := storage.NewStore()
+	dir := t.TempDir()
+	offsets, err := cdc.OpenOffsets(filepath.Join(dir, "offsets.json"))
+	if err != nil {
+		t.Fatalf("OpenOffsets failed: %v", err)
+	}
+	sink := cdc.NewStreamSink(store, offsets)
+	publisher, err := cdc.Start(store, dir, offsets, sink)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer publisher.Close()
+
+	cdcLis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	pb.RegisterCDCServer(s, server.NewCDCServer(sink))
+	go s.Serve(cdcLis)
+	defer s.Stop()
+
+	conn, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return cdcLis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	cdcClient := pb.NewCDCClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// receive reads batches until n changes have arrived
+	receive := func(stream grpc.ServerStreamingClient[pb.ChangeBatch], n int) []*pb.Change {
+		t.Helper()
+		var changes []*pb.Change
+		for len(changes) < n {
+			batch, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			changes = append(changes, batch.Changes...)
+		}
+		return changes
+	}
+
+	streamCtx, stop := context.WithCancel(ctx)
+	stream, err := cdcClient.Subscribe(streamCtx, &pb.SubscribeRequest{ConsumerId: "mirror"})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	// The first batch only reports where the stream starts
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+
+	store.Set("cdc-tenant", "key", []byte("v1"))
+	store.Set("cdc-tenant", "key", []byte("v2"))
+	changes := receive(stream, 2)
+	if changes[1].Op != pb.Change_PUT || string(changes[1].OldValue) != "v1" || string(changes[1].NewValue) != "v2" || !changes[1].HadOldValue {
+		t.Fatalf("Expected v1 replaced by v2, got %+v", changes[1])
+	}
+	stop()
+
+	resp, err := cdcClient.Commit(ctx, &pb.CommitRequest{ConsumerId: "mirror", Revision: changes[0].Revision})
+	if err != nil || !resp.Success {
+		t.Fatalf("Commit failed: %v %+v", err, resp)
+	}
+
+	// A reconnecting consumer resumes after its committed revision
+	stream, err = cdcClient.Subscribe(ctx, &pb.SubscribeRequest{ConsumerId: "mirror"})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	store.Delete("cdc-tenant", "key")
+	changes = receive(stream, 2)
+	if string(changes[0].NewValue) != "v2" || changes[1].Op != pb.Change_DELETE {
+		t.Fatalf("Expected the second put and the delete, got %+v", changes)
+	}
+}
+
 func BenchmarkIntegration_Set(b *testing.B) {
 	conn, err := grpc.DialContext(
 		context.Background(),
@@ -392,3 +863,629 @@ func BenchmarkIntegration_Get(b *testing.B) {
 		})
 	}
 }
+
+func TestIntegration_RequestLogging(t *testing.T) {
+	store := storage.NewStore()
+	tokens, err := auth.OpenTokens(store)
+	if err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	defer tokens.Close()
+	rbac, err := auth.OpenRBAC(store)
+	if err != nil {
+		t.Fatalf("OpenRBAC failed: %v", err)
+	}
+	defer rbac.Close()
+	authenticator := server.NewAuthenticator(tokens, rbac, server.AuthOptions{Required: true, AdminToken: "admin"})
+
+	var logs bytes.Buffer
+	handler, _ := logging.NewHandler(&logs, "text", slog.LevelDebug)
+	requestLog := logging.NewInterceptor(slog.New(handler), slog.New(handler), logging.Options{Keys: logging.KeysRedact})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(requestLog.UnaryServerInterceptor(), authenticator.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(requestLog.StreamServerInterceptor(), authenticator.StreamInterceptor()),
+	)
+	pb.RegisterKVStoreServer(s, server.NewKVStoreServerWithStore(store))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	kv := pb.NewKVStoreClient(conn)
+
+	// The request ID sent by the client is returned and logged
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer admin", logging.RequestIDHeader, "req-1")
+	var header metadata.MD
+	if _, err := kv.Set(ctx, &pb.SetRequest{TenantId: "acme", Key: "secret-key", Value: []byte("v")}, grpc.Header(&header)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if ids := header.Get(logging.RequestIDHeader); len(ids) != 1 || ids[0] != "req-1" {
+		t.Fatalf("Expected the request ID in the response header, got %v", ids)
+	}
+
+	stream, err := kv.ScanStream(ctx, &pb.ScanRequest{TenantId: "acme", Prefix: "secret"})
+	if err != nil {
+		t.Fatalf("ScanStream failed: %v", err)
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			break
+		}
+	}
+
+	// Calls rejected by authentication are logged too
+	kv.Get(context.Background(), &pb.GetRequest{TenantId: "acme", Key: "secret-key"})
+
+	out := logs.String()
+	for _, want := range []string{
+		`level=DEBUG msg=Request request_id=req-1 method=/kvstore.KVStore/Set code=OK`,
+		`method=/kvstore.KVStore/ScanStream code=OK`,
+		`tenant=acme`,
+		`key=[REDACTED]`,
+		`level=WARN msg=Request`,
+		`code=Unauthenticated`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("Expected %q in the request log, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Fatalf("Expected key names to be redacted, got:\n%s", out)
+	}
+}
+
+func TestIntegration_HealthCheck(t *testing.T) {
+	store := storage.NewStore()
+	tokens, _ := auth.OpenTokens(store)
+	defer tokens.Close()
+	rbac, _ := auth.OpenRBAC(store)
+	defer rbac.Close()
+	authenticator := server.NewAuthenticator(tokens, rbac, server.AuthOptions{Required: true})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()),
+	)
+	pb.RegisterKVStoreServer(s, server.NewKVStoreServerWithStore(store))
+	health := server.NewHealth(pb.KVStore_ServiceDesc.ServiceName)
+	healthpb.RegisterHealthServer(s, health)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Health checks need no credentials
+	watch, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: "kvstore.KVStore"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	expectStatus := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if resp.Status != want {
+			t.Fatalf("Expected %s, got %s", want, resp.Status)
+		}
+	}
+
+	expectStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	// Recovery completes
+	health.SetReady(true)
+	expectStatus(healthpb.HealthCheckResponse_SERVING)
+	// A graceful shutdown starts draining
+	health.Shutdown()
+	expectStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// startClusterNode serves node id of a test cluster on lis. Clients need the
+// admin token, peers the cluster secret.
+func startClusterNode(t *testing.T, id string, lis net.Listener, peers map[string]string, join bool) (*raft.Node, *storage.Store) {
+	t.Helper()
+
+	transport := raft.NewGRPCTransport(insecure.NewCredentials(), clusterSecret)
+	t.Cleanup(func() { transport.Close() })
+	node, err := raft.NewNode(raft.Config{
+		ID:                id,
+		Peers:             peers,
+		Join:              join,
+		Dir:               filepath.Join(t.TempDir(), "raft"),
+		ElectionTimeout:   200 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+	}, transport)
+	if err != nil {
+		t.Fatalf("NewNode failed: %v", err)
+	}
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.SnapshotInterval = 0
+	store, err := storage.OpenWithLog(opts, node)
+	if err != nil {
+		t.Fatalf("OpenWithLog failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	t.Cleanup(node.Stop)
+
+	tokens, _ := auth.OpenTokens(store)
+	t.Cleanup(func() { tokens.Close() })
+	rbac, _ := auth.OpenRBAC(store)
+	t.Cleanup(func() { rbac.Close() })
+	authenticator := server.NewAuthenticator(tokens, rbac, server.AuthOptions{Required: true, AdminToken: "admin"})
+
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()),
+	)
+	kvStoreServer := server.NewKVStoreServerWithStore(store)
+	kvStoreServer.SetNode(node)
+	pb.RegisterKVStoreServer(s, kvStoreServer)
+	pb.RegisterRaftServer(s, raft.NewServer(node, clusterSecret))
+	pb.RegisterClusterServer(s, server.NewClusterServer(node))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	node.Start(store)
+	return node, store
+}
+
+// startCluster serves a cluster of the given nodes on local TCP ports
+func startCluster(t *testing.T, ids ...string) (map[string]string, map[string]*raft.Node, map[string]*storage.Store) {
+	t.Helper()
+
+	// Listen first, so every node knows the addresses of its peers
+	peers := make(map[string]string)
+	listeners := make(map[string]net.Listener)
+	for _, id := range ids {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		listeners[id] = lis
+		peers[id] = lis.Addr().String()
+	}
+
+	nodes := make(map[string]*raft.Node)
+	stores := make(map[string]*storage.Store)
+	for id, lis := range listeners {
+		nodes[id], stores[id] = startClusterNode(t, id, lis, peers, false)
+	}
+	return peers, nodes, stores
+}
+
+// waitForLeader waits for a leader among nodes and a follower of it
+func waitForLeader(t *testing.T, nodes map[string]*raft.Node) (leader, follower string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for leader == "" || follower == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a leader")
+		}
+		time.Sleep(20 * time.Millisecond)
+		leader, follower = "", ""
+		for id, node := range nodes {
+			if status := node.Status(); status.Role == raft.Leader {
+				leader = id
+			} else if status.Leader != "" {
+				follower = id
+			}
+		}
+	}
+	return leader, follower
+}
+
+// clusterSecret is the secret the nodes of test clusters share
+const clusterSecret = "cluster-secret"
+
+func TestIntegration_RaftCluster(t *testing.T) {
+	peers, nodes, stores := startCluster(t, "n1", "n2", "n3")
+	leader, follower := waitForLeader(t, nodes)
+
+	// A write sent to a follower is redirected to the leader
+	ctx := context.Background()
+	c, err := client.NewClient(&client.Config{Address: peers[follower], TenantID: "app", Token: "admin"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set through a follower failed: %v", err)
+	}
+	if _, found := stores[leader].Get("app", "key"); !found {
+		t.Fatal("Expected the leader to apply the write as soon as it returned")
+	}
+
+	// Every node applies the write
+	for id, store := range stores {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if value, found := store.Get("app", "key"); found && string(value) == "value" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s to apply the write", id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Without the redirect the follower names the leader
+	conn, err := grpc.NewClient(peers[follower], grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	adminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer admin")
+	_, err = pb.NewKVStoreClient(conn).Set(adminCtx, &pb.SetRequest{TenantId: "app", Key: "key", Value: []byte("direct")})
+	if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), peers[leader]) {
+		t.Fatalf("Expected Unavailable naming the leader %s, got %v", peers[leader], err)
+	}
+
+	// Raft messages need the cluster secret
+	_, err = pb.NewRaftClient(conn).AppendEntries(ctx, &pb.AppendEntriesRequest{Term: 1000, LeaderId: "intruder"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated without the cluster secret, got %v", err)
+	}
+}
+
+func TestIntegration_ReadConsistency(t *testing.T) {
+	peers, nodes, _ := startCluster(t, "n1", "n2", "n3")
+	leader, follower := waitForLeader(t, nodes)
+
+	ctx := context.Background()
+	c, err := client.NewClient(&client.Config{Address: peers[follower], TenantID: "app", Token: "admin"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	conn, err := grpc.NewClient(peers[follower], grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	direct := pb.NewKVStoreClient(conn)
+	adminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer admin")
+
+	// A linearizable read on the follower sees a write that just returned
+	for i := range 20 {
+		value := fmt.Sprintf("v%d", i)
+		if err := c.Set(ctx, "key", []byte(value)); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		resp, err := direct.Get(adminCtx, &pb.GetRequest{TenantId: "app", Key: "key"})
+		if err != nil {
+			t.Fatalf("Linearizable Get on the follower failed: %v", err)
+		}
+		if string(resp.Value) != value {
+			t.Fatalf("Expected %s from a linearizable Get, got %s", value, resp.Value)
+		}
+	}
+
+	// Lease reads are served by the leader only
+	_, err = direct.Get(adminCtx, &pb.GetRequest{TenantId: "app", Key: "key", Consistency: pb.ReadConsistency_LEASE})
+	if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), peers[leader]) {
+		t.Fatalf("Expected Unavailable naming the leader %s, got %v", peers[leader], err)
+	}
+	if value, err := c.GetString(ctx, "key", client.Lease()); err != nil || value != "v19" {
+		t.Fatalf("Expected v19 from a lease read, got %q, %v", value, err)
+	}
+
+	// Stale reads are served by the follower from its local state
+	resp, err := direct.Get(adminCtx, &pb.GetRequest{TenantId: "app", Key: "key", Consistency: pb.ReadConsistency_STALE, MaxLagMs: 1000})
+	if err != nil || string(resp.Value) != "v19" {
+		t.Fatalf("Expected v19 from a stale Get, got %v", err)
+	}
+	page, err := c.Scan(ctx, client.ScanOptions{}, client.Stale(0))
+	if err != nil || len(page.Items) != 1 {
+		t.Fatalf("Expected 1 key from a stale Scan, got %+v, %v", page, err)
+	}
+	_, err = direct.Get(adminCtx, &pb.GetRequest{TenantId: "app", Key: "key", Consistency: pb.ReadConsistency_STALE, MaxLagMs: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for a negative lag, got %v", err)
+	}
+}
+
+func TestIntegration_ClusterMembership(t *testing.T) {
+	peers, nodes, stores := startCluster(t, "n1", "n2", "n3")
+	_, follower := waitForLeader(t, nodes)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := lis.Addr().String()
+	nodes["n4"], stores["n4"] = startClusterNode(t, "n4", lis, nil, true)
+
+	conn, err := grpc.NewClient(peers[follower], grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	cluster := pb.NewClusterClient(conn)
+
+	// Membership changes need an admin, and are made by the leader
+	ctx := context.Background()
+	if _, err := cluster.AddMember(ctx, &pb.AddMemberRequest{Id: "n4", Address: addr}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated without a token, got %v", err)
+	}
+	adminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer admin")
+	_, err = cluster.AddMember(adminCtx, &pb.AddMemberRequest{Id: "n4", Address: addr})
+	leaderAddr, ok := client.NotLeader(err)
+	if !ok || leaderAddr == "" {
+		t.Fatalf("Expected a follower to name the leader, got %v", err)
+	}
+
+	leaderConn, err := grpc.NewClient(leaderAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer leaderConn.Close()
+	cluster = pb.NewClusterClient(leaderConn)
+	if _, err := cluster.AddMember(adminCtx, &pb.AddMemberRequest{Id: "n4", Address: addr}); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	promoteCtx, cancel := context.WithTimeout(adminCtx, 5*time.Second)
+	defer cancel()
+	if _, err := cluster.PromoteMember(promoteCtx, &pb.PromoteMemberRequest{Id: "n4"}); err != nil {
+		t.Fatalf("PromoteMember failed: %v", err)
+	}
+
+	// The new node takes over as leader and serves writes
+	resp, err := cluster.TransferLeadership(adminCtx, &pb.TransferLeadershipRequest{Id: "n4"})
+	if err != nil {
+		t.Fatalf("TransferLeadership failed: %v", err)
+	}
+	if resp.LeaderId != "n4" {
+		t.Fatalf("Expected n4 to lead, got %q", resp.LeaderId)
+	}
+
+	c, err := client.NewClient(&client.Config{Address: peers[follower], TenantID: "app", Token: "admin"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set after the transfer failed: %v", err)
+	}
+	if _, found := stores["n4"].Get("app", "key"); !found {
+		t.Fatal("Expected the new leader to apply the write")
+	}
+
+	list, err := pb.NewClusterClient(conn).ListMembers(adminCtx, &pb.ListMembersRequest{})
+	if err != nil {
+		t.Fatalf("ListMembers failed: %v", err)
+	}
+	if len(list.Members) != 4 || list.LeaderId != "n4" {
+		t.Fatalf("Expected four members led by n4, got %+v", list)
+	}
+}
+
+// startShards serves a standalone server for each shard group on local TCP
+// ports, with the map of the groups
+func startShards(t *testing.T, ids ...string) (*shard.Map, map[string]*storage.Store) {
+	t.Helper()
+
+	groups := make([]shard.Group, len(ids))
+	listeners := make([]net.Listener, len(ids))
+	for i, id := range ids {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		listeners[i] = lis
+		groups[i] = shard.Group{ID: id, Addresses: []string{lis.Addr().String()}}
+	}
+	m, err := shard.New(groups, 0)
+	if err != nil {
+		t.Fatalf("Failed to build the shard map: %v", err)
+	}
+
+	stores := make(map[string]*storage.Store)
+	for i, id := range ids {
+		store := storage.NewStore()
+		stores[id] = store
+		s := grpc.NewServer()
+		kvStoreServer := server.NewKVStoreServerWithStore(store)
+		kvStoreServer.SetShards(m, id)
+		pb.RegisterKVStoreServer(s, kvStoreServer)
+		pb.RegisterShardServer(s, server.NewShardServer(m, id))
+		go s.Serve(listeners[i])
+		t.Cleanup(s.Stop)
+	}
+	return m, stores
+}
+
+func TestIntegration_Sharding(t *testing.T) {
+	m, stores := startShards(t, "s1", "s2", "s3")
+	s1, _ := m.Group("s1")
+	ctx := context.Background()
+
+	// A sharded client sends every key to its group, whichever it asks
+	c, err := client.NewClient(&client.Config{Address: s1.Addresses[0], TenantID: "app", Sharded: true})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+	keys := make([]string, 60)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		if err := c.SetString(ctx, keys[i], "v"); err != nil {
+			t.Fatalf("Set of %s failed: %v", keys[i], err)
+		}
+	}
+	owned := make(map[string]int)
+	for _, key := range keys {
+		owner := m.Locate("app", key).ID
+		for id, store := range stores {
+			if _, found := store.Get("app", key); found != (id == owner) {
+				t.Fatalf("Expected %s only in %s, found in %s: %v", key, owner, id, found)
+			}
+		}
+		owned[owner]++
+	}
+	if len(owned) != 3 {
+		t.Fatalf("Expected the keys to spread over the 3 groups, got %v", owned)
+	}
+
+	// Batches are split by group and reassembled in order
+	results, err := c.MGet(ctx, keys)
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	for i, r := range results {
+		if r.Key != keys[i] || !r.Found {
+			t.Fatalf("Expected %s at %d, got %+v", keys[i], i, r)
+		}
+	}
+	deleted, err := c.MDelete(ctx, keys[:10])
+	if err != nil || len(deleted) != 10 || !deleted[9] {
+		t.Fatalf("Expected MDelete to delete 10 keys, got %v, %v", deleted, err)
+	}
+
+	// Range calls are made on every group and merged in key order
+	want := slices.Sorted(slices.Values(keys[10:]))
+	listed, err := c.Keys(ctx)
+	if err != nil || !slices.Equal(listed, want) {
+		t.Fatalf("Expected Keys to list %v, got %v, %v", want, listed, err)
+	}
+	for _, reverse := range []bool{false, true} {
+		var scanned []string
+		opts := client.ScanOptions{Limit: 7, Reverse: reverse, KeysOnly: true}
+		for {
+			page, err := c.Scan(ctx, opts)
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if len(page.Items) > 7 {
+				t.Fatalf("Expected pages of at most 7 keys, got %d", len(page.Items))
+			}
+			for _, item := range page.Items {
+				scanned = append(scanned, item.Key)
+			}
+			if page.Cursor == "" {
+				break
+			}
+			opts.Cursor = page.Cursor
+		}
+		expected := slices.Clone(want)
+		if reverse {
+			slices.Reverse(expected)
+		}
+		if !slices.Equal(scanned, expected) {
+			t.Fatalf("Expected the scan (reverse %v) to return %v, got %v", reverse, expected, scanned)
+		}
+	}
+	for _, limit := range []int{0, 5} {
+		var streamed []string
+		for kv, err := range c.ScanStream(ctx, client.ScanOptions{Limit: limit}) {
+			if err != nil {
+				t.Fatalf("ScanStream failed: %v", err)
+			}
+			streamed = append(streamed, kv.Key)
+		}
+		expected := want
+		if limit > 0 {
+			expected = want[:limit]
+		}
+		if !slices.Equal(streamed, expected) {
+			t.Fatalf("Expected the stream with limit %d to return %v, got %v", limit, expected, streamed)
+		}
+	}
+
+	// A prefix is watched on every group. The watches start in the
+	// background, so each group's key is set until its change arrives.
+	groupKeys := make(map[string]string)
+	for _, key := range keys[10:] {
+		groupKeys[m.Locate("app", key).ID] = key
+	}
+	watchCtx, cancelWatch := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelWatch()
+	ch := c.Watch(watchCtx, "key-", client.WatchOptions{Prefix: true})
+	watched := make(map[string]bool)
+	for len(watched) < len(groupKeys) {
+		for _, key := range groupKeys {
+			if err := c.SetString(ctx, key, "w"); err != nil {
+				t.Fatalf("Set of %s failed: %v", key, err)
+			}
+		}
+		select {
+		case resp, ok := <-ch:
+			if !ok || resp.Err != nil {
+				t.Fatalf("Expected the watch to continue, got %+v (open %v)", resp, ok)
+			}
+			for _, ev := range resp.Events {
+				watched[m.Locate("app", ev.Key).ID] = true
+			}
+		case <-time.After(100 * time.Millisecond):
+		case <-watchCtx.Done():
+			t.Fatalf("Timed out waiting for changes of every group, got %v", watched)
+		}
+	}
+	resp := <-c.Watch(ctx, "key-", client.WatchOptions{Prefix: true, StartRevision: 1})
+	if !errors.Is(resp.Err, client.ErrInvalidArgument) {
+		t.Fatalf("Expected a start revision to be rejected across groups, got %v", resp.Err)
+	}
+
+	// Without routing the server redirects a key of another group
+	var remote string
+	for _, key := range keys {
+		if m.Locate("app", key).ID != "s1" {
+			remote = key
+			break
+		}
+	}
+	plain, err := client.NewClient(&client.Config{Address: s1.Addresses[0], TenantID: "app"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer plain.Close()
+	_, err = plain.Get(ctx, remote)
+	var clientErr *client.Error
+	owner := m.Locate("app", remote)
+	if !errors.As(err, &clientErr) || clientErr.Code != codes.OutOfRange || clientErr.Reason != "WRONG_SHARD" ||
+		clientErr.Metadata["shard"] != owner.ID || clientErr.Metadata["addresses"] != owner.Addresses[0] {
+		t.Fatalf("Expected WRONG_SHARD naming %s, got %v", owner.ID, err)
+	}
+
+	// Without routing range calls are rejected rather than see one group
+	_, err = plain.Scan(ctx, client.ScanOptions{})
+	if !errors.As(err, &clientErr) || clientErr.Code != codes.FailedPrecondition || clientErr.Reason != "SHARDED_RANGE" {
+		t.Fatalf("Expected SHARDED_RANGE, got %v", err)
+	}
+	if errors.Is(err, client.ErrPreconditionFailed) {
+		t.Fatalf("Expected SHARDED_RANGE not to match ErrPreconditionFailed, got %v", err)
+	}
+
+	// Any server publishes the same map
+	conn, err := grpc.NewClient(owner.Addresses[0], grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	mapResp, err := pb.NewShardClient(conn).GetShardMap(ctx, &pb.GetShardMapRequest{})
+	if err != nil {
+		t.Fatalf("GetShardMap failed: %v", err)
+	}
+	if mapResp.ShardId != owner.ID || mapResp.Map.Version != m.Version() {
+		t.Fatalf("Expected the map of version %d from %s, got %+v", m.Version(), owner.ID, mapResp)
+	}
+}